@@ -23,7 +23,7 @@ fi
 cp "$GOPATH/src/democonf/democonf.json" "$OUTDIR"
 echo "cp $GOPATH/src/democonf/democonf.json $OUTDIR" 
 
-TARGETS=("alice" "bob" "charlie" "dave" "fred")
+TARGETS=("alice" "bob" "charlie" "dave" "fred" "democa")
 
 for target in ${TARGETS[@]}; do
     printf "==== %7s build start ====\n" "$target"
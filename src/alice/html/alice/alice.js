@@ -1,225 +1,370 @@
-
-var wallets = {};
-
-var payinfo = {};
-
-function init() {
-    $("#obtn").click(okpay);
-    $("#cbtn").click(cancelpay);
-    $("#modal-thank").click(cancelpay);
-    $("#addressinput").change(getPayInfo);
-    $("#qr_scanner").hover(function(){$("#qr_sorry").css('color', '#707172');},function(){$("#qr_sorry").css('color', '#EEEFF0');});
-    reset();
-}
-
-function reset() {
-    $("#wallet").hide();
-    $("#purchaseinfo").hide();
-    $("#pay").hide();
-    wallets = {};
-    $("#addressinput").val("");
-    payinfo = {};
-    $("#item-detail").empty();
-    $("#item-detail").text("-");
-    $("#item-pointtype").empty();
-    $("#item-pointtype").text("-");
-    $("#item-price").empty();
-    $("#item-price").text("-");
-    $("#exinfo").empty();
-    $("[data-key=\"mc\"]").empty();
-    getWalletInfo();
-}
-
-function getWalletInfo() {
-    $.getJSON("walletinfo")
-        .done(function (walletinfo) {
-            for (let key in walletinfo.balance) {
-                if (wallets[key]) {
-                    wallets[key].point = walletinfo.balance[key];
-                } else {
-                    var wallet = {
-                        sname: key,
-                        lname: key + "pt",
-                        point: walletinfo.balance[key]
-                    }
-                    wallets[key] = wallet;
-                }
-            }
-            setWalletInfo();
-        })
-        .fail(function (jqXHR, textStatus, errorThrown) {
-            if (confirm("walletinfo fail\n" + JSON.stringify(jqXHR) + "\n" + textStatus + "\n"
-                + errorThrown + "\nCannot retrieve wallet info. Do you want to retry?")) {
-                reset();
-            }
-        });
-}
-
-function setWalletInfo() {
-  $("#walletpoints").empty();
-  var i = 0;
-  var j = Object.keys(wallets).length - 1;
-  for (let key in wallets) {
-    var additionalClass = "";
-    switch (i) {
-      case 0:
-        additionalClass = "toppoint";
-        break;
-      case j:
-        additionalClass = "bottompoint";
-        break;
-      default:
-        additionalClass = "middlepoint";
-    }
-    $("#walletpoints").append(
-      $("<div/>")
-        .addClass(additionalClass + " row point")
-        .attr("id", wallets[key].sname)
-        .append($("<div/>")
-          .addClass("col-md-4 col-md-offset-1 text-center")
-          .append($("<button/>")
-            .addClass("btn btn-unpayable btn-point")
-            .prop("disabled", true)
-            .attr("data-key", wallets[key].sname)
-            .attr("id", wallets[key].sname+"-btn")
-            .append($('<img src="./'+wallets[key].sname+'.png">'))))
-        .append($("<div/>")
-          .addClass("col-md-2 text-center points-values")
-          .attr("id", wallets[key].sname+"-balance")
-          .text(wallets[key].point))
-        .append($("<div/>")
-          .addClass("col-md-1 text-center points-values")
-          .text("→"))
-        .append($("<div/>")
-          .addClass("col-md-3 text-center points-values")
-          .attr("id", wallets[key].sname+"-remainder")
-          .text("-"))
-        .append($("<div>/")
-          .addClass("col-md-1 text-center paypointer")
-          .attr("id", wallets[key].sname+"-pointer")
-          .text("▶︎"))
-    );
-    if (i!=j) {
-      $("#walletpoints").append($("<div/>").addClass("row pointseparator"));
-    }
-    i++;
-  }
-}
-
-function getPayInfo() {
-    //px:invoice?addr=2dcyt9LFshsNYNzPzXAtpzTkCo4kKJKjgG2&asset=ASSET&name=PRODUCTNAME&price=PRICE
-    let uri = $("#addressinput").val();
-    let q = uri.split("?");
-    let errFlg = true;
-    if (q[0] == "px:invoice") {
-        payinfo = {};
-        let as = q[1].split("&");
-        for (let a of as) {
-            let kv = a.split("=");
-            if (kv.length == 2) {
-                payinfo[kv[0]] = decodeURIComponent(kv[1].replace(/\+/ig,"%20"));
-            }
-        }
-        if (payinfo["name"] && payinfo["addr"] && payinfo["price"] && payinfo["asset"]) {
-            errFlg = false;
-            setOrderInfo(payinfo["name"], payinfo["price"], payinfo["asset"]);
-            $("#info").show();
-            getExchangeRate(payinfo["asset"], payinfo["price"]);
-        }
-    }
-    if (errFlg) {
-        payinfo = {};
-        alert("Incorrect payment info format.\n" + uri);
-    }
-    $("#purchaseinfo").fadeIn("slow");
-}
-
-function setOrderInfo(name, price, asset) {
-  $("#item-detail").empty();
-  $("#item-detail").text(name);
-  $("#item-pointtype").empty();
-  $("#item-pointtype").text(asset);
-  $("#item-price").empty();
-  $("#item-price").text(price + " pt");
-}
-
-function getExchangeRate(asset, cost) {
-    $.getJSON("offer", { asset: "" + asset, cost: "" + cost })
-        .done(function (offer) {
-            payinfo["offer"] = offer;
-            setExchangeRate();
-        })
-        .fail(function (jqXHR, textStatus, errorThrown) {
-            alert("offer fail\n" + JSON.stringify(jqXHR) + "\n" + textStatus + "\n" + errorThrown + "\n");
-            reset();
-        });
-}
-
-function setExchangeRate() {
-  let offer = payinfo["offer"];
-  if (offer) {
-    for (var key in offer) {
-      var total_cost = offer[key].cost + offer[key].fee;
-      var balance = wallets[key].point;
-      $("#"+key+"-remainder").empty();
-      var remainder = balance-total_cost;
-      $("#"+key+"-remainder").text(total_cost+" ("+remainder+")");
-      if (total_cost <= balance) {
-        $("#"+key+"-btn")
-          .removeClass("btn-unpayable")
-          .addClass("btn-payable")
-          .prop("disabled", false)
-          .click(confirmExchange);
-        $("#"+key+"-pointer").show();
-      }
-      else {
-        $("#"+key+"-remainder").addClass("points-negative");
-      }
-    }
-  }
-}
-
-function confirmExchange() {
-    payinfo["exasset"] = $(this).attr("data-key");
-    $("[data-key=\"mc\"]").empty();
-    let offer = payinfo["offer"][payinfo["exasset"]];
-    if (offer) {
-        $("#dest_address").text(payinfo["addr"]);
-        $("#bpoint").text(offer["cost"]);
-        $("#basset").text(payinfo["exasset"]);
-        $("#apoint").text(payinfo["price"]);
-        $("#aasset").text(payinfo["asset"]);
-        $("#fpoint").text(offer["fee"]);
-        $("#fasset").text(payinfo["exasset"]);
-        $("#tpoint").text(offer["cost"] + offer["fee"]);
-        $("#tasset").text(payinfo["exasset"]);
-        $("#modal-confirm").show();
-        $("#modal-overlay").fadeIn('slow');
-    }
-}
-
-function cancelpay() {
-    $("#modal-overlay").fadeOut('slow');
-    reset();
-}
-
-function okpay() {
-    $("#modal-confirm").hide();
-    let id = payinfo["offer"][payinfo["exasset"]]["id"];
-    let addr = payinfo["addr"];
-    if (id && addr) {
-        $.getJSON("send", { id: "" + id, addr: "" + addr })
-            .done(function (offer) {
-                $("#modal-thank").show();
-            })
-            .fail(function (jqXHR, textStatus, errorThrown) {
-                alert("Offer failed\n" + JSON.stringify(jqXHR) + "\n" + textStatus + "\n" + errorThrown + "\n");
-                reset();
-            });
-    } else {
-        alert("No payinfo:" + id + "," + addr);
-        reset();
-    }
-}
-
-$(init)
+
+var wallets = {};
+
+var payinfo = {};
+
+// csrf is the CSRF token of the session, sent with the POST requests.
+var csrf = "";
+
+function init() {
+    $("#obtn").click(okpay);
+    $("#cbtn").click(cancelpay);
+    $("#modal-thank").click(cancelpay);
+    $("#addressinput").change(getPayInfo);
+    $("#qr_scanner").hover(function(){$("#qr_sorry").css('color', '#707172');},function(){$("#qr_sorry").css('color', '#EEEFF0');});
+    $.ajaxSetup({
+        beforeSend: function (xhr, settings) {
+            if (settings.type != "GET" && csrf) {
+                xhr.setRequestHeader("X-CSRF-Token", csrf);
+            }
+        }
+    });
+    loadSession(reset);
+}
+
+function reset() {
+    $("#wallet").hide();
+    $("#purchaseinfo").hide();
+    $("#pay").hide();
+    wallets = {};
+    $("#addressinput").val("");
+    payinfo = {};
+    $("#item-detail").empty();
+    $("#item-detail").text("-");
+    $("#item-pointtype").empty();
+    $("#item-pointtype").text("-");
+    $("#item-price").empty();
+    $("#item-price").text("-");
+    $("#exinfo").empty();
+    $("[data-key=\"mc\"]").empty();
+    getWalletInfo();
+}
+
+function getWalletInfo() {
+    $.getJSON("walletinfo")
+        .done(function (walletinfo) {
+            for (let key in walletinfo.balance) {
+                if (wallets[key]) {
+                    wallets[key].point = walletinfo.balance[key];
+                } else {
+                    var wallet = {
+                        sname: key,
+                        lname: key + "pt",
+                        point: walletinfo.balance[key]
+                    }
+                    wallets[key] = wallet;
+                }
+            }
+            setWalletInfo();
+        })
+        .fail(function (jqXHR, textStatus, errorThrown) {
+            let err = apiError(jqXHR, textStatus);
+            if (err.code == "unauthorized") {
+                login(reset);
+                return;
+            }
+            if (confirm(errorMessage(err) + "\nCannot retrieve wallet info. Do you want to retry?")) {
+                reset();
+            }
+        });
+}
+
+function setWalletInfo() {
+  $("#walletpoints").empty();
+  var i = 0;
+  var j = Object.keys(wallets).length - 1;
+  for (let key in wallets) {
+    var additionalClass = "";
+    switch (i) {
+      case 0:
+        additionalClass = "toppoint";
+        break;
+      case j:
+        additionalClass = "bottompoint";
+        break;
+      default:
+        additionalClass = "middlepoint";
+    }
+    $("#walletpoints").append(
+      $("<div/>")
+        .addClass(additionalClass + " row point")
+        .attr("id", wallets[key].sname)
+        .append($("<div/>")
+          .addClass("col-md-4 col-md-offset-1 text-center")
+          .append($("<button/>")
+            .addClass("btn btn-unpayable btn-point")
+            .prop("disabled", true)
+            .attr("data-key", wallets[key].sname)
+            .attr("id", wallets[key].sname+"-btn")
+            .append($('<img src="./'+wallets[key].sname+'.png">'))))
+        .append($("<div/>")
+          .addClass("col-md-2 text-center points-values")
+          .attr("id", wallets[key].sname+"-balance")
+          .text(wallets[key].point))
+        .append($("<div/>")
+          .addClass("col-md-1 text-center points-values")
+          .text("→"))
+        .append($("<div/>")
+          .addClass("col-md-3 text-center points-values")
+          .attr("id", wallets[key].sname+"-remainder")
+          .text("-"))
+        .append($("<div>/")
+          .addClass("col-md-1 text-center paypointer")
+          .attr("id", wallets[key].sname+"-pointer")
+          .text("▶︎"))
+    );
+    if (i!=j) {
+      $("#walletpoints").append($("<div/>").addClass("row pointseparator"));
+    }
+    i++;
+  }
+}
+
+function getPayInfo() {
+    //px:invoice?addr=2dcyt9LFshsNYNzPzXAtpzTkCo4kKJKjgG2&asset=ASSET&name=PRODUCTNAME&price=PRICE
+    let uri = $("#addressinput").val();
+    let q = uri.split("?");
+    let errFlg = true;
+    if (q[0] == "px:invoice") {
+        payinfo = {};
+        let as = q[1].split("&");
+        for (let a of as) {
+            let kv = a.split("=");
+            if (kv.length == 2) {
+                payinfo[kv[0]] = decodeURIComponent(kv[1].replace(/\+/ig,"%20"));
+            }
+        }
+        if (payinfo["name"] && payinfo["addr"] && payinfo["price"] && payinfo["asset"]) {
+            errFlg = false;
+            setOrderInfo(payinfo["name"], payinfo["price"], payinfo["asset"]);
+            $("#info").show();
+            getExchangeRate(payinfo["asset"], payinfo["price"]);
+        }
+    }
+    if (errFlg) {
+        payinfo = {};
+        alert("Incorrect payment info format.\n" + uri);
+    }
+    $("#purchaseinfo").fadeIn("slow");
+}
+
+function setOrderInfo(name, price, asset) {
+  $("#item-detail").empty();
+  $("#item-detail").text(name);
+  $("#item-pointtype").empty();
+  $("#item-pointtype").text(asset);
+  $("#item-price").empty();
+  $("#item-price").text(price + " pt");
+}
+
+function getExchangeRate(asset, cost) {
+    $.getJSON("offer", { asset: "" + asset, cost: "" + cost })
+        .done(function (offer) {
+            payinfo["offer"] = offer;
+            setExchangeRate();
+        })
+        .fail(function (jqXHR, textStatus, errorThrown) {
+            let err = apiError(jqXHR, textStatus);
+            if (err.code == "unauthorized") {
+                login(function () { getExchangeRate(asset, cost); });
+                return;
+            }
+            if (isTemporary(err)) {
+                if (confirm(errorMessage(err) + "\nDo you want to retry?")) {
+                    getExchangeRate(asset, cost);
+                    return;
+                }
+            } else {
+                alert(errorMessage(err));
+            }
+            reset();
+        });
+}
+
+function requote() {
+    $("#modal-overlay").fadeOut('slow');
+    getExchangeRate(payinfo["asset"], payinfo["price"]);
+}
+
+function setExchangeRate() {
+  let offer = payinfo["offer"];
+  if (offer) {
+    for (var key in offer) {
+      $("#"+key+"-btn")
+        .off("click")
+        .removeClass("btn-payable")
+        .addClass("btn-unpayable")
+        .prop("disabled", true);
+      $("#"+key+"-remainder").removeClass("points-negative");
+      var total_cost = offer[key].cost + offer[key].fee;
+      var balance = wallets[key].point;
+      $("#"+key+"-remainder").empty();
+      var remainder = balance-total_cost;
+      $("#"+key+"-remainder").text(total_cost+" ("+remainder+")");
+      if (total_cost <= balance) {
+        $("#"+key+"-btn")
+          .removeClass("btn-unpayable")
+          .addClass("btn-payable")
+          .prop("disabled", false)
+          .click(confirmExchange);
+        $("#"+key+"-pointer").show();
+      }
+      else {
+        $("#"+key+"-remainder").addClass("points-negative");
+      }
+    }
+  }
+}
+
+function confirmExchange() {
+    payinfo["exasset"] = $(this).attr("data-key");
+    $("[data-key=\"mc\"]").empty();
+    let offer = payinfo["offer"][payinfo["exasset"]];
+    if (offer) {
+        $("#dest_address").text(payinfo["addr"]);
+        $("#bpoint").text(offer["cost"]);
+        $("#basset").text(payinfo["exasset"]);
+        $("#apoint").text(payinfo["price"]);
+        $("#aasset").text(payinfo["asset"]);
+        $("#fpoint").text(offer["fee"]);
+        $("#fasset").text(payinfo["exasset"]);
+        $("#tpoint").text(offer["cost"] + offer["fee"]);
+        $("#tasset").text(payinfo["exasset"]);
+        $("#modal-confirm").show();
+        $("#modal-overlay").fadeIn('slow');
+    }
+}
+
+function cancelpay() {
+    $("#modal-overlay").fadeOut('slow');
+    reset();
+}
+
+function okpay() {
+    $("#modal-confirm").hide();
+    sendPayment(false);
+}
+
+// sendPayment sends the confirmed offer, retried once with a new CSRF token if it was refused.
+function sendPayment(retried) {
+    let offer = payinfo["offer"][payinfo["exasset"]];
+    let id = offer["id"];
+    let token = offer["token"];
+    let addr = payinfo["addr"];
+    if (id && token && addr) {
+        $.post("send", { id: "" + id, addr: "" + addr, token: "" + token }, null, "json")
+            .done(function (res) {
+                $("#modal-thank").show();
+            })
+            .fail(function (jqXHR, textStatus, errorThrown) {
+                let err = apiError(jqXHR, textStatus);
+                switch (err.code) {
+                case "unauthorized":
+                    login(function () { sendPayment(retried); });
+                    return;
+                case "forbidden":
+                    if (!retried) {
+                        loadSession(function () { sendPayment(true); });
+                        return;
+                    }
+                    alert(errorMessage(err));
+                    break;
+                case "conflict":
+                case "not_found":
+                    // the quotation has changed or expired, the payment can be quoted again.
+                    if (confirm(errorMessage(err) + "\nDo you want to get a new quotation?")) {
+                        requote();
+                        return;
+                    }
+                    break;
+                default:
+                    alert(errorMessage(err));
+                }
+                reset();
+            });
+    } else {
+        alert("No payinfo:" + id + "," + addr);
+        reset();
+    }
+}
+
+// loadSession gets the CSRF token of the current session if any, and calls next.
+function loadSession(next) {
+    $.getJSON("session")
+        .done(function (session) {
+            csrf = session.csrf;
+        })
+        .always(next);
+}
+
+// login asks the API key of the wallet, starts a session and calls retry.
+function login(retry) {
+    let key = prompt("Login required. API key of the wallet:");
+    if (!key) {
+        return;
+    }
+    $.post("session", { key: key }, null, "json")
+        .done(function (session) {
+            csrf = session.csrf;
+            retry();
+        })
+        .fail(function (jqXHR, textStatus, errorThrown) {
+            alert(errorMessage(apiError(jqXHR, textStatus)));
+            login(retry);
+        });
+}
+
+// apiError returns the error body of a failed API call: {code, message, fields}.
+function apiError(jqXHR, textStatus) {
+    let res = jqXHR.responseJSON;
+    if (res && res.code) {
+        return res;
+    }
+    if (jqXHR.status == 0) {
+        return { code: "upstream_unavailable", message: "cannot reach the wallet (" + textStatus + ")" };
+    }
+    return { code: "internal_error", message: jqXHR.status + " " + textStatus };
+}
+
+// isTemporary reports whether the call may succeed when retried.
+function isTemporary(err) {
+    switch (err.code) {
+    case "upstream_unavailable":
+    case "node_unreachable":
+    case "node_not_ready":
+    case "timeout":
+        return true;
+    }
+    return false;
+}
+
+// errorMessage describes the error for the user by its code. (see lib/errors.go)
+function errorMessage(err) {
+    switch (err.code) {
+    case "unauthorized":
+        return "Login required.";
+    case "forbidden":
+        return "The request was refused, please reload the page.";
+    case "invalid_parameter":
+        return "Invalid request:\n" + (err.fields || []).map(function (f) {
+            return f.field + ": " + f.message;
+        }).join("\n");
+    case "invalid_address":
+        return "The payment address is invalid.";
+    case "insufficient_funds":
+        return "Not enough points in the wallet.";
+    case "conflict":
+        return "The exchange rate has changed.";
+    case "not_found":
+        return "The quotation is not available anymore.";
+    case "submission_rejected":
+    case "transaction_rejected":
+        return "The payment was rejected.\n" + err.message;
+    }
+    if (isTemporary(err)) {
+        return "The service is temporarily unavailable.";
+    }
+    return "Error: " + err.message;
+}
+
+$(init)
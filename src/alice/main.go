@@ -5,21 +5,22 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"democonf"
+	"elementstx"
 	"encoding/binary"
-	"encoding/json"
+	"encoding/hex"
+	"exchanger"
 	"fmt"
-	"io/ioutil"
 	"lib"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"rpc"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,22 +30,27 @@ type UserWalletInfoRequest struct {
 
 // UserOfferRequest is a structure that represents the web-form for "/offer" request.
 type UserOfferRequest struct {
-	Asset string `json:"asset"`
-	Cost  int64  `json:"cost"`
+	Asset string     `json:"asset" validate:"required,asset"`
+	Cost  rpc.Amount `json:"cost" validate:"required,min=1"`
 }
 
 // UserSendRequest is a structure that represents the web-form for "/send" request.
+// Token is the confirmation token of the offer, given by "/offer".
 type UserSendRequest struct {
-	ID   string `json:"id"`
-	Addr string `json:"addr"`
+	ID    string `json:"id" validate:"required,hex"`
+	Addr  string `json:"addr" validate:"required,address"`
+	Token string `json:"token" validate:"required,hex"`
 }
 
 // UserOfferResByAsset is a structure for UserOfferResponse.
+// Token must be sent back with ID to "/send" before Expiry. (unix time)
 type UserOfferResByAsset struct {
-	Fee         int64  `json:"fee"`
-	Cost        int64  `json:"cost"`
-	ID          string `json:"id"`
-	Transaction string `json:"-"`
+	Fee         rpc.Amount `json:"fee"`
+	Cost        rpc.Amount `json:"cost"`
+	ID          string     `json:"id"`
+	Token       string     `json:"token"`
+	Expiry      int64      `json:"expiry"`
+	Transaction string     `json:"-"`
 }
 
 // UserOfferResponse is a map that represents the response for "/offer" request.
@@ -63,8 +69,9 @@ type UserWalletInfoResponse struct {
 
 type quotation struct {
 	RequestAsset  string
-	RequestAmount int64
+	RequestAmount rpc.Amount
 	Offer         map[string]UserOfferResByAsset
+	Expiry        int64
 }
 
 func (e *quotation) getID() string {
@@ -88,52 +95,49 @@ const (
 	defaultRPCUser       = "user"
 	defaultRPCPass       = "pass"
 	defaultLocalAddr     = ":8000"
-	defaultTxPath        = "elements-tx"
-	defaultTxOption      = ""
 	defaultTimeout       = 600
 	exchangerName        = "charlie"
 	defaultExchLocalAddr = ":8020"
+	defaultLockFile      = "alice_locks.json"
+	defaultQuoteFile     = "alice_quotes.json"
+	defaultConfirmTTL    = 120
 )
 
 var logger = log.New(os.Stdout, myActorName+":", log.LstdFlags+log.Lshortfile)
 var conf = democonf.NewDemoConf(myActorName)
 var assetIDMap = make(map[string]string)
-var lockList = make(rpc.LockList)
+var lockList *rpc.LockManager
 var rpcClient *rpc.Rpc
-var elementsTxCommand string
-var elementsTxOption string
 var localAddr string
 var quotationList = make(map[string]quotation)
+var quotationMu sync.Mutex // guards quotationList
+var quoteFile string
+var confirmTTL time.Duration
 var exchangerConf = democonf.NewDemoConf(exchangerName)
-var exchangeRateURL string
-var exchangeOfferWBURL string
-var exchangeOfferURL string
-var exchangeSubmitURL string
-
-var handlerList = map[string]interface{}{
-	"/walletinfo": doWalletInfo,
-	"/offer":      doOffer,
-	"/send":       doSend,
+var exchangerClient *exchanger.Client
+var httpConf lib.HTTPConfig
+var tlsConf lib.TLSConfig
+
+var handlerList = map[string]lib.Handler{
+	"/walletinfo": lib.HandleContext(doWalletInfo),
+	"/offer":      lib.HandleContext(doOffer),
+	"/send":       lib.HandleContext(doSend).Methods("POST"),
 }
 
-func getMyBalance() (rpc.BalanceMap, error) {
-	wallet, err := getWalletInfo()
-	if err != nil {
-		logger.Println("error:", err)
-		return nil, err
-	}
-	chooseKnownAssets(wallet.Balance)
+func getMyBalance(node *rpc.Rpc) (rpc.BalanceMap, error) {
+	balance, err := node.GetBalance()
 	if err != nil {
-		logger.Println("error:", err)
+		logger.Println("RPC/getbalance error:", err)
 		return nil, err
 	}
-	return wallet.Balance, nil
+	chooseKnownAssets(balance)
+	return balance, nil
 }
 
-func doWalletInfo(reqForm UserWalletInfoRequest) (UserWalletInfoResponse, error) {
+func doWalletInfo(ctx context.Context, reqForm UserWalletInfoRequest) (UserWalletInfoResponse, error) {
 	var walletInfoRes UserWalletInfoResponse
 
-	balance, err := getMyBalance()
+	balance, err := getMyBalance(rpcClient.WithContext(ctx))
 	if err != nil {
 		logger.Println("error:", err)
 		return walletInfoRes, err
@@ -153,14 +157,14 @@ func chooseKnownAssets(b rpc.BalanceMap) {
 	return
 }
 
-func doOffer(userOfferRequest UserOfferRequest) (UserOfferResponse, error) {
+func doOffer(ctx context.Context, userOfferRequest UserOfferRequest) (UserOfferResponse, error) {
 	userOfferResponse := make(UserOfferResponse)
 	var quot quotation
 
 	requestAsset := userOfferRequest.Asset
 	requestAmount := userOfferRequest.Cost
 
-	balance, err := getMyBalance()
+	balance, err := getMyBalance(rpcClient.WithContext(ctx))
 	if err != nil {
 		logger.Println("error:", err)
 		return nil, err
@@ -168,13 +172,21 @@ func doOffer(userOfferRequest UserOfferRequest) (UserOfferResponse, error) {
 	quot.RequestAsset = requestAsset
 	quot.RequestAmount = requestAmount
 	quot.Offer = make(map[string]UserOfferResByAsset)
+	quot.Expiry = time.Now().Add(confirmTTL).Unix()
 	offerExists := false
+	var lastErr error
 	for offerAsset := range balance {
 		if offerAsset == requestAsset {
 			continue
 		}
-		exchangeOffer, err := getexchangerate(requestAsset, requestAmount, offerAsset)
+		exchangeOffer, err := getexchangerate(ctx, requestAsset, requestAmount, offerAsset)
 		if err != nil {
+			switch lib.ErrorCode(err) {
+			case lib.CodeNotFound, lib.CodeBadRequest:
+				// no rate of the pair, or the cost out of its range.
+			default:
+				lastErr = err
+			}
 			continue
 		}
 		offerExists = true
@@ -182,171 +194,196 @@ func doOffer(userOfferRequest UserOfferRequest) (UserOfferResponse, error) {
 			Fee:         exchangeOffer.Fee,
 			Cost:        exchangeOffer.Cost,
 			ID:          exchangeOffer.GetID(),
+			Token:       newConfirmToken(),
+			Expiry:      quot.Expiry,
 			Transaction: "",
 		}
 		userOfferResponse[offerAsset] = offerByAsset
 		quot.Offer[offerAsset] = offerByAsset
 	}
 	if offerExists {
+		quotationMu.Lock()
 		quotationList[quot.getID()] = quot
+		quotationMu.Unlock()
+	} else if lastErr != nil {
+		logger.Println("error:", lastErr)
+		return nil, lastErr
 	}
 
 	return userOfferResponse, nil
 }
 
-func appendTransactionInfo(sendToAddr string, sendAsset string, sendAmount int64, offerAsset string, offerDetail UserOfferResByAsset, utxos rpc.UnspentList) (string, error) {
-	template := offerDetail.Transaction
-	cost := offerDetail.Cost
+// getChange returns the change of utxos after paying cost and fee of the offer.
+func getChange(utxos rpc.UnspentList, offerDetail UserOfferResByAsset) (rpc.Amount, error) {
+	total, err := offerDetail.Cost.Add(offerDetail.Fee)
+	if err != nil {
+		return 0, err
+	}
+	return utxos.GetAmount().Sub(total)
+}
+
+func appendTransactionInfo(node *rpc.Rpc, sendToAddr string, sendAsset string, sendAmount rpc.Amount, offerAsset string, offerDetail UserOfferResByAsset, utxos rpc.UnspentList) (string, error) {
 	fee := offerDetail.Fee
-	change := utxos.GetAmount() - (cost + fee)
-	params := []string{}
+	change, err := getChange(utxos, offerDetail)
+	if err != nil {
+		return "", err
+	}
 
-	if elementsTxOption != "" {
-		params = append(params, elementsTxOption)
+	builder, err := elementstx.NewBuilderFromHex(offerDetail.Transaction, elementstx.RegtestParams)
+	if err != nil {
+		return "", err
 	}
-	params = append(params, template)
 
 	for _, u := range utxos {
-		txin := "in=" + u.Txid + ":" + strconv.FormatInt(u.Vout, 10)
-		params = append(params, txin)
+		err = builder.AddInput(u.Txid, u.Vout)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	if 0 < change {
-		addrChange, err := rpcClient.GetNewAddr(false)
+		addrChange, err := node.GetNewAddr(false)
+		if err != nil {
+			return "", err
+		}
+		err = builder.AddOutputAddr(int64(change), addrChange, assetIDMap[offerAsset])
 		if err != nil {
 			return "", err
 		}
-		outAddrChange := "outaddr=" + strconv.FormatInt(change, 10) + ":" + addrChange + ":" + assetIDMap[offerAsset]
-		params = append(params, outAddrChange)
 	}
-	outAddrSend := "outaddr=" + strconv.FormatInt(sendAmount, 10) + ":" + sendToAddr + ":" + assetIDMap[sendAsset]
-	outAddrFee := "outscript=" + strconv.FormatInt(fee, 10) + "::" + assetIDMap[offerAsset]
-	params = append(params, outAddrSend, outAddrFee)
-
-	out, err := exec.Command(elementsTxCommand, params...).Output()
-
+	err = builder.AddOutputAddr(int64(sendAmount), sendToAddr, assetIDMap[sendAsset])
+	if err != nil {
+		return "", err
+	}
+	err = builder.AddFeeOutput(int64(fee), assetIDMap[offerAsset])
 	if err != nil {
-		logger.Println("elements-tx error:", err, params, out)
 		return "", err
 	}
 
-	txTemplate := strings.TrimRight(string(out), "\n")
-	return txTemplate, nil
+	return builder.Hex(), nil
 }
 
-func appendTransactionInfoWB(sendToAddr string, sendAsset string, sendAmount int64, offerAsset string, offerDetail UserOfferResByAsset, utxos rpc.UnspentList, loopbackUtxos rpc.UnspentList) (string, error) {
-	template := offerDetail.Transaction
-	cost := offerDetail.Cost
-	fee := offerDetail.Fee
-	change := utxos.GetAmount() - (cost + fee)
-	params := []string{}
+func appendTransactionInfoWB(node *rpc.Rpc, sendToAddr string, sendAsset string, sendAmount rpc.Amount, offerAsset string, offerDetail UserOfferResByAsset, utxos rpc.UnspentList, loopbackUtxos rpc.UnspentList) (string, error) {
+	change, err := getChange(utxos, offerDetail)
+	if err != nil {
+		return "", err
+	}
 	lbChange := loopbackUtxos.GetAmount()
 
-	if elementsTxOption != "" {
-		params = append(params, elementsTxOption)
+	builder, err := elementstx.NewBuilderFromHex(offerDetail.Transaction, elementstx.RegtestParams)
+	if err != nil {
+		return "", err
 	}
-	params = append(params, template)
 
 	for _, u := range utxos {
-		txin := "in=" + u.Txid + ":" + strconv.FormatInt(u.Vout, 10)
-		params = append(params, txin)
+		err = builder.AddInput(u.Txid, u.Vout)
+		if err != nil {
+			return "", err
+		}
 	}
 	for _, u := range loopbackUtxos {
-		txin := "in=" + u.Txid + ":" + strconv.FormatInt(u.Vout, 10)
-		params = append(params, txin)
+		err = builder.AddInput(u.Txid, u.Vout)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	if 0 < change {
-		addrChange, err := rpcClient.GetNewAddr(true)
+		addrChange, err := node.GetNewAddr(true)
+		if err != nil {
+			return "", err
+		}
+		err = builder.AddOutputAddr(int64(change), addrChange, assetIDMap[offerAsset])
 		if err != nil {
 			return "", err
 		}
-		outAddrChange := "outaddr=" + strconv.FormatInt(change, 10) + ":" + addrChange + ":" + assetIDMap[offerAsset]
-		params = append(params, outAddrChange)
 	}
 	if 0 < lbChange {
-		addrLbChange, err := rpcClient.GetNewAddr(true)
+		addrLbChange, err := node.GetNewAddr(true)
+		if err != nil {
+			return "", err
+		}
+		err = builder.AddOutputAddr(int64(lbChange), addrLbChange, assetIDMap[sendAsset])
 		if err != nil {
 			return "", err
 		}
-		outAddrLbChange := "outaddr=" + strconv.FormatInt(lbChange, 10) + ":" + addrLbChange + ":" + assetIDMap[sendAsset]
-		params = append(params, outAddrLbChange)
 	}
-	outAddrSend := "outaddr=" + strconv.FormatInt(sendAmount, 10) + ":" + sendToAddr + ":" + assetIDMap[sendAsset]
-	params = append(params, outAddrSend)
-
-	out, err := exec.Command(elementsTxCommand, params...).Output()
-
+	err = builder.AddOutputAddr(int64(sendAmount), sendToAddr, assetIDMap[sendAsset])
 	if err != nil {
-		logger.Println("elements-tx error:", err, params, out)
 		return "", err
 	}
 
-	txTemplate := strings.TrimRight(string(out), "\n")
-	return txTemplate, nil
+	return builder.Hex(), nil
 }
 
-func doSend(reqForm UserSendRequest) (UserSendResponse, error) {
+func doSend(ctx context.Context, reqForm UserSendRequest) (UserSendResponse, error) {
 	var userSendResponse UserSendResponse
+	node := rpcClient.WithContext(ctx)
 
 	offerID := reqForm.ID
 	sendToAddr := reqForm.Addr
-	isConfidential, err := isConfidential(sendToAddr)
+	// consumed at once, so the offer is sent only once even by concurrent requests.
+	quot, offerAsset, err := takeQuotation(offerID, reqForm.Token)
+	if err != nil {
+		logger.Println("error:", err)
+		return userSendResponse, err
+	}
+	isConfidential, err := isConfidential(node, sendToAddr)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 
 	if isConfidential {
-		userSendResponse, err = doSendWithBlinding(offerID, sendToAddr)
+		userSendResponse, err = doSendWithBlinding(node, offerID, quot, offerAsset, sendToAddr)
 	} else {
-		userSendResponse, err = doSendWithNoBlinding(offerID, sendToAddr)
+		userSendResponse, err = doSendWithNoBlinding(node, offerID, quot, offerAsset, sendToAddr)
 	}
 
 	return userSendResponse, err
 }
 
-func doSendWithBlinding(offerID string, sendToAddr string) (UserSendResponse, error) {
+func doSendWithBlinding(node *rpc.Rpc, offerID string, quot quotation, offerAsset string, sendToAddr string) (UserSendResponse, error) {
 	var userSendResponse UserSendResponse
 
-	quotationID, offerAsset, err := getQuotation(quotationList, offerID)
-	if err != nil {
-		logger.Println("error:", err)
-		return userSendResponse, err
-	}
-
-	offerDetail := quotationList[quotationID].Offer[offerAsset]
-	sendAsset := quotationList[quotationID].RequestAsset
-	sendAmount := quotationList[quotationID].RequestAmount
+	offerDetail := quot.Offer[offerAsset]
+	sendAsset := quot.RequestAsset
+	sendAmount := quot.RequestAmount
 
-	ofutxos, err := rpcClient.SearchUnspent(lockList, offerAsset, offerDetail.Cost+offerDetail.Fee, true)
+	ofutxos, err := node.SearchUnspent(lockList, offerAsset, offerDetail.Cost+offerDetail.Fee, true)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
-	sautxos, err := rpcClient.SearchMinimalUnspent(lockList, sendAsset, true)
+	sautxos, err := node.SearchMinimalUnspent(lockList, sendAsset, true)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 
 	cmutxos := append(ofutxos, sautxos...)
-	commitments, err := rpcClient.GetCommitments(cmutxos)
+	commitments, err := node.GetCommitments(cmutxos)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 
-	exchangeOffer, err := getexchangeofferwb(sendAsset, sendAmount, offerAsset, commitments)
+	exchangeOffer, err := getexchangeofferwb(node.Context(), sendAsset, sendAmount, offerAsset, commitments)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
+	defer func() {
+		if err != nil {
+			// not cancelled with the request, charlie should release the offer anyway.
+			cancelexchange(context.Background(), exchangeOffer.ID)
+		}
+	}()
 
 	if (offerDetail.Cost != exchangeOffer.Cost) ||
 		(offerDetail.Fee != exchangeOffer.Fee) {
-		err = fmt.Errorf("quotation has changed: old (cost:%d, fee:%d) => new (cost:%d, fee:%d)",
-			offerDetail.Cost, offerDetail.Fee, exchangeOffer.Cost, exchangeOffer.Fee)
+		err = fmt.Errorf("%w: quotation has changed: old (cost:%s, fee:%s) => new (cost:%s, fee:%s)",
+			lib.ErrConflict, offerDetail.Cost, offerDetail.Fee, exchangeOffer.Cost, exchangeOffer.Fee)
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
@@ -354,26 +391,26 @@ func doSendWithBlinding(offerID string, sendToAddr string) (UserSendResponse, er
 	offerDetail.Transaction = exchangeOffer.Transaction
 	commitments = append(exchangeOffer.Commitments, commitments...)
 
-	tx, err := appendTransactionInfoWB(sendToAddr, sendAsset, sendAmount, offerAsset, offerDetail, ofutxos, sautxos)
+	tx, err := appendTransactionInfoWB(node, sendToAddr, sendAsset, sendAmount, offerAsset, offerDetail, ofutxos, sautxos)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 
-	blindtx, _, err := rpcClient.RequestAndCastString("blindrawtransaction", tx, true, commitments)
+	blindtx, err := node.BlindRawTransaction(tx, true, commitments)
 	if err != nil {
 		logger.Println("RPC/blindrawtransaction error:", err, tx)
 		return userSendResponse, err
 	}
 
 	var signedtx rpc.SignedTransaction
-	_, err = rpcClient.RequestAndUnmarshalResult(&signedtx, "signrawtransaction", blindtx)
+	signedtx, err = node.SignRawTransaction(blindtx)
 	if err != nil {
 		logger.Println("RPC/signrawtransaction error:", err, blindtx)
 		return userSendResponse, err
 	}
 
-	submitRes, err := submitexchange(signedtx.Hex)
+	submitRes, err := submitexchange(node.Context(), exchangeOffer.ID, signedtx.Hex)
 	if err != nil {
 		userSendResponse.Result = false
 		userSendResponse.Message = fmt.Sprintf("fail ADDR:%s TxID:%s\nerr:%#v", sendToAddr, offerID, err)
@@ -382,62 +419,60 @@ func doSendWithBlinding(offerID string, sendToAddr string) (UserSendResponse, er
 		userSendResponse.Message = fmt.Sprintf("success ADDR:%s TxID:%s", sendToAddr, submitRes.TransactionID)
 	}
 
-	delete(quotationList, quotationID)
-
 	lockList.UnlockUnspentList(cmutxos)
 
 	return userSendResponse, err
 }
 
-func doSendWithNoBlinding(offerID string, sendToAddr string) (UserSendResponse, error) {
+func doSendWithNoBlinding(node *rpc.Rpc, offerID string, quot quotation, offerAsset string, sendToAddr string) (UserSendResponse, error) {
 	var userSendResponse UserSendResponse
 
-	quotationID, offerAsset, err := getQuotation(quotationList, offerID)
-	if err != nil {
-		logger.Println("error:", err)
-		return userSendResponse, err
-	}
-
-	offerDetail := quotationList[quotationID].Offer[offerAsset]
-	sendAsset := quotationList[quotationID].RequestAsset
-	sendAmount := quotationList[quotationID].RequestAmount
+	offerDetail := quot.Offer[offerAsset]
+	sendAsset := quot.RequestAsset
+	sendAmount := quot.RequestAmount
 
-	exchangeOffer, err := getexchangeoffer(sendAsset, sendAmount, offerAsset)
+	exchangeOffer, err := getexchangeoffer(node.Context(), sendAsset, sendAmount, offerAsset)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
+	defer func() {
+		if err != nil {
+			// not cancelled with the request, charlie should release the offer anyway.
+			cancelexchange(context.Background(), exchangeOffer.ID)
+		}
+	}()
 
 	if (offerDetail.Cost != exchangeOffer.Cost) ||
 		(offerDetail.Fee != exchangeOffer.Fee) {
-		err = fmt.Errorf("quotation has changed: old (cost:%d, fee:%d) => new (cost:%d, fee:%d)",
-			offerDetail.Cost, offerDetail.Fee, exchangeOffer.Cost, exchangeOffer.Fee)
+		err = fmt.Errorf("%w: quotation has changed: old (cost:%s, fee:%s) => new (cost:%s, fee:%s)",
+			lib.ErrConflict, offerDetail.Cost, offerDetail.Fee, exchangeOffer.Cost, exchangeOffer.Fee)
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 	offerDetail.ID = exchangeOffer.GetID()
 	offerDetail.Transaction = exchangeOffer.Transaction
 
-	utxos, err := rpcClient.SearchUnspent(lockList, offerAsset, offerDetail.Cost+offerDetail.Fee, false)
+	utxos, err := node.SearchUnspent(lockList, offerAsset, offerDetail.Cost+offerDetail.Fee, false)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 
-	tx, err := appendTransactionInfo(sendToAddr, sendAsset, sendAmount, offerAsset, offerDetail, utxos)
+	tx, err := appendTransactionInfo(node, sendToAddr, sendAsset, sendAmount, offerAsset, offerDetail, utxos)
 	if err != nil {
 		logger.Println("error:", err)
 		return userSendResponse, err
 	}
 
 	var signedtx rpc.SignedTransaction
-	_, err = rpcClient.RequestAndUnmarshalResult(&signedtx, "signrawtransaction", tx)
+	signedtx, err = node.SignRawTransaction(tx)
 	if err != nil {
 		logger.Println("RPC/signrawtransaction error:", err, tx)
 		return userSendResponse, err
 	}
 
-	submitRes, err := submitexchange(signedtx.Hex)
+	submitRes, err := submitexchange(node.Context(), exchangeOffer.ID, signedtx.Hex)
 	if err != nil {
 		userSendResponse.Result = false
 		userSendResponse.Message = fmt.Sprintf("fail ADDR:%s TxID:%s\nerr:%#v", sendToAddr, offerID, err)
@@ -446,8 +481,6 @@ func doSendWithNoBlinding(offerID string, sendToAddr string) (UserSendResponse,
 		userSendResponse.Message = fmt.Sprintf("success ADDR:%s TxID:%s", sendToAddr, submitRes.TransactionID)
 	}
 
-	delete(quotationList, quotationID)
-
 	lockList.UnlockUnspentList(utxos)
 
 	return userSendResponse, err
@@ -472,33 +505,60 @@ func getQuotation(ql map[string]quotation, offerID string) (string, string, erro
 		}
 	}
 	if !found {
-		return "", "", fmt.Errorf("offerID not found [%s]", offerID)
+		return "", "", fmt.Errorf("%w: offerID [%s]", lib.ErrNotFound, offerID)
 	}
 	return quotationID, offerAsset, nil
 }
 
-func getWalletInfo() (rpc.Wallet, error) {
-	var walletInfo rpc.Wallet
-
-	_, err := rpcClient.RequestAndUnmarshalResult(&walletInfo, "getwalletinfo")
+// takeQuotation removes the quotation of the offer offerID, issued by "/offer", if the confirmation token matches.
+func takeQuotation(offerID string, token string) (quotation, string, error) {
+	quotationMu.Lock()
+	defer quotationMu.Unlock()
+	quotationID, offerAsset, err := getQuotation(quotationList, offerID)
 	if err != nil {
-		logger.Println("RPC/getwalletinfo error:", err)
-		return walletInfo, err
+		return quotation{}, "", err
+	}
+	quot := quotationList[quotationID]
+	if quot.Expiry < time.Now().Unix() {
+		delete(quotationList, quotationID)
+		return quotation{}, "", fmt.Errorf("%w: quotation expired [%s]", lib.ErrNotFound, offerID)
 	}
+	if subtle.ConstantTimeCompare([]byte(quot.Offer[offerAsset].Token), []byte(token)) != 1 {
+		return quotation{}, "", fmt.Errorf("%w: invalid confirmation token of offer [%s]", lib.ErrBadRequest, offerID)
+	}
+	delete(quotationList, quotationID)
+	return quot, offerAsset, nil
+}
 
-	return walletInfo, nil
+// sweepQuotations removes the expired quotations, which "/send" would refuse, as abandoned offers are never taken.
+func sweepQuotations() {
+	now := time.Now().Unix()
+	quotationMu.Lock()
+	defer quotationMu.Unlock()
+	for id, quot := range quotationList {
+		if quot.Expiry < now {
+			delete(quotationList, id)
+		}
+	}
 }
 
-func isConfidential(addr string) (bool, error) {
-	var validAddr rpc.ValidatedAddress
+func newConfirmToken() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		logger.Println("rand#Read error:", err)
+	}
+	return hex.EncodeToString(b)
+}
 
-	_, err := rpcClient.RequestAndUnmarshalResult(&validAddr, "validateaddress", addr)
+func isConfidential(node *rpc.Rpc, addr string) (bool, error) {
+	validAddr, err := node.ValidateAddress(addr)
 	if err != nil {
 		return false, err
 	}
 
 	if !validAddr.IsValid {
-		return false, fmt.Errorf("invalid address [%s]", addr)
+		return false, fmt.Errorf("%w [%s]", lib.ErrInvalidAddress, addr)
 	}
 
 	if addr == validAddr.Unconfidential {
@@ -508,126 +568,133 @@ func isConfidential(addr string) (bool, error) {
 	return true, nil
 }
 
-func getexchangerate(requestAsset string, requestAmount int64, offerAsset string) (lib.ExchangeRateResponse, error) {
-	var rateRes lib.ExchangeRateResponse
-	var rateReq lib.ExchangeRateRequest
-	rateReq.Request = make(map[string]int64)
-	rateReq.Request[requestAsset] = requestAmount
-	rateReq.Offer = offerAsset
+func getexchangerate(ctx context.Context, requestAsset string, requestAmount rpc.Amount, offerAsset string) (lib.ExchangeRateResponse, error) {
+	rateRes, err := exchangerClient.GetRate(ctx, lib.ExchangeRateRequest{
+		Request: map[string]rpc.Amount{requestAsset: requestAmount},
+		Offer:   offerAsset,
+	})
+	return rateRes, exchangerError(err)
+}
 
-	_, err := callExchangerAPI(exchangeRateURL, rateReq, &rateRes)
+func getexchangeofferwb(ctx context.Context, requestAsset string, requestAmount rpc.Amount, offerAsset string, commitments []string) (lib.ExchangeOfferWBResponse, error) {
+	offerRes, err := exchangerClient.GetOfferWB(ctx, lib.ExchangeOfferWBRequest{
+		Request:     map[string]rpc.Amount{requestAsset: requestAmount},
+		Offer:       offerAsset,
+		Commitments: commitments,
+	})
+	return offerRes, exchangerError(err)
+}
 
-	if err != nil {
-		logger.Println("json#Marshal error:", err, rateRes)
-	}
-	return rateRes, err
+func getexchangeoffer(ctx context.Context, requestAsset string, requestAmount rpc.Amount, offerAsset string) (lib.ExchangeOfferResponse, error) {
+	offerRes, err := exchangerClient.GetOffer(ctx, lib.ExchangeOfferRequest{
+		Request: map[string]rpc.Amount{requestAsset: requestAmount},
+		Offer:   offerAsset,
+	})
+	return offerRes, exchangerError(err)
 }
 
-func getexchangeofferwb(requestAsset string, requestAmount int64, offerAsset string, commitments []string) (lib.ExchangeOfferWBResponse, error) {
-	var offerRes lib.ExchangeOfferWBResponse
-	var offerReq lib.ExchangeOfferWBRequest
-	offerReq.Request = make(map[string]int64)
-	offerReq.Request[requestAsset] = requestAmount
-	offerReq.Offer = offerAsset
-	offerReq.Commitments = commitments
+func submitexchange(ctx context.Context, id string, tx string) (lib.SubmitExchangeResponse, error) {
+	submitRes, err := exchangerClient.Submit(ctx, lib.SubmitExchangeRequest{ID: id, Transaction: tx})
+	return submitRes, exchangerError(err)
+}
 
-	_, err := callExchangerAPI(exchangeOfferWBURL, offerReq, &offerRes)
+func cancelexchange(ctx context.Context, id string) (lib.CancelExchangeResponse, error) {
+	cancelRes, err := exchangerClient.Cancel(ctx, lib.CancelExchangeRequest{ID: id})
+	return cancelRes, exchangerError(err)
+}
 
+// exchangerError logs err of the exchanger, whose codes are passed through to the user.
+// (a refusal of alice's credentials is already upstream_unavailable, see exchanger.Client)
+func exchangerError(err error) error {
 	if err != nil {
-		logger.Println("json#Marshal error:", err)
+		logger.Println("exchanger error:", err)
 	}
-	return offerRes, err
+	return err
 }
 
-func getexchangeoffer(requestAsset string, requestAmount int64, offerAsset string) (lib.ExchangeOfferResponse, error) {
-	var offerRes lib.ExchangeOfferResponse
-	var offerReq lib.ExchangeOfferRequest
-	offerReq.Request = make(map[string]int64)
-	offerReq.Request[requestAsset] = requestAmount
-	offerReq.Offer = offerAsset
-
-	_, err := callExchangerAPI(exchangeOfferURL, offerReq, &offerRes)
+func initialize() {
+	logger = log.New(os.Stdout, myActorName+":", log.LstdFlags+log.Lshortfile)
+	lib.SetLogger(logger)
+	rpc.SetLogger(logger)
 
+	rpcConf := rpc.Config{
+		URL:         conf.GetString("rpcurl", defaultRPCURL),
+		User:        conf.GetString("rpcuser", defaultRPCUser),
+		Pass:        conf.GetString("rpcpass", defaultRPCPass),
+		CookieFile:  conf.GetString("rpccookie", ""),
+		SecretsFile: conf.GetString("rpcsecrets", ""),
+		SecretsName: myActorName,
+		Wallet:      conf.GetString("rpcwallet", ""),
+		RecordFile:  conf.GetString("rpcrecord", ""),
+	}
+	var err error
+	rpcClient, err = rpc.NewRpcWithConfig(rpcConf)
 	if err != nil {
-		logger.Println("json#Marshal error:", err)
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
 	}
-	return offerRes, err
-}
-
-func submitexchange(tx string) (lib.SubmitExchangeResponse, error) {
-	var submitReq lib.SubmitExchangeRequest
-	var submitRes lib.SubmitExchangeResponse
-	submitReq.Transaction = tx
-
-	_, err := callExchangerAPI(exchangeSubmitURL, submitReq, &submitRes)
+	labels, err := rpcClient.DumpAssetLabels()
+	if err != nil {
+		logger.Println("RPC/dumpassetlabels error:", err)
+	} else {
+		assetIDMap = labels
+	}
+	delete(assetIDMap, "bitcoin")
 
+	rpcClient.Selector, err = rpc.NewCoinSelector(conf.GetString("coinselect", rpc.SelectLargestFirst))
 	if err != nil {
-		logger.Println("json#Marshal error:", err)
+		logger.Println("error:", err)
+		rpcClient.Selector = rpc.LargestFirst{}
 	}
-	return submitRes, err
-}
 
-func callExchangerAPI(targetURL string, param interface{}, result interface{}) (*http.Response, error) {
-	encodedRequest, err := json.Marshal(param)
+	localAddr = conf.GetString("laddr", defaultLocalAddr)
+	lockList = rpc.NewLockManager(myActorName, time.Duration(int64(conf.GetNumber("timeout", defaultTimeout)))*time.Second)
+	err = lockList.Load(conf.GetString("lockfile", defaultLockFile))
 	if err != nil {
-		logger.Println("json#Marshal error:", err)
-		return nil, err
+		logger.Println("error:", err)
 	}
-	client := &http.Client{}
-	reqBody := string(encodedRequest)
-	req, err := http.NewRequest("POST", targetURL, bytes.NewBufferString(reqBody))
-	req.Header.Set("Content-Type", "text/plain")
-	logger.Println(req)
+	if conf.GetBool("locknode", false) {
+		lockList.Mirror(rpcClient)
+	}
+	quoteFile = conf.GetString("quotefile", defaultQuoteFile)
+	confirmTTL = time.Duration(int64(conf.GetNumber("confirmttl", defaultConfirmTTL))) * time.Second
+	err = lib.LoadState(quoteFile, &quotationList)
 	if err != nil {
-		logger.Println("http#NewRequest error", err)
-		return nil, err
+		logger.Println("error:", err)
 	}
-	res, err := client.Do(req)
-	logger.Println(res)
+
+	conf.GetInterface("http", &httpConf)
+	conf.GetInterface("tls", &tlsConf)
+	err = tlsConf.Load(myActorName)
 	if err != nil {
-		logger.Println("http.Client#Do error:", err)
-		return nil, err
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
 	}
-	body, err := ioutil.ReadAll(res.Body)
-	defer func() {
-		e := res.Body.Close()
-		if e != nil {
-			logger.Println("error:", e)
-		}
-	}()
+	clientConf, err := tlsConf.ClientConfig()
 	if err != nil {
-		logger.Println("ioutil#ReadAll error:", err)
-		return res, err
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
 	}
-	err = json.Unmarshal(body, result)
-
-	return res, err
-}
-
-func initialize() {
-	logger = log.New(os.Stdout, myActorName+":", log.LstdFlags+log.Lshortfile)
-	lib.SetLogger(logger)
 
-	rpcClient = rpc.NewRpc(
-		conf.GetString("rpcurl", defaultRPCURL),
-		conf.GetString("rpcuser", defaultRPCUser),
-		conf.GetString("rpcpass", defaultRPCPass))
-	_, err := rpcClient.RequestAndUnmarshalResult(&assetIDMap, "dumpassetlabels")
+	// https if the exchanger serves it, unless the URL is configured.
+	var exTLSConf lib.TLSConfig
+	exchangerConf.GetInterface("tls", &exTLSConf)
+	scheme := "http"
+	if exTLSConf.Serve {
+		scheme = "https"
+	}
+	exLocalAddr := conf.GetString("exchangerurl", scheme+"://127.0.0.1"+exchangerConf.GetString("laddr", defaultExchLocalAddr))
+	exchangerClient = exchanger.NewClient(exLocalAddr)
+	exchangerClient.HTTPClient = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: clientConf,
+	}}
+	exchangerClient.Name = conf.GetString("exchangerkey", myActorName)
+	exchangerClient.Secret, err = lib.ResolveSecret(conf.GetString("exchangersecret", ""))
 	if err != nil {
-		logger.Println("RPC/dumpassetlabels error:", err)
+		logger.Println("error: exchangersecret:", err)
+		os.Exit(lib.ExitFailure)
 	}
-	delete(assetIDMap, "bitcoin")
-
-	localAddr = conf.GetString("laddr", defaultLocalAddr)
-	elementsTxCommand = conf.GetString("txpath", defaultTxPath)
-	elementsTxOption = conf.GetString("txoption", defaultTxOption)
-	rpc.SetUtxoLockDuration(time.Duration(int64(conf.GetNumber("timeout", defaultTimeout))) * time.Second)
-
-	exLocalAddr := "http://127.0.0.1" + exchangerConf.GetString("laddr", defaultExchLocalAddr)
-	exchangeRateURL = exLocalAddr + "/getexchangerate/"
-	exchangeOfferWBURL = exLocalAddr + "/getexchangeofferwb/"
-	exchangeOfferURL = exLocalAddr + "/getexchangeoffer/"
-	exchangeSubmitURL = exLocalAddr + "/submitexchange/"
 }
 
 func main() {
@@ -636,25 +703,55 @@ func main() {
 	dir, err := os.Getwd()
 	if err != nil {
 		logger.Println("error:", err)
-		return
+		os.Exit(lib.ExitFailure)
 	}
-	listener, err := lib.StartHTTPServer(localAddr, handlerList, dir+"/html/"+myActorName)
+	mws, auth, err := httpConf.Middlewares()
 	if err != nil {
 		logger.Println("error:", err)
-		return
+		os.Exit(lib.ExitFailure)
 	}
-	defer func() {
-		e := listener.Close()
-		if e != nil {
-			logger.Println("error:", e)
+	handler, err := lib.NewHTTPHandler(handlerList, dir+"/html/"+myActorName, mws...)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+
+	lc := lib.NewLifecycle(myActorName)
+	lc.Close("rpc", rpcClient)
+	lc.OnStop("locks", func(context.Context) error {
+		return lockList.Flush()
+	})
+	lc.OnStop("quotes", func(context.Context) error {
+		quotationMu.Lock()
+		defer quotationMu.Unlock()
+		return lib.SaveState(quoteFile, quotationList)
+	})
+	for _, job := range []lib.Job{
+		{Name: "locks", Interval: 3 * time.Second, Run: lib.JobFunc(lockList.Sweep)},
+		{Name: "quotations", Interval: 3 * time.Second, Run: lib.JobFunc(sweepQuotations)},
+	} {
+		err = lc.Schedule(job)
+		if err != nil {
+			logger.Println("error:", err)
+			os.Exit(lib.ExitFailure)
 		}
-	}()
-
-	_, err = lib.StartCyclic(lockList.Sweep, 3, true)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/jobs", lib.Chain(lc.Scheduler().Handler(), mws...))
+	if auth != nil {
+		mux.Handle(lib.SessionPath, auth.SessionHandler())
+	}
+	mux.Handle("/", handler)
+	serverConf, err := tlsConf.ServerConfig()
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	_, err = lc.ListenTLS(localAddr, lib.Chain(mux, lib.RequestID, lib.Recover), serverConf)
 	if err != nil {
 		logger.Println("error:", err)
-		return
+		os.Exit(lib.ExitFailure)
 	}
 
-	logger.Println(myActorName + " stop")
+	os.Exit(lc.Run())
 }
@@ -0,0 +1,191 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package main
+
+import (
+	"context"
+	"elementssim"
+	"elementstx"
+	"exchanger"
+	"fmt"
+	"lib"
+	"net/http/httptest"
+	"os"
+	"rpc"
+	"rpc/rpctest"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	lib.SetLogger(logger)
+	os.Exit(m.Run())
+}
+
+// newTestSim returns the chain of the test, set up the same way when recording and replaying,
+// so that the counterparts of alice see the chain she was recorded against.
+func newTestSim(t *testing.T) (*elementssim.Server, *httptest.Server) {
+	sim := elementssim.NewServer()
+	for _, name := range []string{"alice", "charlie", "dave"} {
+		err := sim.CreateWallet(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sim.AddAsset("AIRSKY")
+	sim.AddAsset("MELON")
+	for _, f := range []struct{ wallet, asset string }{{"alice", "AIRSKY"}, {"charlie", "MELON"}} {
+		_, err := sim.Fund(f.wallet, f.asset, 1000*elementstx.Coin, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return sim, server
+}
+
+func newSimNode(server *httptest.Server, wallet string) *rpc.Rpc {
+	node := rpc.NewRpc(server.URL, "", "")
+	node.Wallet = wallet
+	return node
+}
+
+// newTestCharlie returns a fake charlie quoting 100 AIRSKY and 1 AIRSKY of fee for any request,
+// and broadcasting the submitted transactions with his wallet.
+func newTestCharlie(t *testing.T, server *httptest.Server) *exchanger.Fake {
+	charlie := newSimNode(server, "charlie")
+	rate := func(offer string) lib.ExchangeRateResponse {
+		return lib.ExchangeRateResponse{AssetLabel: offer, Cost: 100 * rpc.Coin, Fee: rpc.Coin}
+	}
+	return &exchanger.Fake{
+		Rate: func(req lib.ExchangeRateRequest) (lib.ExchangeRateResponse, error) {
+			return rate(req.Offer), nil
+		},
+		Offer: func(req lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse, error) {
+			rateRes := rate(req.Offer)
+			res := lib.ExchangeOfferResponse{AssetLabel: rateRes.AssetLabel, Cost: rateRes.Cost, Fee: rateRes.Fee}
+			builder := elementstx.NewBuilder(elementstx.RegtestParams)
+			for asset, amount := range req.Request {
+				utxos, err := charlie.ListUnspent(rpc.ListUnspentOptions{MinConf: 1, Asset: asset})
+				if err != nil || len(utxos) == 0 {
+					return res, fmt.Errorf("no utxo of %s: %v", asset, err)
+				}
+				addrOffer, err := charlie.GetNewAddr(false)
+				if err != nil {
+					return res, err
+				}
+				addrChange, err := charlie.GetNewAddr(false)
+				if err != nil {
+					return res, err
+				}
+				err = builder.AddInput(utxos[0].Txid, utxos[0].Vout)
+				if err != nil {
+					return res, err
+				}
+				err = builder.AddOutputAddr(int64(res.Cost), addrOffer, assetIDMap[req.Offer])
+				if err != nil {
+					return res, err
+				}
+				err = builder.AddOutputAddr(int64(utxos[0].Amount-amount), addrChange, assetIDMap[asset])
+				if err != nil {
+					return res, err
+				}
+			}
+			res.Transaction = builder.Hex()
+			res.ID = res.GetID()
+			return res, nil
+		},
+		Submit: func(req lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error) {
+			var res lib.SubmitExchangeResponse
+			signed, err := charlie.SignRawTransaction(req.Transaction)
+			if err != nil {
+				return res, err
+			}
+			if !signed.Complete {
+				return res, fmt.Errorf("%w: not signed by alice", lib.ErrBadRequest)
+			}
+			res.TransactionID, err = charlie.SendRawTransaction(signed.Hex, true)
+			return res, err
+		},
+		Cancel: func(req lib.CancelExchangeRequest) (lib.CancelExchangeResponse, error) {
+			return lib.CancelExchangeResponse{ID: req.ID, State: "cancelled"}, nil
+		},
+	}
+}
+
+func TestDoSend(t *testing.T) {
+	sim, server := newTestSim(t)
+	rpcClient = rpctest.NewNode(t, server.URL, myActorName, "testdata/dosend.jsonl")
+	var err error
+	assetIDMap, err = rpcClient.DumpAssetLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(assetIDMap, "bitcoin")
+	lockList = rpc.NewLockManager(myActorName, time.Minute)
+	quotationList = make(map[string]quotation)
+	confirmTTL = time.Minute
+
+	fake := newTestCharlie(t, server)
+	fakeServer, err := exchanger.NewFakeServer(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeServer.Close()
+	exchangerClient = exchanger.NewClient(fakeServer.URL)
+
+	dave := newSimNode(server, "dave")
+	addr, err := dave.GetNewAddr(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offerRes, err := doOffer(context.Background(), UserOfferRequest{Asset: "MELON", Cost: 50 * rpc.Coin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, ok := offerRes["AIRSKY"]
+	if !ok || offer.Cost != 100*rpc.Coin || offer.Fee != rpc.Coin {
+		t.Fatalf("offer %+v", offerRes)
+	}
+	_, err = doSend(context.Background(), UserSendRequest{ID: offer.ID, Addr: addr, Token: "00"})
+	if lib.ErrorCode(err) != lib.CodeBadRequest {
+		t.Fatalf("send with a wrong token: %v", err)
+	}
+
+	sendRes, err := doSend(context.Background(), UserSendRequest{ID: offer.ID, Addr: addr, Token: offer.Token})
+	if err != nil || !sendRes.Result {
+		t.Fatalf("send: %+v %v", sendRes, err)
+	}
+	_, err = doSend(context.Background(), UserSendRequest{ID: offer.ID, Addr: addr, Token: offer.Token})
+	if lib.ErrorCode(err) != lib.CodeNotFound {
+		t.Errorf("send twice: %v", err)
+	}
+
+	sim.Mine(1)
+	received, err := dave.GetReceivedByAddress(addr, 1, "MELON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != 50*rpc.Coin {
+		t.Errorf("dave received %s MELON, want 50", received)
+	}
+	if len(lockList.List()) != 0 {
+		t.Errorf("utxos left locked: %+v", lockList.List())
+	}
+}
+
+func TestSweepQuotations(t *testing.T) {
+	now := time.Now().Unix()
+	quotationList = map[string]quotation{
+		"expired": {RequestAsset: "MELON", Expiry: now - 1},
+		"open":    {RequestAsset: "MELON", Expiry: now + 60},
+	}
+	sweepQuotations()
+	if _, ok := quotationList["expired"]; ok || len(quotationList) != 1 {
+		t.Errorf("quotations %+v, want the open one", quotationList)
+	}
+}
@@ -6,23 +6,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"democonf"
+	"elementstx"
 	"lib"
+	"notify"
 	"rpc"
 )
 
-// Block with Transactions
-type Block struct {
-	Tx []string `json:"tx,"`
-}
-
 var rpcurl = "http://127.0.0.1:10010"
 var rpcuser = "user"
 var rpcpass = "pass"
+var rpccookie = ""
+var rpcsecrets = ""
+var rpcwallet = ""
+var rpcrecord = ""
+var notifySources notify.Sources // zmqpub and notifyaddr
+var pollinterval time.Duration
+var statusaddr = ""
 
 var rpcClient *rpc.Rpc
 
@@ -32,61 +38,114 @@ var logger *log.Logger
 
 var blockcount = -1
 
-func getblockcount() (int, error) {
-	blockcount, res, err := rpcClient.RequestAndCastNumber("getblockcount")
+func getblockcount(node *rpc.Rpc) (int, error) {
+	blockcount, err := node.GetBlockCount()
 	if err != nil {
-		logger.Printf("Rpc#RequestAndCastNumber error:%v res:%+v", err, res)
+		logger.Printf("Rpc#GetBlockCount error:%v", err)
 		return -1, err
 	}
 	return int(blockcount), nil
 }
 
-func viewBlock(height int) error {
-	blockhash, res, err := rpcClient.RequestAndCastString("getblockhash", height)
+// maxBatchBlocks is the number of blocks fetched in a batch while catching up.
+const maxBatchBlocks = 100
+
+// viewBlocks prints the transactions of the blocks from..to, fetching them in three batches.
+func viewBlocks(node *rpc.Rpc, from int, to int) error {
+	hashBatch := node.NewBatch()
+	var hashCalls []*rpc.BatchCall
+	for height := from; height <= to; height++ {
+		hashCalls = append(hashCalls, hashBatch.GetBlockHash(int64(height)))
+	}
+	err := hashBatch.Send()
 	if err != nil {
-		logger.Printf("Rpc#RequestAndCastString error:%v res:%+v", err, res)
+		logger.Printf("Rpc#Batch(getblockhash) error:%v", err)
 		return err
 	}
-	var block Block
-	res, err = rpcClient.RequestAndUnmarshalResult(&block, "getblock", blockhash)
+
+	blockBatch := node.NewBatch()
+	var blockCalls []*rpc.BatchCall
+	for _, c := range hashCalls {
+		blockhash, err := c.CastString()
+		if err != nil {
+			logger.Printf("Rpc#GetBlockHash error:%v", err)
+			return err
+		}
+		blockCalls = append(blockCalls, blockBatch.GetBlock(blockhash))
+	}
+	err = blockBatch.Send()
 	if err != nil {
-		logger.Printf("Rpc#RequestAndUnmarshalResult error:%v res:%+v", err, res)
+		logger.Printf("Rpc#Batch(getblock) error:%v", err)
 		return err
 	}
-	for _, tx := range block.Tx {
-		printtxouts(fmt.Sprintf("%v", tx))
+
+	blocks := make([]rpc.Block, len(blockCalls))
+	txBatch := node.NewBatch()
+	txCalls := make([][]*rpc.BatchCall, len(blockCalls))
+	for i, c := range blockCalls {
+		err = c.Unmarshal(&blocks[i])
+		if err != nil {
+			logger.Printf("Rpc#GetBlock error:%v", err)
+			return err
+		}
+		for _, txid := range blocks[i].Tx {
+			txCalls[i] = append(txCalls[i], txBatch.GetRawTransaction(txid))
+		}
+	}
+	err = txBatch.Send()
+	if err != nil {
+		logger.Printf("Rpc#Batch(getrawtransaction) error:%v", err)
+		return err
+	}
+
+	for i, block := range blocks {
+		fmt.Println("Find block", from+i)
+		for j, txid := range block.Tx {
+			printtxouts(txid, txCalls[i][j])
+		}
 	}
 	return nil
 }
 
-func printtxouts(txid string) error {
+func printtxouts(txid string, call *rpc.BatchCall) error {
 	fmt.Println("TXID:", txid)
-	var tx rpc.RawTransaction
-	res, err := rpcClient.RequestAndUnmarshalResult(&tx, "getrawtransaction", txid, 1)
+	rawtx, err := call.CastString()
+	if err != nil {
+		logger.Printf("Rpc#GetRawTransaction error:%v", err)
+		return err
+	}
+	tx, err := elementstx.Decode(rawtx)
 	if err != nil {
-		logger.Printf("Rpc#RequestAndUnmarshalResult error:%v res:%+v", err, res)
+		logger.Printf("elementstx#Decode error:%v tx:%s", err, rawtx)
 		return err
 	}
 	format := "[%d] Value: %3v Asset: %7v -> %v\n"
 	for _, out := range tx.Vout {
-		if out.Asset == "" {
-			fmt.Printf(format, out.N, "???", "???????", out.ScriptPubKey.Addresses)
+		value := rpc.Amount(out.Value).String()
+		if !out.HasExplicitValue() {
+			value = "???"
+		}
+		if out.IsFee() {
+			fmt.Printf(format, out.N, value, assets[out.Asset], "fee")
+			continue
+		}
+		addr, err := elementstx.EncodeAddress(out.ScriptData, nil, elementstx.RegtestParams)
+		if err != nil {
+			addr = out.ScriptPubKey
+		}
+		if !out.HasExplicitAsset() {
+			fmt.Printf(format, out.N, value, "???????", addr)
 		} else {
-			if out.ScriptPubKey.Type == "fee" {
-				fmt.Printf(format, out.N, out.Value, assets[out.Asset], "fee")
-			} else {
-				fmt.Printf(format, out.N, out.Value, assets[out.Asset], out.ScriptPubKey.Addresses)
-			}
+			fmt.Printf(format, out.N, value, assets[out.Asset], addr)
 		}
 	}
 	return nil
 }
 
-func getassetlabels() error {
-	var labels map[string]string
-	res, err := rpcClient.RequestAndUnmarshalResult(&labels, "dumpassetlabels")
+func getassetlabels(node *rpc.Rpc) error {
+	labels, err := node.DumpAssetLabels()
 	if err != nil {
-		logger.Printf("Rpc#RequestAndUnmarshalResult error:%v res:%+v", err, res)
+		logger.Printf("Rpc#DumpAssetLabels error:%v", err)
 		return err
 	}
 	for k, v := range labels {
@@ -95,23 +154,31 @@ func getassetlabels() error {
 	return nil
 }
 
-func callback() {
-	getassetlabels()
-	blockheight, err := getblockcount()
+// followBlocks prints the blocks since the last run. A failed batch is fetched again by the next run.
+func followBlocks(ctx context.Context) error {
+	node := rpcClient.WithContext(ctx)
+	getassetlabels(node)
+	blockheight, err := getblockcount(node)
 	if err != nil {
-		logger.Println("getblockcount error:", err)
-		return
+		return err
 	}
 	if blockcount < 0 {
 		blockcount = blockheight
 		fmt.Println("Start block", blockcount)
-	} else if blockcount < blockheight {
-		for blockcount < blockheight {
-			blockcount++
-			fmt.Println("Find block", blockcount)
-			viewBlock(blockcount)
+		return nil
+	}
+	for blockcount < blockheight {
+		to := blockcount + maxBatchBlocks
+		if blockheight < to {
+			to = blockheight
 		}
+		err = viewBlocks(node, blockcount+1, to)
+		if err != nil {
+			return err
+		}
+		blockcount = to
 	}
+	return nil
 }
 
 func loadConf() {
@@ -119,6 +186,13 @@ func loadConf() {
 	rpcurl = conf.GetString("rpcurl", rpcurl)
 	rpcuser = conf.GetString("rpcuser", rpcuser)
 	rpcpass = conf.GetString("rpcpass", rpcpass)
+	rpccookie = conf.GetString("rpccookie", rpccookie)
+	rpcsecrets = conf.GetString("rpcsecrets", rpcsecrets)
+	rpcwallet = conf.GetString("rpcwallet", rpcwallet)
+	rpcrecord = conf.GetString("rpcrecord", rpcrecord)
+	notifySources = notify.Sources{ZMQ: conf.GetString("zmqpub", ""), HTTP: conf.GetString("notifyaddr", "")}
+	pollinterval = notifySources.PollInterval(conf.GetNumber("pollinterval", 0))
+	statusaddr = conf.GetString("statusaddr", statusaddr)
 }
 
 func main() {
@@ -126,10 +200,52 @@ func main() {
 	fmt.Println("Bob starting")
 
 	loadConf()
-	rpcClient = rpc.NewRpc(rpcurl, rpcuser, rpcpass)
+	var err error
+	rpcClient, err = rpc.NewRpcWithConfig(rpc.Config{
+		URL:         rpcurl,
+		User:        rpcuser,
+		Pass:        rpcpass,
+		CookieFile:  rpccookie,
+		SecretsFile: rpcsecrets,
+		SecretsName: "bob",
+		Wallet:      rpcwallet,
+		RecordFile:  rpcrecord,
+	})
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
 
 	lib.SetLogger(logger)
-	lib.StartCyclic(callback, 3, true)
+	rpc.SetLogger(logger)
+	notify.SetLogger(logger)
+	lc := lib.NewLifecycle("bob")
+	lc.Close("rpc", rpcClient)
+	events, err := notify.Subscribe(lc.Context(), notifySources, notify.TopicHashBlock)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	err = lc.Schedule(lib.Job{
+		Name:     "blocks",
+		Interval: pollinterval,
+		Timeout:  time.Minute,
+		Events:   events,
+		Run:      followBlocks,
+	})
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	if statusaddr != "" {
+		_, err = lc.Listen(statusaddr, lc.Scheduler().Handler())
+		if err != nil {
+			logger.Println("error:", err)
+			os.Exit(lib.ExitFailure)
+		}
+	}
 
+	code := lc.Run()
 	fmt.Println("Bob stopping")
+	os.Exit(code)
 }
@@ -5,24 +5,26 @@
 package main
 
 import (
+	"context"
 	"democonf"
+	"elementstx"
+	"errors"
+	"exchanger"
 	"fmt"
 	"lib"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
 	"rpc"
-	"strconv"
-	"strings"
 	"time"
 )
 
 type exchangeRateTuple struct {
-	Rate float64 `json:"rate"`
-	Min  int64   `json:"min"`
-	Max  int64   `json:"max"`
-	Unit int64   `json:"unit"`
-	Fee  int64   `json:"fee,"`
+	Rate rpc.Amount `json:"rate"`
+	Min  rpc.Amount `json:"min"`
+	Max  rpc.Amount `json:"max"`
+	Unit int64      `json:"unit"`
+	Fee  rpc.Amount `json:"fee,"`
 }
 
 const (
@@ -33,38 +35,40 @@ const (
 	defaultRPCUser   = "user"
 	defaultRPCPass   = "pass"
 	defaultLocalAddr = ":8020"
-	defaultTxPath    = "elements-tx"
-	defaultTxOption  = ""
 	defaultTimeout   = 600
+	defaultOfferBook = "charlie_offers.json"
+	defaultLockFile  = "charlie_locks.json"
 )
 
 var logger = log.New(os.Stdout, myActorName+":", log.LstdFlags+log.Lshortfile)
 var conf = democonf.NewDemoConf(myActorName)
 var assetIDMap = make(map[string]string)
-var lockList = make(rpc.LockList)
+var lockList *rpc.LockManager
 var rpcClient *rpc.Rpc
-var elementsTxCommand string
-var elementsTxOption string
 var localAddr string
-var defaultRateTuple = exchangeRateTuple{Rate: 0.5, Min: 100, Max: 200000, Unit: 20, Fee: 15}
+var defaultRateTuple = exchangeRateTuple{Rate: rpc.Coin / 2, Min: 100 * rpc.Coin, Max: 200000 * rpc.Coin, Unit: 20, Fee: 15 * rpc.Coin}
 var fixedRateTable = make(map[string](map[string]exchangeRateTuple))
-
-var handlerList = map[string]interface{}{
-	"/getexchangerate/":    doGetRate,
-	"/getexchangeofferwb/": doOfferWithBlinding,
-	"/getexchangeoffer/":   doOffer,
-	"/submitexchange/":     doSubmit,
+var offerDuration time.Duration
+var offers *offerBook
+
+var handlerList = map[string]lib.Handler{
+	exchanger.PathRate:        lib.Handle(doGetRate),
+	exchanger.PathOfferWB:     lib.HandleContext(doOfferWithBlinding).Methods("POST"),
+	exchanger.PathOffer:       lib.HandleContext(doOffer).Methods("POST"),
+	exchanger.PathSubmit:      lib.HandleContext(doSubmit).Methods("POST"),
+	exchanger.PathCancel:      lib.Handle(doCancel).Methods("POST"),
+	exchanger.PathOfferStatus: lib.Handle(doOfferStatus),
 }
 
 func doGetRate(rateRequest lib.ExchangeRateRequest) (lib.ExchangeRateResponse, error) {
 	var rateRes lib.ExchangeRateResponse
 	var requestAsset string
-	var requestAmount int64
+	var requestAmount rpc.Amount
 	var err error
 
 	request := rateRequest.Request
 	if len(request) != 1 {
-		err = fmt.Errorf("request must be a single record but has:%d", len(request))
+		err = fmt.Errorf("%w: request must be a single record but has:%d", lib.ErrBadRequest, len(request))
 		logger.Println("error:", err)
 		return rateRes, err
 	}
@@ -82,15 +86,16 @@ func doGetRate(rateRequest lib.ExchangeRateRequest) (lib.ExchangeRateResponse, e
 	return rateRes, err
 }
 
-func doOfferWithBlinding(offerRequest lib.ExchangeOfferWBRequest) (lib.ExchangeOfferWBResponse, error) {
+func doOfferWithBlinding(ctx context.Context, offerRequest lib.ExchangeOfferWBRequest) (lib.ExchangeOfferWBResponse, error) {
 	var offerWBRes lib.ExchangeOfferWBResponse
+	node := rpcClient.WithContext(ctx)
 	var requestAsset string
-	var requestAmount int64
+	var requestAmount rpc.Amount
 	var err error
 
 	request := offerRequest.Request
 	if len(request) != 1 {
-		err = fmt.Errorf("request must be a single record but has:%d", len(request))
+		err = fmt.Errorf("%w: request must be a single record but has:%d", lib.ErrBadRequest, len(request))
 		logger.Println("error:", err)
 		return offerWBRes, err
 	}
@@ -114,19 +119,19 @@ func doOfferWithBlinding(offerRequest lib.ExchangeOfferWBRequest) (lib.ExchangeO
 	offerWBRes.Cost = tmp.Cost
 
 	// 2. lookup unspent
-	utxos, err := rpcClient.SearchUnspent(lockList, requestAsset, requestAmount, true)
+	utxos, err := node.SearchUnspent(lockList, requestAsset, requestAmount, true)
 	if err != nil {
 		logger.Println("error:", err)
 		return offerWBRes, err
 	}
-	rautxos, err := rpcClient.SearchMinimalUnspent(lockList, offer, true)
+	rautxos, err := node.SearchMinimalUnspent(lockList, offer, true)
 	if err != nil {
 		logger.Println("error:", err)
 		return offerWBRes, err
 	}
 
 	// 3. creat tx
-	tx, err := createTransactionTemplateWB(requestAsset, requestAmount, offer, offerWBRes, utxos, rautxos)
+	tx, err := createTransactionTemplateWB(node, requestAsset, requestAmount, offer, offerWBRes, utxos, rautxos)
 	if err != nil {
 		logger.Println("error:", err)
 		return offerWBRes, err
@@ -134,14 +139,14 @@ func doOfferWithBlinding(offerRequest lib.ExchangeOfferWBRequest) (lib.ExchangeO
 
 	// 4. blinding
 	cmutxos := append(utxos, rautxos...)
-	resCommitments, err := rpcClient.GetCommitments(cmutxos)
+	resCommitments, err := node.GetCommitments(cmutxos)
 	if err != nil {
 		logger.Println("error:", err)
 		return offerWBRes, err
 	}
 	commitments = append(resCommitments, commitments...)
 
-	blindtx, _, err := rpcClient.RequestAndCastString("blindrawtransaction", tx, true, commitments)
+	blindtx, err := node.BlindRawTransaction(tx, true, commitments)
 	if err != nil {
 		logger.Println("RPC/blindrawtransaction error:", err, tx)
 		return offerWBRes, err
@@ -149,19 +154,29 @@ func doOfferWithBlinding(offerRequest lib.ExchangeOfferWBRequest) (lib.ExchangeO
 
 	offerWBRes.Transaction = blindtx
 	offerWBRes.Commitments = resCommitments
+	offerWBRes.ID = offerWBRes.GetID()
+
+	// 5. remember offer
+	lockList.Tag(cmutxos, offerWBRes.ID, "offer")
+	err = offers.add(newOfferRecord(offerWBRes.ID, requestAsset, requestAmount, tmp, blindtx, cmutxos))
+	if err != nil {
+		logger.Println("error:", err)
+		return offerWBRes, err
+	}
 
 	return offerWBRes, nil
 }
 
-func doOffer(offerRequest lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse, error) {
+func doOffer(ctx context.Context, offerRequest lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse, error) {
 	var offerRes lib.ExchangeOfferResponse
+	node := rpcClient.WithContext(ctx)
 	var requestAsset string
-	var requestAmount int64
+	var requestAmount rpc.Amount
 	var err error
 
 	request := offerRequest.Request
 	if len(request) != 1 {
-		err = fmt.Errorf("request must be a single record but has:%d", len(request))
+		err = fmt.Errorf("%w: request must be a single record but has:%d", lib.ErrBadRequest, len(request))
 		logger.Println("error:", err)
 		return offerRes, err
 	}
@@ -184,184 +199,249 @@ func doOffer(offerRequest lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse,
 	offerRes.Cost = tmp.Cost
 
 	// 2. lookup unspent
-	utxos, err := rpcClient.SearchUnspent(lockList, requestAsset, requestAmount, false)
+	utxos, err := node.SearchUnspent(lockList, requestAsset, requestAmount, false)
 	if err != nil {
 		logger.Println("error:", err)
 		return offerRes, err
 	}
 
 	// 3. creat tx
-	offerRes.Transaction, err = createTransactionTemplate(requestAsset, requestAmount, offer, offerRes.Cost, utxos)
+	offerRes.Transaction, err = createTransactionTemplate(node, requestAsset, requestAmount, offer, offerRes.Cost, utxos)
 	if err != nil {
 		logger.Println("error:", err)
+		return offerRes, err
 	}
+	offerRes.ID = offerRes.GetID()
 
-	return offerRes, err
+	// 4. remember offer
+	lockList.Tag(utxos, offerRes.ID, "offer")
+	err = offers.add(newOfferRecord(offerRes.ID, requestAsset, requestAmount, tmp, offerRes.Transaction, utxos))
+	if err != nil {
+		logger.Println("error:", err)
+		return offerRes, err
+	}
+
+	return offerRes, nil
 }
 
-func createTransactionTemplate(requestAsset string, requestAmount int64, offer string, cost int64, utxos rpc.UnspentList) (string, error) {
+func createTransactionTemplate(node *rpc.Rpc, requestAsset string, requestAmount rpc.Amount, offer string, cost rpc.Amount, utxos rpc.UnspentList) (string, error) {
 	var addrOffer string
 	var addrChange string
 	var err error
 
-	change := utxos.GetAmount() - requestAmount
-
-	addrOffer, err = rpcClient.GetNewAddr(false)
+	change, err := utxos.GetAmount().Sub(requestAmount)
 	if err != nil {
 		return "", err
 	}
 
-	params := []string{}
-
-	if elementsTxOption != "" {
-		params = append(params, elementsTxOption)
-	}
-	params = append(params, "-create")
-
-	for _, u := range utxos {
-		txin := "in=" + u.Txid + ":" + strconv.FormatInt(u.Vout, 10)
-		params = append(params, txin)
+	addrOffer, err = node.GetNewAddr(false)
+	if err != nil {
+		return "", err
 	}
 
-	outAddrOffer := "outaddr=" + strconv.FormatInt(cost, 10) + ":" + addrOffer + ":" + assetIDMap[offer]
-	params = append(params, outAddrOffer)
+	builder := elementstx.NewBuilder(elementstx.RegtestParams)
 
-	if 0 < change {
-		addrChange, err = rpcClient.GetNewAddr(false)
+	for _, u := range utxos {
+		err = builder.AddInput(u.Txid, u.Vout)
 		if err != nil {
 			return "", err
 		}
-		outAddrChange := "outaddr=" + strconv.FormatInt(change, 10) + ":" + addrChange + ":" + assetIDMap[requestAsset]
-		params = append(params, outAddrChange)
 	}
 
-	out, err := exec.Command(elementsTxCommand, params...).Output()
-
+	err = builder.AddOutputAddr(int64(cost), addrOffer, assetIDMap[offer])
 	if err != nil {
-		logger.Println("elements-tx error:", err, params, out)
 		return "", err
 	}
 
-	txTemplate := strings.TrimRight(string(out), "\n")
-	return txTemplate, nil
+	if 0 < change {
+		addrChange, err = node.GetNewAddr(false)
+		if err != nil {
+			return "", err
+		}
+		err = builder.AddOutputAddr(int64(change), addrChange, assetIDMap[requestAsset])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return builder.Hex(), nil
 }
 
-func createTransactionTemplateWB(requestAsset string, requestAmount int64, offer string, offerRes lib.ExchangeOfferWBResponse, utxos rpc.UnspentList, loopbackUtxos rpc.UnspentList) (string, error) {
+func createTransactionTemplateWB(node *rpc.Rpc, requestAsset string, requestAmount rpc.Amount, offer string, offerRes lib.ExchangeOfferWBResponse, utxos rpc.UnspentList, loopbackUtxos rpc.UnspentList) (string, error) {
 	var addrOffer string
 	var addrChange string
 	var err error
 
-	change := utxos.GetAmount() - requestAmount
-	lbChange := loopbackUtxos.GetAmount() + offerRes.Cost
-
-	addrOffer, err = rpcClient.GetNewAddr(true)
+	change, err := utxos.GetAmount().Sub(requestAmount)
+	if err != nil {
+		return "", err
+	}
+	lbChange, err := loopbackUtxos.GetAmount().Add(offerRes.Cost)
 	if err != nil {
 		return "", err
 	}
 
-	params := []string{}
-
-	if elementsTxOption != "" {
-		params = append(params, elementsTxOption)
+	addrOffer, err = node.GetNewAddr(true)
+	if err != nil {
+		return "", err
 	}
-	params = append(params, "-create")
+
+	builder := elementstx.NewBuilder(elementstx.RegtestParams)
 
 	for _, u := range utxos {
-		txin := "in=" + u.Txid + ":" + strconv.FormatInt(u.Vout, 10)
-		params = append(params, txin)
+		err = builder.AddInput(u.Txid, u.Vout)
+		if err != nil {
+			return "", err
+		}
 	}
 	for _, u := range loopbackUtxos {
-		txin := "in=" + u.Txid + ":" + strconv.FormatInt(u.Vout, 10)
-		params = append(params, txin)
+		err = builder.AddInput(u.Txid, u.Vout)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	outAddrOffer := "outaddr=" + strconv.FormatInt(lbChange, 10) + ":" + addrOffer + ":" + assetIDMap[offer]
-	params = append(params, outAddrOffer)
+	err = builder.AddOutputAddr(int64(lbChange), addrOffer, assetIDMap[offer])
+	if err != nil {
+		return "", err
+	}
 
 	if 0 < change {
-		addrChange, err = rpcClient.GetNewAddr(true)
+		addrChange, err = node.GetNewAddr(true)
+		if err != nil {
+			return "", err
+		}
+		err = builder.AddOutputAddr(int64(change), addrChange, assetIDMap[requestAsset])
 		if err != nil {
 			return "", err
 		}
-		outAddrChange := "outaddr=" + strconv.FormatInt(change, 10) + ":" + addrChange + ":" + assetIDMap[requestAsset]
-		params = append(params, outAddrChange)
 	}
-	outAddrFee := "outscript=" + strconv.FormatInt(offerRes.Fee, 10) + "::" + assetIDMap[offer]
-	params = append(params, outAddrFee)
-
-	out, err := exec.Command(elementsTxCommand, params...).Output()
-
+	err = builder.AddFeeOutput(int64(offerRes.Fee), assetIDMap[offer])
 	if err != nil {
-		logger.Println("elements-tx error:", err, params, out)
 		return "", err
 	}
 
-	txTemplate := strings.TrimRight(string(out), "\n")
-	return txTemplate, nil
+	return builder.Hex(), nil
 }
 
-func doSubmit(submitRequest lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error) {
+func doSubmit(ctx context.Context, submitRequest lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error) {
 	var submitRes lib.SubmitExchangeResponse
-	var rawTx rpc.RawTransaction
+	node := rpcClient.WithContext(ctx)
 	var signedtx rpc.SignedTransaction
 	var err error
 
 	rcvtx := submitRequest.Transaction
 
-	_, err = rpcClient.RequestAndUnmarshalResult(&rawTx, "decoderawtransaction", rcvtx)
+	offer, err := offers.claim(submitRequest.ID)
 	if err != nil {
-		logger.Println("RPC/decoderawtransaction error:", err, rcvtx)
+		logger.Println("error:", err)
 		return submitRes, err
 	}
+	// kept claimed when the broadcast may have succeeded.
+	broadcastUnknown := false
+	defer func() {
+		if err != nil && !broadcastUnknown {
+			offers.reopen(offer.ID)
+		}
+	}()
 
-	// TODO check rawTx (consistency with offer etc...)
+	tx, err := elementstx.ParseTransactionHex(rcvtx)
+	if err != nil {
+		err = reject(offer.ID, lib.RejectInvalidTransaction, "%v", err)
+		logger.Println("error:", err, rcvtx)
+		return submitRes, err
+	}
+
+	err = verifySubmission(node, offer, tx)
+	if err != nil {
+		logger.Println("error:", err, rcvtx)
+		return submitRes, err
+	}
 
-	_, err = rpcClient.RequestAndUnmarshalResult(&signedtx, "signrawtransaction", rcvtx)
+	signedtx, err = node.SignRawTransaction(rcvtx)
 	if err != nil {
 		logger.Println("RPC/signrawtransaction error:", err, rcvtx)
 		return submitRes, err
 	}
 
-	txid, _, err := rpcClient.RequestAndCastString("sendrawtransaction", signedtx.Hex, true)
+	// not cancelled with the request, the offer must not be reopened once broadcasted.
+	txid, err := rpcClient.SendRawTransaction(signedtx.Hex, true)
 	if err != nil {
 		logger.Println("RPC/sendrawtransaction error:", err, signedtx.Hex)
+		broadcastUnknown = errors.Is(err, rpc.ErrTransport)
 		return submitRes, err
 	}
 
 	submitRes.TransactionID = txid
 
-	for _, v := range rawTx.Vin {
-		lockList.Unlock(v.Txid, v.Vout)
-	}
+	offers.complete(offer.ID, txid)
 
 	return submitRes, nil
 }
 
-func lookupRate(requestAsset string, requestAmount int64, offer string) (lib.ExchangeRateResponse, error) {
+func doCancel(cancelRequest lib.CancelExchangeRequest) (lib.CancelExchangeResponse, error) {
+	var cancelRes lib.CancelExchangeResponse
+
+	offer, err := offers.cancel(cancelRequest.ID)
+	if err != nil {
+		logger.Println("error:", err)
+		return cancelRes, err
+	}
+
+	cancelRes.ID = offer.ID
+	cancelRes.State = offer.State
+
+	return cancelRes, nil
+}
+
+func doOfferStatus(statusRequest lib.OfferStatusRequest) (lib.OfferStatusResponse, error) {
+	var statusRes lib.OfferStatusResponse
+
+	if statusRequest.ID == "" {
+		statusRes.Offers = offers.list()
+		return statusRes, nil
+	}
+
+	status, ok := offers.get(statusRequest.ID)
+	if !ok {
+		err := fmt.Errorf("%w: offer [%s]", lib.ErrNotFound, statusRequest.ID)
+		logger.Println("error:", err)
+		return statusRes, err
+	}
+	statusRes.Offers = []lib.OfferStatus{status}
+
+	return statusRes, nil
+}
+
+func lookupRate(requestAsset string, requestAmount rpc.Amount, offer string) (lib.ExchangeRateResponse, error) {
 	var rateRes lib.ExchangeRateResponse
 
 	rateMap, ok := fixedRateTable[offer]
 	if !ok {
-		err := fmt.Errorf("no exchange source:%s", offer)
+		err := fmt.Errorf("%w: no exchange source:%s", lib.ErrNotFound, offer)
 		logger.Println("error:", err)
 		return rateRes, err
 	}
 
 	rate, ok := rateMap[requestAsset]
 	if !ok {
-		err := fmt.Errorf("cannot exchange to:%s", requestAsset)
+		err := fmt.Errorf("%w: cannot exchange to:%s", lib.ErrNotFound, requestAsset)
 		logger.Println("error:", err)
 		return rateRes, err
 	}
 
-	cost := int64(float64(requestAmount) / rate.Rate)
+	cost, err := requestAmount.MulDiv(int64(rpc.Coin), int64(rate.Rate))
+	if err != nil {
+		logger.Println("error:", err)
+		return rateRes, err
+	}
 	if cost < rate.Min {
-		err := fmt.Errorf("cost lower than min value:%d", cost)
+		err := fmt.Errorf("%w: cost lower than min value:%s", lib.ErrBadRequest, cost)
 		logger.Println("error:", err)
 		return rateRes, err
 	}
 	if rate.Max < cost {
-		err := fmt.Errorf("cost higher than max value:%d", cost)
+		err := fmt.Errorf("%w: cost higher than max value:%s", lib.ErrBadRequest, cost)
 		logger.Println("error:", err)
 		return rateRes, err
 	}
@@ -378,21 +458,53 @@ func lookupRate(requestAsset string, requestAmount int64, offer string) (lib.Exc
 func initialize() {
 	logger = log.New(os.Stdout, myActorName+":", log.LstdFlags+log.Lshortfile)
 	lib.SetLogger(logger)
-
-	rpcClient = rpc.NewRpc(
-		conf.GetString("rpcurl", defaultRPCURL),
-		conf.GetString("rpcuser", defaultRPCUser),
-		conf.GetString("rpcpass", defaultRPCPass))
-	_, err := rpcClient.RequestAndUnmarshalResult(&assetIDMap, "dumpassetlabels")
+	rpc.SetLogger(logger)
+
+	rpcConf := rpc.Config{
+		URL:         conf.GetString("rpcurl", defaultRPCURL),
+		User:        conf.GetString("rpcuser", defaultRPCUser),
+		Pass:        conf.GetString("rpcpass", defaultRPCPass),
+		CookieFile:  conf.GetString("rpccookie", ""),
+		SecretsFile: conf.GetString("rpcsecrets", ""),
+		SecretsName: myActorName,
+		Wallet:      conf.GetString("rpcwallet", ""),
+		RecordFile:  conf.GetString("rpcrecord", ""),
+	}
+	var err error
+	rpcClient, err = rpc.NewRpcWithConfig(rpcConf)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	labels, err := rpcClient.DumpAssetLabels()
 	if err != nil {
 		logger.Println("RPC/dumpassetlabels error:", err)
+	} else {
+		assetIDMap = labels
 	}
 	delete(assetIDMap, "bitcoin")
 
+	rpcClient.Selector, err = rpc.NewCoinSelector(conf.GetString("coinselect", rpc.SelectLargestFirst))
+	if err != nil {
+		logger.Println("error:", err)
+		rpcClient.Selector = rpc.LargestFirst{}
+	}
+
 	localAddr = conf.GetString("laddr", defaultLocalAddr)
-	elementsTxCommand = conf.GetString("txpath", defaultTxPath)
-	elementsTxOption = conf.GetString("txoption", defaultTxOption)
-	rpc.SetUtxoLockDuration(time.Duration(int64(conf.GetNumber("timeout", defaultTimeout))) * time.Second)
+	offerDuration = time.Duration(int64(conf.GetNumber("timeout", defaultTimeout))) * time.Second
+	lockList = rpc.NewLockManager(myActorName, offerDuration)
+	err = lockList.Load(conf.GetString("lockfile", defaultLockFile))
+	if err != nil {
+		logger.Println("error:", err)
+	}
+	if conf.GetBool("locknode", false) {
+		lockList.Mirror(rpcClient)
+	}
+	offers = newOfferBook(conf.GetString("offerbook", defaultOfferBook))
+	err = offers.load()
+	if err != nil {
+		logger.Println("error:", err)
+	}
 	fixedRateTable[defaultRateFrom] = map[string]exchangeRateTuple{defaultRateTo: defaultRateTuple}
 	conf.GetInterface("fixrate", &fixedRateTable)
 }
@@ -403,25 +515,62 @@ func main() {
 	dir, err := os.Getwd()
 	if err != nil {
 		logger.Println("error:", err)
-		return
+		os.Exit(lib.ExitFailure)
 	}
-	listener, err := lib.StartHTTPServer(localAddr, handlerList, dir+"/html/"+myActorName)
+	var httpConf lib.HTTPConfig
+	conf.GetInterface("http", &httpConf)
+	mws, auth, err := httpConf.Middlewares()
 	if err != nil {
 		logger.Println("error:", err)
-		return
+		os.Exit(lib.ExitFailure)
 	}
-	defer func() {
-		e := listener.Close()
-		if e != nil {
-			logger.Println("error:", e)
+	var tlsConf lib.TLSConfig
+	conf.GetInterface("tls", &tlsConf)
+	err = tlsConf.Load(myActorName)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	serverConf, err := tlsConf.ServerConfig()
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	handler, err := lib.NewHTTPHandler(handlerList, dir+"/html/"+myActorName, mws...)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+
+	lc := lib.NewLifecycle(myActorName)
+	lc.Close("rpc", rpcClient)
+	lc.OnStop("locks", func(context.Context) error {
+		return lockList.Flush()
+	})
+	lc.OnStop("offers", func(context.Context) error {
+		return offers.flush()
+	})
+	for _, job := range []lib.Job{
+		{Name: "offers", Interval: 3 * time.Second, Run: lib.JobFunc(offers.sweep)},
+		{Name: "locks", Interval: 3 * time.Second, Run: lib.JobFunc(lockList.Sweep)},
+	} {
+		err = lc.Schedule(job)
+		if err != nil {
+			logger.Println("error:", err)
+			os.Exit(lib.ExitFailure)
 		}
-	}()
-
-	_, err = lib.StartCyclic(lockList.Sweep, 3, true)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/jobs", lib.Chain(lc.Scheduler().Handler(), mws...))
+	if auth != nil {
+		mux.Handle(lib.SessionPath, auth.SessionHandler())
+	}
+	mux.Handle("/", handler)
+	_, err = lc.ListenTLS(localAddr, lib.Chain(mux, lib.RequestID, lib.Recover), serverConf)
 	if err != nil {
 		logger.Println("error:", err)
-		return
+		os.Exit(lib.ExitFailure)
 	}
 
-	logger.Println(myActorName + " stop")
+	os.Exit(lc.Run())
 }
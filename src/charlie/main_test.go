@@ -0,0 +1,308 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package main
+
+import (
+	"context"
+	"elementssim"
+	"elementstx"
+	"encoding/json"
+	"errors"
+	"exchanger"
+	"lib"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rpc"
+	"rpc/rpctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	lib.SetLogger(logger)
+	os.Exit(m.Run())
+}
+
+// newTestSim returns the chain of the test, set up the same way when recording and replaying,
+// so that the counterparts of charlie see the chain he was recorded against.
+func newTestSim(t *testing.T) (*elementssim.Server, *httptest.Server) {
+	sim := elementssim.NewServer()
+	for _, name := range []string{"alice", "charlie", "dave"} {
+		err := sim.CreateWallet(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sim.AddAsset("AIRSKY")
+	sim.AddAsset("MELON")
+	for _, f := range []struct{ wallet, asset string }{{"alice", "AIRSKY"}, {"charlie", "MELON"}} {
+		_, err := sim.Fund(f.wallet, f.asset, 1000*elementstx.Coin, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return sim, server
+}
+
+func newSimNode(server *httptest.Server, wallet string) *rpc.Rpc {
+	node := rpc.NewRpc(server.URL, "", "")
+	node.Wallet = wallet
+	return node
+}
+
+// fillOffer completes the template as alice does, paying cost and fee from her wallet
+// (no fee if withFee is false) and 50 MELON to addr, and returns it signed by her.
+func fillOffer(t *testing.T, alice *rpc.Rpc, offer lib.ExchangeOfferResponse, addr string, withFee bool) string {
+	t.Helper()
+	utxos, err := alice.ListUnspent(rpc.ListUnspentOptions{MinConf: 1, Asset: "AIRSKY"})
+	if err != nil || len(utxos) == 0 {
+		t.Fatalf("no utxo of alice: %v", err)
+	}
+	change, err := alice.GetNewAddr(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder, err := elementstx.NewBuilderFromHex(offer.Transaction, elementstx.RegtestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := offer.Fee
+	if !withFee {
+		fee = 0
+	}
+	err = builder.AddInput(utxos[0].Txid, utxos[0].Vout)
+	if err == nil {
+		err = builder.AddOutputAddr(int64(utxos[0].Amount-offer.Cost-fee), change, assetIDMap["AIRSKY"])
+	}
+	if err == nil {
+		err = builder.AddOutputAddr(int64(50*rpc.Coin), addr, assetIDMap["MELON"])
+	}
+	if err == nil && withFee {
+		err = builder.AddFeeOutput(int64(fee), assetIDMap["AIRSKY"])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := alice.SignRawTransaction(builder.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed.Hex
+}
+
+// startCharlie sets charlie up on the node replaying fixture and returns his offer of 50 MELON for AIRSKY.
+func startCharlie(t *testing.T, server *httptest.Server, fixture string) lib.ExchangeOfferResponse {
+	t.Helper()
+	rpcClient = rpctest.NewNode(t, server.URL, myActorName, fixture)
+	var err error
+	assetIDMap, err = rpcClient.DumpAssetLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(assetIDMap, "bitcoin")
+	offerDuration = time.Minute
+	lockList = rpc.NewLockManager(myActorName, offerDuration)
+	offers = newOfferBook(filepath.Join(t.TempDir(), "offers.json"))
+	fixedRateTable = map[string]map[string]exchangeRateTuple{
+		"AIRSKY": {"MELON": {Rate: rpc.Coin / 2, Min: rpc.Coin, Max: 1000 * rpc.Coin, Unit: 20, Fee: rpc.Coin}},
+	}
+
+	offer, err := doOffer(context.Background(), lib.ExchangeOfferRequest{
+		Request: map[string]rpc.Amount{"MELON": 50 * rpc.Coin},
+		Offer:   "AIRSKY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offer
+}
+
+func TestOfferAndSubmit(t *testing.T) {
+	_, server := newTestSim(t)
+	offer := startCharlie(t, server, "testdata/offer.jsonl")
+	if offer.Cost != 100*rpc.Coin || offer.Fee != rpc.Coin {
+		t.Fatalf("offer %+v", offer)
+	}
+	status, ok := offers.get(offer.ID)
+	if !ok || status.State != offerOpen || len(status.Inputs) != 1 {
+		t.Fatalf("offer status %+v", status)
+	}
+	if len(lockList.List()) != 1 {
+		t.Errorf("locks %+v, want the input offered", lockList.List())
+	}
+
+	alice := newSimNode(server, "alice")
+	addr, err := newSimNode(server, "dave").GetNewAddr(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = doSubmit(context.Background(), lib.SubmitExchangeRequest{ID: offer.ID, Transaction: fillOffer(t, alice, offer, addr, false)})
+	var rej *lib.SubmitExchangeRejection
+	if !errors.As(err, &rej) || rej.Reason != lib.RejectInsufficientFee {
+		t.Fatalf("submit without fee: %v", err)
+	}
+	status, _ = offers.get(offer.ID)
+	if status.State != offerOpen {
+		t.Fatalf("offer %s after a rejection, want reopened", status.State)
+	}
+
+	tx := fillOffer(t, alice, offer, addr, true)
+	submitRes, err := doSubmit(context.Background(), lib.SubmitExchangeRequest{ID: offer.ID, Transaction: tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ = offers.get(offer.ID)
+	if status.State != offerSubmitted || status.TransactionID != submitRes.TransactionID || submitRes.TransactionID == "" {
+		t.Errorf("offer status %+v, submitted %+v", status, submitRes)
+	}
+	if len(lockList.List()) != 0 {
+		t.Errorf("utxos left locked: %+v", lockList.List())
+	}
+
+	_, err = doSubmit(context.Background(), lib.SubmitExchangeRequest{ID: offer.ID, Transaction: tx})
+	if !errors.As(err, &rej) || rej.Reason != lib.RejectOfferClosed {
+		t.Errorf("submit twice: %v", err)
+	}
+}
+
+// TestSubmitRejections submits the offer altered to take more from charlie than he offered.
+func TestSubmitRejections(t *testing.T) {
+	sim, server := newTestSim(t)
+	// other utxos of charlie: one listed by his wallet, one locked on his node.
+	// funded first, the addresses of his wallet do not depend on his replayed calls.
+	listed, err := sim.Fund(myActorName, "MELON", 10*elementstx.Coin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err := sim.Fund(myActorName, "MELON", 10*elementstx.Coin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newSimNode(server, myActorName).LockUnspent(false, []rpc.OutPoint{{Txid: locked, Vout: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offer := startCharlie(t, server, "testdata/rejections.jsonl")
+	status, _ := offers.get(offer.ID)
+	if len(status.Inputs) != 1 || strings.HasPrefix(status.Inputs[0], listed) || strings.HasPrefix(status.Inputs[0], locked) {
+		t.Fatalf("offered %+v, want the utxo of 1000 MELON", status.Inputs)
+	}
+	alice := newSimNode(server, "alice")
+	addr, err := newSimNode(server, "dave").GetNewAddr(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filled := fillOffer(t, alice, offer, addr, true)
+
+	alter := func(f func(tx *elementstx.Transaction)) string {
+		tx, err := elementstx.ParseTransactionHex(filled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(tx)
+		return tx.Hex()
+	}
+	addInput := func(txid string) string {
+		builder, err := elementstx.NewBuilderFromHex(filled, elementstx.RegtestParams)
+		if err == nil {
+			err = builder.AddInput(txid, 0)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return builder.Hex()
+	}
+	for _, c := range []struct {
+		name   string
+		tx     string
+		reason string
+	}{
+		{"offered input dropped", alter(func(tx *elementstx.Transaction) { tx.Inputs = tx.Inputs[1:] }), lib.RejectMissingInput},
+		{"wallet utxo added", addInput(listed), lib.RejectUnexpectedInput},
+		{"locked utxo added", addInput(locked), lib.RejectUnexpectedInput},
+		{"unknown input added", addInput(strings.Repeat("ab", 32)), lib.RejectUnexpectedInput},
+		{"quoted output altered", alter(func(tx *elementstx.Transaction) {
+			tx.Outputs[0].Value, _ = elementstx.ExplicitValue(int64(offer.Cost - 1))
+		}), lib.RejectMissingOutput},
+		{"quoted change removed", alter(func(tx *elementstx.Transaction) {
+			tx.Outputs = append(tx.Outputs[:1], tx.Outputs[2:]...)
+		}), lib.RejectMissingOutput},
+	} {
+		_, err = doSubmit(context.Background(), lib.SubmitExchangeRequest{ID: offer.ID, Transaction: c.tx})
+		var rej *lib.SubmitExchangeRejection
+		if !errors.As(err, &rej) || rej.Reason != c.reason {
+			t.Errorf("%s: %v, want %s", c.name, err, c.reason)
+		}
+		status, _ := offers.get(offer.ID)
+		if status.State != offerOpen {
+			t.Errorf("%s: offer %s after a rejection, want reopened", c.name, status.State)
+		}
+	}
+
+	// the offer is still good as quoted.
+	_, err = doSubmit(context.Background(), lib.SubmitExchangeRequest{ID: offer.ID, Transaction: filled})
+	if err != nil {
+		t.Errorf("submit as quoted: %v", err)
+	}
+}
+
+// TestOpenAPI checks the document of charlie's routes, which alice's developers read.
+func TestOpenAPI(t *testing.T) {
+	doc, err := lib.OpenAPI(myActorName, handlerList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		exchanger.PathRate:        "get post",
+		exchanger.PathOffer:       "post",
+		exchanger.PathOfferWB:     "post",
+		exchanger.PathSubmit:      "post",
+		exchanger.PathCancel:      "post",
+		exchanger.PathOfferStatus: "get post",
+	}
+	if len(doc.Paths) != len(want) {
+		t.Errorf("%d paths, want %d", len(doc.Paths), len(want))
+	}
+	resolve := func(s *lib.Schema) *lib.Schema {
+		if s == nil || s.Ref == "" {
+			return s
+		}
+		return doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	for p, methods := range want {
+		ops := doc.Paths[p]
+		for _, m := range []string{"get", "post"} {
+			op, ok := ops[m]
+			if ok != strings.Contains(methods, m) {
+				t.Errorf("%s %s: %v, want %s", m, p, ok, methods)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			res := resolve(op.Responses["200"].Content["application/json"].Schema)
+			errRes := resolve(op.Responses["default"].Content["application/json"].Schema)
+			if res == nil || len(res.Properties) == 0 || errRes == nil || errRes.Properties["code"] == nil {
+				t.Errorf("%s %s: responses %+v", m, p, op.Responses)
+			}
+			if m == "post" && resolve(op.RequestBody.Content["application/json"].Schema) == nil {
+				t.Errorf("%s %s: request body %+v", m, p, op.RequestBody)
+			}
+		}
+	}
+	submit := resolve(doc.Paths[exchanger.PathSubmit]["post"].RequestBody.Content["application/json"].Schema)
+	if submit.Properties["id"] == nil || submit.Properties["tx"] == nil || len(submit.Required) != 2 {
+		t.Errorf("submit request %+v", submit)
+	}
+	if _, err = json.Marshal(doc); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package main
+
+import (
+	"fmt"
+	"lib"
+	"rpc"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of an offer.
+const (
+	offerOpen      = "open"
+	offerSubmitted = "submitted"
+	offerExpired   = "expired"
+	offerCancelled = "cancelled"
+)
+
+// offerRetention is how long closed offers stay in the book for /offerstatus.
+const offerRetention = 24 * time.Hour
+
+// offerRecord keeps what charlie quoted, so a submission can be checked against it.
+type offerRecord struct {
+	ID            string          `json:"id"`
+	RequestAsset  string          `json:"request_asset"`
+	RequestAmount rpc.Amount      `json:"request_amount"`
+	OfferAsset    string          `json:"offer_asset"`
+	Cost          rpc.Amount      `json:"cost"`
+	Fee           rpc.Amount      `json:"fee"`
+	Inputs        rpc.UnspentList `json:"inputs"`
+	Template      string          `json:"template"`
+	Created       time.Time       `json:"created"`
+	Expiry        time.Time       `json:"expiry"`
+	Modified      time.Time       `json:"modified"`
+	State         string          `json:"state"`
+	TransactionID string          `json:"txid"`
+}
+
+func newOfferRecord(id string, requestAsset string, requestAmount rpc.Amount, rateRes lib.ExchangeRateResponse, template string, utxos rpc.UnspentList) *offerRecord {
+	now := time.Now()
+	offer := &offerRecord{
+		ID:            id,
+		RequestAsset:  requestAsset,
+		RequestAmount: requestAmount,
+		OfferAsset:    rateRes.AssetLabel,
+		Cost:          rateRes.Cost,
+		Fee:           rateRes.Fee,
+		Inputs:        utxos,
+		Template:      template,
+		Created:       now,
+		Expiry:        now.Add(offerDuration),
+		Modified:      now,
+		State:         offerOpen,
+	}
+	return offer
+}
+
+func (o *offerRecord) status() lib.OfferStatus {
+	inputs := make([]string, len(o.Inputs))
+	for i, u := range o.Inputs {
+		inputs[i] = outpointKey(u.Txid, u.Vout)
+	}
+	return lib.OfferStatus{
+		ID:            o.ID,
+		RequestAsset:  o.RequestAsset,
+		RequestAmount: o.RequestAmount,
+		OfferAsset:    o.OfferAsset,
+		Cost:          o.Cost,
+		Fee:           o.Fee,
+		Inputs:        inputs,
+		Created:       o.Created.Unix(),
+		Expiry:        o.Expiry.Unix(),
+		State:         o.State,
+		TransactionID: o.TransactionID,
+	}
+}
+
+// offerBook is the persisted set of offers keyed by offer ID.
+type offerBook struct {
+	mu     sync.Mutex
+	path   string
+	offers map[string]*offerRecord
+}
+
+func newOfferBook(path string) *offerBook {
+	return &offerBook{path: path, offers: make(map[string]*offerRecord)}
+}
+
+// load reads the book from disk and locks the inputs of offers still open.
+func (b *offerBook) load() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := lib.LoadState(b.path, &b.offers)
+	if err != nil {
+		return err
+	}
+	for _, o := range b.offers {
+		if o.State != offerOpen {
+			continue
+		}
+		for _, u := range o.Inputs {
+			lockList.LockWith(u.Txid, u.Vout, o.ID, "offer")
+		}
+	}
+	return nil
+}
+
+// flush writes the book, for stopping.
+func (b *offerBook) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.save()
+}
+
+// save must be called with b.mu held.
+func (b *offerBook) save() error {
+	return lib.SaveState(b.path, b.offers)
+}
+
+func (b *offerBook) add(o *offerRecord) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.offers[o.ID] = o
+	return b.save()
+}
+
+// claim marks an open offer as submitted, so it can be submitted only once.
+func (b *offerBook) claim(id string) (*offerRecord, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offers[id]
+	if !ok {
+		return nil, reject(id, lib.RejectUnknownOffer, "offer not found")
+	}
+	if o.State == offerOpen && o.Expiry.Before(time.Now()) {
+		b.close(o, offerExpired)
+	}
+	if o.State != offerOpen {
+		return nil, reject(id, lib.RejectOfferClosed, "offer is %s", o.State)
+	}
+	o.State = offerSubmitted
+	o.Modified = time.Now()
+	return o, b.save()
+}
+
+// reopen reverts a claim when the submission failed.
+func (b *offerBook) reopen(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offers[id]
+	if !ok || o.State != offerSubmitted || o.TransactionID != "" {
+		return
+	}
+	o.State = offerOpen
+	o.Modified = time.Now()
+	err := b.save()
+	if err != nil {
+		logger.Println("error:", err)
+	}
+}
+
+// complete records the broadcasted transaction of a claimed offer.
+func (b *offerBook) complete(id string, txid string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offers[id]
+	if !ok {
+		return
+	}
+	o.TransactionID = txid
+	o.Modified = time.Now()
+	lockList.UnlockUnspentList(o.Inputs)
+	err := b.save()
+	if err != nil {
+		logger.Println("error:", err)
+	}
+}
+
+func (b *offerBook) cancel(id string) (*offerRecord, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: offer [%s]", lib.ErrNotFound, id)
+	}
+	if o.State != offerOpen {
+		return nil, fmt.Errorf("%w: offer is %s [%s]", lib.ErrConflict, o.State, id)
+	}
+	b.close(o, offerCancelled)
+	return o, b.save()
+}
+
+// close must be called with b.mu held.
+func (b *offerBook) close(o *offerRecord, state string) {
+	o.State = state
+	o.Modified = time.Now()
+	lockList.UnlockUnspentList(o.Inputs)
+}
+
+func (b *offerBook) get(id string) (lib.OfferStatus, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, ok := b.offers[id]
+	if !ok {
+		return lib.OfferStatus{}, false
+	}
+	return o.status(), true
+}
+
+func (b *offerBook) list() []lib.OfferStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]lib.OfferStatus, 0, len(b.offers))
+	for _, o := range b.offers {
+		list = append(list, o.status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created < list[j].Created })
+	return list
+}
+
+// sweep expires open offers past their expiry and forgets old closed offers.
+func (b *offerBook) sweep() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	for id, o := range b.offers {
+		switch {
+		case o.State == offerOpen && o.Expiry.Before(now):
+			b.close(o, offerExpired)
+			changed = true
+		case o.State != offerOpen && o.Modified.Add(offerRetention).Before(now):
+			delete(b.offers, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	err := b.save()
+	if err != nil {
+		logger.Println("error:", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package main
+
+import (
+	"bytes"
+	"elementstx"
+	"fmt"
+	"lib"
+	"rpc"
+)
+
+func reject(id string, reason string, format string, a ...interface{}) error {
+	return &lib.SubmitExchangeRejection{
+		Result:  false,
+		ID:      id,
+		Reason:  reason,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// verifySubmission checks that tx spends exactly the inputs charlie offered
+// and keeps every output charlie quoted, including the fee.
+func verifySubmission(node *rpc.Rpc, offer *offerRecord, tx *elementstx.Transaction) error {
+	template, err := elementstx.ParseTransactionHex(offer.Template)
+	if err != nil {
+		return err
+	}
+
+	offered := make(map[string]bool)
+	for _, u := range offer.Inputs {
+		offered[outpointKey(u.Txid, u.Vout)] = true
+	}
+
+	decoded := tx.Decode()
+	spent := make(map[string]bool)
+	for _, in := range decoded.Vin {
+		spent[outpointKey(in.Txid, in.Vout)] = true
+	}
+	for k := range offered {
+		if !spent[k] {
+			return reject(offer.ID, lib.RejectMissingInput, "offered input %s is not spent", k)
+		}
+	}
+
+	// the unconfirmed change and payments are listed only with include_unsafe.
+	wallet, err := node.ListUnspent(rpc.ListUnspentOptions{MinConf: 0, IncludeUnsafe: true})
+	if err != nil {
+		logger.Println("RPC/listunspent error:", err)
+		return err
+	}
+	// utxos locked on the node are not listed by listunspent.
+	locked, err := node.ListLockUnspent()
+	if err != nil {
+		logger.Println("RPC/listlockunspent error:", err)
+		return err
+	}
+	for _, u := range wallet {
+		locked = append(locked, rpc.OutPoint{Txid: u.Txid, Vout: u.Vout})
+	}
+	for _, u := range locked {
+		k := outpointKey(u.Txid, u.Vout)
+		if spent[k] && !offered[k] {
+			return reject(offer.ID, lib.RejectUnexpectedInput, "input %s belongs to charlie but was not offered", k)
+		}
+	}
+	// the wallet signs whatever it owns, so each other input must be shown not to be charlie's.
+	for _, in := range decoded.Vin {
+		k := outpointKey(in.Txid, in.Vout)
+		if offered[k] {
+			continue
+		}
+		err = checkForeignInput(node, offer.ID, in.Txid, in.Vout)
+		if err != nil {
+			return err
+		}
+	}
+
+	used := make([]bool, len(tx.Outputs))
+	for i, want := range template.Outputs {
+		found := false
+		for j, got := range tx.Outputs {
+			if used[j] || !sameOutput(want, got) {
+				continue
+			}
+			used[j] = true
+			found = true
+			break
+		}
+		if !found {
+			return reject(offer.ID, lib.RejectMissingOutput, "quoted output %d is missing or modified", i)
+		}
+	}
+
+	fee := rpc.Amount(decoded.Fee()[assetIDMap[offer.OfferAsset]])
+	if fee < offer.Fee {
+		return reject(offer.ID, lib.RejectInsufficientFee, "fee %s %s is lower than quoted %s",
+			fee, offer.OfferAsset, offer.Fee)
+	}
+
+	return nil
+}
+
+// checkForeignInput returns a rejection if the output txid:vout is unknown or pays to charlie's wallet.
+func checkForeignInput(node *rpc.Rpc, id string, txid string, vout int64) error {
+	out, err := node.GetTxOut(txid, vout)
+	if err != nil {
+		logger.Println("RPC/gettxout error:", err)
+		return err
+	}
+	if out == nil {
+		return reject(id, lib.RejectUnexpectedInput, "input %s:%d is spent or unknown", txid, vout)
+	}
+	addr := out.ScriptPubKey.GetAddress()
+	if addr == "" {
+		// no address, no key of the wallet
+		return nil
+	}
+	info, err := node.ValidateAddress(addr)
+	if err != nil {
+		logger.Println("RPC/validateaddress error:", err)
+		return err
+	}
+	if info.IsMine || info.IsWatchonly {
+		return reject(id, lib.RejectUnexpectedInput, "input %s:%d belongs to charlie but was not offered", txid, vout)
+	}
+	return nil
+}
+
+func sameOutput(a, b *elementstx.TxOut) bool {
+	return bytes.Equal(a.Asset, b.Asset) && bytes.Equal(a.Value, b.Value) &&
+		bytes.Equal(a.Nonce, b.Nonce) && bytes.Equal(a.ScriptPubKey, b.ScriptPubKey)
+}
+
+func outpointKey(txid string, vout int64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
@@ -6,6 +6,8 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,10 +16,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"democonf"
 	"lib"
+	"notify"
 	"rpc"
 )
 
@@ -30,9 +34,37 @@ var rpcuser = "user"
 // Password for accessing RPC
 var rpcpass = "pass"
 
+// Cookie file for accessing RPC (instead of rpcuser and rpcpass)
+var rpccookie = ""
+
+// Secrets file holding the credentials for accessing RPC (instead of rpcuser and rpcpass)
+var rpcsecrets = ""
+
+// Wallet name on the node (default wallet if empty)
+var rpcwallet = ""
+
+// Fixture file recording the RPC exchanges (not recorded if empty)
+var rpcrecord = ""
+
+// Sources of the node notifications: zmqpub, the ZMQ endpoint of the node (e.g. "tcp://127.0.0.1:28040"),
+// and notifyaddr, the listen addr for the blocknotify/walletnotify hooks (e.g. ":8011")
+var notifySources notify.Sources
+
+// Interval of polling the node (pollinterval in seconds, a fallback when notified)
+var pollinterval time.Duration
+
 // Listen addr for RPC Proxy
 var laddr = ":8030"
 
+// File keeping the orders across restarts
+var orderfile = "dave_orders.json"
+
+// Authentication, CORS and body limit of /order, /list and /jobs
+var httpConf lib.HTTPConfig
+
+// HTTPS setting of laddr
+var tlsConf lib.TLSConfig
+
 //  getNewAddress use confidential
 var confidential = false
 
@@ -40,13 +72,13 @@ var rpcClient *rpc.Rpc
 
 // Item details
 type Item struct {
-	Price   float64
+	Price   rpc.Amount
 	Asset   string
 	Timeout int64
 }
 
 var items = map[string]Item{
-	"Caramel Macchiato Coffee": Item{Price: float64(200), Asset: "MELON", Timeout: int64(60 * 60)},
+	"Caramel Macchiato Coffee": Item{Price: 200 * rpc.Coin, Asset: "MELON", Timeout: int64(60 * 60)},
 }
 
 // Order details
@@ -55,19 +87,23 @@ type Order struct {
 	Addr       string
 	Status     int
 	Asset      string
-	Price      float64
+	Price      rpc.Amount
 	Timeout    int64
 	LastModify int64
 }
 
 var list = []*Order{}
+var listMu sync.Mutex // guards list and its orders, scanned by the scheduler while the handlers serve
 
 var logger *log.Logger
 
-func callback() {
+// scanPayments times out the old orders and marks the paid ones.
+func scanPayments(ctx context.Context) error {
 	newlist := []*Order{}
+	var pending []*Order
 	now := time.Now()
 	old := now.AddDate(0, 0, -1)
+	listMu.Lock()
 	for _, order := range list {
 		if order.LastModify > old.Unix() {
 			newlist = append(newlist, order)
@@ -81,9 +117,28 @@ func callback() {
 			order.LastModify = now.Unix()
 			continue
 		}
-		amount, res, err := rpcClient.RequestAndCastNumber("getreceivedbyaddress", order.Addr, 1, order.Asset)
+		pending = append(pending, order)
+	}
+	list = newlist
+	listMu.Unlock()
+
+	// ask the received amounts of all pending orders at once.
+	batch := rpcClient.WithContext(ctx).NewBatch()
+	calls := make([]*rpc.BatchCall, len(pending))
+	for i, order := range pending {
+		calls[i] = batch.GetReceivedByAddress(order.Addr, 1, order.Asset)
+	}
+	err := batch.Send()
+	if err != nil {
+		logger.Printf("Rpc#Batch(getreceivedbyaddress) error:%v", err)
+		return err
+	}
+	listMu.Lock()
+	defer listMu.Unlock()
+	for i, order := range pending {
+		amount, err := calls[i].CastAmount()
 		if err != nil {
-			logger.Printf("Rpc#RequestAndCastNumber error:%v res:%+v", err, res)
+			logger.Printf("Rpc#GetReceivedByAddress error:%v", err)
 			continue
 		}
 		if amount >= order.Price {
@@ -92,24 +147,21 @@ func callback() {
 			order.LastModify = now.Unix()
 		}
 	}
-	list = newlist
+	return nil
 }
 
 func orderhandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Methods", "POST,GET")
-	w.Header().Add("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
-	w.Header().Add("Access-Control-Max-Age", "-1")
-
 	item := r.FormValue("item")
 	result := make(map[string]interface{})
 	result["result"] = false
 	for key, val := range items {
 		if key == item {
-			addr, err := rpcClient.GetNewAddr(confidential)
+			addr, err := rpcClient.WithContext(r.Context()).GetNewAddr(confidential)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
 				logger.Println("getNewAddress error", err)
+				bs, _ := json.Marshal(lib.NewErrorResponse(err))
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(bs)
 				return
 			}
 			result["result"] = true
@@ -119,7 +171,7 @@ func orderhandler(w http.ResponseWriter, r *http.Request) {
 			result["addr"] = addr
 			vals["addr"] = []string{addr}
 			result["price"] = val.Price
-			vals["price"] = []string{fmt.Sprintf("%v", val.Price)}
+			vals["price"] = []string{val.Price.String()}
 			result["asset"] = val.Asset
 			vals["asset"] = []string{val.Asset}
 			uri := fmt.Sprint("px:invoice?", vals.Encode())
@@ -127,8 +179,10 @@ func orderhandler(w http.ResponseWriter, r *http.Request) {
 			result["uri"] = uri
 			now := time.Now().Unix()
 			order := &Order{Item: key, Addr: addr, Status: 0, Timeout: now + val.Timeout, Price: val.Price, Asset: val.Asset, LastModify: now}
+			listMu.Lock()
 			list = append(list, order)
 			bs, _ := json.Marshal(order)
+			listMu.Unlock()
 			fmt.Println("Order", string(bs))
 			break
 		}
@@ -142,14 +196,11 @@ func orderhandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func listhandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Methods", "POST,GET")
-	w.Header().Add("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
-	w.Header().Add("Access-Control-Max-Age", "-1")
-
 	res := make(map[string]interface{})
+	listMu.Lock()
 	res["result"] = list
 	bs, _ := json.Marshal(res)
+	listMu.Unlock()
 	w.Write(bs)
 }
 
@@ -158,8 +209,17 @@ func loadConf() {
 	rpcurl = conf.GetString("rpcurl", rpcurl)
 	rpcuser = conf.GetString("rpcuser", rpcuser)
 	rpcpass = conf.GetString("rpcpass", rpcpass)
+	rpccookie = conf.GetString("rpccookie", rpccookie)
+	rpcsecrets = conf.GetString("rpcsecrets", rpcsecrets)
+	rpcwallet = conf.GetString("rpcwallet", rpcwallet)
+	rpcrecord = conf.GetString("rpcrecord", rpcrecord)
+	notifySources = notify.Sources{ZMQ: conf.GetString("zmqpub", ""), HTTP: conf.GetString("notifyaddr", "")}
+	pollinterval = notifySources.PollInterval(conf.GetNumber("pollinterval", 0))
 	laddr = conf.GetString("laddr", laddr)
+	orderfile = conf.GetString("orderfile", orderfile)
 	confidential = conf.GetBool("confidential", confidential)
+	conf.GetInterface("http", &httpConf)
+	conf.GetInterface("tls", &tlsConf)
 }
 
 func main() {
@@ -167,26 +227,90 @@ func main() {
 	fmt.Println("Dave starting")
 
 	loadConf()
-	rpcClient = rpc.NewRpc(rpcurl, rpcuser, rpcpass)
+	lib.SetLogger(logger)
+	rpc.SetLogger(logger)
+	notify.SetLogger(logger)
+	var err error
+	rpcClient, err = rpc.NewRpcWithConfig(rpc.Config{
+		URL:         rpcurl,
+		User:        rpcuser,
+		Pass:        rpcpass,
+		CookieFile:  rpccookie,
+		SecretsFile: rpcsecrets,
+		SecretsName: "dave",
+		Wallet:      rpcwallet,
+		RecordFile:  rpcrecord,
+	})
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	err = lib.LoadState(orderfile, &list)
+	if err != nil {
+		logger.Println("error:", err)
+	}
 
+	err = tlsConf.Load("dave")
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	serverConf, err := tlsConf.ServerConfig()
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
 	listener, err := net.Listen("tcp", laddr)
 	if err != nil {
 		logger.Println("net#Listen error:", err)
-		return
+		os.Exit(lib.ExitFailure)
+	}
+	if serverConf != nil {
+		listener = tls.NewListener(listener, serverConf)
 	}
-	defer listener.Close()
 
+	lc := lib.NewLifecycle("dave")
+	mws, auth, err := httpConf.Middlewares()
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/order", orderhandler)
-	mux.HandleFunc("/list", listhandler)
+	mux.Handle("/order", lib.Chain(http.HandlerFunc(orderhandler), mws...))
+	mux.Handle("/list", lib.Chain(http.HandlerFunc(listhandler), mws...))
+	mux.Handle("/jobs", lib.Chain(lc.Scheduler().Handler(), mws...))
+	if auth != nil {
+		mux.Handle(lib.SessionPath, auth.SessionHandler())
+	}
 	dir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
 	fmt.Println("html path:", http.Dir(dir+"/html/dave"))
 	mux.Handle("/", http.FileServer(http.Dir(dir+"/html/dave")))
-	fmt.Println("start listening...", listener.Addr().Network(), listener.Addr())
-	go http.Serve(listener, mux)
 
-	lib.SetLogger(logger)
-	lib.StartCyclic(callback, 3, true)
+	lc.Close("rpc", rpcClient)
+	lc.OnStop("orders", func(context.Context) error {
+		listMu.Lock()
+		defer listMu.Unlock()
+		return lib.SaveState(orderfile, list)
+	})
+	lc.Serve(listener, lib.Chain(mux, lib.RequestID, lib.Recover))
+	events, err := notify.Subscribe(lc.Context(), notifySources, notify.TopicHashBlock, notify.TopicHashTx)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	err = lc.Schedule(lib.Job{
+		Name:     "payments",
+		Interval: pollinterval,
+		Timeout:  time.Minute,
+		Events:   events,
+		Run:      scanPayments,
+	})
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
 
+	code := lc.Run()
 	fmt.Println("Dave stopping")
+	os.Exit(code)
 }
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package main
+
+import (
+	"context"
+	"elementssim"
+	"elementstx"
+	"log"
+	"net/http/httptest"
+	"os"
+	"rpc"
+	"rpc/rpctest"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger = log.New(os.Stdout, "Dave:", log.LstdFlags+log.Lshortfile)
+	os.Exit(m.Run())
+}
+
+// pay sends amount MELON from the wallet of charlie to addr and mines it.
+func pay(t *testing.T, sim *elementssim.Server, server *httptest.Server, addr string, amount rpc.Amount) {
+	t.Helper()
+	charlie := rpc.NewRpc(server.URL, "", "")
+	charlie.Wallet = "charlie"
+	labels, err := charlie.DumpAssetLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxos, err := charlie.ListUnspent(rpc.ListUnspentOptions{MinConf: 1, Asset: "MELON"})
+	if err != nil || len(utxos) == 0 {
+		t.Fatalf("no utxo of charlie: %v", err)
+	}
+	change, err := charlie.GetNewAddr(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := elementstx.NewBuilder(elementstx.RegtestParams)
+	err = builder.AddInput(utxos[0].Txid, utxos[0].Vout)
+	if err == nil {
+		err = builder.AddOutputAddr(int64(amount), addr, labels["MELON"])
+	}
+	if err == nil {
+		err = builder.AddOutputAddr(int64(utxos[0].Amount-amount), change, labels["MELON"])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := charlie.SignRawTransaction(builder.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = charlie.SendRawTransaction(signed.Hex, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Mine(1)
+}
+
+func TestScanPayments(t *testing.T) {
+	// set up the same way when recording and replaying, so that the addresses are the recorded ones.
+	sim := elementssim.NewServer()
+	for _, name := range []string{"charlie", "dave"} {
+		err := sim.CreateWallet(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sim.AddAsset("MELON")
+	_, err := sim.Fund("charlie", "MELON", 1000*elementstx.Coin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	addrs := make([]string, 3)
+	for i := range addrs {
+		// the node of dave is replayed, so the orders take their addresses from another client.
+		node := rpc.NewRpc(server.URL, "", "")
+		node.Wallet = "dave"
+		addrs[i], err = node.GetNewAddr(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pay(t, sim, server, addrs[0], 200*rpc.Coin)
+	pay(t, sim, server, addrs[1], 100*rpc.Coin)
+
+	rpcClient = rpctest.NewNode(t, server.URL, "dave", "testdata/scanpayments.jsonl")
+	now := time.Now().Unix()
+	paid := &Order{Addr: addrs[0], Asset: "MELON", Price: 200 * rpc.Coin, Timeout: now + 3600, LastModify: now}
+	short := &Order{Addr: addrs[1], Asset: "MELON", Price: 200 * rpc.Coin, Timeout: now + 3600, LastModify: now}
+	expired := &Order{Addr: addrs[2], Asset: "MELON", Price: 200 * rpc.Coin, Timeout: now - 1, LastModify: now}
+	list = []*Order{paid, short, expired}
+
+	err = scanPayments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid.Status != 1 || short.Status != 0 || expired.Status != -1 {
+		t.Errorf("status paid:%d short:%d expired:%d, want 1 0 -1", paid.Status, short.Status, expired.Status)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// democa project main.go
+//
+// democa issues the demo CA and the certificates of the actors before they start.
+//
+//	democa -dir democa alice charlie dave
+//
+// Only ca.pem and the certificate and key of each actor are written, the key of the CA is not kept.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"lib"
+)
+
+func main() {
+	dir := flag.String("dir", "democa", "directory of the certificates")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: democa [-dir DIR] NAME...")
+		os.Exit(lib.ExitFailure)
+	}
+	err := lib.IssueDemoCerts(*dir, flag.Args()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementssim Chain state
+package elementssim
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"elementstx"
+	"rpc"
+)
+
+// PolicyAssetLabel is the label of the policy asset, which pays the coinbase.
+const PolicyAssetLabel = "bitcoin"
+
+// PolicyAsset is the asset id of the policy asset.
+var PolicyAsset = hashHex("asset", PolicyAssetLabel)
+
+// genesisTime is the time of the genesis block, each block is a minute later.
+const genesisTime = int64(1500000000)
+
+// Prefix bytes of the simulated (blinded) commitments.
+const (
+	prefixAssetCommitment = byte(10)
+	prefixValueCommitment = byte(8)
+	prefixNonce           = byte(2)
+)
+
+const opReturn = 0x6a
+
+// coin is a transaction output paying to a script.
+type coin struct {
+	Txid            string
+	Vout            int64
+	Script          []byte
+	Asset           string
+	Amount          int64
+	AssetCommitment string // "" if explicit
+	ValueCommitment string // "" if explicit
+	Blinder         string
+	SpentBy         string // txid of the spending transaction
+}
+
+// blinding is what the simulator remembers of a blinded output.
+type blinding struct {
+	Asset           string
+	Amount          int64
+	AssetCommitment string
+	Blinder         string
+}
+
+type txRecord struct {
+	tx     *elementstx.Transaction
+	hex    string
+	height int64 // -1 in the mempool
+}
+
+type blockRecord struct {
+	hash   string
+	height int64
+	time   int64
+	txids  []string
+	size   int64
+}
+
+// credit is an output of a transaction created by the simulator itself.
+type credit struct {
+	addr   *address
+	asset  string
+	amount int64
+}
+
+type chain struct {
+	blocks  []*blockRecord
+	byHash  map[string]*blockRecord
+	txs     map[string]*txRecord
+	mempool []string
+	coins   map[string]*coin     // keyed by outpoint
+	blinded map[string]*blinding // keyed by value commitment
+	counter int64
+}
+
+func newChain() *chain {
+	c := &chain{
+		byHash:  make(map[string]*blockRecord),
+		txs:     make(map[string]*txRecord),
+		mempool: []string{},
+		coins:   make(map[string]*coin),
+		blinded: make(map[string]*blinding),
+	}
+	c.addBlock(nil)
+	return c
+}
+
+func (c *chain) height() int64 {
+	return int64(len(c.blocks)) - 1
+}
+
+// confirmations returns the confirmations of a transaction. (0 in the mempool)
+func (c *chain) confirmations(txid string) int64 {
+	rec, ok := c.txs[txid]
+	if !ok || rec.height < 0 {
+		return 0
+	}
+	return c.height() - rec.height + 1
+}
+
+func (c *chain) addBlock(txids []string) *blockRecord {
+	height := int64(len(c.blocks))
+	prev := ""
+	if 0 < height {
+		prev = c.blocks[height-1].hash
+	}
+	b := &blockRecord{
+		height: height,
+		time:   genesisTime + height*60,
+		txids:  txids,
+	}
+	b.hash = hashHex(append([]string{"block", prev, strconv.FormatInt(height, 10)}, txids...)...)
+	for _, txid := range txids {
+		rec := c.txs[txid]
+		rec.height = height
+		b.size += int64(len(rec.hex) / 2)
+	}
+	c.blocks = append(c.blocks, b)
+	c.byHash[b.hash] = b
+	return b
+}
+
+// mine mines blocks with a coinbase each, the first one including the mempool.
+func (c *chain) mine(blocks int) []string {
+	hashes := make([]string, 0, blocks)
+	for i := 0; i < blocks; i++ {
+		coinbase := c.newCoinbase(c.height() + 1)
+		txids := append([]string{c.store(coinbase, 0).tx.TxID()}, c.mempool...)
+		c.mempool = []string{}
+		hashes = append(hashes, c.addBlock(txids).hash)
+	}
+	return hashes
+}
+
+// newCoinbase returns a coinbase paying nothing, as in Elements regtest.
+func (c *chain) newCoinbase(height int64) *elementstx.Transaction {
+	tx := elementstx.NewTransaction()
+	tx.Inputs = append(tx.Inputs, &elementstx.TxIn{
+		PrevOut:   elementstx.OutPoint{Index: 0xffffffff},
+		ScriptSig: scriptNumber(height),
+		Sequence:  elementstx.DefaultSequence,
+	})
+	asset, _ := elementstx.ExplicitAsset(PolicyAsset)
+	value, _ := elementstx.ExplicitValue(0)
+	tx.Outputs = append(tx.Outputs, &elementstx.TxOut{Asset: asset, Value: value, ScriptPubKey: []byte{opReturn}})
+	return tx
+}
+
+// credit adds a transaction paying credits out of nothing to the mempool and returns its txid.
+func (c *chain) credit(credits []credit, blind bool) (string, error) {
+	c.counter++
+	tx := elementstx.NewTransaction()
+	tx.Inputs = append(tx.Inputs, &elementstx.TxIn{
+		PrevOut:   elementstx.OutPoint{Index: 0xffffffff},
+		ScriptSig: scriptNumber(c.counter),
+		Sequence:  elementstx.DefaultSequence,
+	})
+	for _, cr := range credits {
+		var blindingKey []byte
+		if blind {
+			blindingKey = cr.addr.blindingKey
+		}
+		assetCommitment, err := elementstx.ExplicitAsset(cr.asset)
+		if err != nil {
+			return "", err
+		}
+		valueCommitment, err := elementstx.ExplicitValue(cr.amount)
+		if err != nil {
+			return "", err
+		}
+		tx.Outputs = append(tx.Outputs, &elementstx.TxOut{
+			Asset:        assetCommitment,
+			Value:        valueCommitment,
+			Nonce:        blindingKey,
+			ScriptPubKey: cr.addr.script,
+		})
+	}
+	if blind {
+		c.blind(tx)
+	}
+	return c.accept(tx), nil
+}
+
+// blind replaces the explicit outputs to a confidential address with simulated commitments,
+// and returns the number of outputs blinded.
+func (c *chain) blind(tx *elementstx.Transaction) int {
+	decoded := tx.Decode()
+	count := 0
+	for i, out := range tx.Outputs {
+		do := decoded.Vout[i]
+		if !do.HasExplicitAsset() || !do.HasExplicitValue() || len(out.ScriptPubKey) == 0 || !isPubKey(out.Nonce) {
+			continue
+		}
+		c.counter++
+		seed := hashHex("blind", decoded.Txid, strconv.Itoa(i), strconv.FormatInt(c.counter, 10))
+		out.Asset = commitment(prefixAssetCommitment, "asset", seed)
+		out.Value = commitment(prefixValueCommitment, "value", seed)
+		out.Nonce = commitment(prefixNonce, "nonce", seed)
+		c.blinded[hex.EncodeToString(out.Value)] = &blinding{
+			Asset:           do.Asset,
+			Amount:          do.Value,
+			AssetCommitment: hex.EncodeToString(out.Asset),
+			Blinder:         hashHex("blinder", seed),
+		}
+
+		if len(tx.OutWitness) < len(tx.Outputs) {
+			ws := make([]*elementstx.TxOutWitness, len(tx.Outputs))
+			copy(ws, tx.OutWitness)
+			tx.OutWitness = ws
+		}
+		tx.OutWitness[i] = &elementstx.TxOutWitness{
+			SurjectionProof: hashBytes("surjectionproof", seed),
+			RangeProof:      hashBytes("rangeproof", seed),
+		}
+		count++
+	}
+	for i := range tx.OutWitness {
+		if tx.OutWitness[i] == nil {
+			tx.OutWitness[i] = &elementstx.TxOutWitness{}
+		}
+	}
+	return count
+}
+
+// unblind returns the asset and the amount of an output.
+func (c *chain) unblind(out elementstx.DecodedOutput) (*blinding, bool) {
+	if out.HasExplicitAsset() && out.HasExplicitValue() {
+		return &blinding{Asset: out.Asset, Amount: out.Value}, true
+	}
+	b, ok := c.blinded[out.ValueCommitment]
+	if !ok || b.AssetCommitment != out.AssetCommitment {
+		return nil, false
+	}
+	return b, true
+}
+
+// validate checks that tx spends unspent coins with signatures and balances per asset.
+func (c *chain) validate(tx *elementstx.Transaction) error {
+	decoded := tx.Decode()
+	if rec, ok := c.txs[decoded.Txid]; ok {
+		if 0 <= rec.height {
+			return rpcError(rpc.ErrCodeVerifyAlreadyInBC, "transaction already in block chain")
+		}
+		return rpcError(rpc.ErrCodeVerifyRejected, "txn-already-in-mempool")
+	}
+	if len(decoded.Vin) == 0 || len(decoded.Vout) == 0 {
+		return rpcError(rpc.ErrCodeVerifyRejected, "bad-txns-vin-empty or vout-empty")
+	}
+
+	balance := make(map[string]int64)
+	spent := make(map[string]bool)
+	for i, in := range decoded.Vin {
+		if in.IsCoinbase {
+			return rpcError(rpc.ErrCodeVerifyRejected, "coinbase")
+		}
+		key := outpointKey(in.Txid, in.Vout)
+		cn, ok := c.coins[key]
+		if !ok {
+			return rpcError(rpc.ErrCodeVerify, "Missing inputs")
+		}
+		if cn.SpentBy != "" || spent[key] {
+			return rpcError(rpc.ErrCodeVerifyRejected, "bad-txns-inputs-spent: %s spent by %s", key, cn.SpentBy)
+		}
+		if len(in.ScriptSigData) == 0 {
+			return rpcError(rpc.ErrCodeVerifyRejected, "mandatory-script-verify-flag-failed: input %d is not signed", i)
+		}
+		spent[key] = true
+		balance[cn.Asset] += cn.Amount
+	}
+	for i, out := range decoded.Vout {
+		b, ok := c.unblind(out)
+		if !ok {
+			return rpcError(rpc.ErrCodeVerifyRejected, "bad-txns-unknown-commitment: output %d", i)
+		}
+		if len(out.ScriptData) == 0 && !out.IsFee() {
+			return rpcError(rpc.ErrCodeVerifyRejected, "bad-txns-fee-not-explicit: output %d", i)
+		}
+		balance[b.Asset] -= b.Amount
+	}
+	for asset, diff := range balance {
+		if diff != 0 {
+			return rpcError(rpc.ErrCodeVerifyRejected, "bad-txns-in-ne-out: asset %s differs by %d", asset, diff)
+		}
+	}
+	return nil
+}
+
+// accept adds tx to the mempool, spending its inputs and adding its outputs. It returns the txid.
+func (c *chain) accept(tx *elementstx.Transaction) string {
+	rec := c.store(tx, -1)
+	txid := rec.tx.TxID()
+	c.mempool = append(c.mempool, txid)
+	return txid
+}
+
+// store records tx and its coins at height.
+func (c *chain) store(tx *elementstx.Transaction, height int64) *txRecord {
+	rec := &txRecord{tx: tx, hex: tx.Hex(), height: height}
+	decoded := tx.Decode()
+	c.txs[decoded.Txid] = rec
+	for _, in := range decoded.Vin {
+		if in.IsCoinbase {
+			continue
+		}
+		if cn, ok := c.coins[outpointKey(in.Txid, in.Vout)]; ok {
+			cn.SpentBy = decoded.Txid
+		}
+	}
+	for i, out := range decoded.Vout {
+		if len(out.ScriptData) == 0 || out.ScriptData[0] == opReturn {
+			continue
+		}
+		b, ok := c.unblind(out)
+		if !ok {
+			continue
+		}
+		c.coins[outpointKey(decoded.Txid, int64(i))] = &coin{
+			Txid:            decoded.Txid,
+			Vout:            int64(i),
+			Script:          out.ScriptData,
+			Asset:           b.Asset,
+			Amount:          b.Amount,
+			AssetCommitment: out.AssetCommitment,
+			ValueCommitment: out.ValueCommitment,
+			Blinder:         b.Blinder,
+		}
+	}
+	return rec
+}
+
+func outpointKey(txid string, vout int64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+// hashHex returns the hex of the sha256 of the parts, used for ids and hashes.
+func hashHex(parts ...string) string {
+	return hex.EncodeToString(hashBytes(parts...))
+}
+
+func hashBytes(parts ...string) []byte {
+	h := sha256.New()
+	for _, p := range parts {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+		h.Write(n[:])
+		h.Write([]byte(p))
+	}
+	return h.Sum(nil)
+}
+
+func commitment(prefix byte, parts ...string) []byte {
+	return append([]byte{prefix}, hashBytes(parts...)...)
+}
+
+func isPubKey(b []byte) bool {
+	return len(b) == 33 && (b[0] == 2 || b[0] == 3)
+}
+
+// scriptNumber returns a script pushing n, which makes coinbase transactions unique.
+func scriptNumber(n int64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(n))
+	size := 8
+	for 1 < size && b[size-1] == 0 {
+		size--
+	}
+	return append([]byte{byte(size)}, b[:size]...)
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package elementssim is an in-memory stand-in for elementsd serving the JSON-RPC
+subset the actors use, so the demo flow runs without a node.
+
+It keeps real state: wallets with addresses, explicit and blinded utxos,
+assets and labels, a mempool and blocks. Blinding is simulated: commitments
+are random looking hashes which the simulator remembers, so it can check
+that transactions balance.
+
+usage:
+
+	sim := elementssim.NewServer()
+	sim.CreateWallet("alice")
+	sim.AddAsset("AIRSKY")
+	_, err := sim.Fund("alice", "AIRSKY", 1000*elementstx.Coin, true)
+
+	ts := httptest.NewServer(sim)
+	defer ts.Close()
+	rpcClient := rpc.NewRpc(ts.URL, "", "")
+	rpcClient.Wallet = "alice"
+*/
+package elementssim
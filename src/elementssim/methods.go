@@ -0,0 +1,513 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementssim RPC methods
+package elementssim
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"elementstx"
+	"rpc"
+)
+
+// walletVersion is reported by getwalletinfo.
+const walletVersion = 130000
+
+// unspent is an entry of listunspent, with the label of the newer nodes.
+type unspent struct {
+	rpc.Unspent
+	Label string `json:"label"`
+}
+
+func (s *Server) getNetworkInfo(_ *wallet, _ []json.RawMessage) (interface{}, error) {
+	return rpc.NetworkInfo{
+		Version:         s.Version,
+		Subversion:      "/Elements Core:sim/",
+		ProtocolVersion: 70015,
+	}, nil
+}
+
+func (s *Server) getBlockCount(_ *wallet, _ []json.RawMessage) (interface{}, error) {
+	return s.chain.height(), nil
+}
+
+func (s *Server) getBlockHash(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	height, err := paramInt(params, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || s.chain.height() < height {
+		return nil, rpcError(rpc.ErrCodeInvalidParameter, "Block height out of range")
+	}
+	return s.chain.blocks[height].hash, nil
+}
+
+func (s *Server) getBlock(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	hash, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	b, ok := s.chain.byHash[hash]
+	if !ok {
+		return nil, rpcError(rpc.ErrCodeInvalidAddress, "Block not found")
+	}
+	block := rpc.Block{
+		Hash:          b.hash,
+		Confirmations: s.chain.height() - b.height + 1,
+		Size:          b.size,
+		Height:        b.height,
+		Version:       0x20000000,
+		MerkleRoot:    hashHex(append([]string{"merkle"}, b.txids...)...),
+		Tx:            append([]string{}, b.txids...),
+		Time:          b.time,
+	}
+	if 0 < b.height {
+		block.PreviousBlockHash = s.chain.blocks[b.height-1].hash
+	}
+	if b.height < s.chain.height() {
+		block.NextBlockHash = s.chain.blocks[b.height+1].hash
+	}
+	return block, nil
+}
+
+func (s *Server) getRawTransaction(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	txid, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := s.chain.txs[txid]
+	if !ok {
+		return nil, rpcError(rpc.ErrCodeInvalidAddress, "No such mempool or blockchain transaction")
+	}
+	if 1 < len(params) {
+		verbose := strings.TrimSpace(string(params[1]))
+		if verbose == "true" || verbose == "1" {
+			return rec.tx.Decode(), nil
+		}
+	}
+	return rec.hex, nil
+}
+
+func (s *Server) getRawMempool(_ *wallet, _ []json.RawMessage) (interface{}, error) {
+	return append([]string{}, s.chain.mempool...), nil
+}
+
+// getTxOut returns null for a spent or unknown output, and a mempool output only if include_mempool is set.
+func (s *Server) getTxOut(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	txid, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	vout, err := paramInt(params, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	mempool, err := paramBool(params, 2, true)
+	if err != nil {
+		return nil, err
+	}
+	cn, ok := s.chain.coins[outpointKey(txid, vout)]
+	conf := s.chain.confirmations(txid)
+	if !ok || cn.SpentBy != "" || (conf == 0 && !mempool) {
+		return nil, nil
+	}
+	out := rpc.TxOut{
+		BestBlock:     s.chain.blocks[len(s.chain.blocks)-1].hash,
+		Confirmations: conf,
+		ScriptPubKey:  rpc.ScriptPubKey{Hex: hex.EncodeToString(cn.Script)},
+	}
+	if addr, err := elementstx.EncodeAddress(cn.Script, nil, elementstx.RegtestParams); err == nil {
+		out.ScriptPubKey.Address = addr
+	}
+	return out, nil
+}
+
+// sendRawTransaction accepts a signed and balanced transaction into the mempool.
+// The fee limit (allowhighfees or maxfeerate) is ignored.
+func (s *Server) sendRawTransaction(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	tx, err := paramTransaction(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = s.chain.validate(tx)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.accept(tx), nil
+}
+
+func (s *Server) dumpAssetLabels(_ *wallet, _ []json.RawMessage) (interface{}, error) {
+	labels := make(map[string]string, len(s.assets))
+	for label, id := range s.assets {
+		labels[label] = id
+	}
+	return labels, nil
+}
+
+// validateAddress serves validateaddress and getaddressinfo.
+func (s *Server) validateAddress(w *wallet, params []json.RawMessage) (interface{}, error) {
+	addr, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := elementstx.DecodeAddress(addr, elementstx.RegtestParams)
+	if err != nil {
+		return rpc.ValidatedAddress{IsValid: false}, nil
+	}
+	res := rpc.ValidatedAddress{
+		IsValid:      true,
+		Address:      addr,
+		ScriptPubKey: hex.EncodeToString(decoded.ScriptPubKey),
+	}
+	res.Unconfidential, _ = elementstx.EncodeAddress(decoded.ScriptPubKey, nil, elementstx.RegtestParams)
+	if decoded.IsConfidential() {
+		res.ConfidentialKey = hex.EncodeToString(decoded.BlindingKey)
+	}
+	if a, ok := w.lookup(decoded.ScriptPubKey); ok {
+		res.IsMine = true
+		res.PubKey = hex.EncodeToString(a.pubKey)
+		res.IsCompressed = true
+		res.Account = a.label
+		res.ConfidentialKey = hex.EncodeToString(a.blindingKey)
+		res.Confidential = a.confidential
+	}
+	return res, nil
+}
+
+// getNewAddress returns a confidential address, whatever the address type asked for.
+func (s *Server) getNewAddress(w *wallet, params []json.RawMessage) (interface{}, error) {
+	label, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	a := w.newAddress()
+	a.label = label
+	return a.confidential, nil
+}
+
+// balances returns the confirmed and unconfirmed balances of the wallet keyed by asset label.
+func (s *Server) balances(w *wallet) (rpc.BalanceMap, rpc.BalanceMap) {
+	confirmed := make(rpc.BalanceMap)
+	unconfirmed := make(rpc.BalanceMap)
+	for _, cn := range w.coins(s.chain) {
+		if cn.SpentBy != "" {
+			continue
+		}
+		label := s.assetLabel(cn.Asset)
+		if s.chain.confirmations(cn.Txid) == 0 {
+			unconfirmed[label] += rpc.Amount(cn.Amount)
+		} else {
+			confirmed[label] += rpc.Amount(cn.Amount)
+		}
+	}
+	return confirmed, unconfirmed
+}
+
+func (s *Server) getWalletInfo(w *wallet, _ []json.RawMessage) (interface{}, error) {
+	confirmed, unconfirmed := s.balances(w)
+	txids := make(map[string]bool)
+	for _, cn := range w.coins(s.chain) {
+		txids[cn.Txid] = true
+		if cn.SpentBy != "" {
+			txids[cn.SpentBy] = true
+		}
+	}
+	return rpc.Wallet{
+		WalletVersion:      walletVersion,
+		Balance:            confirmed,
+		UnconfirmedBalance: unconfirmed,
+		ImmatureBalance:    rpc.BalanceMap{},
+		TxCount:            int64(len(txids)),
+		KeypoolSize:        100,
+		HDMasterKeyId:      hashHex("hdmaster", w.name)[:40],
+	}, nil
+}
+
+func (s *Server) getBalances(w *wallet, _ []json.RawMessage) (interface{}, error) {
+	confirmed, unconfirmed := s.balances(w)
+	return map[string]map[string]rpc.BalanceMap{
+		"mine": {
+			"trusted":           confirmed,
+			"untrusted_pending": unconfirmed,
+			"immature":          {},
+		},
+	}, nil
+}
+
+// listUnspent takes the asset filter either as a string (2017) or as query_options (0.17 and later).
+func (s *Server) listUnspent(w *wallet, params []json.RawMessage) (interface{}, error) {
+	minconf, err := paramInt(params, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	maxconf, err := paramInt(params, 1, 9999999)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	err = paramUnmarshal(params, 2, &addrs)
+	if err != nil {
+		return nil, err
+	}
+	scripts := make(map[string]bool)
+	for _, addr := range addrs {
+		decoded, err := elementstx.DecodeAddress(addr, elementstx.RegtestParams)
+		if err != nil {
+			return nil, rpcError(rpc.ErrCodeInvalidAddress, "Invalid Bitcoin address: %s", addr)
+		}
+		scripts[hex.EncodeToString(decoded.ScriptPubKey)] = true
+	}
+	asset := ""
+	if 4 < len(params) {
+		var opts struct {
+			Asset string `json:"asset"`
+		}
+		if json.Unmarshal(params[4], &opts) != nil {
+			err = paramUnmarshal(params, 4, &opts.Asset)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if opts.Asset != "" {
+			id, ok := s.assetID(opts.Asset)
+			if !ok {
+				return nil, rpcError(rpc.ErrCodeInvalidParameter, "Unknown label and invalid asset hex: %s", opts.Asset)
+			}
+			asset = id
+		}
+	}
+
+	list := []unspent{}
+	for _, cn := range w.coins(s.chain) {
+		conf := s.chain.confirmations(cn.Txid)
+		key := outpointKey(cn.Txid, cn.Vout)
+		if cn.SpentBy != "" || w.locked[key] || conf < minconf || maxconf < conf {
+			continue
+		}
+		if asset != "" && cn.Asset != asset {
+			continue
+		}
+		scriptHex := hex.EncodeToString(cn.Script)
+		if 0 < len(scripts) && !scripts[scriptHex] {
+			continue
+		}
+		a, _ := w.lookup(cn.Script)
+		u := unspent{Label: a.label}
+		u.Txid = cn.Txid
+		u.Vout = cn.Vout
+		u.Address = a.unconfidential
+		u.Account = a.label
+		u.ScriptPubKey = scriptHex
+		u.Amount = rpc.Amount(cn.Amount)
+		u.Asset = cn.Asset
+		u.AssetCommitment = cn.AssetCommitment
+		u.Confirmations = conf
+		u.SerValue = cn.ValueCommitment
+		u.Blinder = cn.Blinder
+		u.Spendable = true
+		u.Solvable = true
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// getReceivedByAddress takes include_immature_coinbase before the asset if there are 4 params (23.x).
+func (s *Server) getReceivedByAddress(w *wallet, params []json.RawMessage) (interface{}, error) {
+	addr, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	minconf, err := paramInt(params, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	assetIndex := 2
+	if 3 < len(params) {
+		assetIndex = 3
+	}
+	asset, err := paramString(params, assetIndex, PolicyAssetLabel)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := elementstx.DecodeAddress(addr, elementstx.RegtestParams)
+	if err != nil {
+		return nil, rpcError(rpc.ErrCodeInvalidAddress, "Invalid Bitcoin address")
+	}
+	if _, ok := w.lookup(decoded.ScriptPubKey); !ok {
+		return nil, rpcError(rpc.ErrCodeWallet, "Address not found in wallet")
+	}
+	assetID, ok := s.assetID(asset)
+	if !ok {
+		return nil, rpcError(rpc.ErrCodeInvalidParameter, "Unknown label and invalid asset hex: %s", asset)
+	}
+
+	total := rpc.Amount(0)
+	for _, cn := range w.coins(s.chain) {
+		if cn.Asset == assetID && string(cn.Script) == string(decoded.ScriptPubKey) &&
+			minconf <= s.chain.confirmations(cn.Txid) {
+			total += rpc.Amount(cn.Amount)
+		}
+	}
+	return total, nil
+}
+
+// blindRawTransaction blinds the explicit outputs to confidential addresses.
+// The input commitments are not needed by the simulation.
+func (s *Server) blindRawTransaction(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	tx, err := paramTransaction(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	ignoreBlindFail, err := paramBool(params, 1, true)
+	if err != nil {
+		return nil, err
+	}
+	if s.chain.blind(tx) == 0 && !ignoreBlindFail {
+		return nil, rpcError(rpc.ErrCodeInvalidParameter, "Unable to blind transaction: no output to blind")
+	}
+	return tx.Hex(), nil
+}
+
+func (s *Server) signRawTransaction(w *wallet, params []json.RawMessage) (interface{}, error) {
+	tx, err := paramTransaction(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	complete := w.sign(s.chain, tx)
+	return rpc.SignedTransaction{Hex: tx.Hex(), Complete: complete}, nil
+}
+
+func (s *Server) generate(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	blocks, err := paramInt(params, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	return s.chain.mine(int(blocks)), nil
+}
+
+func (s *Server) generateToAddress(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	blocks, err := paramInt(params, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := paramString(params, 1, "")
+	if err != nil {
+		return nil, err
+	}
+	_, err = elementstx.DecodeAddress(addr, elementstx.RegtestParams)
+	if err != nil {
+		return nil, rpcError(rpc.ErrCodeInvalidAddress, "Error: Invalid address")
+	}
+	return s.chain.mine(int(blocks)), nil
+}
+
+// issueAsset issues a new unlabeled asset and its reissuance token to the wallet, in the mempool.
+func (s *Server) issueAsset(w *wallet, params []json.RawMessage) (interface{}, error) {
+	amount, err := paramAmount(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	tokenAmount, err := paramAmount(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	blind, err := paramBool(params, 2, true)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 && tokenAmount <= 0 {
+		return nil, rpcError(rpc.ErrCodeInvalidParameter, "Issuance must have one non-zero component")
+	}
+
+	s.chain.counter++
+	entropy := hashHex("entropy", w.name, strconv.FormatInt(s.chain.counter, 10))
+	issued := rpc.IssuedAsset{
+		Entropy: entropy,
+		Asset:   hashHex("asset", entropy),
+		Token:   hashHex("token", entropy),
+	}
+	var credits []credit
+	if 0 < amount {
+		credits = append(credits, credit{w.newAddress(), issued.Asset, int64(amount)})
+	}
+	if 0 < tokenAmount {
+		credits = append(credits, credit{w.newAddress(), issued.Token, int64(tokenAmount)})
+	}
+	issued.Txid, err = s.chain.credit(credits, blind)
+	return issued, err
+}
+
+func (s *Server) lockUnspent(w *wallet, params []json.RawMessage) (interface{}, error) {
+	unlock, err := paramBool(params, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) < 2 {
+		if !unlock {
+			return nil, rpcError(rpc.ErrCodeInvalidParameter, "Invalid parameter, expected transactions")
+		}
+		w.locked = make(map[string]bool)
+		return true, nil
+	}
+	var outpoints []rpc.OutPoint
+	err = paramUnmarshal(params, 1, &outpoints)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range outpoints {
+		key := outpointKey(op.Txid, op.Vout)
+		cn, ok := s.chain.coins[key]
+		if !ok {
+			return nil, rpcError(rpc.ErrCodeInvalidParameter, "Invalid parameter, unknown transaction")
+		}
+		if cn.SpentBy != "" {
+			return nil, rpcError(rpc.ErrCodeInvalidParameter, "Invalid parameter, expected unspent output")
+		}
+	}
+	for _, op := range outpoints {
+		key := outpointKey(op.Txid, op.Vout)
+		if unlock {
+			delete(w.locked, key)
+		} else {
+			w.locked[key] = true
+		}
+	}
+	return true, nil
+}
+
+func (s *Server) listLockUnspent(w *wallet, _ []json.RawMessage) (interface{}, error) {
+	outpoints := []rpc.OutPoint{}
+	for _, cn := range w.coins(s.chain) {
+		if w.locked[outpointKey(cn.Txid, cn.Vout)] {
+			outpoints = append(outpoints, rpc.OutPoint{Txid: cn.Txid, Vout: cn.Vout})
+		}
+	}
+	return outpoints, nil
+}
+
+func (s *Server) createWallet(_ *wallet, params []json.RawMessage) (interface{}, error) {
+	name, err := paramString(params, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.wallets[name]; ok {
+		return nil, rpcError(rpc.ErrCodeWallet, "Wallet %s already exists.", name)
+	}
+	s.wallets[name] = newWallet(name)
+	return map[string]string{"name": name, "warning": ""}, nil
+}
+
+func (s *Server) listWallets(_ *wallet, _ []json.RawMessage) (interface{}, error) {
+	names := make([]string, 0, len(s.wallets))
+	for name := range s.wallets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
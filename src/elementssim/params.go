@@ -0,0 +1,64 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementssim RPC parameters
+package elementssim
+
+import (
+	"encoding/json"
+	"strings"
+
+	"elementstx"
+	"rpc"
+)
+
+// paramUnmarshal decodes the i-th param into v, leaving v as is if it is absent or null.
+func paramUnmarshal(params []json.RawMessage, i int, v interface{}) error {
+	if len(params) <= i || strings.TrimSpace(string(params[i])) == "null" {
+		return nil
+	}
+	err := json.Unmarshal(params[i], v)
+	if err != nil {
+		return rpcError(rpc.ErrCodeTypeError, "Expected type of param %d: %v", i, err)
+	}
+	return nil
+}
+
+func paramString(params []json.RawMessage, i int, def string) (string, error) {
+	v := def
+	err := paramUnmarshal(params, i, &v)
+	return v, err
+}
+
+func paramInt(params []json.RawMessage, i int, def int64) (int64, error) {
+	v := def
+	err := paramUnmarshal(params, i, &v)
+	return v, err
+}
+
+func paramBool(params []json.RawMessage, i int, def bool) (bool, error) {
+	v := def
+	err := paramUnmarshal(params, i, &v)
+	return v, err
+}
+
+// paramAmount decodes an amount in coin units.
+func paramAmount(params []json.RawMessage, i int) (rpc.Amount, error) {
+	var v rpc.Amount
+	err := paramUnmarshal(params, i, &v)
+	return v, err
+}
+
+// paramTransaction decodes a hex encoded transaction.
+func paramTransaction(params []json.RawMessage, i int) (*elementstx.Transaction, error) {
+	s, err := paramString(params, i, "")
+	if err != nil {
+		return nil, err
+	}
+	tx, err := elementstx.ParseTransactionHex(s)
+	if err != nil {
+		return nil, rpcError(rpc.ErrCodeDeserialization, "TX decode failed: %v", err)
+	}
+	return tx, nil
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementssim JSON-RPC server
+package elementssim
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"rpc"
+)
+
+// DefaultVersion is the version getnetworkinfo reports unless Server.Version is set. (Elements 2017)
+const DefaultVersion = 140100
+
+// walletPath is the URL prefix of the requests to a named wallet.
+const walletPath = "/wallet/"
+
+// Server is an in-memory Elements node. It implements http.Handler.
+type Server struct {
+	// Version is reported by getnetworkinfo, which selects the RPC shapes of the clients.
+	Version int64
+	// User and Pass are the credentials required when User is set.
+	User string
+	Pass string
+
+	mu      sync.Mutex
+	wallets map[string]*wallet
+	assets  map[string]string // asset id keyed by label
+	chain   *chain
+	methods map[string]method
+}
+
+// method is a RPC handler. w is nil for the methods which do not need a wallet.
+type method struct {
+	needWallet bool
+	handle     func(s *Server, w *wallet, params []json.RawMessage) (interface{}, error)
+}
+
+// NewServer returns new Server with the default wallet ("") and the bitcoin asset.
+func NewServer() *Server {
+	s := &Server{
+		Version: DefaultVersion,
+		wallets: make(map[string]*wallet),
+		assets:  map[string]string{PolicyAssetLabel: PolicyAsset},
+		chain:   newChain(),
+	}
+	s.wallets[""] = newWallet("")
+	s.methods = map[string]method{
+		"getnetworkinfo":               {false, (*Server).getNetworkInfo},
+		"getblockcount":                {false, (*Server).getBlockCount},
+		"getblockhash":                 {false, (*Server).getBlockHash},
+		"getblock":                     {false, (*Server).getBlock},
+		"getrawtransaction":            {false, (*Server).getRawTransaction},
+		"getrawmempool":                {false, (*Server).getRawMempool},
+		"gettxout":                     {false, (*Server).getTxOut},
+		"sendrawtransaction":           {false, (*Server).sendRawTransaction},
+		"dumpassetlabels":              {false, (*Server).dumpAssetLabels},
+		"validateaddress":              {true, (*Server).validateAddress},
+		"getaddressinfo":               {true, (*Server).validateAddress},
+		"getnewaddress":                {true, (*Server).getNewAddress},
+		"getwalletinfo":                {true, (*Server).getWalletInfo},
+		"getbalances":                  {true, (*Server).getBalances},
+		"listunspent":                  {true, (*Server).listUnspent},
+		"getreceivedbyaddress":         {true, (*Server).getReceivedByAddress},
+		"blindrawtransaction":          {true, (*Server).blindRawTransaction},
+		"signrawtransaction":           {true, (*Server).signRawTransaction},
+		"signrawtransactionwithwallet": {true, (*Server).signRawTransaction},
+		"generate":                     {true, (*Server).generate},
+		"generatetoaddress":            {false, (*Server).generateToAddress},
+		"issueasset":                   {true, (*Server).issueAsset},
+		"lockunspent":                  {true, (*Server).lockUnspent},
+		"listlockunspent":              {true, (*Server).listLockUnspent},
+		"createwallet":                 {false, (*Server).createWallet},
+		"listwallets":                  {false, (*Server).listWallets},
+	}
+	return s
+}
+
+// CreateWallet adds an empty wallet served at /wallet/<name>.
+func (s *Server) CreateWallet(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.wallets[name]; ok {
+		return fmt.Errorf("wallet already exists: %s", name)
+	}
+	s.wallets[name] = newWallet(name)
+	return nil
+}
+
+// AddAsset registers an asset label and returns its asset id.
+func (s *Server) AddAsset(label string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.assets[label]; ok {
+		return id
+	}
+	id := hashHex("asset", label)
+	s.assets[label] = id
+	return id
+}
+
+// Fund pays amount satoshi of the asset (label or id) to a new address of the wallet
+// in a new block, blinded if blind is true. It returns the txid.
+func (s *Server) Fund(walletName string, asset string, amount int64, blind bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.wallets[walletName]
+	if !ok {
+		return "", fmt.Errorf("wallet not found: %s", walletName)
+	}
+	assetID, ok := s.assetID(asset)
+	if !ok {
+		return "", fmt.Errorf("asset not found: %s", asset)
+	}
+	txid, err := s.chain.credit([]credit{{w.newAddress(), assetID, amount}}, blind)
+	if err != nil {
+		return "", err
+	}
+	s.chain.mine(1)
+	return txid, nil
+}
+
+// Mine mines blocks including the mempool and returns their hashes.
+func (s *Server) Mine(blocks int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chain.mine(blocks)
+}
+
+// ServeHTTP implements http.Handler. It serves single and batch requests.
+func (s *Server) ServeHTTP(hw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(hw, "JSONRPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.User != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.User || pass != s.Pass {
+			hw.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+			http.Error(hw, "", http.StatusUnauthorized)
+			return
+		}
+	}
+	walletName := ""
+	if strings.HasPrefix(r.URL.Path, walletPath) {
+		walletName = strings.TrimPrefix(r.URL.Path, walletPath)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(hw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	hw.Header().Set("Content-Type", "application/json")
+
+	if len(body) != 0 && body[0] == '[' {
+		var reqs []request
+		err = json.Unmarshal(body, &reqs)
+		if err != nil {
+			writeJSON(hw, http.StatusInternalServerError, response{Error: parseError(err)})
+			return
+		}
+		responses := make([]response, len(reqs))
+		for i, req := range reqs {
+			responses[i] = s.call(walletName, req)
+		}
+		writeJSON(hw, http.StatusOK, responses)
+		return
+	}
+
+	var req request
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeJSON(hw, http.StatusInternalServerError, response{Error: parseError(err)})
+		return
+	}
+	res := s.call(walletName, req)
+	status := http.StatusOK
+	if res.Error != nil {
+		status = http.StatusInternalServerError
+		if res.Error.Code == errCodeMethodNotFound {
+			status = http.StatusNotFound
+		}
+	}
+	writeJSON(hw, status, res)
+}
+
+// request is a JSON-RPC request.
+type request struct {
+	Id     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// response is a JSON-RPC response.
+type response struct {
+	Result interface{}   `json:"result"`
+	Error  *rpc.RpcError `json:"error"`
+	Id     interface{}   `json:"id"`
+}
+
+// JSON-RPC error codes of the server itself.
+const (
+	errCodeMethodNotFound = -32601
+	errCodeParse          = -32700
+)
+
+func (s *Server) call(walletName string, req request) response {
+	res := response{Id: req.Id}
+	m, ok := s.methods[req.Method]
+	if !ok {
+		res.Error = rpcError(errCodeMethodNotFound, "Method not found")
+		return res
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var w *wallet
+	if m.needWallet {
+		w, ok = s.wallets[walletName]
+		if !ok {
+			res.Error = rpcError(rpc.ErrCodeWalletNotFound, "Requested wallet does not exist or is not loaded")
+			return res
+		}
+	}
+	result, err := m.handle(s, w, req.Params)
+	if err != nil {
+		rerr, ok := err.(*rpc.RpcError)
+		if !ok {
+			rerr = rpcError(rpc.ErrCodeMisc, "%v", err)
+		}
+		res.Error = rerr
+		return res
+	}
+	res.Result = result
+	return res
+}
+
+// assetID resolves an asset label or id.
+func (s *Server) assetID(asset string) (string, bool) {
+	if id, ok := s.assets[asset]; ok {
+		return id, true
+	}
+	if _, err := hex.DecodeString(asset); err == nil && len(asset) == 64 {
+		return asset, true
+	}
+	return "", false
+}
+
+// assetLabel returns the label of an asset id, or the id if it has none.
+func (s *Server) assetLabel(id string) string {
+	for label, aid := range s.assets {
+		if aid == id {
+			return label
+		}
+	}
+	return id
+}
+
+func rpcError(code int, format string, a ...interface{}) *rpc.RpcError {
+	return &rpc.RpcError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func parseError(err error) *rpc.RpcError {
+	return rpcError(errCodeParse, "Parse error: %v", err)
+}
+
+func writeJSON(hw http.ResponseWriter, status int, v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		http.Error(hw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hw.WriteHeader(status)
+	hw.Write(bs)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package elementssim
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"elementstx"
+	"rpc"
+)
+
+func newNode(url string, wallet string) *rpc.Rpc {
+	node := rpc.NewRpc(url, "", "")
+	node.Wallet = wallet
+	return node
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExchange runs the demo: charlie offers MELON for AIRSKY, alice completes and blinds the offer
+// paying dave, charlie signs and broadcasts it, and dave sees the payment once it is mined.
+func TestExchange(t *testing.T) {
+	sim := NewServer()
+	for _, name := range []string{"alice", "charlie", "dave"} {
+		must(t, sim.CreateWallet(name))
+	}
+	airsky := sim.AddAsset("AIRSKY")
+	melon := sim.AddAsset("MELON")
+	_, err := sim.Fund("alice", "AIRSKY", 1000*elementstx.Coin, true)
+	must(t, err)
+	_, err = sim.Fund("charlie", "MELON", 1000*elementstx.Coin, true)
+	must(t, err)
+
+	ts := httptest.NewServer(sim)
+	defer ts.Close()
+	alice := newNode(ts.URL, "alice")
+	charlie := newNode(ts.URL, "charlie")
+	dave := newNode(ts.URL, "dave")
+
+	// dave: an order to be paid 50 MELON
+	orderAddr, err := dave.GetNewAddr(true)
+	must(t, err)
+
+	// charlie: the offer of 50 MELON for 100 AIRSKY
+	const cost, fee, amount = 100 * elementstx.Coin, elementstx.Coin, 50 * elementstx.Coin
+	cutxos, err := charlie.ListUnspent(rpc.ListUnspentOptions{MinConf: 1, Asset: "MELON"})
+	must(t, err)
+	if len(cutxos) != 1 {
+		t.Fatalf("utxos of charlie %+v", cutxos)
+	}
+	addrOffer, err := charlie.GetNewAddr(true)
+	must(t, err)
+	addrChange, err := charlie.GetNewAddr(true)
+	must(t, err)
+	builder := elementstx.NewBuilder(elementstx.RegtestParams)
+	must(t, builder.AddInput(cutxos[0].Txid, cutxos[0].Vout))
+	must(t, builder.AddOutputAddr(cost, addrOffer, airsky))
+	must(t, builder.AddOutputAddr(int64(cutxos[0].Amount)-amount, addrChange, melon))
+	template := builder.Hex()
+
+	// alice: her input, her change, the payment and the fee
+	autxos, err := alice.ListUnspent(rpc.ListUnspentOptions{MinConf: 1, Asset: "AIRSKY"})
+	must(t, err)
+	if len(autxos) != 1 {
+		t.Fatalf("utxos of alice %+v", autxos)
+	}
+	aliceChange, err := alice.GetNewAddr(true)
+	must(t, err)
+	builder, err = elementstx.NewBuilderFromHex(template, elementstx.RegtestParams)
+	must(t, err)
+	must(t, builder.AddInput(autxos[0].Txid, autxos[0].Vout))
+	must(t, builder.AddOutputAddr(int64(autxos[0].Amount)-cost-fee, aliceChange, airsky))
+	must(t, builder.AddOutputAddr(amount, orderAddr, melon))
+	must(t, builder.AddFeeOutput(fee, airsky))
+	commitments, err := alice.GetCommitments(append(cutxos, autxos...))
+	must(t, err)
+	blinded, err := alice.BlindRawTransaction(builder.Hex(), false, commitments)
+	must(t, err)
+	signed, err := alice.SignRawTransaction(blinded)
+	must(t, err)
+	if signed.Complete {
+		t.Fatal("signed by alice alone")
+	}
+
+	// charlie: submitted
+	signed, err = charlie.SignRawTransaction(signed.Hex)
+	must(t, err)
+	if !signed.Complete {
+		t.Fatal("not signed by both")
+	}
+	tampered, err := elementstx.ParseTransactionHex(signed.Hex)
+	must(t, err)
+	tampered.Inputs = tampered.Inputs[1:]
+	_, err = charlie.SendRawTransaction(tampered.Hex(), true)
+	if !errors.Is(err, rpc.ErrRejected) {
+		t.Errorf("unbalanced transaction: %v", err)
+	}
+	txid, err := charlie.SendRawTransaction(signed.Hex, true)
+	must(t, err)
+	again, err := charlie.SendRawTransaction(signed.Hex, true)
+	if err != nil || again != txid {
+		t.Errorf("sent again: %s %v, want %s", again, err, txid)
+	}
+
+	// dave: paid once mined
+	received, err := dave.GetReceivedByAddress(orderAddr, 1, "MELON")
+	must(t, err)
+	if received != 0 {
+		t.Errorf("received %s before mined", received)
+	}
+	sim.Mine(1)
+	received, err = dave.GetReceivedByAddress(orderAddr, 1, "MELON")
+	must(t, err)
+	if received != rpc.Amount(amount) {
+		t.Errorf("received %s, want %s", received, rpc.Amount(amount))
+	}
+
+	for _, c := range []struct {
+		node *rpc.Rpc
+		want rpc.BalanceMap
+	}{
+		{alice, rpc.BalanceMap{"AIRSKY": 899 * rpc.Coin}},
+		{charlie, rpc.BalanceMap{"AIRSKY": 100 * rpc.Coin, "MELON": 950 * rpc.Coin}},
+		{dave, rpc.BalanceMap{"MELON": 50 * rpc.Coin}},
+	} {
+		balance, err := c.node.GetBalance()
+		must(t, err)
+		for asset, want := range c.want {
+			if balance[asset] != want {
+				t.Errorf("%s: %s %s, want %s", c.node.Wallet, balance[asset], asset, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementssim Wallets
+package elementssim
+
+import (
+	"encoding/hex"
+	"sort"
+	"strconv"
+
+	"elementstx"
+)
+
+// address is a key of a wallet.
+type address struct {
+	script         []byte
+	pubKey         []byte
+	blindingKey    []byte
+	label          string
+	confidential   string
+	unconfidential string
+}
+
+// wallet holds the keys of a node wallet. Its coins are those of the chain paying to its keys.
+type wallet struct {
+	name      string
+	addresses map[string]*address // keyed by scriptPubKey hex
+	locked    map[string]bool     // keyed by outpoint
+	count     int
+}
+
+func newWallet(name string) *wallet {
+	return &wallet{
+		name:      name,
+		addresses: make(map[string]*address),
+		locked:    make(map[string]bool),
+	}
+}
+
+// newAddress derives the next P2PKH key of the wallet with its blinding key.
+func (w *wallet) newAddress() *address {
+	w.count++
+	seed := hashHex("key", w.name, strconv.Itoa(w.count))
+	hash160 := hashBytes("hash160", seed)[:20]
+
+	a := &address{
+		script:      append(append([]byte{0x76, 0xa9, 20}, hash160...), 0x88, 0xac),
+		pubKey:      append([]byte{3}, hashBytes("pubkey", seed)...),
+		blindingKey: append([]byte{2}, hashBytes("blindingkey", seed)...),
+	}
+	// the script and the keys are well formed, so encoding does not fail.
+	a.confidential, _ = elementstx.EncodeAddress(a.script, a.blindingKey, elementstx.RegtestParams)
+	a.unconfidential, _ = elementstx.EncodeAddress(a.script, nil, elementstx.RegtestParams)
+	w.addresses[hex.EncodeToString(a.script)] = a
+	return a
+}
+
+// lookup returns the key of the wallet for a scriptPubKey.
+func (w *wallet) lookup(script []byte) (*address, bool) {
+	a, ok := w.addresses[hex.EncodeToString(script)]
+	return a, ok
+}
+
+// coins returns the coins of the wallet in the chain, spent ones included, ordered by outpoint.
+func (w *wallet) coins(c *chain) []*coin {
+	var coins []*coin
+	for _, cn := range c.coins {
+		if _, ok := w.lookup(cn.Script); ok {
+			coins = append(coins, cn)
+		}
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].Txid != coins[j].Txid {
+			return coins[i].Txid < coins[j].Txid
+		}
+		return coins[i].Vout < coins[j].Vout
+	})
+	return coins
+}
+
+// sign signs the inputs spending coins of the wallet, and reports whether all inputs are signed.
+// The signature is simulated, a push of a hash followed by a push of the pubkey.
+func (w *wallet) sign(c *chain, tx *elementstx.Transaction) bool {
+	decoded := tx.Decode()
+	complete := true
+	for i, in := range tx.Inputs {
+		if len(in.ScriptSig) != 0 {
+			continue
+		}
+		key := outpointKey(decoded.Vin[i].Txid, decoded.Vin[i].Vout)
+		cn, ok := c.coins[key]
+		if !ok {
+			complete = false
+			continue
+		}
+		a, ok := w.lookup(cn.Script)
+		if !ok {
+			complete = false
+			continue
+		}
+		sig := hashBytes("signature", w.name, key)
+		in.ScriptSig = append(append([]byte{byte(len(sig))}, sig...), byte(len(a.pubKey)))
+		in.ScriptSig = append(in.ScriptSig, a.pubKey...)
+	}
+	return complete
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementstx Address decoding
+package elementstx
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+// AddressParams contains the base58 prefixes of a chain.
+type AddressParams struct {
+	Name          string
+	PubKeyHashID  byte
+	ScriptHashID  byte
+	BlindedPrefix byte
+}
+
+// RegtestParams is the address parameters of Elements regtest, which the demo runs on.
+var RegtestParams = &AddressParams{
+	Name:          "regtest",
+	PubKeyHashID:  235,
+	ScriptHashID:  75,
+	BlindedPrefix: 4,
+}
+
+// Address is a decoded address.
+type Address struct {
+	ScriptPubKey []byte
+	BlindingKey  []byte
+}
+
+// IsConfidential reports whether the address carries a blinding pubkey.
+func (a *Address) IsConfidential() bool {
+	return len(a.BlindingKey) != 0
+}
+
+const (
+	opDup         = 0x76
+	opHash160     = 0xa9
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opCheckSig    = 0xac
+	hash160Size   = 20
+	pubKeySize    = 33
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// DecodeAddress decodes a (confidential) base58 address into its scriptPubKey and blinding key.
+func DecodeAddress(addr string, params *AddressParams) (*Address, error) {
+	payload, err := base58CheckDecode(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address [%s]: %v", addr, err)
+	}
+
+	var a Address
+	switch {
+	case len(payload) == 1+hash160Size:
+	case len(payload) == 2+pubKeySize+hash160Size && payload[0] == params.BlindedPrefix:
+		a.BlindingKey = append([]byte{}, payload[2:2+pubKeySize]...)
+		if a.BlindingKey[0] != 2 && a.BlindingKey[0] != 3 {
+			return nil, fmt.Errorf("invalid address [%s]: blinding key is not a compressed pubkey", addr)
+		}
+		payload = append([]byte{payload[1]}, payload[2+pubKeySize:]...)
+	default:
+		return nil, fmt.Errorf("invalid address [%s]: unexpected length %d for %s", addr, len(payload), params.Name)
+	}
+
+	hash := payload[1:]
+	switch payload[0] {
+	case params.PubKeyHashID:
+		a.ScriptPubKey = append([]byte{opDup, opHash160, hash160Size}, hash...)
+		a.ScriptPubKey = append(a.ScriptPubKey, opEqualVerify, opCheckSig)
+	case params.ScriptHashID:
+		a.ScriptPubKey = append([]byte{opHash160, hash160Size}, hash...)
+		a.ScriptPubKey = append(a.ScriptPubKey, opEqual)
+	default:
+		return nil, fmt.Errorf("invalid address [%s]: unknown version %d for %s", addr, payload[0], params.Name)
+	}
+
+	return &a, nil
+}
+
+// EncodeAddress returns the base58 address of a P2PKH or P2SH scriptPubKey.
+// blindingKey may be nil for an unconfidential address.
+func EncodeAddress(script []byte, blindingKey []byte, params *AddressParams) (string, error) {
+	var payload []byte
+	switch {
+	case len(script) == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == hash160Size &&
+		script[23] == opEqualVerify && script[24] == opCheckSig:
+		payload = append([]byte{params.PubKeyHashID}, script[3:23]...)
+	case len(script) == 23 && script[0] == opHash160 && script[1] == hash160Size && script[22] == opEqual:
+		payload = append([]byte{params.ScriptHashID}, script[2:22]...)
+	default:
+		return "", fmt.Errorf("script has no address form: %x", script)
+	}
+	if len(blindingKey) != 0 {
+		if len(blindingKey) != pubKeySize {
+			return "", fmt.Errorf("invalid blinding key length: %d", len(blindingKey))
+		}
+		payload = append(append([]byte{params.BlindedPrefix, payload[0]}, blindingKey...), payload[1:]...)
+	}
+	return base58CheckEncode(payload), nil
+}
+
+func base58CheckEncode(payload []byte) string {
+	hash := doubleSha256(payload)
+	raw := append(append([]byte{}, payload...), hash[:4]...)
+
+	n := new(big.Int).SetBytes(raw)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range raw {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58CheckDecode(s string) ([]byte, error) {
+	raw, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 5 {
+		return nil, fmt.Errorf("too short")
+	}
+	payload, checksum := raw[:len(raw)-4], raw[len(raw)-4:]
+	hash := doubleSha256(payload)
+	if !bytes.Equal(hash[:4], checksum) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return payload, nil
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := bytes.IndexRune([]byte(base58Alphabet), c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementstx Transaction builder
+package elementstx
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Builder composes an unsigned transaction the same way elements-tx does.
+type Builder struct {
+	Tx     *Transaction
+	params *AddressParams
+}
+
+// NewBuilder returns a builder for a new transaction. (elements-tx -create)
+func NewBuilder(params *AddressParams) *Builder {
+	return &Builder{Tx: NewTransaction(), params: params}
+}
+
+// NewBuilderFromHex returns a builder which extends the template transaction.
+func NewBuilderFromHex(template string, params *AddressParams) (*Builder, error) {
+	tx, err := ParseTransactionHex(template)
+	if err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+	return &Builder{Tx: tx, params: params}, nil
+}
+
+// AddInput adds an input spending txid:vout. (elements-tx in=TXID:VOUT)
+func (b *Builder) AddInput(txid string, vout int64) error {
+	hash, err := hashFromString(txid)
+	if err != nil {
+		return fmt.Errorf("input txid: %v", err)
+	}
+	if vout < 0 || uint32(vout) > outpointIndexMask {
+		return fmt.Errorf("input vout out of range: %d", vout)
+	}
+	b.Tx.Inputs = append(b.Tx.Inputs, &TxIn{
+		PrevOut:  OutPoint{Hash: hash, Index: uint32(vout)},
+		Sequence: DefaultSequence,
+	})
+	if b.Tx.InWitness != nil {
+		b.Tx.InWitness = append(b.Tx.InWitness, &TxInWitness{})
+	}
+	return nil
+}
+
+// AddOutputAddr adds an explicit output paying amount satoshi of asset to addr.
+// A confidential addr puts its blinding pubkey into the nonce, so blindrawtransaction can blind it.
+// (elements-tx outaddr=VALUE:ADDRESS:ASSET)
+func (b *Builder) AddOutputAddr(amount int64, addr string, asset string) error {
+	a, err := DecodeAddress(addr, b.params)
+	if err != nil {
+		return err
+	}
+	return b.addOutput(amount, a.ScriptPubKey, a.BlindingKey, asset)
+}
+
+// AddOutputScript adds an explicit output paying amount satoshi of asset to script.
+// (elements-tx outscript=VALUE:SCRIPT:ASSET)
+func (b *Builder) AddOutputScript(amount int64, script []byte, asset string) error {
+	return b.addOutput(amount, script, nil, asset)
+}
+
+// AddFeeOutput adds the explicit fee output, which has an empty script.
+// (elements-tx outscript=VALUE::ASSET)
+func (b *Builder) AddFeeOutput(amount int64, asset string) error {
+	return b.addOutput(amount, []byte{}, nil, asset)
+}
+
+func (b *Builder) addOutput(amount int64, script []byte, nonce []byte, asset string) error {
+	assetCommitment, err := ExplicitAsset(asset)
+	if err != nil {
+		return err
+	}
+	valueCommitment, err := ExplicitValue(amount)
+	if err != nil {
+		return err
+	}
+	b.Tx.Outputs = append(b.Tx.Outputs, &TxOut{
+		Asset:        assetCommitment,
+		Value:        valueCommitment,
+		Nonce:        nonce,
+		ScriptPubKey: script,
+	})
+	if b.Tx.OutWitness != nil {
+		b.Tx.OutWitness = append(b.Tx.OutWitness, &TxOutWitness{})
+	}
+	return nil
+}
+
+// Hex returns the hex encoded transaction.
+func (b *Builder) Hex() string {
+	return b.Tx.Hex()
+}
+
+// ExplicitAsset returns the explicit asset commitment of the RPC hex asset id.
+func ExplicitAsset(asset string) ([]byte, error) {
+	hash, err := hashFromString(asset)
+	if err != nil {
+		return nil, fmt.Errorf("asset: %v", err)
+	}
+	return append([]byte{CommitmentExplicit}, hash[:]...), nil
+}
+
+// ExplicitValue returns the explicit value commitment of amount satoshi.
+func ExplicitValue(amount int64) ([]byte, error) {
+	if amount < 0 {
+		return nil, fmt.Errorf("value out of range: %d", amount)
+	}
+	c := make([]byte, 9)
+	c[0] = CommitmentExplicit
+	binary.BigEndian.PutUint64(c[1:], uint64(amount))
+	return c, nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementstx Transaction decoder
+package elementstx
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// DecodedIssuance is the issuance data of an input.
+type DecodedIssuance struct {
+	AssetBlindingNonce      string `json:"assetBlindingNonce"`
+	AssetEntropy            string `json:"assetEntropy"`
+	AssetAmount             int64  `json:"assetamount"`
+	AssetAmountCommitment   string `json:"assetamountcommitment,omitempty"`
+	InflationKeys           int64  `json:"tokenamount"`
+	InflationKeysCommitment string `json:"tokenamountcommitment,omitempty"`
+	AmountRangeProof        string `json:"amountrangeproof,omitempty"`
+	InflationKeysRangeProof string `json:"tokenrangeproof,omitempty"`
+}
+
+// DecodedInput is an input of DecodedTransaction.
+type DecodedInput struct {
+	Txid          string           `json:"txid"`
+	Vout          int64            `json:"vout"`
+	ScriptSig     string           `json:"scriptSig"`
+	Sequence      uint32           `json:"sequence"`
+	IsPegin       bool             `json:"is_pegin"`
+	Issuance      *DecodedIssuance `json:"issuance,omitempty"`
+	TxInWitness   []string         `json:"txinwitness,omitempty"`
+	PeginWitness  []string         `json:"pegin_witness,omitempty"`
+	IsCoinbase    bool             `json:"-"`
+	HasIssuance   bool             `json:"-"`
+	ScriptSigData []byte           `json:"-"`
+}
+
+// DecodedOutput is an output of DecodedTransaction.
+// Explicit asset and value are set only when they are not blinded.
+type DecodedOutput struct {
+	N               int64  `json:"n"`
+	Asset           string `json:"asset,omitempty"`
+	AssetCommitment string `json:"assetcommitment,omitempty"`
+	Value           int64  `json:"value"`
+	ValueCommitment string `json:"valuecommitment,omitempty"`
+	Nonce           string `json:"commitmentnonce"`
+	ScriptPubKey    string `json:"scriptPubKey"`
+	SurjectionProof string `json:"surjectionproof,omitempty"`
+	RangeProof      string `json:"rangeproof,omitempty"`
+	ScriptData      []byte `json:"-"`
+	NonceData       []byte `json:"-"`
+}
+
+// HasExplicitAsset reports whether the asset is not blinded.
+func (o *DecodedOutput) HasExplicitAsset() bool {
+	return o.AssetCommitment == ""
+}
+
+// HasExplicitValue reports whether the value is not blinded.
+func (o *DecodedOutput) HasExplicitValue() bool {
+	return o.ValueCommitment == ""
+}
+
+// IsFee reports whether the output is a fee output. (explicit and empty script)
+func (o *DecodedOutput) IsFee() bool {
+	return len(o.ScriptData) == 0 && o.HasExplicitAsset() && o.HasExplicitValue()
+}
+
+// DecodedTransaction is a transaction decoded without a node round-trip.
+type DecodedTransaction struct {
+	Txid     string          `json:"txid"`
+	Hash     string          `json:"hash"`
+	Size     int64           `json:"size"`
+	Version  int32           `json:"version"`
+	LockTime uint32          `json:"locktime"`
+	Vin      []DecodedInput  `json:"vin"`
+	Vout     []DecodedOutput `json:"vout"`
+}
+
+// Fee returns the sum of the explicit fee outputs per asset id.
+func (d *DecodedTransaction) Fee() map[string]int64 {
+	fee := make(map[string]int64)
+	for _, o := range d.Vout {
+		if o.IsFee() {
+			fee[o.Asset] += o.Value
+		}
+	}
+	return fee
+}
+
+// Decode parses a hex encoded transaction into DecodedTransaction.
+func Decode(s string) (*DecodedTransaction, error) {
+	tx, err := ParseTransactionHex(s)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Decode(), nil
+}
+
+// Decode returns the inspectable form of the transaction.
+func (tx *Transaction) Decode() *DecodedTransaction {
+	raw := tx.Serialize()
+	wtxid := hashToString(doubleSha256(raw))
+
+	d := &DecodedTransaction{
+		Txid:     tx.TxID(),
+		Hash:     wtxid,
+		Size:     int64(len(raw)),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Vin:      make([]DecodedInput, len(tx.Inputs)),
+		Vout:     make([]DecodedOutput, len(tx.Outputs)),
+	}
+
+	for i, in := range tx.Inputs {
+		di := DecodedInput{
+			Txid:          hashToString(in.PrevOut.Hash),
+			Vout:          int64(in.PrevOut.Index),
+			ScriptSig:     hex.EncodeToString(in.ScriptSig),
+			Sequence:      in.Sequence,
+			IsPegin:       in.IsPegin,
+			IsCoinbase:    in.PrevOut.IsNull(),
+			HasIssuance:   !in.Issuance.IsNull(),
+			ScriptSigData: in.ScriptSig,
+		}
+		var wit *TxInWitness
+		if i < len(tx.InWitness) {
+			wit = tx.InWitness[i]
+		}
+		if di.HasIssuance {
+			is := &DecodedIssuance{
+				AssetBlindingNonce: hashToString(in.Issuance.AssetBlindingNonce),
+				AssetEntropy:       hashToString(in.Issuance.AssetEntropy),
+			}
+			is.AssetAmount, is.AssetAmountCommitment = decodeValue(in.Issuance.Amount)
+			is.InflationKeys, is.InflationKeysCommitment = decodeValue(in.Issuance.InflationKeys)
+			if wit != nil {
+				is.AmountRangeProof = hex.EncodeToString(wit.IssuanceAmountRangeProof)
+				is.InflationKeysRangeProof = hex.EncodeToString(wit.InflationKeysRangeProof)
+			}
+			di.Issuance = is
+		}
+		if wit != nil {
+			di.TxInWitness = encodeStack(wit.ScriptWitness)
+			di.PeginWitness = encodeStack(wit.PeginWitness)
+		}
+		d.Vin[i] = di
+	}
+
+	for i, out := range tx.Outputs {
+		do := DecodedOutput{
+			N:            int64(i),
+			Nonce:        hex.EncodeToString(out.Nonce),
+			ScriptPubKey: hex.EncodeToString(out.ScriptPubKey),
+			ScriptData:   out.ScriptPubKey,
+			NonceData:    out.Nonce,
+		}
+		do.Asset, do.AssetCommitment = decodeAsset(out.Asset)
+		do.Value, do.ValueCommitment = decodeValue(out.Value)
+		if i < len(tx.OutWitness) && tx.OutWitness[i] != nil {
+			do.SurjectionProof = hex.EncodeToString(tx.OutWitness[i].SurjectionProof)
+			do.RangeProof = hex.EncodeToString(tx.OutWitness[i].RangeProof)
+		}
+		d.Vout[i] = do
+	}
+
+	return d
+}
+
+// decodeAsset returns the asset id if explicit, otherwise the commitment.
+func decodeAsset(c []byte) (string, string) {
+	if len(c) == 0 {
+		return "", ""
+	}
+	if c[0] != CommitmentExplicit {
+		return "", hex.EncodeToString(c)
+	}
+	var h [32]byte
+	copy(h[:], c[1:])
+	return hashToString(h), ""
+}
+
+// decodeValue returns the satoshi value if explicit, otherwise the commitment.
+func decodeValue(c []byte) (int64, string) {
+	if len(c) == 0 {
+		return 0, ""
+	}
+	if c[0] != CommitmentExplicit {
+		return 0, hex.EncodeToString(c)
+	}
+	return int64(binary.BigEndian.Uint64(c[1:])), ""
+}
+
+func encodeStack(stack [][]byte) []string {
+	if len(stack) == 0 {
+		return nil
+	}
+	items := make([]string, len(stack))
+	for i, item := range stack {
+		items[i] = hex.EncodeToString(item)
+	}
+	return items
+}
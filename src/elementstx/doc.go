@@ -0,0 +1,21 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package elementstx composes and serializes Elements transactions natively,
+so the parties in the demo do not need the elements-tx binary.
+
+usage:
+
+	// create a new transaction (elements-tx -create)
+	b := elementstx.NewBuilder(elementstx.RegtestParams)
+	// or extend a template received from another party
+	b, err := elementstx.NewBuilderFromHex(template, elementstx.RegtestParams)
+
+	err = b.AddInput(txid, vout)
+	err = b.AddOutputAddr(100*elementstx.Coin, addr, assetID)
+	err = b.AddFeeOutput(15*elementstx.Coin, assetID)
+	tx := b.Hex()
+*/
+package elementstx
@@ -0,0 +1,604 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package elementstx Elements transaction wire format
+package elementstx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Coin is the number of satoshi in one coin unit.
+const Coin = int64(100000000)
+
+// DefaultVersion is the version of a newly created transaction.
+const DefaultVersion = int32(2)
+
+// DefaultSequence is the sequence number of a newly added input.
+const DefaultSequence = uint32(0xffffffff)
+
+const (
+	outpointIssuanceFlag = uint32(1 << 31)
+	outpointPeginFlag    = uint32(1 << 30)
+	outpointIndexMask    = uint32(0x3fffffff)
+	witnessFlag          = byte(1)
+	maxVectorSize        = uint64(0x02000000)
+)
+
+// Prefix bytes of confidential commitments.
+const (
+	CommitmentNull     = byte(0)
+	CommitmentExplicit = byte(1)
+)
+
+// commitmentSpec describes one kind of confidential commitment.
+type commitmentSpec struct {
+	name         string
+	explicitSize int
+	committedLen int
+	prefixA      byte
+	prefixB      byte
+}
+
+var (
+	assetSpec = commitmentSpec{name: "asset", explicitSize: 33, committedLen: 33, prefixA: 10, prefixB: 11}
+	valueSpec = commitmentSpec{name: "value", explicitSize: 9, committedLen: 33, prefixA: 8, prefixB: 9}
+	nonceSpec = commitmentSpec{name: "nonce", explicitSize: 33, committedLen: 33, prefixA: 2, prefixB: 3}
+)
+
+// The smallest serialized input (prevout, empty scriptSig, sequence) and output (null commitments,
+// empty scriptPubKey), bounding the counts read before allocating.
+const (
+	minTxInSize  = 32 + 4 + 1 + 4
+	minTxOutSize = 1 + 1 + 1 + 1
+)
+
+// OutPoint is a reference to a previous output.
+type OutPoint struct {
+	Hash  [32]byte
+	Index uint32
+}
+
+// IsNull reports whether the outpoint is the coinbase outpoint.
+func (o OutPoint) IsNull() bool {
+	return o.Hash == [32]byte{} && o.Index == 0xffffffff
+}
+
+// AssetIssuance is the issuance data attached to an input.
+type AssetIssuance struct {
+	AssetBlindingNonce [32]byte
+	AssetEntropy       [32]byte
+	Amount             []byte
+	InflationKeys      []byte
+}
+
+// IsNull reports whether the issuance is empty.
+func (ai *AssetIssuance) IsNull() bool {
+	return ai == nil || (len(ai.Amount) == 0 && len(ai.InflationKeys) == 0)
+}
+
+// TxIn is a transaction input.
+type TxIn struct {
+	PrevOut   OutPoint
+	IsPegin   bool
+	ScriptSig []byte
+	Sequence  uint32
+	Issuance  *AssetIssuance
+}
+
+// TxOut is a transaction output.
+type TxOut struct {
+	Asset        []byte
+	Value        []byte
+	Nonce        []byte
+	ScriptPubKey []byte
+}
+
+// TxInWitness is the witness data of an input.
+type TxInWitness struct {
+	IssuanceAmountRangeProof []byte
+	InflationKeysRangeProof  []byte
+	ScriptWitness            [][]byte
+	PeginWitness             [][]byte
+}
+
+// IsNull reports whether the input witness is empty.
+func (w *TxInWitness) IsNull() bool {
+	return len(w.IssuanceAmountRangeProof) == 0 && len(w.InflationKeysRangeProof) == 0 &&
+		len(w.ScriptWitness) == 0 && len(w.PeginWitness) == 0
+}
+
+// TxOutWitness is the witness data of an output.
+type TxOutWitness struct {
+	SurjectionProof []byte
+	RangeProof      []byte
+}
+
+// IsNull reports whether the output witness is empty.
+func (w *TxOutWitness) IsNull() bool {
+	return len(w.SurjectionProof) == 0 && len(w.RangeProof) == 0
+}
+
+// Transaction is an Elements transaction.
+type Transaction struct {
+	Version    int32
+	Inputs     []*TxIn
+	Outputs    []*TxOut
+	LockTime   uint32
+	InWitness  []*TxInWitness
+	OutWitness []*TxOutWitness
+}
+
+// NewTransaction returns an empty transaction like "elements-tx -create".
+func NewTransaction() *Transaction {
+	return &Transaction{Version: DefaultVersion}
+}
+
+// HasWitness reports whether any input or output carries witness data.
+func (tx *Transaction) HasWitness() bool {
+	for _, w := range tx.InWitness {
+		if w != nil && !w.IsNull() {
+			return true
+		}
+	}
+	for _, w := range tx.OutWitness {
+		if w != nil && !w.IsNull() {
+			return true
+		}
+	}
+	return false
+}
+
+// Serialize encodes the transaction including its witness.
+func (tx *Transaction) Serialize() []byte {
+	var buf bytes.Buffer
+	tx.serialize(&buf, true)
+	return buf.Bytes()
+}
+
+// SerializeNoWitness encodes the transaction without its witness.
+func (tx *Transaction) SerializeNoWitness() []byte {
+	var buf bytes.Buffer
+	tx.serialize(&buf, false)
+	return buf.Bytes()
+}
+
+// Hex returns the hex encoded serialization.
+func (tx *Transaction) Hex() string {
+	return hex.EncodeToString(tx.Serialize())
+}
+
+// TxID returns the transaction id in RPC (byte reversed) hex.
+func (tx *Transaction) TxID() string {
+	return hashToString(doubleSha256(tx.SerializeNoWitness()))
+}
+
+func doubleSha256(b []byte) [32]byte {
+	first := sha256.Sum256(b)
+	return sha256.Sum256(first[:])
+}
+
+func (tx *Transaction) serialize(w *bytes.Buffer, allowWitness bool) {
+	writeUint32(w, uint32(tx.Version))
+
+	flags := byte(0)
+	if allowWitness && tx.HasWitness() {
+		flags |= witnessFlag
+	}
+	w.WriteByte(flags)
+
+	writeCompactSize(w, uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		writeTxIn(w, in)
+	}
+	writeCompactSize(w, uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		writeTxOut(w, out)
+	}
+	writeUint32(w, tx.LockTime)
+
+	if flags&witnessFlag == 0 {
+		return
+	}
+	for i := range tx.Inputs {
+		wit := &TxInWitness{}
+		if i < len(tx.InWitness) && tx.InWitness[i] != nil {
+			wit = tx.InWitness[i]
+		}
+		writeBytes(w, wit.IssuanceAmountRangeProof)
+		writeBytes(w, wit.InflationKeysRangeProof)
+		writeStack(w, wit.ScriptWitness)
+		writeStack(w, wit.PeginWitness)
+	}
+	for i := range tx.Outputs {
+		wit := &TxOutWitness{}
+		if i < len(tx.OutWitness) && tx.OutWitness[i] != nil {
+			wit = tx.OutWitness[i]
+		}
+		writeBytes(w, wit.SurjectionProof)
+		writeBytes(w, wit.RangeProof)
+	}
+}
+
+func writeTxIn(w *bytes.Buffer, in *TxIn) {
+	w.Write(in.PrevOut.Hash[:])
+	index := in.PrevOut.Index
+	hasIssuance := !in.Issuance.IsNull()
+	if !in.PrevOut.IsNull() {
+		if hasIssuance {
+			index |= outpointIssuanceFlag
+		}
+		if in.IsPegin {
+			index |= outpointPeginFlag
+		}
+	} else {
+		hasIssuance = false
+	}
+	writeUint32(w, index)
+	writeBytes(w, in.ScriptSig)
+	writeUint32(w, in.Sequence)
+	if hasIssuance {
+		w.Write(in.Issuance.AssetBlindingNonce[:])
+		w.Write(in.Issuance.AssetEntropy[:])
+		writeCommitment(w, in.Issuance.Amount)
+		writeCommitment(w, in.Issuance.InflationKeys)
+	}
+}
+
+func writeTxOut(w *bytes.Buffer, out *TxOut) {
+	writeCommitment(w, out.Asset)
+	writeCommitment(w, out.Value)
+	writeCommitment(w, out.Nonce)
+	writeBytes(w, out.ScriptPubKey)
+}
+
+func writeCommitment(w *bytes.Buffer, c []byte) {
+	if len(c) == 0 {
+		w.WriteByte(CommitmentNull)
+		return
+	}
+	w.Write(c)
+}
+
+func writeUint32(w *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func writeCompactSize(w *bytes.Buffer, n uint64) {
+	var b [9]byte
+	switch {
+	case n < 0xfd:
+		w.WriteByte(byte(n))
+	case n <= 0xffff:
+		b[0] = 0xfd
+		binary.LittleEndian.PutUint16(b[1:], uint16(n))
+		w.Write(b[:3])
+	case n <= 0xffffffff:
+		b[0] = 0xfe
+		binary.LittleEndian.PutUint32(b[1:], uint32(n))
+		w.Write(b[:5])
+	default:
+		b[0] = 0xff
+		binary.LittleEndian.PutUint64(b[1:], n)
+		w.Write(b[:9])
+	}
+}
+
+func writeBytes(w *bytes.Buffer, b []byte) {
+	writeCompactSize(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func writeStack(w *bytes.Buffer, stack [][]byte) {
+	writeCompactSize(w, uint64(len(stack)))
+	for _, item := range stack {
+		writeBytes(w, item)
+	}
+}
+
+// ParseTransaction decodes a serialized transaction.
+func ParseTransaction(raw []byte) (*Transaction, error) {
+	r := bytes.NewReader(raw)
+	tx, err := readTransaction(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after transaction", r.Len())
+	}
+	return tx, nil
+}
+
+// ParseTransactionHex decodes a hex encoded transaction.
+func ParseTransactionHex(s string) (*Transaction, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hex: %v", err)
+	}
+	return ParseTransaction(raw)
+}
+
+func readTransaction(r *bytes.Reader) (*Transaction, error) {
+	tx := new(Transaction)
+
+	version, err := readUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("version: %v", err)
+	}
+	tx.Version = int32(version)
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("flags: %v", err)
+	}
+
+	nIn, err := readCompactSize(r)
+	if err != nil {
+		return nil, fmt.Errorf("input count: %v", err)
+	}
+	if nIn > uint64(r.Len()/minTxInSize) {
+		return nil, fmt.Errorf("input count %d exceeds remaining %d bytes", nIn, r.Len())
+	}
+	tx.Inputs = make([]*TxIn, nIn)
+	for i := range tx.Inputs {
+		tx.Inputs[i], err = readTxIn(r)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+	}
+
+	nOut, err := readCompactSize(r)
+	if err != nil {
+		return nil, fmt.Errorf("output count: %v", err)
+	}
+	if nOut > uint64(r.Len()/minTxOutSize) {
+		return nil, fmt.Errorf("output count %d exceeds remaining %d bytes", nOut, r.Len())
+	}
+	tx.Outputs = make([]*TxOut, nOut)
+	for i := range tx.Outputs {
+		tx.Outputs[i], err = readTxOut(r)
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %v", i, err)
+		}
+	}
+
+	tx.LockTime, err = readUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("locktime: %v", err)
+	}
+
+	if flags&witnessFlag != 0 {
+		flags ^= witnessFlag
+		tx.InWitness = make([]*TxInWitness, len(tx.Inputs))
+		for i := range tx.InWitness {
+			wit := new(TxInWitness)
+			if wit.IssuanceAmountRangeProof, err = readBytes(r); err != nil {
+				return nil, fmt.Errorf("input witness %d: %v", i, err)
+			}
+			if wit.InflationKeysRangeProof, err = readBytes(r); err != nil {
+				return nil, fmt.Errorf("input witness %d: %v", i, err)
+			}
+			if wit.ScriptWitness, err = readStack(r); err != nil {
+				return nil, fmt.Errorf("input witness %d: %v", i, err)
+			}
+			if wit.PeginWitness, err = readStack(r); err != nil {
+				return nil, fmt.Errorf("input witness %d: %v", i, err)
+			}
+			tx.InWitness[i] = wit
+		}
+		tx.OutWitness = make([]*TxOutWitness, len(tx.Outputs))
+		for i := range tx.OutWitness {
+			wit := new(TxOutWitness)
+			if wit.SurjectionProof, err = readBytes(r); err != nil {
+				return nil, fmt.Errorf("output witness %d: %v", i, err)
+			}
+			if wit.RangeProof, err = readBytes(r); err != nil {
+				return nil, fmt.Errorf("output witness %d: %v", i, err)
+			}
+			tx.OutWitness[i] = wit
+		}
+		if !tx.HasWitness() {
+			return nil, fmt.Errorf("superfluous witness record")
+		}
+	}
+	if flags != 0 {
+		return nil, fmt.Errorf("unknown transaction optional data")
+	}
+
+	return tx, nil
+}
+
+func readTxIn(r *bytes.Reader) (*TxIn, error) {
+	in := new(TxIn)
+	if _, err := io.ReadFull(r, in.PrevOut.Hash[:]); err != nil {
+		return nil, fmt.Errorf("prevout: %v", err)
+	}
+	index, err := readUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("prevout: %v", err)
+	}
+	hasIssuance := false
+	if index == 0xffffffff {
+		in.PrevOut.Index = index
+	} else {
+		hasIssuance = index&outpointIssuanceFlag != 0
+		in.IsPegin = index&outpointPeginFlag != 0
+		in.PrevOut.Index = index & outpointIndexMask
+	}
+	if in.ScriptSig, err = readBytes(r); err != nil {
+		return nil, fmt.Errorf("scriptSig: %v", err)
+	}
+	if in.Sequence, err = readUint32(r); err != nil {
+		return nil, fmt.Errorf("sequence: %v", err)
+	}
+	if hasIssuance {
+		ai := new(AssetIssuance)
+		if _, err = io.ReadFull(r, ai.AssetBlindingNonce[:]); err != nil {
+			return nil, fmt.Errorf("issuance: %v", err)
+		}
+		if _, err = io.ReadFull(r, ai.AssetEntropy[:]); err != nil {
+			return nil, fmt.Errorf("issuance: %v", err)
+		}
+		if ai.Amount, err = readCommitment(r, valueSpec); err != nil {
+			return nil, fmt.Errorf("issuance: %v", err)
+		}
+		if ai.InflationKeys, err = readCommitment(r, valueSpec); err != nil {
+			return nil, fmt.Errorf("issuance: %v", err)
+		}
+		in.Issuance = ai
+	}
+	return in, nil
+}
+
+func readTxOut(r *bytes.Reader) (*TxOut, error) {
+	var err error
+	out := new(TxOut)
+	if out.Asset, err = readCommitment(r, assetSpec); err != nil {
+		return nil, err
+	}
+	if out.Value, err = readCommitment(r, valueSpec); err != nil {
+		return nil, err
+	}
+	if out.Nonce, err = readCommitment(r, nonceSpec); err != nil {
+		return nil, err
+	}
+	if out.ScriptPubKey, err = readBytes(r); err != nil {
+		return nil, fmt.Errorf("scriptPubKey: %v", err)
+	}
+	return out, nil
+}
+
+func readCommitment(r *bytes.Reader, spec commitmentSpec) ([]byte, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", spec.name, err)
+	}
+	var size int
+	switch prefix {
+	case CommitmentNull:
+		return nil, nil
+	case CommitmentExplicit:
+		size = spec.explicitSize
+	case spec.prefixA, spec.prefixB:
+		size = spec.committedLen
+	default:
+		return nil, fmt.Errorf("%s: invalid commitment prefix 0x%02x", spec.name, prefix)
+	}
+	c := make([]byte, size)
+	c[0] = prefix
+	if _, err = io.ReadFull(r, c[1:]); err != nil {
+		return nil, fmt.Errorf("%s: %v", spec.name, err)
+	}
+	return c, nil
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func readCompactSize(r *bytes.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	var min uint64
+	switch first {
+	case 0xfd:
+		var b [2]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.LittleEndian.Uint16(b[:])), 0xfd
+	case 0xfe:
+		var b [4]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.LittleEndian.Uint32(b[:])), 0x10000
+	case 0xff:
+		var b [8]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n, min = binary.LittleEndian.Uint64(b[:]), 0x100000000
+	default:
+		return uint64(first), nil
+	}
+	if n < min {
+		return 0, fmt.Errorf("non-canonical compact size")
+	}
+	if n > maxVectorSize {
+		return 0, fmt.Errorf("compact size too large: %d", n)
+	}
+	return n, nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, fmt.Errorf("length %d exceeds remaining %d bytes", n, r.Len())
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readStack(r *bytes.Reader) ([][]byte, error) {
+	n, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, fmt.Errorf("stack size %d exceeds remaining %d bytes", n, r.Len())
+	}
+	stack := make([][]byte, n)
+	for i := range stack {
+		if stack[i], err = readBytes(r); err != nil {
+			return nil, err
+		}
+	}
+	return stack, nil
+}
+
+// hashToString returns the RPC (byte reversed) hex of a hash.
+func hashToString(h [32]byte) string {
+	var rev [32]byte
+	for i := range h {
+		rev[i] = h[31-i]
+	}
+	return hex.EncodeToString(rev[:])
+}
+
+// hashFromString parses an RPC (byte reversed) hex hash such as a txid or asset id.
+func hashFromString(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, fmt.Errorf("invalid hash [%s]: %v", s, err)
+	}
+	if len(b) != 32 {
+		return h, fmt.Errorf("invalid hash length [%s]: %d", s, len(b))
+	}
+	for i := range b {
+		h[i] = b[31-i]
+	}
+	return h, nil
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package elementstx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	testTxid  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testAsset = "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+	testKey   = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+)
+
+var elementsTxPath = flag.String("elements-tx", "", "records testdata/elements-tx.json by running this elements-tx")
+
+const goldenFile = "testdata/elements-tx.json"
+
+// goldenTx is a transaction printed by elements-tx with args, TEMPLATE standing for the hex of Template.
+type goldenTx struct {
+	Name     string   `json:"name"`
+	Template string   `json:"template,omitempty"` // name of a previous goldenTx
+	Args     []string `json:"args"`
+	Hex      string   `json:"hex"`
+}
+
+// join joins the serialized fields of a hand-made transaction.
+func join(fields ...string) string {
+	return strings.Join(fields, "")
+}
+
+// parseValue converts a value of elements-tx (coins with up to 8 decimals) to satoshi.
+func parseValue(s string) (int64, error) {
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		i = len(s)
+		s += "."
+	}
+	frac := s[i+1:]
+	if 8 < len(frac) {
+		return 0, fmt.Errorf("too many decimals: %s", s)
+	}
+	return strconv.ParseInt(s[:i]+frac+strings.Repeat("0", 8-len(frac)), 10, 64)
+}
+
+// build runs the elements-tx args on a builder.
+func build(args []string) (*Builder, error) {
+	if len(args) < 2 || args[0] != "-regtest" {
+		return nil, fmt.Errorf("args must start with -regtest: %v", args)
+	}
+	b := NewBuilder(RegtestParams)
+	if args[1] != "-create" {
+		var err error
+		b, err = NewBuilderFromHex(args[1], RegtestParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, arg := range args[2:] {
+		kv := strings.SplitN(arg, "=", 2)
+		fields := strings.Split(kv[len(kv)-1], ":")
+		var err error
+		switch {
+		case len(kv) == 2 && kv[0] == "in" && len(fields) == 2:
+			var vout int64
+			vout, err = strconv.ParseInt(fields[1], 10, 64)
+			if err == nil {
+				err = b.AddInput(fields[0], vout)
+			}
+		case len(kv) == 2 && kv[0] == "outaddr" && len(fields) == 3:
+			var value int64
+			value, err = parseValue(fields[0])
+			if err == nil {
+				err = b.AddOutputAddr(value, fields[1], fields[2])
+			}
+		case len(kv) == 2 && kv[0] == "outscript" && len(fields) == 3 && fields[1] == "":
+			var value int64
+			value, err = parseValue(fields[0])
+			if err == nil {
+				err = b.AddFeeOutput(value, fields[2])
+			}
+		default:
+			err = fmt.Errorf("unknown arg: %s", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// TestBuilderGolden builds the transactions which alice and charlie used to create with elements-tx,
+// and compares them with its output in testdata.
+// go test elementstx -elements-tx $(which elements-tx) records the output again.
+func TestBuilderGolden(t *testing.T) {
+	data, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var golden []goldenTx
+	err = json.Unmarshal(data, &golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := make(map[string]string)
+	for i := range golden {
+		g := &golden[i]
+		args := append([]string{}, g.Args...)
+		for j, arg := range args {
+			if arg == "TEMPLATE" {
+				args[j] = templates[g.Template]
+			}
+		}
+		if *elementsTxPath != "" {
+			out, err := exec.Command(*elementsTxPath, args...).Output()
+			if err != nil {
+				t.Fatalf("%s: %v", g.Name, err)
+			}
+			g.Hex = strings.TrimRight(string(out), "\n")
+		}
+		templates[g.Name] = g.Hex
+
+		b, err := build(args)
+		if err != nil {
+			t.Errorf("%s: %v", g.Name, err)
+			continue
+		}
+		if b.Hex() != g.Hex {
+			t.Errorf("%s:\n%s\nwant\n%s", g.Name, b.Hex(), g.Hex)
+		}
+		tx, err := ParseTransactionHex(g.Hex)
+		if err != nil || tx.Hex() != g.Hex {
+			t.Errorf("%s: not parsed as printed: %v", g.Name, err)
+		}
+	}
+	if *elementsTxPath != "" {
+		data, err = json.MarshalIndent(golden, "", "\t")
+		if err == nil {
+			err = ioutil.WriteFile(goldenFile, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuilderConfidentialOutput(t *testing.T) {
+	blindingKey, _ := hex.DecodeString(testKey)
+	script := append(append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{0x11}, 20)...), 0x88, 0xac)
+	addr, err := EncodeAddress(script, blindingKey, RegtestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBuilder(RegtestParams)
+	err = b.AddOutputAddr(Coin, addr, testAsset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the blinding pubkey goes to the nonce, to be blinded by blindrawtransaction.
+	want := join(
+		"02000000", "00", "00",
+		"01",
+		"01"+"3f3e3d3c3b3a393837363534333231302f2e2d2c2b2a29282726252423222120", "01"+"0000000005f5e100", testKey, "19"+hex.EncodeToString(script),
+		"00000000",
+	)
+	if b.Hex() != want {
+		t.Errorf("confidential:\n%s\nwant\n%s", b.Hex(), want)
+	}
+	tx, err := ParseTransactionHex(b.Hex())
+	if err != nil || !bytes.Equal(tx.Outputs[0].Nonce, blindingKey) {
+		t.Errorf("parsed %v", err)
+	}
+
+	// extending a template keeps its bytes.
+	ext, err := NewBuilderFromHex(want, RegtestParams)
+	if err != nil || ext.Hex() != want {
+		t.Errorf("template %v:\n%s\nwant\n%s", err, ext.Hex(), want)
+	}
+}
+
+func TestDecodeAddress(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x22}, 20)
+	p2pkh := append(append([]byte{0x76, 0xa9, 0x14}, hash...), 0x88, 0xac)
+	p2sh := append(append([]byte{0xa9, 0x14}, hash...), 0x87)
+	blindingKey, _ := hex.DecodeString(testKey)
+	for addr, want := range map[string]Address{
+		"2dcYEFfcwgRHpN3rJZBTCtUw7iwnGTVdnru":                                              {ScriptPubKey: p2pkh},
+		"XETiiHvr5Z9dWhYSe4ADJRPF2EZLgBtbaw":                                               {ScriptPubKey: p2sh},
+		"CTEnytPypSwkVb1CE3wn4qVFmo674KWFneuNuETAVYSALbLXyE77c31699VNKC2swMUCwmmuh6zsmXcR": {ScriptPubKey: p2pkh, BlindingKey: blindingKey},
+		"AzpnGUZTcajQ5zquyP3rdKscuV7tyXCvsQ3WVavG4wxFRkC6xKfC9TuLUQQQLHFXnh6Wj6U6WxSeXdfA": {ScriptPubKey: p2sh, BlindingKey: blindingKey},
+	} {
+		a, err := DecodeAddress(addr, RegtestParams)
+		if err != nil {
+			t.Errorf("%s: %v", addr, err)
+			continue
+		}
+		if !bytes.Equal(a.ScriptPubKey, want.ScriptPubKey) || !bytes.Equal(a.BlindingKey, want.BlindingKey) || a.IsConfidential() != want.IsConfidential() {
+			t.Errorf("%s: script %x blinding key %x", addr, a.ScriptPubKey, a.BlindingKey)
+		}
+		encoded, err := EncodeAddress(want.ScriptPubKey, want.BlindingKey, RegtestParams)
+		if err != nil || encoded != addr {
+			t.Errorf("encoded %s %v, want %s", encoded, err, addr)
+		}
+	}
+
+	notCompressed, err := EncodeAddress(p2pkh, append([]byte{0x04}, blindingKey[1:]...), RegtestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]string{
+		"2dcYEFfcwgRHpN3rJZBTCtUw7iwnGTVdnrv":               "checksum",
+		"2dcYEFfcwgRHpN3rJZBTCtUw7iwnGTVdnr0":               "base58",
+		"1BoatSLRHtKNngkdXEeobR76b53LETtpyT":                "version", // mainnet bitcoin
+		"2dcY":                                              "short",
+		notCompressed:                                       "compressed",
+		base58CheckEncode(append([]byte{235}, hash[1:]...)): "length",
+	} {
+		if _, err := DecodeAddress(addr, RegtestParams); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, want %s", addr, err, want)
+		}
+	}
+}
+
+func TestParseTransactionRoundTrip(t *testing.T) {
+	committed := func(prefix string) string { return prefix + strings.Repeat("ab", 32) }
+	for name, raw := range map[string]string{
+		// blinded and signed: committed asset, value and nonce with their proofs in the witness.
+		"witness": join(
+			"02000000", "01",
+			"01", testTxid, "00000000", "00", "feffffff",
+			"02",
+			committed("0a"), committed("08"), committed("02"), "1976a914"+strings.Repeat("11", 20)+"88ac",
+			"01"+testAsset, "01"+"0000000000002710", "00", "00",
+			"65000000",
+			// input: issuance proofs, script witness, pegin witness
+			"00", "00", "02"+"03010203"+"02aabb", "00",
+			// outputs: surjection proof, range proof
+			"02cdef", "03112233",
+			"00", "00",
+		),
+		// an issuance input.
+		"issuance": join(
+			"02000000", "00",
+			"01", testTxid, "00000080", "00", "ffffffff",
+			strings.Repeat("00", 32), testAsset, "01"+"0000000005f5e100", "00",
+			"01",
+			"01"+testAsset, "01"+"0000000005f5e100", "00", "00",
+			"00000000",
+		),
+	} {
+		tx, err := ParseTransactionHex(raw)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if tx.Hex() != raw {
+			t.Errorf("%s:\n%s\nwant\n%s", name, tx.Hex(), raw)
+		}
+	}
+}
+
+func TestParseTransactionCounts(t *testing.T) {
+	for name, raw := range map[string]string{
+		"inputs":        join("02000000", "00", "ffffffffffffffffff"),
+		"inputs bytes":  join("02000000", "00", "02", testTxid, "00000000", "00", "ffffffff"),
+		"outputs":       join("02000000", "00", "00", "feffffffff"),
+		"outputs bytes": join("02000000", "00", "00", "03", "00000000", "00000000"),
+	} {
+		_, err := ParseTransactionHex(raw)
+		if err == nil || !strings.Contains(err.Error(), "count") {
+			t.Errorf("%s: %v, want a count exceeding the bytes", name, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package exchanger client of the exchanger API
+package exchanger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"lib"
+)
+
+// Paths of the exchanger API.
+const (
+	PathRate        = "/getexchangerate/"
+	PathOffer       = "/getexchangeoffer/"
+	PathOfferWB     = "/getexchangeofferwb/"
+	PathSubmit      = "/submitexchange/"
+	PathCancel      = "/cancelexchange/"
+	PathOfferStatus = "/offerstatus/"
+)
+
+// DefaultTimeout is the time limit of a call if Client.Timeout is 0.
+const DefaultTimeout = 60 * time.Second
+
+// maxResponseBytes is the size limit of a response body.
+const maxResponseBytes = 8 << 20
+
+// Client calls the exchanger API at BaseURL.
+type Client struct {
+	BaseURL    string        // e.g. "https://127.0.0.1:8020"
+	HTTPClient *http.Client  // http.DefaultClient if nil
+	Timeout    time.Duration // time limit of a call, DefaultTimeout if 0
+	Name       string        // key name of the signed requests
+	Secret     string        // HMAC secret, requests are not signed if empty
+}
+
+// NewClient returns new Client of the exchanger at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// GetRate returns the rate of the request.
+func (c *Client) GetRate(ctx context.Context, req lib.ExchangeRateRequest) (lib.ExchangeRateResponse, error) {
+	var res lib.ExchangeRateResponse
+	err := c.call(ctx, PathRate, req, &res)
+	return res, err
+}
+
+// GetOffer returns an offer with the transaction template, locking the exchanger's inputs until it expires.
+func (c *Client) GetOffer(ctx context.Context, req lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse, error) {
+	var res lib.ExchangeOfferResponse
+	err := c.call(ctx, PathOffer, req, &res)
+	return res, err
+}
+
+// GetOfferWB returns an offer as GetOffer, blinded with the commitments of the request.
+func (c *Client) GetOfferWB(ctx context.Context, req lib.ExchangeOfferWBRequest) (lib.ExchangeOfferWBResponse, error) {
+	var res lib.ExchangeOfferWBResponse
+	err := c.call(ctx, PathOfferWB, req, &res)
+	return res, err
+}
+
+// Submit submits the signed transaction of an offer.
+// A transaction not matching the offer is returned as *lib.SubmitExchangeRejection.
+func (c *Client) Submit(ctx context.Context, req lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error) {
+	var res lib.SubmitExchangeResponse
+	err := c.call(ctx, PathSubmit, req, &res)
+	return res, err
+}
+
+// Cancel cancels an offer, releasing its inputs.
+func (c *Client) Cancel(ctx context.Context, req lib.CancelExchangeRequest) (lib.CancelExchangeResponse, error) {
+	var res lib.CancelExchangeResponse
+	err := c.call(ctx, PathCancel, req, &res)
+	return res, err
+}
+
+// OfferStatus returns the status of an offer, or of all offers if req.ID is empty.
+func (c *Client) OfferStatus(ctx context.Context, req lib.OfferStatusRequest) (lib.OfferStatusResponse, error) {
+	var res lib.OfferStatusResponse
+	err := c.call(ctx, PathOfferStatus, req, &res)
+	return res, err
+}
+
+// call posts req as JSON to path and decodes the response into res.
+func (c *Client) call(ctx context.Context, path string, req interface{}, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	hreq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "application/json")
+	if id := lib.RequestIDFrom(ctx); id != "" {
+		hreq.Header.Set(lib.RequestIDHeader, id)
+	}
+	if c.Secret != "" {
+		lib.SignRequest(hreq, c.Name, c.Secret, body)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hres, err := client.Do(hreq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("exchanger %s: %w", path, ctx.Err())
+		}
+		return fmt.Errorf("%w: exchanger %s: %v", lib.ErrUnavailable, path, err)
+	}
+	defer hres.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(hres.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("%w: exchanger %s: %v", lib.ErrUnavailable, path, err)
+	}
+
+	if hres.StatusCode < 200 || 299 < hres.StatusCode {
+		return responseError(hres, data)
+	}
+	err = json.Unmarshal(data, res)
+	if err != nil {
+		return fmt.Errorf("%w: exchanger %s: invalid response: %v", lib.ErrUnavailable, path, err)
+	}
+	return nil
+}
+
+// responseError returns the error answered with a failure status.
+// A refusal of the credentials of the client is upstream_unavailable, not unauthorized,
+// so that it is not taken for a failed login of the user of the caller.
+func responseError(hres *http.Response, data []byte) error {
+	errRes := &lib.ErrorResponse{}
+	err := json.Unmarshal(data, errRes)
+	if err != nil || errRes.Code == "" {
+		// not from the API (e.g. a proxy), tell by the status.
+		code := lib.CodeInternal
+		switch {
+		case 500 <= hres.StatusCode, hres.StatusCode == http.StatusUnauthorized, hres.StatusCode == http.StatusForbidden:
+			code = lib.CodeUnavailable
+		}
+		return &lib.ErrorResponse{Code: code, Message: "exchanger: " + hres.Status}
+	}
+	switch errRes.Code {
+	case lib.CodeUnauthorized, lib.CodeForbidden:
+		return &lib.ErrorResponse{Code: lib.CodeUnavailable, Message: "exchanger: " + errRes.Message, RequestID: errRes.RequestID}
+	}
+	if errRes.Code == lib.CodeSubmissionRejected {
+		rej := &lib.SubmitExchangeRejection{}
+		if json.Unmarshal(data, rej) == nil && rej.Reason != "" {
+			return rej
+		}
+	}
+	return errRes
+}
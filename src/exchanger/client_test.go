@@ -0,0 +1,147 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package exchanger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lib"
+	"rpc"
+)
+
+func init() {
+	lib.SetLogger(log.New(ioutil.Discard, "", 0))
+}
+
+func newTestClient(t *testing.T, fake *Fake, mws ...lib.Middleware) *Client {
+	server, err := NewFakeServer(fake, mws...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return NewClient(server.URL)
+}
+
+func TestClientOfferStatus(t *testing.T) {
+	want := lib.OfferStatus{ID: "ab", RequestAsset: "MELON", RequestAmount: 50 * rpc.Coin, OfferAsset: "AIRSKY",
+		Cost: 100 * rpc.Coin, Fee: rpc.Coin, Inputs: []string{"cd:0"}, State: "submitted", TransactionID: "ef"}
+	fake := &Fake{
+		OfferStatus: func(req lib.OfferStatusRequest) (lib.OfferStatusResponse, error) {
+			if req.ID != want.ID {
+				return lib.OfferStatusResponse{}, fmt.Errorf("%w: offer [%s]", lib.ErrNotFound, req.ID)
+			}
+			return lib.OfferStatusResponse{Offers: []lib.OfferStatus{want}}, nil
+		},
+	}
+	client := newTestClient(t, fake)
+
+	res, err := client.OfferStatus(context.Background(), lib.OfferStatusRequest{ID: want.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Offers) != 1 || fmt.Sprint(res.Offers[0]) != fmt.Sprint(want) {
+		t.Errorf("offers %+v, want %+v", res.Offers, want)
+	}
+	if calls := fake.Calls(); len(calls) != 1 || calls[0] != PathOfferStatus {
+		t.Errorf("calls %v", calls)
+	}
+
+	_, err = client.OfferStatus(context.Background(), lib.OfferStatusRequest{ID: "00"})
+	var errRes *lib.ErrorResponse
+	if !errors.As(err, &errRes) || errRes.Code != lib.CodeNotFound || errRes.RequestID == "" {
+		t.Errorf("unknown offer: %#v", err)
+	}
+}
+
+func TestClientErrorResponse(t *testing.T) {
+	client := newTestClient(t, &Fake{})
+
+	// not faked, answered not_found as charlie does.
+	_, err := client.GetRate(context.Background(), lib.ExchangeRateRequest{Request: map[string]rpc.Amount{"MELON": 1}, Offer: "AIRSKY"})
+	if lib.ErrorCode(err) != lib.CodeNotFound {
+		t.Errorf("rate: %v", err)
+	}
+	// refused by the validation of the request.
+	_, err = client.GetRate(context.Background(), lib.ExchangeRateRequest{})
+	var errRes *lib.ErrorResponse
+	if !errors.As(err, &errRes) || errRes.Code != lib.CodeInvalidParameter || len(errRes.Fields) == 0 {
+		t.Errorf("invalid rate request: %#v", err)
+	}
+}
+
+func TestClientSubmitRejection(t *testing.T) {
+	client := newTestClient(t, &Fake{
+		Submit: func(req lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error) {
+			return lib.SubmitExchangeResponse{}, &lib.SubmitExchangeRejection{ID: req.ID, Reason: lib.RejectMissingInput, Message: "offered input is not spent"}
+		},
+	})
+
+	_, err := client.Submit(context.Background(), lib.SubmitExchangeRequest{ID: "ab", Transaction: "00"})
+	var rej *lib.SubmitExchangeRejection
+	if !errors.As(err, &rej) {
+		t.Fatalf("submit: %#v", err)
+	}
+	if rej.ID != "ab" || rej.Reason != lib.RejectMissingInput || lib.ErrorCode(err) != lib.CodeSubmissionRejected {
+		t.Errorf("rejection %+v", rej)
+	}
+}
+
+func TestClientCredentialsRefused(t *testing.T) {
+	auth := lib.NewAPIAuth(lib.AuthConfig{HMACKeys: map[string]string{"alice": "secret"}})
+	client := newTestClient(t, &Fake{
+		Cancel: func(req lib.CancelExchangeRequest) (lib.CancelExchangeResponse, error) {
+			return lib.CancelExchangeResponse{ID: req.ID, State: "cancelled"}, nil
+		},
+	}, auth.Middleware)
+
+	client.Name, client.Secret = "alice", "secret"
+	res, err := client.Cancel(context.Background(), lib.CancelExchangeRequest{ID: "ab"})
+	if err != nil || res.State != "cancelled" {
+		t.Fatalf("signed: %+v %v", res, err)
+	}
+	for _, secret := range []string{"wrong", ""} {
+		client.Secret = secret
+		_, err = client.Cancel(context.Background(), lib.CancelExchangeRequest{ID: "ab"})
+		if lib.ErrorCode(err) != lib.CodeUnavailable {
+			t.Errorf("secret %q: %v, want %s", secret, err, lib.CodeUnavailable)
+		}
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		code   string
+	}{
+		{http.StatusUnauthorized, lib.CodeUnavailable},
+		{http.StatusForbidden, lib.CodeUnavailable},
+		{http.StatusBadGateway, lib.CodeUnavailable},
+		{http.StatusNotFound, lib.CodeInternal},
+	} {
+		// a proxy answering without the API.
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "proxy error", c.status)
+		}))
+		_, err := NewClient(server.URL).GetRate(context.Background(), lib.ExchangeRateRequest{})
+		server.Close()
+		if lib.ErrorCode(err) != c.code {
+			t.Errorf("status %d: %v, want %s", c.status, err, c.code)
+		}
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	_, err := NewClient(server.URL).GetRate(context.Background(), lib.ExchangeRateRequest{})
+	if !errors.Is(err, lib.ErrUnavailable) {
+		t.Errorf("unreachable: %v", err)
+	}
+}
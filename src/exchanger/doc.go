@@ -0,0 +1,27 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package exchanger is a client of the exchanger API served by charlie.
+
+usage:
+
+	client := exchanger.NewClient("https://127.0.0.1:8020")
+	client.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+	client.Name, client.Secret = "alice", secret // signs the requests (see lib.SignRequest)
+	rate, err := client.GetRate(ctx, lib.ExchangeRateRequest{
+		Request: map[string]rpc.Amount{"MELON": 100},
+		Offer:   "AIRSKY",
+	})
+	if lib.ErrorCode(err) == lib.CodeNotFound {
+		// no rate of the pair
+	}
+
+An error answered by the exchanger is returned as *lib.ErrorResponse, or *lib.SubmitExchangeRejection
+for a rejected submission, so lib.ErrorCode tells its code. An unreachable exchanger is lib.ErrUnavailable,
+and an exchanger refusing the credentials of the client answers upstream_unavailable.
+
+Fake serves the API by functions, for tests without charlie.
+*/
+package exchanger
@@ -0,0 +1,83 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package exchanger Fake exchanger API
+package exchanger
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"lib"
+)
+
+// Fake serves the exchanger API by its functions, for tests.
+// A path whose function is nil answers not_found.
+//
+//	fake := &exchanger.Fake{
+//		Rate: func(req lib.ExchangeRateRequest) (lib.ExchangeRateResponse, error) {
+//			return lib.ExchangeRateResponse{AssetLabel: req.Offer, Cost: 50, Fee: 5}, nil
+//		},
+//	}
+//	server, err := exchanger.NewFakeServer(fake)
+//	defer server.Close()
+//	client := exchanger.NewClient(server.URL)
+type Fake struct {
+	Rate        func(lib.ExchangeRateRequest) (lib.ExchangeRateResponse, error)
+	Offer       func(lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse, error)
+	OfferWB     func(lib.ExchangeOfferWBRequest) (lib.ExchangeOfferWBResponse, error)
+	Submit      func(lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error)
+	Cancel      func(lib.CancelExchangeRequest) (lib.CancelExchangeResponse, error)
+	OfferStatus func(lib.OfferStatusRequest) (lib.OfferStatusResponse, error)
+
+	mu    sync.Mutex
+	calls []string
+}
+
+// NewFakeServer starts a test server of fake. Close it after use.
+func NewFakeServer(fake *Fake, mws ...lib.Middleware) (*httptest.Server, error) {
+	handler, err := fake.Handler(mws...)
+	if err != nil {
+		return nil, err
+	}
+	return httptest.NewServer(handler), nil
+}
+
+// Handler returns the handler of the API, with the routes and the errors of charlie.
+func (f *Fake) Handler(mws ...lib.Middleware) (http.Handler, error) {
+	handlers := map[string]lib.Handler{
+		PathRate:        lib.HandleContext(fakeRoute(f, PathRate, func() func(lib.ExchangeRateRequest) (lib.ExchangeRateResponse, error) { return f.Rate })),
+		PathOffer:       lib.HandleContext(fakeRoute(f, PathOffer, func() func(lib.ExchangeOfferRequest) (lib.ExchangeOfferResponse, error) { return f.Offer })).Methods("POST"),
+		PathOfferWB:     lib.HandleContext(fakeRoute(f, PathOfferWB, func() func(lib.ExchangeOfferWBRequest) (lib.ExchangeOfferWBResponse, error) { return f.OfferWB })).Methods("POST"),
+		PathSubmit:      lib.HandleContext(fakeRoute(f, PathSubmit, func() func(lib.SubmitExchangeRequest) (lib.SubmitExchangeResponse, error) { return f.Submit })).Methods("POST"),
+		PathCancel:      lib.HandleContext(fakeRoute(f, PathCancel, func() func(lib.CancelExchangeRequest) (lib.CancelExchangeResponse, error) { return f.Cancel })).Methods("POST"),
+		PathOfferStatus: lib.HandleContext(fakeRoute(f, PathOfferStatus, func() func(lib.OfferStatusRequest) (lib.OfferStatusResponse, error) { return f.OfferStatus })),
+	}
+	return lib.NewHTTPHandler(handlers, "", mws...)
+}
+
+// fakeRoute returns the handler function of path, calling the function of f at the time of the request.
+func fakeRoute[Req, Res any](f *Fake, path string, fn func() func(Req) (Res, error)) func(context.Context, Req) (Res, error) {
+	return func(_ context.Context, req Req) (Res, error) {
+		f.mu.Lock()
+		f.calls = append(f.calls, path)
+		f.mu.Unlock()
+		h := fn()
+		if h == nil {
+			var res Res
+			return res, fmt.Errorf("%w: %s is not faked", lib.ErrNotFound, path)
+		}
+		return h(req)
+	}
+}
+
+// Calls returns the paths called, in order.
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
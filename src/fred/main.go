@@ -6,12 +6,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"democonf"
 	"lib"
+	"notify"
 	"rpc"
 )
 
@@ -24,36 +27,53 @@ var rpcuser = "user"
 // Password for accessing RPC
 var rpcpass = "pass"
 
+// Cookie file for accessing RPC (instead of rpcuser and rpcpass)
+var rpccookie = ""
+
+// Secrets file holding the credentials for accessing RPC (instead of rpcuser and rpcpass)
+var rpcsecrets = ""
+
+// Wallet name on the node (default wallet if empty)
+var rpcwallet = ""
+
+// Fixture file recording the RPC exchanges (not recorded if empty)
+var rpcrecord = ""
+
+// Sources of the node notifications: zmqpub, the ZMQ endpoint of the node (e.g. "tcp://127.0.0.1:28040"),
+// and notifyaddr, the listen addr for the blocknotify/walletnotify hooks (e.g. ":8011")
+var notifySources notify.Sources
+
+// Interval of polling the node (pollinterval in seconds, a fallback when notified)
+var pollinterval time.Duration
+
+// Listen addr for the status of the jobs (not served if empty)
+var statusaddr = ""
+
 var rpcClient *rpc.Rpc
 
 var logger *log.Logger
 
-func checkgenerate() error {
-	var txs []string
-	res, err := rpcClient.RequestAndUnmarshalResult(&txs, "getrawmempool")
+func checkgenerate(node *rpc.Rpc) error {
+	txs, err := node.GetRawMempool()
 	if err != nil {
-		logger.Printf("Rpc#RequestAndUnmarshalResult error:%v res:%+v", err, res)
+		logger.Printf("Rpc#GetRawMempool error:%v", err)
 		return err
 	}
 	if len(txs) == 0 {
 		return nil
 	}
-	rpcClient.View = true
-	var hashs []string
-	res, err = rpcClient.RequestAndUnmarshalResult(&hashs, "generate", 1)
-	rpcClient.View = false
+	node.View = true
+	_, err = node.Generate(1)
+	node.View = false
 	if err != nil {
-		logger.Printf("Rpc#RequestAndUnmarshalResult error:%v res:%+v", err, res)
+		logger.Printf("Rpc#Generate error:%v", err)
 		return err
 	}
 	return nil
 }
 
-func callback() {
-	err := checkgenerate()
-	if err != nil {
-		logger.Println("checkgenarate error:", err)
-	}
+func generate(ctx context.Context) error {
+	return checkgenerate(rpcClient.WithContext(ctx))
 }
 
 func loadConf() {
@@ -61,6 +81,13 @@ func loadConf() {
 	rpcurl = conf.GetString("rpcurl", rpcurl)
 	rpcuser = conf.GetString("rpcuser", rpcuser)
 	rpcpass = conf.GetString("rpcpass", rpcpass)
+	rpccookie = conf.GetString("rpccookie", rpccookie)
+	rpcsecrets = conf.GetString("rpcsecrets", rpcsecrets)
+	rpcwallet = conf.GetString("rpcwallet", rpcwallet)
+	rpcrecord = conf.GetString("rpcrecord", rpcrecord)
+	notifySources = notify.Sources{ZMQ: conf.GetString("zmqpub", ""), HTTP: conf.GetString("notifyaddr", "")}
+	pollinterval = notifySources.PollInterval(conf.GetNumber("pollinterval", 0))
+	statusaddr = conf.GetString("statusaddr", statusaddr)
 }
 
 func main() {
@@ -68,10 +95,52 @@ func main() {
 	fmt.Println("Fred start")
 
 	loadConf()
-	rpcClient = rpc.NewRpc(rpcurl, rpcuser, rpcpass)
+	var err error
+	rpcClient, err = rpc.NewRpcWithConfig(rpc.Config{
+		URL:         rpcurl,
+		User:        rpcuser,
+		Pass:        rpcpass,
+		CookieFile:  rpccookie,
+		SecretsFile: rpcsecrets,
+		SecretsName: "fred",
+		Wallet:      rpcwallet,
+		RecordFile:  rpcrecord,
+	})
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
 
 	lib.SetLogger(logger)
-	lib.StartCyclic(callback, 3, true)
+	rpc.SetLogger(logger)
+	notify.SetLogger(logger)
+	lc := lib.NewLifecycle("fred")
+	lc.Close("rpc", rpcClient)
+	events, err := notify.Subscribe(lc.Context(), notifySources, notify.TopicHashTx)
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	err = lc.Schedule(lib.Job{
+		Name:     "generate",
+		Interval: pollinterval,
+		Timeout:  time.Minute,
+		Events:   events,
+		Run:      generate,
+	})
+	if err != nil {
+		logger.Println("error:", err)
+		os.Exit(lib.ExitFailure)
+	}
+	if statusaddr != "" {
+		_, err = lc.Listen(statusaddr, lc.Scheduler().Handler())
+		if err != nil {
+			logger.Println("error:", err)
+			os.Exit(lib.ExitFailure)
+		}
+	}
 
+	code := lc.Run()
 	fmt.Println("Fred stop")
+	os.Exit(code)
 }
@@ -0,0 +1,406 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (auth.go) authenticates the requests of the JSON-API.
+
+A request is authenticated by one of
+1) an API key: "X-API-Key: <key>" or "Authorization: Bearer <key>",
+2) an HMAC signature of another actor (see SignRequest):
+
+	X-Auth-Key: <name>
+	X-Auth-Timestamp: <unix time>
+	X-Auth-Nonce: <random hex, used once>
+	X-Auth-Signature: hex(HMAC-SHA256(secret, method "\n" request URI "\n" timestamp "\n" nonce "\n" hex(SHA256(body))))
+
+3) a session cookie of a browser, given by POST /session with key=<API key>. (see SessionHandler)
+4) a client certificate verified by the TLS server, of a common name in ClientCerts. (see TLSConfig)
+A request of a session other than GET must have the CSRF token of the session in X-CSRF-Token.
+A signed request is accepted once, within MaxClockSkew of its timestamp.
+*/
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers and paths of the authentication.
+const (
+	APIKeyHeader        = "X-API-Key"
+	AuthKeyHeader       = "X-Auth-Key"
+	AuthTimestampHeader = "X-Auth-Timestamp"
+	AuthNonceHeader     = "X-Auth-Nonce"
+	AuthSignatureHeader = "X-Auth-Signature"
+	CSRFHeader          = "X-CSRF-Token"
+	SessionCookie       = "session"
+	SessionPath         = "/session"
+)
+
+// MaxClockSkew is the accepted difference between the timestamp of a signed request and the clock.
+const MaxClockSkew = 5 * time.Minute
+
+// AuthConfig is the authentication setting of an HTTP server.
+// The keys may be references to the secrets. (see ResolveSecret)
+type AuthConfig struct {
+	APIKeys  map[string]string `json:"apikeys"`  // name -> API key
+	HMACKeys map[string]string `json:"hmackeys"` // name -> secret of the signed requests
+	Session  int64             `json:"session"`  // lifetime in seconds of the browser sessions (none if 0)
+	Public   []string          `json:"public"`   // route prefixes not authenticated
+
+	ClientCerts []string `json:"clientcerts"` // common names of the client certificates accepted
+}
+
+// ResolveSecret returns the secret referred to by v:
+// "env:NAME" is the environment variable NAME, "file:PATH" is the content of the file PATH
+// without the trailing newlines, and any other value is the secret itself.
+func ResolveSecret(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "env:"):
+		name := strings.TrimPrefix(v, "env:")
+		secret := os.Getenv(name)
+		if secret == "" {
+			return "", fmt.Errorf("environment variable [%s] is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(v, "file:"):
+		path := strings.TrimPrefix(v, "file:")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("secret file [%s] is empty", path)
+		}
+		return secret, nil
+	}
+	return v, nil
+}
+
+// resolve returns conf with the references of its keys replaced by the secrets. (see ResolveSecret)
+func (conf AuthConfig) resolve() (AuthConfig, error) {
+	var err error
+	conf.APIKeys, err = resolveSecrets(conf.APIKeys)
+	if err != nil {
+		return conf, fmt.Errorf("apikeys: %v", err)
+	}
+	conf.HMACKeys, err = resolveSecrets(conf.HMACKeys)
+	if err != nil {
+		return conf, fmt.Errorf("hmackeys: %v", err)
+	}
+	return conf, nil
+}
+
+func resolveSecrets(keys map[string]string) (map[string]string, error) {
+	if keys == nil {
+		return nil, nil
+	}
+	resolved := make(map[string]string, len(keys))
+	for name, v := range keys {
+		secret, err := ResolveSecret(v)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %v", name, err)
+		}
+		resolved[name] = secret
+	}
+	return resolved, nil
+}
+
+// APIAuth authenticates the requests by AuthConfig.
+type APIAuth struct {
+	conf     AuthConfig
+	mu       sync.Mutex
+	sessions map[string]session
+	nonces   map[string]time.Time // name "/" nonce -> expiry of the signed requests seen
+}
+
+type session struct {
+	name   string
+	csrf   string
+	expiry time.Time
+}
+
+type principalKey struct{}
+
+// NewAPIAuth returns new APIAuth, nil if conf has no key.
+func NewAPIAuth(conf AuthConfig) *APIAuth {
+	if len(conf.APIKeys) == 0 && len(conf.HMACKeys) == 0 && len(conf.ClientCerts) == 0 {
+		return nil
+	}
+	return &APIAuth{conf: conf, sessions: make(map[string]session), nonces: make(map[string]time.Time)}
+}
+
+// Principal returns the name of the key authenticating the request of ctx, empty if none.
+func Principal(ctx context.Context) string {
+	name, _ := ctx.Value(principalKey{}).(string)
+	return name
+}
+
+// Middleware answers 401 to the requests not authenticated, except for the public routes and the preflights,
+// and 403 to the requests of a session without its CSRF token.
+func (a *APIAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || a.public(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, sess, err := a.authenticate(r)
+		if err != nil {
+			writeError(w, r, err, CodeUnauthorized)
+			return
+		}
+		if sess != nil && r.Method != "GET" && r.Method != "HEAD" &&
+			subtle.ConstantTimeCompare([]byte(sess.csrf), []byte(r.Header.Get(CSRFHeader))) != 1 {
+			writeError(w, r, fmt.Errorf("%w: invalid CSRF token", ErrForbidden), CodeForbidden)
+			return
+		}
+		logger.Println("auth:", name, "request:", RequestIDFrom(r.Context()))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, name)))
+	})
+}
+
+func (a *APIAuth) public(path string) bool {
+	for _, prefix := range a.conf.Public {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the name of the key, and the session if authenticated by the cookie.
+func (a *APIAuth) authenticate(r *http.Request) (string, *session, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if key != "" {
+		name, ok := a.apiKey(key)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: invalid API key", ErrUnauthorized)
+		}
+		return name, nil, nil
+	}
+	if r.Header.Get(AuthSignatureHeader) != "" {
+		name, err := a.verifySignature(r)
+		return name, nil, err
+	}
+	if name, ok := a.clientCert(r); ok {
+		return name, nil, nil
+	}
+	sess, err := a.cookieSession(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return sess.name, sess, nil
+}
+
+func (a *APIAuth) cookieSession(r *http.Request) (*session, error) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no credentials", ErrUnauthorized)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[c.Value]
+	if !ok || time.Now().After(s.expiry) {
+		delete(a.sessions, c.Value)
+		return nil, fmt.Errorf("%w: session expired", ErrUnauthorized)
+	}
+	return &s, nil
+}
+
+// clientCert returns the common name of the verified client certificate, if accepted.
+func (a *APIAuth) clientCert(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, n := range a.conf.ClientCerts {
+		if n == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (a *APIAuth) apiKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for name, k := range a.conf.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (a *APIAuth) verifySignature(r *http.Request) (string, error) {
+	name := r.Header.Get(AuthKeyHeader)
+	secret, ok := a.conf.HMACKeys[name]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown key [%s]", ErrUnauthorized, name)
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(AuthTimestampHeader), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid timestamp", ErrUnauthorized)
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < -MaxClockSkew || MaxClockSkew < skew {
+		return "", fmt.Errorf("%w: timestamp out of range", ErrUnauthorized)
+	}
+	nonce := r.Header.Get(AuthNonceHeader)
+	if len(nonce) < 16 || 128 < len(nonce) {
+		return "", fmt.Errorf("%w: invalid nonce", ErrUnauthorized)
+	}
+	sig, err := hex.DecodeString(r.Header.Get(AuthSignatureHeader))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal(sig, signature(secret, r.Method, r.URL.RequestURI(), ts, nonce, body)) {
+		return "", fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+	if !a.useNonce(name, nonce, time.Unix(ts, 0).Add(MaxClockSkew)) {
+		return "", fmt.Errorf("%w: replayed request", ErrUnauthorized)
+	}
+	return name, nil
+}
+
+// useNonce records the nonce of a signed request until expiry, after which its timestamp is out of range.
+// It returns false if the nonce was already used.
+func (a *APIAuth) useNonce(name string, nonce string, expiry time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for k, e := range a.nonces {
+		if e.Before(now) {
+			delete(a.nonces, k)
+		}
+	}
+	key := name + "/" + nonce
+	if _, ok := a.nonces[key]; ok {
+		return false
+	}
+	a.nonces[key] = expiry
+	return true
+}
+
+// SignRequest signs req with body as the actor name holding secret, with a new nonce.
+func SignRequest(req *http.Request, name string, secret string, body []byte) {
+	ts := time.Now().Unix()
+	nonce := newRequestID() + newRequestID()
+	req.Header.Set(AuthKeyHeader, name)
+	req.Header.Set(AuthTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(AuthNonceHeader, nonce)
+	req.Header.Set(AuthSignatureHeader, hex.EncodeToString(signature(secret, req.Method, req.URL.RequestURI(), ts, nonce, body)))
+}
+
+func signature(secret string, method string, uri string, ts int64, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n%x", method, uri, ts, nonce, sha256.Sum256(body))
+	return mac.Sum(nil)
+}
+
+// SessionResponse is the JSON-API response of the session endpoint.
+type SessionResponse struct {
+	CSRF string `json:"csrf"`
+}
+
+// SessionHandler returns the endpoint of the browser sessions, mounted at SessionPath.
+// POST with key=<API key> sets the session cookie, DELETE removes it.
+// POST and GET answer the CSRF token of the session. (see SessionResponse)
+func (a *APIAuth) SessionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.conf.Session <= 0 {
+			writeError(w, r, fmt.Errorf("%w: sessions are disabled", ErrNotFound), CodeNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			sess, err := a.cookieSession(r)
+			if err != nil {
+				writeError(w, r, err, CodeUnauthorized)
+				return
+			}
+			writeSession(w, sess)
+		case "POST":
+			r.Body = http.MaxBytesReader(w, r.Body, 4096)
+			name, ok := a.apiKey(r.PostFormValue("key"))
+			if !ok {
+				writeError(w, r, fmt.Errorf("%w: invalid API key", ErrUnauthorized), CodeUnauthorized)
+				return
+			}
+			token := newSessionToken()
+			lifetime := time.Duration(a.conf.Session) * time.Second
+			a.mu.Lock()
+			now := time.Now()
+			for t, s := range a.sessions {
+				if now.After(s.expiry) {
+					delete(a.sessions, t)
+				}
+			}
+			sess := session{name: name, csrf: newSessionToken(), expiry: now.Add(lifetime)}
+			a.sessions[token] = sess
+			a.mu.Unlock()
+			http.SetCookie(w, &http.Cookie{
+				Name:     SessionCookie,
+				Value:    token,
+				Path:     "/",
+				MaxAge:   int(a.conf.Session),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			logger.Println("session:", name, "request:", RequestIDFrom(r.Context()))
+			writeSession(w, &sess)
+		case "DELETE":
+			if c, err := r.Cookie(SessionCookie); err == nil {
+				a.mu.Lock()
+				delete(a.sessions, c.Value)
+				a.mu.Unlock()
+			}
+			http.SetCookie(w, &http.Cookie{Name: SessionCookie, Path: "/", MaxAge: -1})
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, r, fmt.Errorf("method not allowed:%s", r.Method), CodeBadRequest)
+		}
+	})
+}
+
+func writeSession(w http.ResponseWriter, sess *session) {
+	b, err := json.Marshal(SessionResponse{CSRF: sess.csrf})
+	if err != nil {
+		logger.Println("json#Marshal error:", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(b)
+	if err != nil {
+		logger.Println("w#Write Error:", err)
+	}
+}
+
+func newSessionToken() string {
+	return newRequestID() + newRequestID() + newRequestID() + newRequestID()
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAuthHandler returns the handler of the test routes behind a, answering the principal.
+func newAuthHandler(a *APIAuth) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(SessionPath, a.SessionHandler())
+	mux.Handle("/", Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Principal(r.Context())))
+	}), a.Middleware))
+	return Chain(mux, RequestID)
+}
+
+// serve answers req by h, checking the status and the code of an error.
+func serve(t *testing.T, h http.Handler, req *http.Request, status int, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != status {
+		t.Errorf("%s %s: %d %s, want %d", req.Method, req.URL, rec.Code, rec.Body, status)
+		return rec
+	}
+	if code != "" {
+		var res ErrorResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &res)
+		if err != nil || res.Code != code || res.RequestID == "" {
+			t.Errorf("%s %s: %s %v, want %s", req.Method, req.URL, rec.Body, err, code)
+		}
+	}
+	return rec
+}
+
+func signedRequest(name string, secret string, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/submitexchange/?x=1", strings.NewReader(body))
+	SignRequest(req, name, secret, []byte(body))
+	return req
+}
+
+func TestAPIAuthSignature(t *testing.T) {
+	h := newAuthHandler(NewAPIAuth(AuthConfig{HMACKeys: map[string]string{"alice": "secret"}}))
+
+	rec := serve(t, h, signedRequest("alice", "secret", `{"id":"1"}`), http.StatusOK, "")
+	if rec.Body.String() != "alice" {
+		t.Errorf("principal %q, want alice", rec.Body)
+	}
+
+	// the same request sent again
+	req := signedRequest("alice", "secret", `{"id":"2"}`)
+	replayed := httptest.NewRequest("POST", "/submitexchange/?x=1", strings.NewReader(`{"id":"2"}`))
+	replayed.Header = req.Header.Clone()
+	serve(t, h, req, http.StatusOK, "")
+	rec = serve(t, h, replayed, http.StatusUnauthorized, CodeUnauthorized)
+	if !strings.Contains(rec.Body.String(), "replayed") {
+		t.Errorf("replayed: %s", rec.Body)
+	}
+
+	// a wrong secret, an unknown key
+	serve(t, h, signedRequest("alice", "other", `{}`), http.StatusUnauthorized, CodeUnauthorized)
+	serve(t, h, signedRequest("mallory", "secret", `{}`), http.StatusUnauthorized, CodeUnauthorized)
+
+	// the body, the URI or the signature altered after signing
+	req = signedRequest("alice", "secret", `{"amount":1}`)
+	tampered := httptest.NewRequest("POST", "/submitexchange/?x=1", strings.NewReader(`{"amount":100}`))
+	tampered.Header = req.Header
+	serve(t, h, tampered, http.StatusUnauthorized, CodeUnauthorized)
+	req = signedRequest("alice", "secret", `{}`)
+	tampered = httptest.NewRequest("POST", "/submitexchange/?x=2", strings.NewReader(`{}`))
+	tampered.Header = req.Header
+	serve(t, h, tampered, http.StatusUnauthorized, CodeUnauthorized)
+	req = signedRequest("alice", "secret", `{}`)
+	req.Header.Set(AuthSignatureHeader, "zz")
+	serve(t, h, req, http.StatusUnauthorized, CodeUnauthorized)
+
+	// a stale or future timestamp, signed with it
+	for _, skew := range []time.Duration{-MaxClockSkew - time.Minute, MaxClockSkew + time.Minute} {
+		req = httptest.NewRequest("POST", "/submitexchange/", strings.NewReader(`{}`))
+		ts := time.Now().Add(skew).Unix()
+		nonce := newRequestID() + newRequestID()
+		req.Header.Set(AuthKeyHeader, "alice")
+		req.Header.Set(AuthTimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(AuthNonceHeader, nonce)
+		req.Header.Set(AuthSignatureHeader, hex.EncodeToString(signature("secret", "POST", "/submitexchange/", ts, nonce, []byte(`{}`))))
+		rec = serve(t, h, req, http.StatusUnauthorized, CodeUnauthorized)
+		if !strings.Contains(rec.Body.String(), "timestamp") {
+			t.Errorf("skew %s: %s", skew, rec.Body)
+		}
+	}
+
+	// a nonce too short to be random
+	req = signedRequest("alice", "secret", `{}`)
+	req.Header.Set(AuthNonceHeader, "1")
+	serve(t, h, req, http.StatusUnauthorized, CodeUnauthorized)
+}
+
+func TestAPIAuthKeys(t *testing.T) {
+	h := newAuthHandler(NewAPIAuth(AuthConfig{APIKeys: map[string]string{"ui": "key"}, Public: []string{"/getexchangerate/"}}))
+
+	req := httptest.NewRequest("GET", "/balance", nil)
+	req.Header.Set(APIKeyHeader, "key")
+	if rec := serve(t, h, req, http.StatusOK, ""); rec.Body.String() != "ui" {
+		t.Errorf("principal %q, want ui", rec.Body)
+	}
+	req = httptest.NewRequest("GET", "/balance", nil)
+	req.Header.Set("Authorization", "Bearer key")
+	serve(t, h, req, http.StatusOK, "")
+	req = httptest.NewRequest("GET", "/balance", nil)
+	req.Header.Set(APIKeyHeader, "other")
+	serve(t, h, req, http.StatusUnauthorized, CodeUnauthorized)
+	serve(t, h, httptest.NewRequest("GET", "/balance", nil), http.StatusUnauthorized, CodeUnauthorized)
+
+	// public routes and preflights are not authenticated.
+	serve(t, h, httptest.NewRequest("GET", "/getexchangerate/", nil), http.StatusOK, "")
+	serve(t, h, httptest.NewRequest("OPTIONS", "/balance", nil), http.StatusOK, "")
+
+	if NewAPIAuth(AuthConfig{Public: []string{"/"}}) != nil {
+		t.Error("authenticator without keys")
+	}
+}
+
+func TestAPIAuthSessionCSRF(t *testing.T) {
+	h := newAuthHandler(NewAPIAuth(AuthConfig{APIKeys: map[string]string{"ui": "key"}, Session: 60}))
+
+	login := func(key string, status int) (*http.Cookie, string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", SessionPath, strings.NewReader(url.Values{"key": {key}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := serve(t, h, req, status, "")
+		if status != http.StatusOK {
+			return nil, ""
+		}
+		var res SessionResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &res)
+		cookies := rec.Result().Cookies()
+		if err != nil || res.CSRF == "" || len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+			t.Fatalf("session %s %v %+v", rec.Body, err, cookies)
+		}
+		return cookies[0], res.CSRF
+	}
+	login("other", http.StatusUnauthorized)
+	cookie, csrf := login("key", http.StatusOK)
+	_, otherCSRF := login("key", http.StatusOK)
+
+	request := func(method string, token string) *http.Request {
+		req := httptest.NewRequest(method, "/send", nil)
+		req.AddCookie(cookie)
+		if token != "" {
+			req.Header.Set(CSRFHeader, token)
+		}
+		return req
+	}
+	if rec := serve(t, h, request("GET", ""), http.StatusOK, ""); rec.Body.String() != "ui" {
+		t.Errorf("principal %q, want ui", rec.Body)
+	}
+	serve(t, h, request("POST", ""), http.StatusForbidden, CodeForbidden)
+	serve(t, h, request("POST", otherCSRF), http.StatusForbidden, CodeForbidden)
+	serve(t, h, request("POST", csrf), http.StatusOK, "")
+
+	// the token is answered again to the session.
+	req := httptest.NewRequest("GET", SessionPath, nil)
+	req.AddCookie(cookie)
+	var res SessionResponse
+	rec := serve(t, h, req, http.StatusOK, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.CSRF != csrf {
+		t.Errorf("session %s %v, want the token %s", rec.Body, err, csrf)
+	}
+
+	req = httptest.NewRequest("DELETE", SessionPath, nil)
+	req.AddCookie(cookie)
+	serve(t, h, req, http.StatusNoContent, "")
+	serve(t, h, request("GET", ""), http.StatusUnauthorized, CodeUnauthorized)
+}
+
+func TestAPIAuthClientCert(t *testing.T) {
+	h := newAuthHandler(NewAPIAuth(AuthConfig{ClientCerts: []string{"alice"}}))
+	verified := func(name string) *http.Request {
+		req := httptest.NewRequest("POST", "/getexchangeoffer/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: name}}}}}
+		return req
+	}
+	if rec := serve(t, h, verified("alice"), http.StatusOK, ""); rec.Body.String() != "alice" {
+		t.Errorf("principal %q, want alice", rec.Body)
+	}
+	serve(t, h, verified("charlie"), http.StatusUnauthorized, CodeUnauthorized)
+
+	// a certificate not verified by the server
+	req := verified("alice")
+	req.TLS.PeerCertificates, req.TLS.VerifiedChains = req.TLS.VerifiedChains[0], nil
+	serve(t, h, req, http.StatusUnauthorized, CodeUnauthorized)
+}
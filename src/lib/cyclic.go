@@ -4,6 +4,7 @@
 
 /*
 Package lib (cyclic.go) provides a very simple cyclic process management.
+(Scheduler with Lifecycle is preferred, it runs several jobs and stops gracefully.)
 
 usage:
 1) define a function for cyclic process. (no input/no output)
@@ -0,0 +1,275 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package lib (decode.go) decodes the web-form and JSON requests.
+package lib
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxFormIndex is the largest index of an indexed form name. (e.g. items[255][x])
+const maxFormIndex = 255
+
+// decodeRequest decodes the request of r into req, a pointer to a struct.
+// A field error is returned as *ValidationError.
+func decodeRequest(r *http.Request, req interface{}) error {
+	switch r.Method {
+	case "GET":
+		return decodeForm(r, req)
+	case "POST":
+		ct := strings.Split(""+r.Header.Get("Content-Type"), ";")[0]
+		switch ct {
+		case "application/x-www-form-urlencoded":
+			return decodeForm(r, req)
+		case "application/json", "text/plain":
+			return decodeJSON(r, req)
+		default:
+			return fmt.Errorf("content-type not allowed:%s", ct)
+		}
+	default:
+		return fmt.Errorf("method not allowed:%s", r.Method)
+	}
+}
+
+func decodeJSON(r *http.Request, req interface{}) error {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logger.Println("ioutil#ReadAll error:", err)
+		return err
+	}
+	err = json.Unmarshal(reqBody, req)
+	var terr *json.UnmarshalTypeError
+	if errors.As(err, &terr) && terr.Field != "" {
+		return &ValidationError{Fields: []FieldError{{
+			Field:   terr.Field,
+			Message: fmt.Sprintf("invalid %s [%s]", terr.Type, terr.Value),
+		}}}
+	}
+	if err != nil {
+		logger.Println("json#Unmarshal error:", err)
+		return err
+	}
+	return nil
+}
+
+// formEntry is a form value with the path of its name.
+// Both "a.b" and "a[b]" are the path [a b], and "a[]" is [a].
+type formEntry struct {
+	path   []string
+	values []string
+}
+
+func decodeForm(r *http.Request, req interface{}) error {
+	err := r.ParseForm()
+	if err != nil {
+		logger.Println("http.Request#ParseForm error:", err)
+		return err
+	}
+	var errs []FieldError
+	decodeFormValue(reflect.ValueOf(req).Elem(), "", formEntries(r.Form), &errs)
+	if len(errs) != 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+func formEntries(form url.Values) []formEntry {
+	entries := make([]formEntry, 0, len(form))
+	for k, v := range form {
+		path := strings.Split(strings.NewReplacer("[", ".", "]", "").Replace(k), ".")
+		if 1 < len(path) && path[len(path)-1] == "" {
+			path = path[:len(path)-1]
+		}
+		entries = append(entries, formEntry{path: path, values: v})
+	}
+	return entries
+}
+
+// subEntries returns the entries under the name (case-insensitive if fold), without the name.
+func subEntries(entries []formEntry, name string, fold bool) []formEntry {
+	var sub []formEntry
+	for _, e := range entries {
+		if len(e.path) == 0 {
+			continue
+		}
+		if e.path[0] == name || (fold && strings.EqualFold(e.path[0], name)) {
+			sub = append(sub, formEntry{path: e.path[1:], values: e.values})
+		}
+	}
+	return sub
+}
+
+// entryKeys returns the sorted first names of the entries.
+func entryKeys(entries []formEntry) []string {
+	set := make(map[string]bool)
+	var keys []string
+	for _, e := range entries {
+		if len(e.path) != 0 && !set[e.path[0]] {
+			set[e.path[0]] = true
+			keys = append(keys, e.path[0])
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// leafValues returns the values of the entries without a remaining path.
+func leafValues(entries []formEntry) []string {
+	var values []string
+	for _, e := range entries {
+		if len(e.path) == 0 {
+			values = append(values, e.values...)
+		}
+	}
+	return values
+}
+
+func decodeFormValue(v reflect.Value, field string, entries []formEntry, errs *[]FieldError) {
+	if len(entries) == 0 {
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		decodeFormValue(v.Elem(), field, entries, errs)
+		return
+	}
+	if _, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		decodeFormScalar(v, field, leafValues(entries), errs)
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			name := fieldName(sf)
+			sub := subEntries(entries, name, true)
+			if !strings.EqualFold(name, sf.Name) {
+				sub = append(sub, subEntries(entries, sf.Name, true)...)
+			}
+			decodeFormValue(v.Field(i), joinField(field, name), sub, errs)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			*errs = append(*errs, FieldError{Field: field, Message: "unsupported map key"})
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for _, k := range entryKeys(entries) {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			decodeFormValue(ev, joinField(field, k), subEntries(entries, k, false), errs)
+			v.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), ev)
+		}
+	case reflect.Slice:
+		values := leafValues(entries)
+		if 0 < len(values) {
+			// repeated names: a=1&a=2
+			s := reflect.MakeSlice(v.Type(), len(values), len(values))
+			for i, value := range values {
+				decodeFormScalar(s.Index(i), fmt.Sprintf("%s[%d]", field, i), []string{value}, errs)
+			}
+			v.Set(s)
+			return
+		}
+		// indexed names: a[0][x]=1&a[1][x]=2, possibly sparse as a lone a[1][x]=1
+		// the index is limited, so a huge index cannot grow the slice.
+		for _, k := range entryKeys(entries) {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 {
+				*errs = append(*errs, FieldError{Field: joinField(field, k), Message: "invalid index"})
+				continue
+			}
+			if maxFormIndex < i {
+				*errs = append(*errs, FieldError{Field: joinField(field, k), Message: "index out of range"})
+				continue
+			}
+			for v.Len() <= i {
+				v.Set(reflect.Append(v, reflect.New(v.Type().Elem()).Elem()))
+			}
+			decodeFormValue(v.Index(i), fmt.Sprintf("%s[%d]", field, i), subEntries(entries, k, false), errs)
+		}
+	default:
+		decodeFormScalar(v, field, leafValues(entries), errs)
+	}
+}
+
+// decodeFormScalar sets the first value. An empty value leaves the zero value.
+func decodeFormScalar(v reflect.Value, field string, values []string, errs *[]FieldError) {
+	if len(values) == 0 || values[0] == "" {
+		return
+	}
+	value := values[0]
+	if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		err := tu.UnmarshalText([]byte(value))
+		if err != nil {
+			*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf("invalid value [%s]: %v", value, err)})
+		}
+		return
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		if value == "on" {
+			b, err = true, nil
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(value, 10, v.Type().Bits())
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, v.Type().Bits())
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, v.Type().Bits())
+		v.SetFloat(f)
+	default:
+		err = fmt.Errorf("unsupported type %s", v.Type())
+	}
+	if err != nil {
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf("invalid %s [%s]", v.Type(), value)})
+	}
+}
+
+// fieldName returns the JSON name of the field.
+func fieldName(sf reflect.StructField) string {
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func joinField(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"rpc"
+	"strings"
+	"testing"
+)
+
+type formItem struct {
+	X      int64      `json:"x"`
+	Asset  string     `json:"asset" validate:"asset"`
+	Amount rpc.Amount `json:"amount"`
+}
+
+type formRequest struct {
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Rate    float64           `json:"rate"`
+	Count   uint8             `json:"count"`
+	Tags    []string          `json:"tags"`
+	Items   []formItem        `json:"items"`
+	Inner   formItem          `json:"inner"`
+	Pay     map[string]string `json:"pay"`
+}
+
+func decodeTestForm(form string) (formRequest, error) {
+	var req formRequest
+	r := httptest.NewRequest("GET", "/?"+form, nil)
+	err := decodeRequest(r, &req)
+	return req, err
+}
+
+func TestDecodeForm(t *testing.T) {
+	for form, want := range map[string]formRequest{
+		"name=a&enabled=true&rate=0.5&count=3": {Name: "a", Enabled: true, Rate: 0.5, Count: 3},
+		"enabled=on&rate=1e-3":                 {Enabled: true, Rate: 0.001},
+		"tags=a&tags=b":                        {Tags: []string{"a", "b"}},
+		"items[0][x]=1&items[1][x]=2":          {Items: []formItem{{X: 1}, {X: 2}}},
+		// a sparse index, as a form without its first rows
+		"items[1][x]=1": {Items: []formItem{{}, {X: 1}}},
+		"inner[x]=7&inner[asset]=MELON&Inner[amount]=1.5": {Inner: formItem{X: 7, Asset: "MELON", Amount: 150000000}},
+		"pay[MELON]=1&pay[AIRSKY]=2":                      {Pay: map[string]string{"MELON": "1", "AIRSKY": "2"}},
+		"name=&count=":                                    {},
+	} {
+		got, err := decodeTestForm(form)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %+v %v\nwant %+v", form, got, err, want)
+		}
+	}
+
+	// the largest index
+	got, err := decodeTestForm("items[255][x]=1")
+	if err != nil || len(got.Items) != 256 || got.Items[255].X != 1 {
+		t.Errorf("items[255]: %d items %v", len(got.Items), err)
+	}
+
+	for form, field := range map[string]string{
+		"enabled=yes":               "enabled",
+		"rate=fast":                 "rate",
+		"count=256":                 "count",
+		"count=-1":                  "count",
+		"items[256][x]=1":           "items.256",
+		"items[-1][x]=1":            "items.-1",
+		"items[a][x]=1":             "items.a",
+		"items[0][x]=1.5":           "items[0].x",
+		"inner[amount]=0.000000001": "inner.amount",
+	} {
+		_, err := decodeTestForm(form)
+		if _, ok := fieldMessages(err)[field]; !ok {
+			t.Errorf("%s: %v, want an error of %s", form, err, field)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	post := func(ct string, body string) (formRequest, error) {
+		var req formRequest
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		err := decodeRequest(r, &req)
+		return req, err
+	}
+	got, err := post("application/json; charset=utf-8", `{"name":"a","enabled":true,"rate":0.5,"items":[{"x":1,"amount":"2"}],"inner":{"asset":"MELON"}}`)
+	want := formRequest{Name: "a", Enabled: true, Rate: 0.5, Items: []formItem{{X: 1, Amount: 200000000}}, Inner: formItem{Asset: "MELON"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("json: %+v %v", got, err)
+	}
+	if _, err = post("application/json", `{"count":"many"}`); fieldMessages(err)["count"] == "" {
+		t.Errorf("type error: %v", err)
+	}
+	if _, err = post("application/xml", `<a/>`); err == nil {
+		t.Error("xml decoded")
+	}
+}
+
+type addressRequest struct {
+	Addr   string     `json:"addr" validate:"required,address"`
+	Items  []formItem `json:"items" validate:"required"`
+	Amount int64      `json:"amount" validate:"min=1"`
+}
+
+func sendAddress(req addressRequest) (addressRequest, error) {
+	return req, nil
+}
+
+// TestHandlerFieldErrors checks the 400 response listing each bad field, decoded or validated.
+func TestHandlerFieldErrors(t *testing.T) {
+	h, err := NewHTTPHandler(map[string]Handler{"/send": Handle(sendAddress)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"addr": {"1BoatSLRHtKNngkdXEeobR76b53LETtpyT"}, "items[0][x]": {"a"}, "items[1][asset]": {"ME LON"}, "amount": {"0x1"}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/send?"+form.Encode(), nil))
+	var res ErrorResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &res)
+	if rec.Code != http.StatusBadRequest || err != nil || res.Code != CodeInvalidParameter {
+		t.Fatalf("%d %s %v", rec.Code, rec.Body, err)
+	}
+	got := make(map[string]string)
+	for _, f := range res.Fields {
+		got[f.Field] = f.Message
+	}
+	want := map[string]string{
+		"addr":           "invalid address [1BoatSLRHtKNngkdXEeobR76b53LETtpyT]",
+		"items[0].x":     "invalid int64 [a]",
+		"items[1].asset": "invalid asset label [ME LON]",
+		"amount":         "invalid int64 [0x1]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fields %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package lib (errors.go) maps errors into the codes of the JSON-API.
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"rpc"
+)
+
+// Kinds of the handler errors, wrapped with %w to tell the code and the HTTP status.
+//
+//	return fmt.Errorf("%w: offer [%s]", lib.ErrNotFound, id)
+var (
+	ErrBadRequest     = errors.New("bad request")
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrUnavailable    = errors.New("upstream unavailable")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrForbidden      = errors.New("forbidden")
+)
+
+// Codes of ErrorResponse.
+const (
+	CodeInternal            = "internal_error"
+	CodeBadRequest          = "bad_request"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeUnavailable         = "upstream_unavailable"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeTooLarge            = "request_too_large"
+	CodeNodeUnreachable     = "node_unreachable"
+	CodeNodeAuth            = "node_auth_failed"
+	CodeNodeNotReady        = "node_not_ready"
+	CodeWallet              = "wallet_error"
+	CodeInsufficientFunds   = "insufficient_funds"
+	CodeInvalidAddress      = "invalid_address"
+	CodeInvalidParameter    = "invalid_parameter"
+	CodeTransactionRejected = "transaction_rejected"
+	CodeSubmissionRejected  = "submission_rejected"
+	CodeCancelled           = "cancelled"
+	CodeTimeout             = "timeout"
+)
+
+// Error returns the message of ErrorResponse, so a client can return it as is.
+func (e *ErrorResponse) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// ErrorCode returns the code of ErrorResponse for err.
+func ErrorCode(err error) string {
+	var res *ErrorResponse
+	if errors.As(err, &res) && res.Code != "" {
+		// passed through from another actor.
+		return res.Code
+	}
+	var rej *SubmitExchangeRejection
+	if errors.As(err, &rej) {
+		return CodeSubmissionRejected
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return CodeInvalidParameter
+	}
+	var merr *http.MaxBytesError
+	if errors.As(err, &merr) {
+		return CodeTooLarge
+	}
+	var rerr *rpc.RpcError
+	if errors.As(err, &rerr) {
+		switch rerr.Code {
+		case rpc.ErrCodeInsufficientFunds:
+			return CodeInsufficientFunds
+		case rpc.ErrCodeInvalidAddress:
+			return CodeInvalidAddress
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrBadRequest):
+		return CodeBadRequest
+	case errors.Is(err, ErrInvalidAddress):
+		return CodeInvalidAddress
+	case errors.Is(err, ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrConflict):
+		return CodeConflict
+	case errors.Is(err, ErrUnavailable):
+		return CodeUnavailable
+	case errors.Is(err, ErrUnauthorized):
+		return CodeUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, context.Canceled):
+		return CodeCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, rpc.ErrInsufficientFunds):
+		return CodeInsufficientFunds
+	case errors.Is(err, rpc.ErrAuth):
+		return CodeNodeAuth
+	case errors.Is(err, rpc.ErrTransport):
+		return CodeNodeUnreachable
+	case errors.Is(err, rpc.ErrNodeNotReady):
+		return CodeNodeNotReady
+	case errors.Is(err, rpc.ErrWallet):
+		return CodeWallet
+	case errors.Is(err, rpc.ErrInvalidRequest):
+		return CodeInvalidParameter
+	case errors.Is(err, rpc.ErrRejected):
+		return CodeTransactionRejected
+	}
+	return CodeInternal
+}
+
+// StatusCode returns the HTTP status of the code of ErrorResponse.
+func StatusCode(code string) int {
+	switch code {
+	case CodeBadRequest, CodeInvalidParameter, CodeInvalidAddress:
+		return http.StatusBadRequest
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeInsufficientFunds, CodeTransactionRejected, CodeSubmissionRejected:
+		return http.StatusUnprocessableEntity
+	case CodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeCancelled:
+		return http.StatusRequestTimeout
+	case CodeNodeUnreachable, CodeNodeAuth, CodeWallet, CodeUnavailable:
+		return http.StatusBadGateway
+	case CodeNodeNotReady:
+		return http.StatusServiceUnavailable
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// NewErrorResponse returns ErrorResponse for err.
+func NewErrorResponse(err error) *ErrorResponse {
+	res := &ErrorResponse{
+		Result:  false,
+		Message: err.Error(),
+		Code:    ErrorCode(err),
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		res.Fields = verr.Fields
+	}
+	return res
+}
+
+// errorBody returns what is written for the handler error err.
+// A rejection keeps its details, other errors become ErrorResponse.
+func errorBody(err error) interface{} {
+	var rej *SubmitExchangeRejection
+	if errors.As(err, &rej) {
+		r := *rej
+		r.Code = CodeSubmissionRejected
+		return &r
+	}
+	return NewErrorResponse(err)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package lib (handler.go) provides the typed handlers of the JSON-API.
+package lib
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// Handler is a JSON-API handler registered to StartHTTPServer, made by Handle or HandleContext.
+type Handler struct {
+	name     string
+	request  reflect.Type
+	response reflect.Type
+	methods  []string
+	decode   func(r *http.Request) (interface{}, error)
+	invoke   func(ctx context.Context, req interface{}) (interface{}, error)
+}
+
+// Handle returns Handler for f.
+// The request is decoded from the form or the JSON body into Req and validated. (see Validate)
+func Handle[Req, Res any](f func(Req) (Res, error)) Handler {
+	return newHandler(funcName(f), func(_ context.Context, req Req) (Res, error) {
+		return f(req)
+	})
+}
+
+// HandleContext returns Handler for f, which receives the context of the HTTP request.
+// The context is cancelled when the client goes away.
+func HandleContext[Req, Res any](f func(context.Context, Req) (Res, error)) Handler {
+	return newHandler(funcName(f), f)
+}
+
+func newHandler[Req, Res any](name string, f func(context.Context, Req) (Res, error)) Handler {
+	return Handler{
+		name:     name,
+		request:  reflect.TypeOf((*Req)(nil)).Elem(),
+		response: reflect.TypeOf((*Res)(nil)).Elem(),
+		decode: func(r *http.Request) (interface{}, error) {
+			var req Req
+			err := decodeRequest(r, &req)
+			return req, err
+		},
+		invoke: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return f(ctx, req.(Req))
+		},
+	}
+}
+
+func funcName(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
+
+// Name returns the function name of the handler.
+func (h Handler) Name() string {
+	return h.name
+}
+
+// RequestType returns the type of the request.
+func (h Handler) RequestType() reflect.Type {
+	return h.request
+}
+
+// ResponseType returns the type of the response.
+func (h Handler) ResponseType() reflect.Type {
+	return h.response
+}
+
+// Methods returns a copy of h accepting only the HTTP methods. (e.g. POST for moving funds)
+func (h Handler) Methods(methods ...string) Handler {
+	h.methods = append([]string(nil), methods...)
+	return h
+}
+
+// AllowedMethods returns the HTTP methods accepted by h, GET and POST by default.
+func (h Handler) AllowedMethods() []string {
+	if len(h.methods) == 0 {
+		return []string{"GET", "POST"}
+	}
+	return append([]string(nil), h.methods...)
+}
+
+func (h Handler) allows(method string) bool {
+	for _, m := range h.AllowedMethods() {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
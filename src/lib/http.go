@@ -12,32 +12,33 @@ package lib
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
-	"net/url"
+	"os"
+	"path"
 	"reflect"
-	"runtime"
-	"strconv"
+	"rpc"
 	"strings"
 	"time"
 )
 
 // ExchangeRateRequest is a structure that represents the JSON-API request.
 type ExchangeRateRequest struct {
-	Request map[string]int64 `json:"request"`
-	Offer   string           `json:"offer"`
+	Request map[string]rpc.Amount `json:"request" validate:"required,asset"`
+	Offer   string                `json:"offer" validate:"required,asset"`
 }
 
 // ExchangeRateResponse is a structure that represents the JSON-API response.
 type ExchangeRateResponse struct {
-	Fee        int64  `json:"fee"`
-	AssetLabel string `json:"assetid"`
-	Cost       int64  `json:"cost"`
+	Fee        rpc.Amount `json:"fee"`
+	AssetLabel string     `json:"assetid"`
+	Cost       rpc.Amount `json:"cost"`
 }
 
 // GetID returns ID of ExchangeRateResponse instance.
@@ -57,16 +58,17 @@ func generateID(key string) string {
 
 // ExchangeOfferRequest is a structure that represents the JSON-API request.
 type ExchangeOfferRequest struct {
-	Request map[string]int64 `json:"request"`
-	Offer   string           `json:"offer"`
+	Request map[string]rpc.Amount `json:"request" validate:"required,asset"`
+	Offer   string                `json:"offer" validate:"required,asset"`
 }
 
 // ExchangeOfferResponse is a structure that represents the JSON-API response.
 type ExchangeOfferResponse struct {
-	Fee         int64  `json:"fee"`
-	AssetLabel  string `json:"assetid"`
-	Cost        int64  `json:"cost"`
-	Transaction string `json:"tx"`
+	ID          string     `json:"id"`
+	Fee         rpc.Amount `json:"fee"`
+	AssetLabel  string     `json:"assetid"`
+	Cost        rpc.Amount `json:"cost"`
+	Transaction string     `json:"tx"`
 }
 
 // GetID returns ID of ExchangeOfferResponse instance.
@@ -76,18 +78,19 @@ func (u *ExchangeOfferResponse) GetID() string {
 
 // ExchangeOfferWBRequest is a structure that represents the JSON-API request.
 type ExchangeOfferWBRequest struct {
-	Request     map[string]int64 `json:"request"`
-	Offer       string           `json:"offer"`
-	Commitments []string         `json:"commitments"`
+	Request     map[string]rpc.Amount `json:"request" validate:"required,asset"`
+	Offer       string                `json:"offer" validate:"required,asset"`
+	Commitments []string              `json:"commitments" validate:"hex"`
 }
 
 // ExchangeOfferWBResponse is a structure that represents the JSON-API response.
 type ExchangeOfferWBResponse struct {
-	Fee         int64    `json:"fee"`
-	AssetLabel  string   `json:"assetid"`
-	Cost        int64    `json:"cost"`
-	Transaction string   `json:"tx"`
-	Commitments []string `json:"commitments"`
+	ID          string     `json:"id"`
+	Fee         rpc.Amount `json:"fee"`
+	AssetLabel  string     `json:"assetid"`
+	Cost        rpc.Amount `json:"cost"`
+	Transaction string     `json:"tx"`
+	Commitments []string   `json:"commitments"`
 }
 
 // GetID returns ID of ExchangeOfferWBResponse instance.
@@ -96,8 +99,10 @@ func (u *ExchangeOfferWBResponse) GetID() string {
 }
 
 // SubmitExchangeRequest is a structure that represents the JSON-API request.
+// ID is the offer ID issued by the exchanger with the transaction template.
 type SubmitExchangeRequest struct {
-	Transaction string `json:"tx"`
+	ID          string `json:"id" validate:"required,hex"`
+	Transaction string `json:"tx" validate:"required,hex"`
 }
 
 // SubmitExchangeResponse is a structure that represents the JSON-API response.
@@ -105,12 +110,81 @@ type SubmitExchangeResponse struct {
 	TransactionID string `json:"txid"`
 }
 
-// ErrorResponse is a structure that represents the JSON-API response.
-type ErrorResponse struct {
+// CancelExchangeRequest is a structure that represents the JSON-API request.
+type CancelExchangeRequest struct {
+	ID string `json:"id" validate:"required,hex"`
+}
+
+// CancelExchangeResponse is a structure that represents the JSON-API response.
+type CancelExchangeResponse struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+}
+
+// OfferStatusRequest is a structure that represents the JSON-API request.
+// An empty ID requests all offers.
+type OfferStatusRequest struct {
+	ID string `json:"id" validate:"hex"`
+}
+
+// OfferStatus is a structure for OfferStatusResponse.
+type OfferStatus struct {
+	ID            string     `json:"id"`
+	RequestAsset  string     `json:"request_asset"`
+	RequestAmount rpc.Amount `json:"request_amount"`
+	OfferAsset    string     `json:"offer_asset"`
+	Cost          rpc.Amount `json:"cost"`
+	Fee           rpc.Amount `json:"fee"`
+	Inputs        []string   `json:"inputs"`
+	Created       int64      `json:"created"`
+	Expiry        int64      `json:"expiry"`
+	State         string     `json:"state"`
+	TransactionID string     `json:"txid"`
+}
+
+// OfferStatusResponse is a structure that represents the JSON-API response.
+type OfferStatusResponse struct {
+	Offers []OfferStatus `json:"offers"`
+}
+
+// Reasons of SubmitExchangeRejection.
+const (
+	RejectUnknownOffer       = "unknown_offer"
+	RejectOfferClosed        = "offer_closed"
+	RejectInvalidTransaction = "invalid_transaction"
+	RejectMissingInput       = "missing_input"
+	RejectUnexpectedInput    = "unexpected_input"
+	RejectMissingOutput      = "missing_output"
+	RejectInsufficientFee    = "insufficient_fee"
+)
+
+// SubmitExchangeRejection is a structure that represents the JSON-API response
+// when a submitted transaction does not match the offer.
+type SubmitExchangeRejection struct {
 	Result  bool   `json:"result"`
+	Code    string `json:"code"`
+	ID      string `json:"id"`
+	Reason  string `json:"reason"`
 	Message string `json:"message"`
 }
 
+// Error returns the message of SubmitExchangeRejection.
+func (e *SubmitExchangeRejection) Error() string {
+	return fmt.Sprintf("submission rejected [%s]: %s: %s", e.ID, e.Reason, e.Message)
+}
+
+// ErrorResponse is a structure that represents the JSON-API response.
+// Code tells the kind of the error. (see Code* constants)
+// Fields lists the bad fields of an invalid request.
+// RequestID is the ID of the request in the logs of the server.
+type ErrorResponse struct {
+	Result    bool         `json:"result"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
 var logger *log.Logger
 
 // SetLogger sets logger.
@@ -124,6 +198,7 @@ func createErrorByteArray(e error) []byte {
 	}
 	res := ErrorResponse{
 		Result:  false,
+		Code:    CodeInternal,
 		Message: fmt.Sprintf("%s", e),
 	}
 	b, err := json.Marshal(res)
@@ -133,18 +208,9 @@ func createErrorByteArray(e error) []byte {
 	return b
 }
 
-func handler(w http.ResponseWriter, r *http.Request, fi interface{}, n string) {
-
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Methods", "GET")
-	w.Header().Add("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
-	w.Header().Add("Access-Control-Max-Age", "-1")
-
+func handler(w http.ResponseWriter, r *http.Request, h Handler) {
 	status := http.StatusOK
-	createParam := formToFlatStruct
-	var res interface{}
-	var err error
-	var fp0e reflect.Value
+	id := RequestIDFrom(r.Context())
 
 	defer func() {
 		e := r.Body.Close()
@@ -153,122 +219,49 @@ func handler(w http.ResponseWriter, r *http.Request, fi interface{}, n string) {
 		}
 	}()
 
-	switch r.Method {
-	case "GET":
-	case "POST":
-		ct := strings.Split(""+r.Header.Get("Content-Type"), ";")[0]
-		switch ct {
-		case "application/x-www-form-urlencoded":
-		case "application/json", "text/plain":
-			createParam = jsonToStruct
-		default:
-			status = http.StatusBadRequest
-			err = fmt.Errorf("content-type not allowed:%s", ct)
-			logger.Println("error:", err)
-			handleTermninate(w, res, status, err)
-			return
-		}
-	default:
+	if !h.allows(r.Method) {
 		status = http.StatusMethodNotAllowed
-		err = fmt.Errorf("method not allowed:%s", r.Method)
-		logger.Println("error:", err)
-		handleTermninate(w, res, status, err)
+		err := fmt.Errorf("method not allowed:%s", r.Method)
+		logger.Println("error:", err, "request:", id)
+		w.Header().Set("Allow", strings.Join(h.AllowedMethods(), ", "))
+		handleTermninate(w, &ErrorResponse{Code: CodeMethodNotAllowed, Message: err.Error(), RequestID: id}, status, err)
 		return
 	}
 
-	fp0e, err = createParam(r, fi)
-	if err != nil {
-		status = http.StatusInternalServerError
-		logger.Println("error:", err)
-		handleTermninate(w, res, status, err)
-		return
-	}
-
-	fv := reflect.ValueOf(fi)
-	logger.Println("start:", n)
-	result := fv.Call([]reflect.Value{fp0e})
-	logger.Println("end:", n)
-
-	if err, ok := result[1].Interface().(error); ok {
-		status = http.StatusInternalServerError
-		logger.Println("error:", err)
-		handleTermninate(w, res, status, err)
+	req, err := h.decode(r)
+	var verr *ValidationError
+	if err == nil || errors.As(err, &verr) {
+		err = validateRequest(req, verr)
 	}
-
-	handleTermninate(w, result[0].Interface(), status, nil)
-
-	return
-}
-
-func formToFlatStruct(r *http.Request, fi interface{}) (reflect.Value, error) {
-	var formValue reflect.Value
-
-	err := r.ParseForm()
 	if err != nil {
-		logger.Println("http.Request#ParseForm error:", err)
-		return formValue, err
-	}
-
-	fv := reflect.ValueOf(fi)
-	fp0t := fv.Type().In(0)
-	fp0v := reflect.New(fp0t)
-	formValue = fp0v.Elem()
-
-	for i := 0; i < fp0t.NumField(); i++ {
-		sfv := formValue.Field(i)
-
-		if !sfv.CanSet() {
-			continue
+		logger.Println("error:", err, "request:", id)
+		code := ErrorCode(err)
+		if code == CodeInternal {
+			code = CodeBadRequest
 		}
-
-		paramv := getParamByNameIgnoreCase(r.Form, fp0t.Field(i).Name)
-		switch sfv.Kind() {
-		case reflect.String:
-			sfv.SetString(paramv)
-		case reflect.Int64:
-			val, err := strconv.ParseInt(paramv, 10, 64)
-			if err != nil {
-				break
-			}
-			sfv.SetInt(val)
-		default:
-		}
-	}
-
-	return formValue, nil
-}
-
-func getParamByNameIgnoreCase(form url.Values, key string) string {
-	lkey := strings.ToLower(key)
-	var value string
-	for k, v := range form {
-		if strings.ToLower(k) == lkey {
-			value = v[0]
-		}
-	}
-	return value
-}
-
-func jsonToStruct(r *http.Request, fi interface{}) (reflect.Value, error) {
-	var fp0e reflect.Value
-
-	reqBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		logger.Println("ioutil#ReadAll error:", err)
-		return fp0e, err
+		res := NewErrorResponse(err)
+		res.Code = code
+		res.RequestID = id
+		handleTermninate(w, res, StatusCode(code), err)
+		return
 	}
 
-	fv := reflect.ValueOf(fi)
-	fp0v := reflect.New(fv.Type().In(0))
-	fp0i := fp0v.Interface()
+	logger.Println("start:", h.name, "request:", id)
+	res, err := h.invoke(r.Context(), req)
+	logger.Println("end:", h.name, "request:", id)
 
-	err = json.Unmarshal(reqBody, fp0i)
 	if err != nil {
-		logger.Println("json#Unmarshal error:", err)
-		return fp0e, err
+		status = StatusCode(ErrorCode(err))
+		logger.Println("error:", err, "request:", id)
+		body := errorBody(err)
+		if res, ok := body.(*ErrorResponse); ok {
+			res.RequestID = id
+		}
+		handleTermninate(w, body, status, err)
+		return
 	}
 
-	return fp0v.Elem(), nil
+	handleTermninate(w, res, status, nil)
 }
 
 func handleTermninate(w http.ResponseWriter, resif interface{}, status int, err error) {
@@ -291,61 +284,89 @@ func handleTermninate(w http.ResponseWriter, resif interface{}, status int, err
 	}
 }
 
-func generateMuxHandler(h interface{}) func(http.ResponseWriter, *http.Request) {
-	fv := reflect.ValueOf(h)
-	n := runtime.FuncForPC(fv.Pointer()).Name()
-	return func(w http.ResponseWriter, r *http.Request) {
-		handler(w, r, h, n)
-		return
+// NewHTTPHandler binds specific URL and handler, and serves the files of filepath (none if empty) on the others.
+// A handler is made by Handle or HandleContext from a function taking the request struct.
+// The handlers are wrapped with mws, and the whole server with RequestID and Recover. (see HTTPConfig)
+// The API is described at OpenAPIPath and APIDocsPath, not wrapped with mws as the files.
+// It fails if two routes collide or a type of the API cannot be described. (see OpenAPI)
+func NewHTTPHandler(handlers map[string]Handler, filepath string, mws ...Middleware) (http.Handler, error) {
+	if filepath != "" {
+		err := checkRoutes(handlers, "/")
+		if err != nil {
+			return nil, err
+		}
 	}
-}
-
-// StartHTTPServer binds specific URL and handler function. And it starts http server.
-func StartHTTPServer(laddr string, handlers map[string]interface{}, filepath string) (net.Listener, error) {
-	listener, err := net.Listen("tcp", laddr)
+	doc, err := OpenAPI(path.Base(os.Args[0]), handlers)
 	if err != nil {
-		return listener, err
+		return nil, err
 	}
-
 	mux := http.NewServeMux()
+	mux.Handle(OpenAPIPath, doc.Handler())
+	mux.Handle(APIDocsPath, doc.PageHandler())
 	for p, h := range handlers {
-		hv := reflect.ValueOf(h)
-		if !hv.IsValid() {
-			return listener, fmt.Errorf("handler is invalid")
-		}
-		if hv.Kind() != reflect.Func {
-			return listener, fmt.Errorf("each handler must be a function")
+		if h.invoke == nil {
+			return nil, fmt.Errorf("handler of [%s] is invalid", p)
 		}
-		funcname := runtime.FuncForPC(hv.Pointer()).Name()
-		ht := hv.Type()
-		if ht.NumIn() != 1 {
-			return listener, fmt.Errorf("[%s] must have one input. but it has %d", funcname, ht.NumIn())
+		if h.request.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("[%s] input must be a struct", h.name)
 		}
-		hi0t := ht.In(0)
-		if hi0t.Kind() != reflect.Struct {
-			return listener, fmt.Errorf("[%s] input must be a struct", funcname)
+		if (h.response.Kind() != reflect.Struct) && (h.response.Kind() != reflect.Map) {
+			return nil, fmt.Errorf("[%s] 1st output must be a struct or a map", h.name)
 		}
-		if ht.NumOut() != 2 {
-			return listener, fmt.Errorf("[%s] must have two output. but it has %d", funcname, ht.NumOut())
-		}
-		ho0t := ht.Out(0)
-		if (ho0t.Kind() != reflect.Struct) && (ho0t.Kind() != reflect.Map) {
-			return listener, fmt.Errorf("[%s] 1st output must be a struct or a map", funcname)
-		}
-		ho1t := ht.Out(1)
-		if ho1t.Kind() != reflect.Interface {
-			return listener, fmt.Errorf("[%s] 2nd output must be a interface", funcname)
+		for _, m := range h.AllowedMethods() {
+			if m != "GET" && m != "POST" {
+				return nil, fmt.Errorf("[%s] method %s is not supported", h.name, m)
+			}
 		}
-		errorType := reflect.TypeOf((*error)(nil)).Elem()
-		if !ho1t.Implements(errorType) {
-			return listener, fmt.Errorf("[%s] 2nd output must implements error", funcname)
+
+		h := h
+		mux.Handle(p, Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r, h)
+		}), mws...))
+	}
+
+	if filepath != "" {
+		mux.Handle("/", http.FileServer(http.Dir(filepath)))
+	}
+	return Chain(mux, RequestID, Recover), nil
+}
+
+// StartHTTPServer binds specific URL and handler. And it starts http server.
+// The server cannot be shut down, Lifecycle.Listen with NewHTTPHandler is preferred.
+func StartHTTPServer(laddr string, handlers map[string]Handler, filepath string, mws ...Middleware) (net.Listener, error) {
+	mux, err := NewHTTPHandler(handlers, filepath, mws...)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return listener, err
+	}
+
+	go func() {
+		e := http.Serve(listener, mux)
+		if e != nil {
+			logger.Println("error:", e)
 		}
+	}()
+
+	return listener, err
+}
 
-		f := generateMuxHandler(h)
-		mux.HandleFunc(p, f)
+// StartHTTPServerTLS starts https server as StartHTTPServer, with http if conf is nil. (see TLSConfig)
+func StartHTTPServerTLS(laddr string, handlers map[string]Handler, filepath string, conf *tls.Config, mws ...Middleware) (net.Listener, error) {
+	if conf == nil {
+		return StartHTTPServer(laddr, handlers, filepath, mws...)
+	}
+	mux, err := NewHTTPHandler(handlers, filepath, mws...)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", laddr, conf)
+	if err != nil {
+		return listener, err
 	}
 
-	mux.Handle("/", http.FileServer(http.Dir(filepath)))
 	go func() {
 		e := http.Serve(listener, mux)
 		if e != nil {
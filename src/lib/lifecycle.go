@@ -0,0 +1,281 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (lifecycle.go) runs the servers and jobs of an actor and stops them gracefully.
+
+usage:
+
+	lc := lib.NewLifecycle("alice")
+	_, err := lc.Listen(":8000", handler)
+	err = lc.Schedule(lib.Job{Name: "locks", Interval: 3 * time.Second, Run: lib.JobFunc(lockList.Sweep)})
+	lc.OnStop("locks", func(ctx context.Context) error { return lockList.Flush() })
+	os.Exit(lc.Run())
+
+Run starts the scheduled jobs and waits for SIGINT, SIGTERM, Stop or a failing job, then
+ 1. cancels the context of the jobs and stops accepting HTTP requests,
+ 2. drains the in-flight requests and waits for the jobs within the drain timeout,
+    the context of the requests is cancelled only after draining or when the timeout passes,
+ 3. calls the OnStop functions in reverse order, to flush the state and close the clients.
+
+A second signal gives up draining.
+*/
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes returned by Lifecycle.Run.
+const (
+	ExitOK          = 0 // stopped by a signal or Stop
+	ExitFailure     = 1 // a job or a server failed
+	ExitStopFailure = 2 // draining timed out or an OnStop function failed
+)
+
+// DefaultDrainTimeout is the time given to the in-flight requests and the jobs to finish.
+const DefaultDrainTimeout = 10 * time.Second
+
+// Lifecycle owns the HTTP servers, the jobs and the stop functions of an actor.
+type Lifecycle struct {
+	// DrainTimeout is the time given to the in-flight requests and the jobs to finish.
+	DrainTimeout time.Duration
+
+	name    string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	servers []*http.Server
+	sched   *Scheduler
+	stops   []stopFunc
+	jobs    sync.WaitGroup
+	errc    chan error
+	stopc   chan struct{}
+	once    sync.Once
+
+	// the context of the requests, not cancelled while draining.
+	serveCtx    context.Context
+	serveCancel context.CancelFunc
+}
+
+type stopFunc struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+// NewLifecycle returns new Lifecycle of the actor name.
+func NewLifecycle(name string) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	serveCtx, serveCancel := context.WithCancel(context.Background())
+	return &Lifecycle{
+		DrainTimeout: DefaultDrainTimeout,
+		name:         name,
+		ctx:          ctx,
+		cancel:       cancel,
+		serveCtx:     serveCtx,
+		serveCancel:  serveCancel,
+		sched:        NewScheduler(),
+		errc:         make(chan error, 1),
+		stopc:        make(chan struct{}),
+	}
+}
+
+// Context returns the context of the jobs, cancelled when stopping.
+func (lc *Lifecycle) Context() context.Context {
+	return lc.ctx
+}
+
+// Listen serves handler on laddr until stopping.
+func (lc *Lifecycle) Listen(laddr string, handler http.Handler) (net.Listener, error) {
+	listener, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	lc.Serve(listener, handler)
+	return listener, nil
+}
+
+// ListenTLS serves handler with HTTPS on laddr until stopping, with HTTP if conf is nil. (see TLSConfig)
+func (lc *Lifecycle) ListenTLS(laddr string, handler http.Handler, conf *tls.Config) (net.Listener, error) {
+	if conf == nil {
+		return lc.Listen(laddr, handler)
+	}
+	listener, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	listener = tls.NewListener(listener, conf)
+	lc.Serve(listener, handler)
+	return listener, nil
+}
+
+// Serve serves handler on listener until stopping.
+func (lc *Lifecycle) Serve(listener net.Listener, handler http.Handler) {
+	server := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return lc.serveCtx },
+	}
+	lc.mu.Lock()
+	lc.servers = append(lc.servers, server)
+	lc.mu.Unlock()
+	logger.Println("start listening...", listener.Addr().Network(), listener.Addr())
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			lc.fail(fmt.Errorf("server %s: %v", listener.Addr(), err))
+		}
+	}()
+}
+
+// Go runs job until its context is cancelled. An error returned before stopping stops the actor.
+func (lc *Lifecycle) Go(name string, job func(ctx context.Context) error) {
+	lc.jobs.Add(1)
+	go func() {
+		defer lc.jobs.Done()
+		err := job(lc.ctx)
+		if err != nil && lc.ctx.Err() == nil {
+			lc.fail(fmt.Errorf("job %s: %v", name, err))
+		}
+	}()
+}
+
+// Schedule adds job to the scheduler run until stopping.
+func (lc *Lifecycle) Schedule(job Job) error {
+	return lc.sched.Add(job)
+}
+
+// Scheduler returns the scheduler of the jobs, for the introspection.
+func (lc *Lifecycle) Scheduler() *Scheduler {
+	return lc.sched
+}
+
+// OnStop registers f called when stopping, after the servers and the jobs. (in reverse order)
+func (lc *Lifecycle) OnStop(name string, f func(ctx context.Context) error) {
+	lc.mu.Lock()
+	lc.stops = append(lc.stops, stopFunc{name: name, f: f})
+	lc.mu.Unlock()
+}
+
+// Close registers c closed when stopping.
+func (lc *Lifecycle) Close(name string, c io.Closer) {
+	lc.OnStop(name, func(context.Context) error {
+		return c.Close()
+	})
+}
+
+// Stop makes Run stop the actor.
+func (lc *Lifecycle) Stop() {
+	lc.once.Do(func() {
+		close(lc.stopc)
+	})
+}
+
+func (lc *Lifecycle) fail(err error) {
+	select {
+	case lc.errc <- err:
+	default:
+	}
+}
+
+// Run waits for a signal, Stop or a failure, stops the actor and returns the exit code.
+func (lc *Lifecycle) Run() int {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	lc.Go("scheduler", lc.sched.Run)
+
+	code := ExitOK
+	select {
+	case rcv := <-sig:
+		logger.Println("signal:", rcv)
+	case <-lc.stopc:
+	case err := <-lc.errc:
+		logger.Println("error:", err)
+		code = ExitFailure
+	}
+	logger.Println(lc.name, "stopping")
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), lc.DrainTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case rcv := <-sig:
+			logger.Println("signal:", rcv, "(stop draining)")
+			cancel()
+		case <-drainCtx.Done():
+		}
+	}()
+
+	err := lc.shutdown(drainCtx)
+	if err != nil {
+		logger.Println("error:", err)
+		if code == ExitOK {
+			code = ExitStopFailure
+		}
+	}
+	logger.Println(lc.name, "stopped, exit code", code)
+	return code
+}
+
+func (lc *Lifecycle) shutdown(ctx context.Context) error {
+	lc.cancel()
+
+	lc.mu.Lock()
+	servers := lc.servers
+	stops := lc.stops
+	lc.mu.Unlock()
+
+	var errs []error
+	var wg sync.WaitGroup
+	var emu sync.Mutex
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			err := server.Shutdown(ctx)
+			if err != nil {
+				server.Close()
+				emu.Lock()
+				errs = append(errs, fmt.Errorf("drain requests: %v", err))
+				emu.Unlock()
+			}
+		}(server)
+	}
+	wg.Wait()
+	// drained or given up, the requests still running are cancelled.
+	lc.serveCancel()
+
+	jobsDone := make(chan struct{})
+	go func() {
+		lc.jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("wait jobs: %v", ctx.Err()))
+	}
+
+	// flush the state even if draining timed out.
+	stopCtx, cancel := context.WithTimeout(context.Background(), lc.DrainTimeout)
+	defer cancel()
+	for i := len(stops) - 1; 0 <= i; i-- {
+		err := stops[i].f(stopCtx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %v", stops[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
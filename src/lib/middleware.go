@@ -0,0 +1,282 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (middleware.go) provides the middlewares of the HTTP servers.
+
+usage:
+
+	var httpConf lib.HTTPConfig
+	conf.GetInterface("http", &httpConf)
+	mws, auth, err := httpConf.Middlewares()
+	handler, err := lib.NewHTTPHandler(handlerList, dir, mws...)
+
+NewHTTPHandler wraps each API route with the middlewares, in order, and the whole
+server with RequestID and Recover. The files are served without authentication.
+*/
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with the middlewares. The first one is the outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; 0 <= i; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// DefaultMaxBodyBytes is the size limit of the request bodies if not configured.
+const DefaultMaxBodyBytes = 1 << 20
+
+// HTTPConfig is the "http" setting of an actor in democonf.json.
+//
+//	"http": {
+//		"auth": {"apikeys": {"ui": "..."}, "session": 3600, "public": ["/order"]},
+//		"cors": {"/getexchangerate/": {"origins": ["*"]}},
+//		"maxbody": 65536
+//	}
+type HTTPConfig struct {
+	Auth    AuthConfig            `json:"auth"`
+	CORS    map[string]CORSPolicy `json:"cors"`    // route prefix -> policy
+	MaxBody int64                 `json:"maxbody"` // DefaultMaxBodyBytes if 0
+}
+
+// Middlewares returns the middlewares of the API routes, and the authenticator (nil if no key is configured).
+// It fails if a CORS policy allows the credentials of any origin, or if a key can not be resolved.
+func (c HTTPConfig) Middlewares() ([]Middleware, *APIAuth, error) {
+	for prefix, p := range c.CORS {
+		if p.Credentials && containsFold(p.Origins, "*") {
+			return nil, nil, fmt.Errorf("cors [%s]: credentials need explicit origins, not *", prefix)
+		}
+	}
+	maxBody := c.MaxBody
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBodyBytes
+	}
+	authConf, err := c.Auth.resolve()
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth: %v", err)
+	}
+	mws := []Middleware{CORS(c.CORS), LimitBody(maxBody)}
+	auth := NewAPIAuth(authConf)
+	if auth == nil {
+		logger.Println("warning: no API key is configured, the API is not authenticated")
+		return mws, nil, nil
+	}
+	return append(mws, auth.Middleware), auth, nil
+}
+
+type requestIDKey struct{}
+
+// RequestIDHeader carries the request ID in the requests and the responses.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// RequestID gives each request an ID, taken from the X-Request-ID header if valid.
+// The ID is set to the response header and to the context. (see RequestIDFrom)
+// A request which already has an ID keeps it.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestIDFrom(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFrom returns the request ID in ctx, empty if none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		logger.Println("rand#Read error:", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Recover answers 500 to a request whose handler panics, and logs the stack.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logger.Printf("panic: %v request: %s\n%s", v, RequestIDFrom(r.Context()), debug.Stack())
+			writeError(w, r, errors.New("internal error"), CodeInternal)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimitBody makes reading more than n bytes of a request body fail. (413 by the handlers)
+func LimitBody(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n < r.ContentLength {
+				err := fmt.Errorf("request body too large: %d bytes (max %d)", r.ContentLength, n)
+				writeError(w, r, err, CodeTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORSPolicy is the cross-origin policy of a route.
+type CORSPolicy struct {
+	Origins     []string `json:"origins"`     // allowed origins, "*" for any
+	Methods     []string `json:"methods"`     // GET and POST if empty
+	Headers     []string `json:"headers"`     // allowed request headers, Content-Type if empty
+	Credentials bool     `json:"credentials"` // allows the cookies, only of the explicit origins
+	MaxAge      int64    `json:"maxage"`      // seconds a preflight is cached
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin of origin, empty if not allowed.
+// "*" never allows the credentials, so a page of any site cannot act with the user's cookie.
+func (p *CORSPolicy) allowOrigin(origin string) string {
+	for _, o := range p.Origins {
+		if o == "*" && !p.Credentials {
+			return "*"
+		}
+		if o == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (p *CORSPolicy) methods() []string {
+	if len(p.Methods) == 0 {
+		return []string{"GET", "POST"}
+	}
+	return p.Methods
+}
+
+func (p *CORSPolicy) headers() []string {
+	if len(p.Headers) == 0 {
+		return []string{"Content-Type"}
+	}
+	return p.Headers
+}
+
+// CORS applies the policy of the longest route prefix matching the path.
+// Cross-origin requests of a route without a policy get no CORS headers, so browsers block them.
+func CORS(policies map[string]CORSPolicy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			p := corsPolicy(policies, r.URL.Path)
+			allowed := ""
+			if p != nil {
+				allowed = p.allowOrigin(origin)
+			}
+			preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+			if allowed == "" {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			if p.Credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
+				next.ServeHTTP(w, r)
+				return
+			}
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !containsFold(p.methods(), method) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.methods(), ","))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.headers(), ","))
+			if 0 < p.MaxAge {
+				w.Header().Set("Access-Control-Max-Age", strconv.FormatInt(p.MaxAge, 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func corsPolicy(policies map[string]CORSPolicy, path string) *CORSPolicy {
+	var found *CORSPolicy
+	longest := -1
+	for prefix, p := range policies {
+		if strings.HasPrefix(path, prefix) && longest < len(prefix) {
+			p := p
+			found, longest = &p, len(prefix)
+		}
+	}
+	return found
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError writes ErrorResponse of err with code and its status, for the middlewares.
+func writeError(w http.ResponseWriter, r *http.Request, err error, code string) {
+	id := RequestIDFrom(r.Context())
+	logger.Println("error:", err, "request:", id)
+	res := &ErrorResponse{Code: code, Message: err.Error(), RequestID: id}
+	b, e := json.Marshal(res)
+	if e != nil {
+		logger.Println("json#Marshal error:", e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(StatusCode(code))
+	_, e = w.Write(b)
+	if e != nil {
+		logger.Println("w#Write Error:", e)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewaresCORS(t *testing.T) {
+	// any origin never gets the credentials.
+	conf := HTTPConfig{CORS: map[string]CORSPolicy{"/getexchangerate/": {Origins: []string{"https://a.example", "*"}, Credentials: true}}}
+	if _, _, err := conf.Middlewares(); err == nil || !strings.Contains(err.Error(), "credentials") {
+		t.Errorf("* with credentials: %v", err)
+	}
+	p := CORSPolicy{Origins: []string{"*"}, Credentials: true}
+	if o := p.allowOrigin("https://evil.example"); o != "" {
+		t.Errorf("allowed %q with credentials", o)
+	}
+
+	conf = HTTPConfig{CORS: map[string]CORSPolicy{
+		"/":                 {Origins: []string{"https://ui.example"}, Credentials: true, MaxAge: 60},
+		"/getexchangerate/": {Origins: []string{"*"}},
+	}}
+	mws, auth, err := conf.Middlewares()
+	if err != nil || auth != nil {
+		t.Fatal(err, auth)
+	}
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), mws...)
+	for _, c := range []struct {
+		method, path, origin string
+		status               int
+		allow, credentials   string
+	}{
+		{"GET", "/getexchangerate/", "https://any.example", http.StatusOK, "*", ""},
+		{"GET", "/balance", "https://ui.example", http.StatusOK, "https://ui.example", "true"},
+		{"GET", "/balance", "https://evil.example", http.StatusOK, "", ""},
+		{"OPTIONS", "/balance", "https://ui.example", http.StatusNoContent, "https://ui.example", "true"},
+		{"OPTIONS", "/balance", "https://evil.example", http.StatusForbidden, "", ""},
+	} {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("Origin", c.origin)
+		if c.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.status || rec.Header().Get("Access-Control-Allow-Origin") != c.allow ||
+			rec.Header().Get("Access-Control-Allow-Credentials") != c.credentials {
+			t.Errorf("%s %s from %s: %d %v", c.method, c.path, c.origin, rec.Code, rec.Header())
+		}
+	}
+
+	// a method not allowed by the policy
+	req := httptest.NewRequest("OPTIONS", "/balance", nil)
+	req.Header.Set("Origin", "https://ui.example")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("preflight of DELETE: %d", rec.Code)
+	}
+}
+
+type echoRequest struct {
+	Message string `json:"message"`
+}
+
+func echo(req echoRequest) (echoRequest, error) {
+	return req, nil
+}
+
+func TestLimitBody(t *testing.T) {
+	mws, _, err := HTTPConfig{MaxBody: 32}.Middlewares()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHTTPHandler(map[string]Handler{"/echo": Handle(echo).Methods("POST")}, "", mws...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(body string, length int64) *http.Request {
+		req := httptest.NewRequest("POST", "/echo", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.ContentLength = length
+		return req
+	}
+	small := `{"message":"hi"}`
+	large := `{"message":"` + strings.Repeat("x", 64) + `"}`
+	serve(t, h, post(small, int64(len(small))), http.StatusOK, "")
+	// refused by its length, or when read beyond the limit without a length
+	serve(t, h, post(large, int64(len(large))), http.StatusRequestEntityTooLarge, CodeTooLarge)
+	serve(t, h, post(large, -1), http.StatusRequestEntityTooLarge, CodeTooLarge)
+
+	if mws, _, err = (HTTPConfig{}).Middlewares(); err != nil {
+		t.Fatal(err)
+	}
+	h, _ = NewHTTPHandler(map[string]Handler{"/echo": Handle(echo).Methods("POST")}, "", mws...)
+	serve(t, h, post(large, -1), http.StatusOK, "")
+}
+
+func TestRecover(t *testing.T) {
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errors.New("nil map"))
+	}), RequestID, Recover)
+	req := httptest.NewRequest("GET", "/balance", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	rec := serve(t, h, req, http.StatusInternalServerError, CodeInternal)
+	if strings.Contains(rec.Body.String(), "nil map") || !strings.Contains(rec.Body.String(), "req-1") {
+		t.Errorf("response %s, want no detail but the request id", rec.Body)
+	}
+
+	// an aborted response is not answered.
+	h = Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), Recover)
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want ErrAbortHandler", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestIDFrom(r.Context())
+	}), RequestID)
+	for id, keep := range map[string]bool{"abc-1.2_3": true, "": false, "bad id": false, strings.Repeat("a", 65): false} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got == "" || (got == id) != keep || rec.Header().Get(RequestIDHeader) != got {
+			t.Errorf("%q: id %q header %q", id, got, rec.Header().Get(RequestIDHeader))
+		}
+	}
+	if RequestIDFrom(context.Background()) != "" {
+		t.Error("id without a request")
+	}
+}
@@ -0,0 +1,658 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (openapi.go) describes the JSON-API of the handlers as an OpenAPI 3 document.
+
+usage:
+
+	doc, err := lib.OpenAPI("charlie", handlerList)
+	http.Handle(lib.OpenAPIPath, doc.Handler())
+	http.Handle(lib.APIDocsPath, doc.PageHandler())
+
+NewHTTPHandler serves the document of its handlers this way.
+The schemas are made from the request and response types by their json tags,
+with the constraints of their validate tags. (see Validate)
+A GET request is described by query parameters, "a[b]" for a nested field.
+A type without a definite JSON shape (an interface, a func, a custom json.Marshaler, ...) is an error.
+*/
+package lib
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"rpc"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of the API description served by NewHTTPHandler.
+const (
+	OpenAPIPath = "/openapi.json"
+	APIDocsPath = "/apidocs"
+)
+
+// OpenAPIDocument is an OpenAPI 3.1 document.
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// OpenAPIInfo is the info of OpenAPIDocument.
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents holds the schemas of the named struct types.
+type OpenAPIComponents struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// OpenAPIOperation is a method of a path.
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter is a query parameter of a GET request.
+type OpenAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Style    string  `json:"style,omitempty"`
+	Explode  bool    `json:"explode,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// OpenAPIRequestBody is the body of a POST request.
+type OpenAPIRequestBody struct {
+	Required bool                     `json:"required"`
+	Content  map[string]*OpenAPIMedia `json:"content"`
+}
+
+// OpenAPIResponse is a response of an operation.
+type OpenAPIResponse struct {
+	Description string                   `json:"description"`
+	Content     map[string]*OpenAPIMedia `json:"content,omitempty"`
+}
+
+// OpenAPIMedia is the schema of a content type.
+type OpenAPIMedia struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema of OpenAPIDocument.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int64             `json:"minLength,omitempty"`
+	MaxLength            *int64             `json:"maxLength,omitempty"`
+	MinItems             *int64             `json:"minItems,omitempty"`
+	MaxItems             *int64             `json:"maxItems,omitempty"`
+	MinProperties        *int64             `json:"minProperties,omitempty"`
+	MaxProperties        *int64             `json:"maxProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	PropertyNames        *Schema            `json:"propertyNames,omitempty"`
+}
+
+const componentPrefix = "#/components/schemas/"
+
+// Patterns of the validate rules.
+const (
+	hexPattern   = `^([0-9a-fA-F]{2})*$`
+	assetPattern = `^([A-Za-z0-9_\-]{1,32}|[0-9a-fA-F]{64})$`
+)
+
+var (
+	amountType          = reflect.TypeOf(rpc.Amount(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	componentNameFilter = regexp.MustCompile(`[^A-Za-z0-9._\-]`)
+)
+
+// OpenAPI returns the document of the handlers bound to their paths.
+// It fails if two paths collide or a type cannot be described.
+func OpenAPI(title string, handlers map[string]Handler) (*OpenAPIDocument, error) {
+	err := checkRoutes(handlers, OpenAPIPath, APIDocsPath, SessionPath)
+	if err != nil {
+		return nil, err
+	}
+	g := &schemaGen{schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+	errSchema, err := g.schema(reflect.TypeOf(ErrorResponse{}))
+	if err != nil {
+		return nil, err
+	}
+	doc := &OpenAPIDocument{
+		OpenAPI:    "3.1.0",
+		Info:       OpenAPIInfo{Title: title, Version: "1.0.0"},
+		Paths:      make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{Schemas: g.schemas},
+	}
+	for _, p := range sortedRoutes(handlers) {
+		h := handlers[p]
+		reqSchema, err := g.schema(h.request)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] request: %v", p, err)
+		}
+		resSchema, err := g.schema(h.response)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] response: %v", p, err)
+		}
+		ops := make(map[string]*OpenAPIOperation)
+		for _, m := range h.AllowedMethods() {
+			op := &OpenAPIOperation{
+				OperationID: operationID(m, p),
+				Summary:     path.Base(h.name),
+				Responses: map[string]*OpenAPIResponse{
+					"200": {
+						Description: "OK",
+						Content:     map[string]*OpenAPIMedia{"application/json": {Schema: resSchema}},
+					},
+					"default": {
+						Description: "error",
+						Content:     map[string]*OpenAPIMedia{"application/json": {Schema: errSchema}},
+					},
+				},
+			}
+			switch m {
+			case "GET":
+				op.Parameters = g.queryParameters(reqSchema)
+			case "POST":
+				op.RequestBody = &OpenAPIRequestBody{
+					Required: true,
+					Content: map[string]*OpenAPIMedia{
+						"application/json":                  {Schema: reqSchema},
+						"application/x-www-form-urlencoded": {Schema: reqSchema},
+					},
+				}
+			}
+			ops[strings.ToLower(m)] = op
+		}
+		doc.Paths[p] = ops
+	}
+	return doc, nil
+}
+
+// checkRoutes fails if a path is not a plain path, or two paths (or a reserved one) differ only by a trailing slash.
+func checkRoutes(handlers map[string]Handler, reserved ...string) error {
+	taken := make(map[string]bool)
+	for _, p := range reserved {
+		taken[strings.TrimSuffix(p, "/")] = true
+	}
+	seen := make(map[string]string)
+	for _, p := range sortedRoutes(handlers) {
+		if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, " {}") {
+			return fmt.Errorf("route [%s] must be a path", p)
+		}
+		key := strings.TrimSuffix(p, "/")
+		if taken[key] {
+			return fmt.Errorf("route [%s] is reserved", p)
+		}
+		if q, ok := seen[key]; ok {
+			return fmt.Errorf("routes [%s] and [%s] collide", q, p)
+		}
+		seen[key] = p
+	}
+	return nil
+}
+
+func sortedRoutes(handlers map[string]Handler) []string {
+	paths := make([]string, 0, len(handlers))
+	for p := range handlers {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// operationID returns the ID of method on p, e.g. post_getexchangerate.
+func operationID(method string, p string) string {
+	id := strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.Trim(p, "/"))
+	return strings.ToLower(method) + "_" + id
+}
+
+// schemaGen makes the schemas, registering the named structs as components.
+type schemaGen struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func (g *schemaGen) schema(t reflect.Type) (*Schema, error) {
+	switch t {
+	case amountType:
+		return &Schema{Type: "number", Format: "amount", Description: "in coin units, also accepted as a string"}, nil
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		return g.schema(t.Elem())
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return nil, fmt.Errorf("cannot describe %s: custom JSON encoding", t)
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return &Schema{Type: "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}, nil
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		key := t.Key()
+		if key.Kind() != reflect.String && !key.Implements(textMarshalerType) && !isIntKind(key.Kind()) {
+			return nil, fmt.Errorf("cannot describe %s: map key %s", t, key)
+		}
+		values, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return g.component(t)
+	}
+	return nil, fmt.Errorf("cannot describe %s", t)
+}
+
+// component returns the reference to the schema of the named struct t.
+func (g *schemaGen) component(t reflect.Type) (*Schema, error) {
+	if name, ok := g.names[t]; ok {
+		return &Schema{Ref: componentPrefix + name}, nil
+	}
+	name := componentNameFilter.ReplaceAllString(t.Name(), "_")
+	if _, ok := g.schemas[name]; ok {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	// registered before the fields for the recursive types
+	s := &Schema{}
+	g.names[t] = name
+	g.schemas[name] = s
+	built, err := g.structSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	*s = *built
+	return &Schema{Ref: componentPrefix + name}, nil
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) (*Schema, error) {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	err := g.addFields(s, t)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// addFields adds the fields of t to s, with the fields of the embedded structs as encoding/json does.
+func (g *schemaGen) addFields(s *Schema, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		ft := sf.Type
+		if sf.Anonymous && opts[0] == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				err := g.addFields(s, ft)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := fieldName(sf)
+		fs, err := g.schema(ft)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), sf.Name, err)
+		}
+		if hasOption(opts[1:], "string") && fs.Ref == "" {
+			switch fs.Type {
+			case "integer", "number", "boolean":
+				fs = &Schema{Type: "string", Format: fs.Type}
+			}
+		}
+		rules := sf.Tag.Get("validate")
+		err = applyRules(fs, ft, rules)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), sf.Name, err)
+		}
+		s.Properties[name] = fs
+		if hasRule(rules, "required") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+func hasOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// applyRules sets the constraints of the validate rules to s, the schema of t.
+func applyRules(s *Schema, t reflect.Type, tag string) error {
+	if tag == "" {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg := rule, ""
+		if n := strings.Index(rule, "="); 0 <= n {
+			name, arg = rule[:n], rule[n+1:]
+		}
+		switch name {
+		case "required", "":
+		case "min", "max":
+			err := applyBound(s, t, name, arg)
+			if err != nil {
+				return err
+			}
+		case "hex", "asset", "address":
+			target, err := stringsSchema(s, t)
+			if err != nil {
+				return fmt.Errorf("rule %s: %v", name, err)
+			}
+			switch name {
+			case "hex":
+				target.Pattern = hexPattern
+			case "asset":
+				target.Pattern = assetPattern
+			case "address":
+				target.Format = "address"
+			}
+		default:
+			return fmt.Errorf("unknown rule %s", name)
+		}
+	}
+	return nil
+}
+
+func applyBound(s *Schema, t reflect.Type, name string, arg string) error {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return fmt.Errorf("invalid rule %s=%s", name, arg)
+	}
+	n := int64(bound)
+	var p **int64
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if t == amountType {
+			// the rule is in satoshi, the JSON in coin units
+			bound /= float64(rpc.Coin)
+		}
+		if name == "min" {
+			s.Minimum = &bound
+		} else {
+			s.Maximum = &bound
+		}
+		return nil
+	case reflect.String:
+		p = &s.MinLength
+		if name == "max" {
+			p = &s.MaxLength
+		}
+	case reflect.Slice, reflect.Array:
+		p = &s.MinItems
+		if name == "max" {
+			p = &s.MaxItems
+		}
+	case reflect.Map:
+		p = &s.MinProperties
+		if name == "max" {
+			p = &s.MaxProperties
+		}
+	default:
+		return fmt.Errorf("rule %s is not for %s", name, t)
+	}
+	*p = &n
+	return nil
+}
+
+// stringsSchema returns the schema of the strings checked by a string rule:
+// s itself, the items of an array or the property names of an object.
+func stringsSchema(s *Schema, t reflect.Type) (*Schema, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Slice, reflect.Array:
+		if s.Items != nil && s.Items.Type == "string" {
+			return s.Items, nil
+		}
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String {
+			s.PropertyNames = &Schema{Type: "string"}
+			return s.PropertyNames, nil
+		}
+	}
+	return nil, fmt.Errorf("not for %s", t)
+}
+
+// queryParameters returns the parameters of a GET request of the struct described by s.
+func (g *schemaGen) queryParameters(s *Schema) []OpenAPIParameter {
+	if strings.HasPrefix(s.Ref, componentPrefix) {
+		s = g.schemas[strings.TrimPrefix(s.Ref, componentPrefix)]
+	}
+	required := make(map[string]bool)
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make([]OpenAPIParameter, 0, len(names))
+	for _, name := range names {
+		ps := s.Properties[name]
+		param := OpenAPIParameter{Name: name, In: "query", Required: required[name], Schema: ps}
+		if ps.Ref != "" || ps.Type == "object" {
+			param.Style, param.Explode = "deepObject", true
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// Handler returns the handler serving doc as JSON.
+func (doc *OpenAPIDocument) Handler() http.Handler {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeError(w, r, err, CodeInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write(body)
+		if err != nil {
+			logger.Println("w#Write Error:", err)
+		}
+	})
+}
+
+// apiDocsPage is the browsable page of OpenAPIDocument.
+var apiDocsPage = template.Must(template.New("apidocs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+h2 { border-bottom: 1px solid #ccc; }
+.method { font-weight: bold; text-transform: uppercase; margin-right: 1em; }
+pre { background: #f4f4f4; padding: 0.5em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p><a href="{{.DocPath}}">{{.DocPath}}</a></p>
+{{range .Paths}}
+<h2>{{.Path}}</h2>
+{{range .Operations}}
+<h3><span class="method">{{.Method}}</span>{{.Summary}}</h3>
+<h4>request</h4>
+<pre>{{.Request}}</pre>
+<h4>response</h4>
+<pre>{{.Response}}</pre>
+{{end}}
+{{end}}
+<h2>schemas</h2>
+{{range .Schemas}}
+<h3 id="{{.Name}}">{{.Name}}</h3>
+<pre>{{.Schema}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+type apiDocsPath struct {
+	Path       string
+	Operations []apiDocsOperation
+}
+
+type apiDocsOperation struct {
+	Method   string
+	Summary  string
+	Request  string
+	Response string
+}
+
+type apiDocsSchema struct {
+	Name   string
+	Schema string
+}
+
+// PageHandler returns the handler serving doc as an HTML page.
+func (doc *OpenAPIDocument) PageHandler() http.Handler {
+	var buf bytes.Buffer
+	err := apiDocsPage.Execute(&buf, doc.page())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeError(w, r, err, CodeInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err := w.Write(buf.Bytes())
+		if err != nil {
+			logger.Println("w#Write Error:", err)
+		}
+	})
+}
+
+func (doc *OpenAPIDocument) page() interface{} {
+	var paths []apiDocsPath
+	for p, ops := range doc.Paths {
+		dp := apiDocsPath{Path: p}
+		for m, op := range ops {
+			do := apiDocsOperation{Method: m, Summary: op.Summary}
+			if op.RequestBody != nil {
+				do.Request = schemaText(op.RequestBody.Content["application/json"].Schema)
+			} else {
+				do.Request = schemaText(op.Parameters)
+			}
+			do.Response = schemaText(op.Responses["200"].Content["application/json"].Schema)
+			dp.Operations = append(dp.Operations, do)
+		}
+		sort.Slice(dp.Operations, func(i, j int) bool { return dp.Operations[i].Method < dp.Operations[j].Method })
+		paths = append(paths, dp)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Path < paths[j].Path })
+
+	var schemas []apiDocsSchema
+	for name, s := range doc.Components.Schemas {
+		schemas = append(schemas, apiDocsSchema{Name: name, Schema: schemaText(s)})
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+
+	return struct {
+		Title   string
+		DocPath string
+		Paths   []apiDocsPath
+		Schemas []apiDocsSchema
+	}{doc.Info.Title, OpenAPIPath, paths, schemas}
+}
+
+func schemaText(v interface{}) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
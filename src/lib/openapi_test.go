@@ -0,0 +1,152 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rpc"
+	"strings"
+	"testing"
+)
+
+type emptyRequest struct{}
+
+type emptyResponse struct{}
+
+func empty(emptyRequest) (emptyResponse, error) {
+	return emptyResponse{}, nil
+}
+
+func TestCheckRoutes(t *testing.T) {
+	h := Handle(empty)
+	for _, c := range []struct {
+		routes []string
+		err    string
+	}{
+		{[]string{"/offer", "/offer/"}, "collide"},
+		{[]string{"/a/", "/b", "/b/"}, "collide"},
+		{[]string{"offer"}, "must be a path"},
+		{[]string{"/offer/{id}"}, "must be a path"},
+		{[]string{"/openapi.json"}, "reserved"},
+		{[]string{"/apidocs/"}, "reserved"},
+		{[]string{"/session"}, "reserved"},
+		{[]string{"/offer", "/offer/status", "/"}, ""},
+	} {
+		handlers := make(map[string]Handler)
+		for _, r := range c.routes {
+			handlers[r] = h
+		}
+		_, err := OpenAPI("test", handlers)
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%v: %v, want %q", c.routes, err, c.err)
+		}
+	}
+	// the files are served on "/" by NewHTTPHandler.
+	if _, err := NewHTTPHandler(map[string]Handler{"/": h}, "html"); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("route / with files: %v", err)
+	}
+}
+
+type customJSON struct{}
+
+func (customJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`"custom"`), nil
+}
+
+type customResponse struct {
+	Value customJSON `json:"value"`
+}
+
+func custom(emptyRequest) (customResponse, error) {
+	return customResponse{}, nil
+}
+
+type chanRequest struct {
+	C chan int `json:"c"`
+}
+
+func withChan(chanRequest) (emptyResponse, error) {
+	return emptyResponse{}, nil
+}
+
+func TestOpenAPIUndescribable(t *testing.T) {
+	for name, h := range map[string]Handler{"custom": Handle(custom), "chan": Handle(withChan)} {
+		_, err := OpenAPI("test", map[string]Handler{"/x": h})
+		if err == nil || !strings.Contains(err.Error(), "cannot describe") {
+			t.Errorf("%s: %v", name, err)
+		}
+		// an actor does not start with it.
+		if _, err = NewHTTPHandler(map[string]Handler{"/x": h}, ""); err == nil {
+			t.Errorf("%s: handler made", name)
+		}
+	}
+}
+
+// treeNode is a recursive type.
+type treeNode struct {
+	Name     string      `json:"name" validate:"required,max=8"`
+	Children []*treeNode `json:"children"`
+}
+
+// OutPoint has the name of rpc.OutPoint.
+type OutPoint struct {
+	Index int `json:"index"`
+}
+
+type componentsResponse struct {
+	Tree   treeNode     `json:"tree"`
+	Local  OutPoint     `json:"local"`
+	Remote rpc.OutPoint `json:"remote"`
+	Amount rpc.Amount   `json:"amount"`
+}
+
+func components(emptyRequest) (componentsResponse, error) {
+	return componentsResponse{}, nil
+}
+
+func TestOpenAPIComponents(t *testing.T) {
+	doc, err := OpenAPI("test", map[string]Handler{"/components": Handle(components)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := doc.Components.Schemas
+	res := schemas["componentsResponse"]
+	if res == nil {
+		t.Fatalf("components %v", schemas)
+	}
+	tree := schemas["treeNode"]
+	if res.Properties["tree"].Ref != componentPrefix+"treeNode" || tree == nil ||
+		tree.Properties["children"].Items.Ref != componentPrefix+"treeNode" {
+		t.Errorf("recursive: %+v", tree)
+	}
+	if *tree.Properties["name"].MaxLength != 8 || len(tree.Required) != 1 || tree.Required[0] != "name" {
+		t.Errorf("rules: %+v", tree.Properties["name"])
+	}
+
+	// the second type of a name is qualified by its package.
+	local, remote := res.Properties["local"].Ref, res.Properties["remote"].Ref
+	if local == remote || schemas[strings.TrimPrefix(local, componentPrefix)].Properties["index"] == nil ||
+		schemas[strings.TrimPrefix(remote, componentPrefix)].Properties["txid"] == nil {
+		t.Errorf("same name: %s %s in %v", local, remote, schemas)
+	}
+	if res.Properties["amount"].Format != "amount" {
+		t.Errorf("amount: %+v", res.Properties["amount"])
+	}
+
+	// the document is served as JSON.
+	rec := httptest.NewRecorder()
+	doc.Handler().ServeHTTP(rec, httptest.NewRequest("GET", OpenAPIPath, nil))
+	var served map[string]interface{}
+	if err = json.Unmarshal(rec.Body.Bytes(), &served); err != nil || rec.Code != http.StatusOK || served["openapi"] != "3.1.0" {
+		t.Errorf("served %d %v", rec.Code, err)
+	}
+	rec = httptest.NewRecorder()
+	doc.PageHandler().ServeHTTP(rec, httptest.NewRequest("GET", APIDocsPath, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/components") {
+		t.Errorf("page %d", rec.Code)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (scheduler.go) runs named jobs with their own intervals.
+
+usage:
+
+	sched := lib.NewScheduler()
+	err := sched.Add(lib.Job{
+		Name:     "payments",
+		Interval: 3 * time.Second,
+		Timeout:  10 * time.Second,
+		Events:   events, // optional, runs the job on each signal too (e.g. of notify.Subscribe)
+		Run:      scanPayments, // func(ctx context.Context) error
+	})
+	go sched.Run(ctx)
+	http.Handle("/jobs", sched.Handler())
+
+A job is not started again while it is still running, the due run is skipped.
+A job returning an error is retried with an exponential backoff up to MaxBackoff.
+*/
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UnhealthyFailures is the number of consecutive failures making a job unhealthy.
+const UnhealthyFailures = 3
+
+// Job is a job of Scheduler.
+type Job struct {
+	Name       string
+	Interval   time.Duration   // time between the starts of the runs
+	Jitter     time.Duration   // random delay up to Jitter added to each interval
+	Timeout    time.Duration   // deadline of the context of a run (none if 0)
+	MaxBackoff time.Duration   // longest delay after failures (16 * Interval if 0)
+	Events     <-chan struct{} // signals running the job at once (optional)
+	Run        func(context.Context) error
+}
+
+// JobFunc returns the run function of a job which cannot fail.
+func JobFunc(f func()) func(context.Context) error {
+	return func(context.Context) error {
+		f()
+		return nil
+	}
+}
+
+// JobStatus is the status of a job, for the introspection.
+type JobStatus struct {
+	Name         string    `json:"name"`
+	Interval     string    `json:"interval"`
+	Healthy      bool      `json:"healthy"`
+	Running      bool      `json:"running"`
+	Runs         int64     `json:"runs"`
+	Skipped      int64     `json:"skipped"`
+	Failures     int64     `json:"failures"` // consecutive failures
+	LastRun      time.Time `json:"last_run"`
+	LastDuration string    `json:"last_duration"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at"`
+	NextRun      time.Time `json:"next_run"`
+}
+
+// SchedulerStatus is the JSON-API response of the introspection endpoint.
+type SchedulerStatus struct {
+	Healthy bool        `json:"healthy"`
+	Jobs    []JobStatus `json:"jobs"`
+}
+
+// Scheduler runs named jobs.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	started bool
+}
+
+type scheduledJob struct {
+	Job
+	status JobStatus
+}
+
+// NewScheduler returns new Scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add registers job. Jobs must be added before Run.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Run == nil {
+		return fmt.Errorf("job must have a name and a run function")
+	}
+	if job.Interval <= 0 {
+		return fmt.Errorf("job %s: interval must be a plus value: %s", job.Name, job.Interval)
+	}
+	if job.MaxBackoff <= 0 {
+		job.MaxBackoff = 16 * job.Interval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("job %s: scheduler already started", job.Name)
+	}
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("job %s: already added", job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, &scheduledJob{
+		Job:    job,
+		status: JobStatus{Name: job.Name, Interval: job.Interval.String(), Healthy: true},
+	})
+	return nil
+}
+
+// Run runs the jobs until ctx is done, then waits for the running ones.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	s.started = true
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *scheduledJob) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *scheduledJob) {
+	logger.Println("job", j.Name, "interval", j.Interval)
+	timer := time.NewTimer(s.schedule(j, j.delay(0)))
+	defer timer.Stop()
+	events := j.Events
+	done := make(chan error, 1)
+	running := false
+	pending := false // an event came while running
+
+	start := func() {
+		if running {
+			s.update(j, func(st *JobStatus) { st.Skipped++ })
+			return
+		}
+		running = true
+		s.update(j, func(st *JobStatus) {
+			st.Running = true
+			st.LastRun = time.Now()
+		})
+		go func() {
+			runCtx := ctx
+			if 0 < j.Timeout {
+				var cancel context.CancelFunc
+				runCtx, cancel = context.WithTimeout(ctx, j.Timeout)
+				defer cancel()
+			}
+			done <- j.Run(runCtx)
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if running {
+				<-done
+				s.update(j, func(st *JobStatus) { st.Running = false })
+			}
+			return
+		case <-timer.C:
+			// the next run is due an interval after this start, whether this one is skipped or not.
+			timer.Reset(s.schedule(j, j.delay(0)))
+			start()
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if running {
+				pending = true
+				continue
+			}
+			start()
+		case err := <-done:
+			running = false
+			var failures int64
+			s.update(j, func(st *JobStatus) {
+				st.Running = false
+				st.Runs++
+				st.LastDuration = time.Since(st.LastRun).String()
+				if err != nil {
+					st.Failures++
+					st.LastError = err.Error()
+					st.LastErrorAt = time.Now()
+				} else {
+					st.Failures = 0
+				}
+				st.Healthy = st.Failures < UnhealthyFailures
+				failures = st.Failures
+			})
+			if err != nil {
+				logger.Println("job", j.Name, "error:", err)
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(s.schedule(j, j.delay(failures)))
+				continue
+			}
+			if pending {
+				pending = false
+				start()
+			}
+		}
+	}
+}
+
+// delay returns the time to the next run after failures consecutive failures.
+func (j *scheduledJob) delay(failures int64) time.Duration {
+	d := j.Interval
+	for i := int64(0); i < failures && d < j.MaxBackoff; i++ {
+		d *= 2
+	}
+	if j.MaxBackoff < d {
+		d = j.MaxBackoff
+	}
+	if 0 < j.Jitter {
+		d += time.Duration(rand.Int63n(int64(j.Jitter)))
+	}
+	return d
+}
+
+// schedule records the next run after d and returns d.
+func (s *Scheduler) schedule(j *scheduledJob, d time.Duration) time.Duration {
+	s.update(j, func(st *JobStatus) { st.NextRun = time.Now().Add(d) })
+	return d
+}
+
+func (s *Scheduler) update(j *scheduledJob, f func(st *JobStatus)) {
+	s.mu.Lock()
+	f(&j.status)
+	s.mu.Unlock()
+}
+
+// Status returns the status of the jobs.
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := SchedulerStatus{Healthy: true, Jobs: make([]JobStatus, len(s.jobs))}
+	for i, j := range s.jobs {
+		res.Jobs[i] = j.status
+		if !j.status.Healthy {
+			res.Healthy = false
+		}
+	}
+	return res
+}
+
+// Handler returns the introspection endpoint, answering the status as JSON.
+// The status is 503 when a job is unhealthy, for health checks.
+func (s *Scheduler) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := s.Status()
+		status := http.StatusOK
+		if !st.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		b, err := json.Marshal(st)
+		if err != nil {
+			logger.Println("json#Marshal error:", err)
+			status = http.StatusInternalServerError
+			b = createErrorByteArray(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, err = w.Write(b)
+		if err != nil {
+			logger.Println("w#Write Error:", err)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	SetLogger(log.New(ioutil.Discard, "", 0))
+	os.Exit(m.Run())
+}
+
+// runScheduler runs the jobs until the end of the test.
+func runScheduler(t *testing.T, jobs ...Job) *Scheduler {
+	s := NewScheduler()
+	for _, job := range jobs {
+		err := s.Add(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+// waitStatus waits until the status of the job satisfies cond.
+func waitStatus(t *testing.T, s *Scheduler, cond func(JobStatus) bool) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := s.Status().Jobs[0]
+		if cond(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status %+v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	s := NewScheduler()
+	run := JobFunc(func() {})
+	for _, job := range []Job{{Interval: time.Second, Run: run}, {Name: "a", Interval: time.Second}, {Name: "a", Run: run}} {
+		if err := s.Add(job); err == nil {
+			t.Errorf("%+v added", job)
+		}
+	}
+	if err := s.Add(Job{Name: "a", Interval: time.Second, Run: run}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(Job{Name: "a", Interval: time.Second, Run: run}); err == nil {
+		t.Error("added twice")
+	}
+	if s.jobs[0].MaxBackoff != 16*time.Second {
+		t.Errorf("max backoff %s, want 16 intervals", s.jobs[0].MaxBackoff)
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	j := &scheduledJob{Job: Job{Interval: time.Second, MaxBackoff: 10 * time.Second}}
+	for failures, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		if d := j.delay(int64(failures)); d != want*time.Second {
+			t.Errorf("%d failures: %s, want %s", failures, d, want*time.Second)
+		}
+	}
+	j.Jitter = time.Second
+	for i := 0; i < 100; i++ {
+		if d := j.delay(0); d < time.Second || 2*time.Second <= d {
+			t.Fatalf("jitter: %s", d)
+		}
+	}
+
+	// a failing job is retried later and later, and becomes unhealthy.
+	var runs int64
+	var last, longest time.Duration
+	start := time.Now()
+	s := runScheduler(t, Job{
+		Name:       "fail",
+		Interval:   5 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+		Run: func(context.Context) error {
+			n := atomic.AddInt64(&runs, 1)
+			now := time.Since(start)
+			if gap := now - last; 1 < n && longest < gap {
+				longest = gap
+			}
+			last = now
+			return errors.New("node down")
+		},
+	})
+	st := waitStatus(t, s, func(st JobStatus) bool { return 5 <= st.Failures })
+	if st.Healthy || st.LastError != "node down" || st.LastErrorAt.IsZero() {
+		t.Errorf("status %+v", st)
+	}
+	if s.Status().Healthy {
+		t.Error("scheduler healthy with a failing job")
+	}
+	// 4 failures put the run 40ms (not 5ms) after the previous one.
+	if longest < 30*time.Millisecond {
+		t.Errorf("longest delay %s, want the backoff", longest)
+	}
+}
+
+func TestSchedulerSkipIfRunning(t *testing.T) {
+	release := make(chan struct{})
+	var running, overlapped int64
+	s := runScheduler(t, Job{
+		Name:     "slow",
+		Interval: time.Millisecond,
+		Run: func(context.Context) error {
+			if atomic.AddInt64(&running, 1) != 1 {
+				atomic.StoreInt64(&overlapped, 1)
+			}
+			<-release
+			atomic.AddInt64(&running, -1)
+			return nil
+		},
+	})
+	st := waitStatus(t, s, func(st JobStatus) bool { return 3 <= st.Skipped })
+	if !st.Running || st.Runs != 0 {
+		t.Errorf("status %+v", st)
+	}
+	close(release)
+	waitStatus(t, s, func(st JobStatus) bool { return 2 <= st.Runs })
+	if atomic.LoadInt64(&overlapped) != 0 {
+		t.Error("run while running")
+	}
+}
+
+func TestSchedulerTimeout(t *testing.T) {
+	s := runScheduler(t, Job{
+		Name:     "stuck",
+		Interval: time.Millisecond,
+		Timeout:  10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	st := waitStatus(t, s, func(st JobStatus) bool { return 1 <= st.Failures })
+	if !strings.Contains(st.LastError, "deadline exceeded") {
+		t.Errorf("last error %q", st.LastError)
+	}
+}
+
+func TestSchedulerLastError(t *testing.T) {
+	var fail atomic.Value
+	fail.Store(true)
+	events := make(chan struct{}, 1)
+	s := runScheduler(t, Job{
+		Name:     "events",
+		Interval: time.Hour,
+		Events:   events,
+		Run: func(context.Context) error {
+			if fail.Load().(bool) {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	})
+	// run at once on a signal, not an hour later.
+	events <- struct{}{}
+	failed := waitStatus(t, s, func(st JobStatus) bool { return st.Runs == 1 })
+	if failed.Failures != 1 || failed.LastError != "rejected" {
+		t.Errorf("status %+v", failed)
+	}
+
+	// the last error is kept after a success, with its time.
+	fail.Store(false)
+	events <- struct{}{}
+	st := waitStatus(t, s, func(st JobStatus) bool { return st.Runs == 2 })
+	if st.Failures != 0 || !st.Healthy || st.LastError != "rejected" || !st.LastErrorAt.Equal(failed.LastErrorAt) {
+		t.Errorf("status %+v", st)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	var res SchedulerStatus
+	err := json.Unmarshal(rec.Body.Bytes(), &res)
+	if rec.Code != http.StatusOK || err != nil || !res.Healthy || len(res.Jobs) != 1 || res.Jobs[0].LastError != "rejected" {
+		t.Errorf("/jobs %d %s %v", rec.Code, rec.Body, err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package lib (state.go) persists the state of an actor across restarts.
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// LoadState reads the JSON state in path into v. A missing file leaves v as is.
+func LoadState(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("state file [%s]: %v", path, err)
+	}
+	return nil
+}
+
+// SaveState writes v to path as JSON, replacing the file at once.
+func SaveState(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtOnce(path, data, 0600)
+}
+
+// WriteFileAtOnce writes data to a temporary file renamed to path, so readers never see it partly written.
+// The temporary name is per process, as the actors of the demo may write the same file.
+func WriteFileAtOnce(path string, data []byte, perm os.FileMode) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	err := ioutil.WriteFile(tmp, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (tls.go) configures TLS and mutual TLS of the servers and the clients.
+
+usage:
+
+	var tlsConf lib.TLSConfig
+	conf.GetInterface("tls", &tlsConf)
+	err := tlsConf.Load("charlie") // uses the demo certificates if democa is set
+	serverConf, err := tlsConf.ServerConfig() // nil if not serving HTTPS
+	_, err = lc.ListenTLS(":8020", handler, serverConf)
+
+With "democa": "democa", an actor uses the certificate of its name (localhost and 127.0.0.1) in the directory democa,
+issued with the CA by IssueDemoCerts before the actors start. (see the democa command run by start_demo.sh)
+The key of the CA is dropped once the certificates are signed, so no actor can issue a certificate of another name.
+The certificate of an actor is both for serving and for authenticating as a client. (see AuthConfig.ClientCerts)
+*/
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSConfig is the "tls" setting of an actor in democonf.json.
+type TLSConfig struct {
+	Serve      bool   `json:"serve"`      // serves HTTPS instead of HTTP
+	Cert       string `json:"cert"`       // PEM certificate file of the actor
+	Key        string `json:"key"`        // PEM private key file of the actor
+	CA         string `json:"ca"`         // PEM CA file verifying the servers called and the client certificates
+	ClientAuth string `json:"clientauth"` // "" (none), "request" (verified if given) or "require"
+	DemoCA     string `json:"democa"`     // directory of the demo certificates (see IssueDemoCerts)
+}
+
+// Lifetimes of the demo certificates.
+const (
+	demoCALifetime   = 10 * 365 * 24 * time.Hour
+	demoCertLifetime = 365 * 24 * time.Hour
+)
+
+// Load uses the demo certificate of the actor name and the demo CA if DemoCA is set,
+// where Cert, Key and CA are not set.
+func (c *TLSConfig) Load(name string) error {
+	if c.DemoCA == "" {
+		return nil
+	}
+	certFile, keyFile := DemoCertFiles(c.DemoCA, name)
+	if c.Cert == "" && c.Key == "" {
+		c.Cert, c.Key = certFile, keyFile
+	}
+	if c.CA == "" {
+		c.CA = filepath.Join(c.DemoCA, "ca.pem")
+	}
+	for _, file := range []string{c.Cert, c.Key, c.CA} {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("demo certificates of %s are not issued (see start_demo.sh): %v", name, err)
+		}
+	}
+	return nil
+}
+
+// DemoCertFiles returns the certificate and key files of name in the directory of the demo certificates.
+func DemoCertFiles(dir string, name string) (string, string) {
+	return filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+}
+
+// IssueDemoCerts creates a CA and a certificate of each name signed by it in dir, replacing the previous ones.
+// Only ca.pem and the certificates with their keys are written, the key of the CA is not kept.
+func IssueDemoCerts(dir string, names ...string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	ca, caKey, err := newCA()
+	if err != nil {
+		return fmt.Errorf("demo CA: %v", err)
+	}
+	for _, name := range names {
+		certFile, keyFile := DemoCertFiles(dir, name)
+		err = createCert(name, ca, caKey, certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("certificate of %s: %v", name, err)
+		}
+	}
+	return WriteFileAtOnce(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)
+}
+
+// ServerConfig returns the TLS config of the servers, nil if not serving HTTPS.
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if !c.Serve {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("tls certificate: %v", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch c.ClientAuth {
+	case "":
+		return conf, nil
+	case "request":
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("tls clientauth must be request or require: %s", c.ClientAuth)
+	}
+	conf.ClientCAs, err = loadCertPool(c.CA)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// ClientConfig returns the TLS config of the clients, verifying the servers by CA (the system roots if empty)
+// and presenting the certificate of the actor if any.
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CA != "" {
+		pool, err := loadCertPool(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, fmt.Errorf("tls ca is not set")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in [%s]", file)
+	}
+	return pool, nil
+}
+
+// newCA returns a CA certificate and its key, generated in memory.
+func newCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DG Lab demo CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(demoCALifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func createCert(name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(demoCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost", name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = WriteFileAtOnce(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtOnce(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
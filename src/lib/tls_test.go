@@ -0,0 +1,128 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIssueDemoCerts(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "democa")
+	err := IssueDemoCerts(dir, "alice", "charlie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no key of the CA to issue another certificate.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, info := range infos {
+		files = append(files, info.Name())
+	}
+	sort.Strings(files)
+	if got := strings.Join(files, " "); got != "alice-key.pem alice.pem ca.pem charlie-key.pem charlie.pem" {
+		t.Errorf("files %s", got)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil || strings.Contains(string(data), "PRIVATE KEY") {
+		t.Errorf("ca.pem %v\n%s", err, data)
+	}
+
+	// charlie serves with its certificate and verifies alice's.
+	charlie := TLSConfig{Serve: true, ClientAuth: "require", DemoCA: dir}
+	alice := TLSConfig{DemoCA: dir}
+	if err = charlie.Load("charlie"); err == nil {
+		err = alice.Load("alice")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConf, err := charlie.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf, err := alice.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = serverConf
+	server.StartTLS()
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "alice" {
+		t.Errorf("client %q, want alice", body)
+	}
+
+	// the certificates of a previous CA are not trusted.
+	other := filepath.Join(t.TempDir(), "democa")
+	err = IssueDemoCerts(other, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger := TLSConfig{DemoCA: other}
+	stranger.Load("alice")
+	strangerConf, err := stranger.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerConf.RootCAs = clientConf.RootCAs
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: strangerConf}}
+	if res, err = client.Get(server.URL); err == nil {
+		res.Body.Close()
+		t.Error("a certificate of another CA accepted")
+	}
+
+	// an actor without its certificate does not start.
+	if err = (&TLSConfig{DemoCA: dir}).Load("mallory"); err == nil {
+		t.Error("loaded without a certificate")
+	}
+	if err = (&TLSConfig{}).Load("mallory"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServerConfigClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	err := IssueDemoCerts(dir, "charlie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for auth, want := range map[string]tls.ClientAuthType{"": tls.NoClientCert, "request": tls.VerifyClientCertIfGiven, "require": tls.RequireAndVerifyClientCert} {
+		c := TLSConfig{Serve: true, ClientAuth: auth, DemoCA: dir}
+		if err = c.Load("charlie"); err != nil {
+			t.Fatal(err)
+		}
+		conf, err := c.ServerConfig()
+		if err != nil || conf.ClientAuth != want {
+			t.Errorf("%q: %v %v", auth, conf, err)
+		}
+	}
+	c := TLSConfig{Serve: true, ClientAuth: "any", DemoCA: dir}
+	c.Load("charlie")
+	if _, err = c.ServerConfig(); err == nil {
+		t.Error("clientauth any accepted")
+	}
+	if conf, err := (TLSConfig{}).ServerConfig(); conf != nil || err != nil {
+		t.Errorf("not serving: %v %v", conf, err)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package lib (validate.go) validates the requests by the rules of the field tags.
+
+usage:
+
+	type Request struct {
+		ID    string            `json:"id" validate:"required,hex"`
+		Addr  string            `json:"addr" validate:"required,address"`
+		Count int64             `json:"count" validate:"min=1,max=10"`
+		Pay   map[string]string `json:"pay" validate:"required,asset"`
+	}
+
+rules:
+
+	required  not the zero value, or not empty for a string, slice or map
+	min=N     at least N for a number, at least N long for a string, slice or map
+	max=N     at most N, as min
+	hex       a hex string
+	asset     an asset label (e.g. MELON) or a 64 characters hex asset id
+	address   a (confidential) base58 address of the demo chain, or a bech32 address
+
+hex, asset and address apply to the elements of a slice and the keys of a map.
+The rules except required are not checked for an empty value.
+*/
+package lib
+
+import (
+	"elementstx"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a bad field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the bad fields of a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error returns the message of ValidationError.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid request: " + strings.Join(msgs, ", ")
+}
+
+var assetLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,32}$`)
+
+// Validate checks the fields of req, a struct or a pointer to a struct, by their validate tags.
+// It returns *ValidationError listing each bad field.
+func Validate(req interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("request must be a struct: %s", v.Kind())
+	}
+	var errs []FieldError
+	validateStruct(v, "", &errs)
+	if len(errs) != 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// validateRequest validates req decoded with the field errors decoded, and lists both.
+func validateRequest(req interface{}, decoded *ValidationError) error {
+	var fields []FieldError
+	bad := make(map[string]bool)
+	if decoded != nil {
+		fields = append(fields, decoded.Fields...)
+		for _, f := range decoded.Fields {
+			bad[f.Field] = true
+		}
+	}
+	err := Validate(req)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		for _, f := range verr.Fields {
+			if !bad[f.Field] {
+				fields = append(fields, f)
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if len(fields) != 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, parent string, errs *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		field := joinField(parent, fieldName(sf))
+		fv := v.Field(i)
+		if tag := sf.Tag.Get("validate"); tag != "" {
+			msg := validateValue(fv, tag)
+			if msg != "" {
+				*errs = append(*errs, FieldError{Field: field, Message: msg})
+				continue
+			}
+		}
+		validateNested(fv, field, errs)
+	}
+}
+
+func validateNested(v reflect.Value, field string, errs *[]FieldError) {
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStruct(v, field, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateNested(v.Index(i), fmt.Sprintf("%s[%d]", field, i), errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			validateNested(iter.Value(), joinField(field, fmt.Sprint(iter.Key())), errs)
+		}
+	}
+}
+
+// validateValue returns the message of the first broken rule, empty if none.
+func validateValue(v reflect.Value, tag string) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if hasRule(tag, "required") {
+				return "required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.IsZero() || (isContainer(v) && v.Len() == 0) {
+		if hasRule(tag, "required") {
+			return "required"
+		}
+		return ""
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg := rule, ""
+		if n := strings.Index(rule, "="); 0 <= n {
+			name, arg = rule[:n], rule[n+1:]
+		}
+		var msg string
+		switch name {
+		case "required", "":
+		case "min", "max":
+			msg = checkBound(v, name, arg)
+		case "hex":
+			msg = checkStrings(v, "hex", isHex)
+		case "asset":
+			msg = checkStrings(v, "asset label", isAsset)
+		case "address":
+			msg = checkStrings(v, "address", isAddress)
+		default:
+			msg = "unknown rule " + name
+		}
+		if msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func hasRule(tag string, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func isContainer(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+func checkBound(v reflect.Value, name string, arg string) string {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return fmt.Sprintf("invalid rule %s=%s", name, arg)
+	}
+	var x float64
+	what := "value"
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		x = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		x = float64(v.Len())
+		what = "length"
+	default:
+		return fmt.Sprintf("rule %s is not for %s", name, v.Type())
+	}
+	if name == "min" && x < bound {
+		return fmt.Sprintf("%s must be at least %s", what, arg)
+	}
+	if name == "max" && bound < x {
+		return fmt.Sprintf("%s must be at most %s", what, arg)
+	}
+	return ""
+}
+
+// checkStrings checks a string, the elements of a slice or the keys of a map.
+func checkStrings(v reflect.Value, what string, check func(string) bool) string {
+	var values []string
+	switch v.Kind() {
+	case reflect.String:
+		values = []string{v.String()}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			e := reflect.Indirect(v.Index(i))
+			if e.Kind() != reflect.String {
+				return fmt.Sprintf("rule %s is not for %s", what, v.Type())
+			}
+			values = append(values, e.String())
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Sprintf("rule %s is not for %s", what, v.Type())
+		}
+		for _, k := range v.MapKeys() {
+			values = append(values, k.String())
+		}
+	default:
+		return fmt.Sprintf("rule %s is not for %s", what, v.Type())
+	}
+	for _, s := range values {
+		if !check(s) {
+			return fmt.Sprintf("invalid %s [%s]", what, s)
+		}
+	}
+	return ""
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func isAsset(s string) bool {
+	if len(s) == 64 {
+		return isHex(s)
+	}
+	return assetLabelPattern.MatchString(s)
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// isAddress checks a base58 address of the demo chain (see elementstx.DecodeAddress) or the encoding of a bech32 address.
+func isAddress(s string) bool {
+	if isBech32(s) {
+		return true
+	}
+	_, err := elementstx.DecodeAddress(s, elementstx.RegtestParams)
+	return err == nil
+}
+
+// isBech32 checks the charset of a bech32 (or blech32) address, not its checksum.
+func isBech32(s string) bool {
+	if s != strings.ToLower(s) && s != strings.ToUpper(s) {
+		return false
+	}
+	s = strings.ToLower(s)
+	n := strings.LastIndex(s, "1")
+	return 0 < n && n+7 <= len(s) && strings.Trim(s[n+1:], bech32Charset) == ""
+}
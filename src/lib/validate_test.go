@@ -0,0 +1,101 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type validatedItem struct {
+	Asset  string `json:"asset" validate:"required,asset"`
+	Amount int64  `json:"amount" validate:"min=1"`
+}
+
+type validatedRequest struct {
+	ID    string            `json:"id" validate:"required,hex"`
+	Addr  string            `json:"addr" validate:"address"`
+	Count int64             `json:"count" validate:"min=1,max=10"`
+	Name  string            `json:"name" validate:"max=4"`
+	Pay   map[string]string `json:"pay" validate:"asset"`
+	Items []validatedItem   `json:"items" validate:"max=2"`
+	Note  *string           `json:"note" validate:"required"`
+}
+
+func fieldMessages(err error) map[string]string {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, f := range verr.Fields {
+		m[f.Field] = f.Message
+	}
+	return m
+}
+
+func TestValidateAddress(t *testing.T) {
+	note := "n"
+	for addr, ok := range map[string]bool{
+		"2dcYEFfcwgRHpN3rJZBTCtUw7iwnGTVdnru":                                              true,
+		"XETiiHvr5Z9dWhYSe4ADJRPF2EZLgBtbaw":                                               true,
+		"CTEnytPypSwkVb1CE3wn4qVFmo674KWFneuNuETAVYSALbLXyE77c31699VNKC2swMUCwmmuh6zsmXcR": true,
+		"ert1qw508d6qejxtdg4y5r3zarvary0c5xw7kxw508d":                                      true,
+		"2dcYEFfcwgRHpN3rJZBTCtUw7iwnGTVdnrv":                                              false, // checksum
+		"1BoatSLRHtKNngkdXEeobR76b53LETtpyT":                                               false, // another chain
+		"2dcYEFfcwgRHpN3rJZBTCtUw7iwnGTVdnr0":                                              false,
+		"ert1qW508d6qejxtdg4y5r3zarvary0c5xw7kxw508d":                                      false, // mixed case
+	} {
+		err := Validate(validatedRequest{ID: "ab", Addr: addr, Count: 1, Note: &note})
+		if (err == nil) != ok {
+			t.Errorf("%s: %v", addr, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	note := "n"
+	valid := validatedRequest{
+		ID:    "0a1b",
+		Count: 10,
+		Name:  "abcd",
+		Pay:   map[string]string{"MELON": "1"},
+		Items: []validatedItem{{Asset: "AIRSKY", Amount: 1}},
+		Note:  &note,
+	}
+	if err := Validate(&valid); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Validate(validatedRequest{
+		ID:    "xyz",
+		Count: 11,
+		Name:  "abcde",
+		Pay:   map[string]string{"MEL ON": "1"},
+		Items: []validatedItem{{Asset: "AIRSKY", Amount: -1}, {Amount: 1}},
+	})
+	want := map[string]string{
+		"id":              "invalid hex [xyz]",
+		"count":           "value must be at most 10",
+		"name":            "length must be at most 4",
+		"pay":             "invalid asset label [MEL ON]",
+		"items[0].amount": "value must be at least 1",
+		"items[1].asset":  "required",
+		"note":            "required",
+	}
+	if got := fieldMessages(err); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields %v\nwant %v", got, want)
+	}
+
+	if err = Validate(struct {
+		N int `validate:"even"`
+	}{1}); err == nil {
+		t.Error("unknown rule accepted")
+	}
+	if err = Validate(1); err == nil {
+		t.Error("not a struct validated")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package notify delivers chain notifications of elementsd to the actors,
+so they react to new blocks and transactions instead of polling.
+
+Notifications come from the ZMQ publisher of the node (-zmqpubhashblock,
+-zmqpubhashtx, ...), spoken natively without libzmq, or from an HTTP endpoint
+fed by the -blocknotify and -walletnotify hooks:
+
+	blocknotify=curl -s http://127.0.0.1:8011/notify/block/%s
+	walletnotify=curl -s http://127.0.0.1:8011/notify/tx/%s
+
+usage:
+
+	hub := notify.NewHub()
+	err := hub.Start(ctx, notify.Sources{ZMQ: "tcp://127.0.0.1:28332", HTTP: ":8011"})
+	sub := hub.Subscribe(notify.TopicHashBlock)
+	defer sub.Close()
+	for ev := range sub.C {
+		fmt.Println("new block", ev.Hash())
+	}
+
+An actor runs its job on the signals of Subscribe, nil without sources, and polls every
+src.PollInterval(pollinterval) as a fallback:
+
+	events, err := notify.Subscribe(ctx, src, notify.TopicHashTx)
+
+FakePublisher publishes notifications like a node, for tests without elementsd.
+*/
+package notify
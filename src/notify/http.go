@@ -0,0 +1,47 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package notify HTTP endpoint for blocknotify and walletnotify
+package notify
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Handler returns the endpoint the -blocknotify and -walletnotify hooks call with curl.
+// The path ends with /block/<blockhash> (hashblock) or /tx/<txid> (hashtx), so it can be mounted anywhere.
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(segments) < 2 {
+			http.NotFound(w, r)
+			return
+		}
+		kind, hash := segments[len(segments)-2], segments[len(segments)-1]
+
+		var topic string
+		switch kind {
+		case "block":
+			topic = TopicHashBlock
+		case "tx":
+			topic = TopicHashTx
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		body, err := hex.DecodeString(hash)
+		if err != nil || len(body) != 32 {
+			http.Error(w, "invalid hash: "+hash, http.StatusBadRequest)
+			return
+		}
+		h.Publish(Event{Topic: topic, Body: body, Source: "http"})
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	hub := NewHub()
+	blocks := hub.Subscribe(TopicHashBlock)
+	txs := hub.Subscribe(TopicHashTx)
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	for _, c := range []struct {
+		method string
+		path   string
+		status int
+		topic  string
+	}{
+		{"GET", "/notify/block/" + testHash, http.StatusNoContent, TopicHashBlock},
+		{"POST", "/mounted/elsewhere/tx/" + testHash, http.StatusNoContent, TopicHashTx},
+		{"GET", "/notify/rawtx/" + testHash, http.StatusNotFound, ""},
+		{"GET", "/" + testHash, http.StatusNotFound, ""},
+		{"GET", "/notify/block/" + testHash[2:], http.StatusBadRequest, ""},
+		{"GET", "/notify/block/nothex", http.StatusBadRequest, ""},
+		{"PUT", "/notify/block/" + testHash, http.StatusMethodNotAllowed, ""},
+	} {
+		req, err := http.NewRequest(c.method, server.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Errorf("%s %s: status %d, want %d", c.method, c.path, res.StatusCode, c.status)
+		}
+
+		var got []Event
+		for _, sub := range []*Subscription{blocks, txs} {
+			select {
+			case ev := <-sub.C:
+				got = append(got, ev)
+			default:
+			}
+		}
+		if c.topic == "" {
+			if len(got) != 0 {
+				t.Errorf("%s %s: published %+v", c.method, c.path, got)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].Topic != c.topic || got[0].Hash() != testHash || got[0].Source != "http" {
+			t.Errorf("%s %s: published %+v, want one %s", c.method, c.path, got, c.topic)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package notify Hub and subscriptions
+package notify
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Topics of the node notifications. (the ZMQ topic names)
+const (
+	TopicHashBlock = "hashblock"
+	TopicHashTx    = "hashtx"
+	TopicRawBlock  = "rawblock"
+	TopicRawTx     = "rawtx"
+)
+
+// subscriptionBuffer is the number of events a slow subscriber may lag behind.
+const subscriptionBuffer = 16
+
+// Intervals of polling the node: without notifications, and as a fallback with them.
+const (
+	DefaultPollInterval  = 3 * time.Second
+	FallbackPollInterval = 30 * time.Second
+)
+
+var logger = log.New(ioutil.Discard, "", 0)
+
+// SetLogger sets logger.
+func SetLogger(loggerIn *log.Logger) {
+	logger = loggerIn
+}
+
+// Event is a notification of the node.
+type Event struct {
+	Topic  string
+	Body   []byte // hash in RPC byte order for hash topics, serialized block or transaction for raw topics
+	Seq    uint32 // sequence number of the topic (ZMQ only)
+	Source string // "zmq" or "http"
+}
+
+// Hash returns the block hash or the txid of a hash topic.
+func (e Event) Hash() string {
+	if e.Topic != TopicHashBlock && e.Topic != TopicHashTx {
+		return ""
+	}
+	return hex.EncodeToString(e.Body)
+}
+
+// Hex returns the body in hex, the raw block or transaction of a raw topic.
+func (e Event) Hex() string {
+	return hex.EncodeToString(e.Body)
+}
+
+// Hub delivers the events of the sources to the subscribers.
+type Hub struct {
+	mu      sync.Mutex
+	subs    map[*Subscription]bool
+	dropped int64
+}
+
+// NewHub returns new Hub.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]bool)}
+}
+
+// Subscription receives the events of its topics on C.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	topics map[string]bool
+	hub    *Hub
+}
+
+// Subscribe returns a subscription to topics, all topics if none.
+// Events are dropped for a subscriber lagging more than a few events behind,
+// so a subscriber should query the node state rather than count events.
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, topics: make(map[string]bool), hub: h}
+	for _, t := range topics {
+		s.topics[t] = true
+	}
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if s.hub.subs[s] {
+		delete(s.hub.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish delivers ev to the subscribers of its topic.
+func (h *Hub) Publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if len(s.topics) != 0 && !s.topics[ev.Topic] {
+			continue
+		}
+		select {
+		case s.c <- ev:
+		default:
+			h.dropped++
+		}
+	}
+}
+
+// Dropped returns the number of events dropped for lagging subscribers.
+func (h *Hub) Dropped() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dropped
+}
+
+// Sources are the notification sources of an actor. Empty ones are not used.
+type Sources struct {
+	ZMQ  string // ZMQ endpoint of the node (e.g. "tcp://127.0.0.1:28332")
+	HTTP string // listen address of the blocknotify/walletnotify endpoint (e.g. ":8011")
+}
+
+// Enabled reports whether any source is set.
+func (src Sources) Enabled() bool {
+	return src.ZMQ != "" || src.HTTP != ""
+}
+
+// PollInterval returns the interval of polling the node, seconds if set (the pollinterval of the actor)
+// or else FallbackPollInterval with notifications and DefaultPollInterval without.
+func (src Sources) PollInterval(seconds float64) time.Duration {
+	switch {
+	case 0 < seconds:
+		return time.Duration(seconds * float64(time.Second))
+	case src.Enabled():
+		return FallbackPollInterval
+	default:
+		return DefaultPollInterval
+	}
+}
+
+// Start feeds the hub from the sources until ctx is done.
+// An invalid ZMQ endpoint or a listen error of HTTP is returned, the ZMQ subscription then
+// reconnects in the background.
+func (h *Hub) Start(ctx context.Context, src Sources) error {
+	if src.ZMQ != "" {
+		_, err := zmtpEndpoint(src.ZMQ)
+		if err != nil {
+			return err
+		}
+	}
+	if src.HTTP != "" {
+		listener, err := net.Listen("tcp", src.HTTP)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Handler: h.Handler()}
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+		go func() {
+			logger.Println("notify: listening", listener.Addr())
+			err := server.Serve(listener)
+			if err != nil && err != http.ErrServerClosed {
+				logger.Println("error:", err)
+			}
+		}()
+	}
+	if src.ZMQ != "" {
+		go h.SubscribeZMQ(ctx, src.ZMQ, TopicHashBlock, TopicHashTx)
+	}
+	return nil
+}
+
+// Subscribe starts a hub fed by src until ctx is done and returns a signal for the events of the topics,
+// the Events of a lib.Job. Signals not yet received are merged. It returns nil when no source is set,
+// so an actor polls only.
+func Subscribe(ctx context.Context, src Sources, topics ...string) (<-chan struct{}, error) {
+	if !src.Enabled() {
+		return nil, nil
+	}
+	hub := NewHub()
+	sub := hub.Subscribe(topics...)
+	err := hub.Start(ctx, src)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	signal := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+	go func() {
+		defer close(signal)
+		for ev := range sub.C {
+			logger.Println("notify:", ev.Topic, ev.Hash(), ev.Source)
+			select {
+			case signal <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return signal, nil
+}
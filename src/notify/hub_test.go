@@ -0,0 +1,74 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPollInterval(t *testing.T) {
+	for _, c := range []struct {
+		src     Sources
+		seconds float64
+		want    time.Duration
+	}{
+		{Sources{}, 0, DefaultPollInterval},
+		{Sources{ZMQ: "tcp://127.0.0.1:28040"}, 0, FallbackPollInterval},
+		{Sources{HTTP: ":8011"}, 0, FallbackPollInterval},
+		{Sources{ZMQ: "tcp://127.0.0.1:28040"}, 10, 10 * time.Second},
+		{Sources{}, 0.5, 500 * time.Millisecond},
+	} {
+		if got := c.src.PollInterval(c.seconds); got != c.want {
+			t.Errorf("%+v %v: %s, want %s", c.src, c.seconds, got, c.want)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := Subscribe(ctx, Sources{})
+	if events != nil || err != nil {
+		t.Errorf("no source: %v %v, want polling only", events, err)
+	}
+	_, err = Subscribe(ctx, Sources{ZMQ: "ipc:///tmp/elementsd"})
+	if err == nil {
+		t.Error("ipc endpoint subscribed")
+	}
+
+	pub, err := NewFakePublisher("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	events, err = Subscribe(ctx, Sources{ZMQ: pub.Endpoint()}, TopicHashTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.WaitSubscribers(1)
+	err = pub.PublishHash(TopicHashTx, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no signal")
+	}
+
+	// closed with the hub.
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("signal after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not closed")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package notify Fake ZMQ publisher
+package notify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sync"
+)
+
+// FakePublisher is a ZMQ publisher sending notifications like elementsd, for tests.
+//
+//	pub, err := notify.NewFakePublisher("127.0.0.1:0")
+//	defer pub.Close()
+//	go hub.SubscribeZMQ(ctx, pub.Endpoint(), notify.TopicHashBlock)
+//	pub.WaitSubscribers(1)
+//	pub.PublishHash(notify.TopicHashBlock, blockhash)
+type FakePublisher struct {
+	listener net.Listener
+	mu       sync.Mutex
+	cond     *sync.Cond
+	peers    map[*fakePeer]bool
+	seqs     map[string]uint32
+	closed   bool
+}
+
+type fakePeer struct {
+	conn   *zmtpConn
+	topics [][]byte
+}
+
+// NewFakePublisher listens on addr (e.g. "127.0.0.1:0") and accepts subscribers.
+func NewFakePublisher(addr string) (*FakePublisher, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &FakePublisher{
+		listener: listener,
+		peers:    make(map[*fakePeer]bool),
+		seqs:     make(map[string]uint32),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.accept()
+	return p, nil
+}
+
+// Endpoint returns the ZMQ endpoint of the publisher.
+func (p *FakePublisher) Endpoint() string {
+	return "tcp://" + p.listener.Addr().String()
+}
+
+func (p *FakePublisher) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(conn)
+	}
+}
+
+func (p *FakePublisher) serve(conn net.Conn) {
+	c, err := newZMTPConn(conn, "PUB")
+	if err != nil {
+		logger.Println("notify: fake publisher error:", err)
+		conn.Close()
+		return
+	}
+	peer := &fakePeer{conn: c}
+	defer func() {
+		p.mu.Lock()
+		delete(p.peers, peer)
+		p.mu.Unlock()
+		c.Close()
+	}()
+	for {
+		parts, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		if len(parts) != 1 || len(parts[0]) == 0 {
+			continue
+		}
+		topic := parts[0][1:]
+		p.mu.Lock()
+		switch parts[0][0] {
+		case subscribeByte:
+			peer.topics = append(peer.topics, topic)
+			p.peers[peer] = true
+		case unsubscribeByte:
+			for i, t := range peer.topics {
+				if bytes.Equal(t, topic) {
+					peer.topics = append(peer.topics[:i], peer.topics[i+1:]...)
+					break
+				}
+			}
+		}
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+}
+
+// WaitSubscribers waits until n subscribers have subscribed, since messages
+// published before a subscription are lost as with a real publisher.
+func (p *FakePublisher) WaitSubscribers(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.peers) < n && !p.closed {
+		p.cond.Wait()
+	}
+}
+
+// Publish sends body on topic to the subscribers of a matching prefix.
+func (p *FakePublisher) Publish(topic string, body []byte) {
+	p.mu.Lock()
+	seq := p.seqs[topic]
+	p.seqs[topic] = seq + 1
+	var peers []*fakePeer
+	for peer := range p.peers {
+		for _, t := range peer.topics {
+			if bytes.HasPrefix([]byte(topic), t) {
+				peers = append(peers, peer)
+				break
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	var seqBytes [4]byte
+	binary.LittleEndian.PutUint32(seqBytes[:], seq)
+	for _, peer := range peers {
+		err := peer.conn.writeMessage([]byte(topic), body, seqBytes[:])
+		if err != nil {
+			logger.Println("notify: fake publisher error:", err)
+		}
+	}
+}
+
+// PublishHash sends a block hash or a txid on a hash topic.
+func (p *FakePublisher) PublishHash(topic string, hash string) error {
+	body, err := hex.DecodeString(hash)
+	if err != nil {
+		return err
+	}
+	p.Publish(topic, body)
+	return nil
+}
+
+// Close stops the publisher and disconnects the subscribers.
+func (p *FakePublisher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for peer := range p.peers {
+		peer.conn.Close()
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	return p.listener.Close()
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package notify ZMQ subscriber
+package notify
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	zmqMinBackoff = time.Second
+	zmqMaxBackoff = 30 * time.Second
+)
+
+// SubscribeZMQ publishes the topics of the ZMQ publisher of the node at endpoint
+// (e.g. "tcp://127.0.0.1:28332") to the hub, reconnecting until ctx is done.
+func (h *Hub) SubscribeZMQ(ctx context.Context, endpoint string, topics ...string) error {
+	addr, err := zmtpEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	backoff := zmqMinBackoff
+	for {
+		connected, err := h.receiveZMQ(ctx, addr, topics)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			backoff = zmqMinBackoff
+		}
+		logger.Println("notify: zmq", endpoint, "error:", err, "retry in", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if zmqMaxBackoff < backoff {
+			backoff = zmqMaxBackoff
+		}
+	}
+}
+
+// receiveZMQ receives the topics of one connection, it reports whether the handshake was done.
+func (h *Hub) receiveZMQ(ctx context.Context, addr string, topics []string) (bool, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := newZMTPConn(conn, "SUB")
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	defer c.Close()
+	for _, t := range topics {
+		err = c.writeMessage(append([]byte{subscribeByte}, t...))
+		if err != nil {
+			return true, err
+		}
+	}
+	logger.Println("notify: zmq subscribed", addr, topics)
+
+	seqs := make(map[string]uint32)
+	for {
+		parts, err := c.readMessage()
+		if err != nil {
+			return true, err
+		}
+		// topic, body, sequence number (little endian)
+		if len(parts) < 2 {
+			continue
+		}
+		ev := Event{Topic: string(parts[0]), Body: parts[1], Source: "zmq"}
+		if 2 < len(parts) && len(parts[2]) == 4 {
+			ev.Seq = binary.LittleEndian.Uint32(parts[2])
+			if last, ok := seqs[ev.Topic]; ok && ev.Seq != last+1 {
+				logger.Println("notify: zmq", fmt.Sprintf("%s missed %d notifications", ev.Topic, ev.Seq-last-1))
+			}
+			seqs[ev.Topic] = ev.Seq
+		}
+		h.Publish(ev)
+	}
+}
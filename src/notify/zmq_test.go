@@ -0,0 +1,136 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testHash = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+
+// logBuffer collects the log lines of the package.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	saved := logger
+	SetLogger(log.New(b, "", 0))
+	t.Cleanup(func() { SetLogger(saved) })
+	return b
+}
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case ev := <-sub.C:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+// startZMQ subscribes a new hub to the topics of a new publisher.
+func startZMQ(t *testing.T, topics ...string) (*FakePublisher, *Subscription) {
+	pub, err := NewFakePublisher("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pub.Close() })
+	hub := NewHub()
+	sub := hub.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go hub.SubscribeZMQ(ctx, pub.Endpoint(), topics...)
+	pub.WaitSubscribers(1)
+	return pub, sub
+}
+
+func TestSubscribeZMQ(t *testing.T) {
+	pub, sub := startZMQ(t, TopicHashBlock)
+
+	pub.mu.Lock()
+	for peer := range pub.peers {
+		if len(peer.topics) != 1 || string(peer.topics[0]) != TopicHashBlock {
+			t.Errorf("subscribed to %q, want %s", peer.topics, TopicHashBlock)
+		}
+	}
+	pub.mu.Unlock()
+
+	// not subscribed, so not sent.
+	err := pub.PublishHash(TopicHashTx, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pub.PublishHash(TopicHashBlock, testHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := receive(t, sub)
+	if ev.Topic != TopicHashBlock || ev.Hash() != testHash || ev.Seq != 0 || ev.Source != "zmq" {
+		t.Errorf("event %+v", ev)
+	}
+}
+
+func TestSubscribeZMQEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"ipc:///tmp/elementsd", "tcp://", "tcp://127.0.0.1"} {
+		err := NewHub().SubscribeZMQ(context.Background(), endpoint)
+		if err == nil {
+			t.Errorf("%s accepted", endpoint)
+		}
+		// reported at once by Start, not left to the background subscription.
+		err = NewHub().Start(context.Background(), Sources{ZMQ: endpoint})
+		if err == nil {
+			t.Errorf("%s started", endpoint)
+		}
+	}
+}
+
+func TestZMQSequenceGap(t *testing.T) {
+	logs := captureLog(t)
+	pub, sub := startZMQ(t, TopicHashBlock)
+
+	pub.PublishHash(TopicHashBlock, testHash)
+	receive(t, sub)
+	// two notifications lost on the way.
+	pub.mu.Lock()
+	pub.seqs[TopicHashBlock] += 2
+	pub.mu.Unlock()
+	pub.PublishHash(TopicHashBlock, testHash)
+	ev := receive(t, sub)
+	if ev.Seq != 3 {
+		t.Errorf("seq %d, want 3", ev.Seq)
+	}
+	if !strings.Contains(logs.String(), "hashblock missed 2 notifications") {
+		t.Errorf("gap not logged: %s", logs)
+	}
+
+	pub.PublishHash(TopicHashBlock, testHash)
+	receive(t, sub)
+	if strings.Count(logs.String(), "missed") != 1 {
+		t.Errorf("gap logged without a gap: %s", logs)
+	}
+}
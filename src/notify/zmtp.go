@@ -0,0 +1,231 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package notify ZMTP 3.0 framing (the wire protocol of ZMQ) with the NULL mechanism
+package notify
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	greetingSize     = 64
+	handshakeTimeout = 10 * time.Second
+	maxFrameSize     = 64 * 1024 * 1024
+
+	flagMore    = byte(1)
+	flagLong    = byte(2)
+	flagCommand = byte(4)
+
+	// first byte of a ZMTP 3.0 subscription message.
+	subscribeByte   = byte(1)
+	unsubscribeByte = byte(0)
+)
+
+// zmtpConn is a ZMTP connection after the handshake.
+type zmtpConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// zmtpEndpoint returns the host:port of a "tcp://host:port" endpoint.
+func zmtpEndpoint(endpoint string) (string, error) {
+	if !strings.HasPrefix(endpoint, "tcp://") {
+		return "", fmt.Errorf("unsupported zmq endpoint [%s]: only tcp:// is supported", endpoint)
+	}
+	addr := strings.TrimPrefix(endpoint, "tcp://")
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid zmq endpoint [%s]: %v", endpoint, err)
+	}
+	return addr, nil
+}
+
+// newZMTPConn does the handshake as socketType ("SUB" or "PUB").
+func newZMTPConn(conn net.Conn, socketType string) (*zmtpConn, error) {
+	c := &zmtpConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	greeting := make([]byte, greetingSize)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3 // version 3.0
+	greeting[11] = 0
+	copy(greeting[12:32], "NULL")
+	_, err := conn.Write(greeting)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := make([]byte, greetingSize)
+	_, err = io.ReadFull(c.r, peer)
+	if err != nil {
+		return nil, fmt.Errorf("zmtp greeting: %v", err)
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f || peer[10] < 3 {
+		return nil, fmt.Errorf("zmtp greeting: not a ZMTP 3 peer")
+	}
+	if mechanism := strings.TrimRight(string(peer[12:32]), "\x00"); mechanism != "NULL" {
+		return nil, fmt.Errorf("zmtp greeting: unsupported mechanism %s", mechanism)
+	}
+
+	err = c.writeCommand("READY", zmtpProperty("Socket-Type", socketType))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		flags, body, err := c.readFrame()
+		if err != nil {
+			return nil, fmt.Errorf("zmtp handshake: %v", err)
+		}
+		if flags&flagCommand == 0 {
+			return nil, fmt.Errorf("zmtp handshake: message before READY")
+		}
+		name, data := parseCommand(body)
+		switch name {
+		case "READY":
+			return c, nil
+		case "ERROR":
+			return nil, fmt.Errorf("zmtp handshake: peer error: %s", commandError(data))
+		}
+	}
+}
+
+func zmtpProperty(name string, value string) []byte {
+	p := []byte{byte(len(name))}
+	p = append(p, name...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(value)))
+	p = append(p, size[:]...)
+	return append(p, value...)
+}
+
+func parseCommand(body []byte) (string, []byte) {
+	if len(body) == 0 || len(body) < 1+int(body[0]) {
+		return "", nil
+	}
+	n := int(body[0])
+	return string(body[1 : 1+n]), body[1+n:]
+}
+
+func commandError(data []byte) string {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return ""
+	}
+	return string(data[1 : 1+int(data[0])])
+}
+
+func (c *zmtpConn) writeCommand(name string, data []byte) error {
+	body := append([]byte{byte(len(name))}, name...)
+	body = append(body, data...)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrame(flagCommand, body)
+}
+
+// writeMessage writes a multipart message.
+func (c *zmtpConn) writeMessage(parts ...[]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for i, p := range parts {
+		flags := byte(0)
+		if i < len(parts)-1 {
+			flags |= flagMore
+		}
+		err := c.writeFrame(flags, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *zmtpConn) writeFrame(flags byte, body []byte) error {
+	var header []byte
+	if len(body) <= 255 {
+		header = []byte{flags, byte(len(body))}
+	} else {
+		header = make([]byte, 9)
+		header[0] = flags | flagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	}
+	_, err := c.conn.Write(append(header, body...))
+	return err
+}
+
+func (c *zmtpConn) readFrame() (byte, []byte, error) {
+	flags, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&flagLong != 0 {
+		var b [8]byte
+		_, err = io.ReadFull(c.r, b[:])
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		var b byte
+		b, err = c.r.ReadByte()
+		size = uint64(b)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if maxFrameSize < size {
+		return 0, nil, fmt.Errorf("zmtp frame too large: %d", size)
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(c.r, body)
+	return flags, body, err
+}
+
+// readMessage reads the next multipart message, handling the commands in between.
+func (c *zmtpConn) readMessage() ([][]byte, error) {
+	var parts [][]byte
+	for {
+		flags, body, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&flagCommand != 0 {
+			name, data := parseCommand(body)
+			switch name {
+			case "ERROR":
+				return nil, fmt.Errorf("zmtp peer error: %s", commandError(data))
+			case "PING":
+				// ZMTP 3.1 heartbeat, answered with the context of the ping.
+				if 2 < len(data) {
+					c.writeCommand("PONG", data[2:])
+				} else {
+					c.writeCommand("PONG", nil)
+				}
+			case "SUBSCRIBE", "CANCEL":
+				// ZMTP 3.1 subscriptions, returned like the 3.0 messages.
+				b := unsubscribeByte
+				if name == "SUBSCRIBE" {
+					b = subscribeByte
+				}
+				return [][]byte{append([]byte{b}, data...)}, nil
+			}
+			continue
+		}
+		parts = append(parts, body)
+		if flags&flagMore == 0 {
+			return parts, nil
+		}
+	}
+}
+
+func (c *zmtpConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Fixed-point amount
+package rpc
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Amount is a satoshi denominated amount of any asset.
+// In JSON and forms it is written in coin units as the node does. (e.g. 1.5)
+type Amount int64
+
+// Coin is one coin unit in satoshi.
+const Coin = Amount(100000000)
+
+// CoinDecimals is the number of decimals of a coin unit.
+const CoinDecimals = 8
+
+var (
+	bigCoin       = big.NewInt(int64(Coin))
+	precisionMu   sync.RWMutex
+	precisionList = make(map[string]int)
+
+	// amountPattern is a plain decimal, without the fractions, exponents and bases big.Rat accepts.
+	amountPattern = regexp.MustCompile(`^[-+]?[0-9]+(?:\.([0-9]+))?$`)
+)
+
+// ParseAmount parses a decimal coin amount such as "1.5" or "200.00000000" exactly.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	m := amountPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid amount [%s]", s)
+	}
+	if len(m[1]) > CoinDecimals {
+		return 0, fmt.Errorf("amount has more than %d decimals [%s]", CoinDecimals, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount [%s]", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(bigCoin))
+	n := r.Num()
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("amount out of range [%s]", s)
+	}
+	return Amount(n.Int64()), nil
+}
+
+// AmountFromCoins returns the amount of n whole coins.
+func AmountFromCoins(n int64) (Amount, error) {
+	return Amount(n).Mul(int64(Coin))
+}
+
+// Add returns a+b or an error on overflow.
+func (a Amount) Add(b Amount) (Amount, error) {
+	c := a + b
+	if (b > 0 && c < a) || (b < 0 && c > a) {
+		return 0, fmt.Errorf("amount overflow: %s + %s", a, b)
+	}
+	return c, nil
+}
+
+// Sub returns a-b or an error on overflow.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	c := a - b
+	if (b > 0 && c > a) || (b < 0 && c < a) {
+		return 0, fmt.Errorf("amount overflow: %s - %s", a, b)
+	}
+	return c, nil
+}
+
+// Mul returns a*n or an error on overflow.
+func (a Amount) Mul(n int64) (Amount, error) {
+	if a == 0 || n == 0 {
+		return 0, nil
+	}
+	c := a * Amount(n)
+	if c/Amount(n) != a || (a == -1 && n == math.MinInt64) || (n == -1 && a == math.MinInt64) {
+		return 0, fmt.Errorf("amount overflow: %s * %d", a, n)
+	}
+	return c, nil
+}
+
+// MulDiv returns a*num/den rounded toward zero, without intermediate overflow.
+func (a Amount) MulDiv(num int64, den int64) (Amount, error) {
+	if den == 0 {
+		return 0, fmt.Errorf("amount division by zero")
+	}
+	r := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(num))
+	r.Quo(r, big.NewInt(den))
+	if !r.IsInt64() {
+		return 0, fmt.Errorf("amount overflow: %s * %d / %d", a, num, den)
+	}
+	return Amount(r.Int64()), nil
+}
+
+// Coins returns the whole coin part.
+func (a Amount) Coins() int64 {
+	return int64(a / Coin)
+}
+
+// Format returns the amount in coin units with the given number of decimals (0-8).
+// Digits beyond decimals are truncated.
+func (a Amount) Format(decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+	if decimals > CoinDecimals {
+		decimals = CoinDecimals
+	}
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign = "-"
+		u = uint64(-(a + 1)) + 1
+	}
+	whole := u / uint64(Coin)
+	frac := fmt.Sprintf("%08d", u%uint64(Coin))[:decimals]
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, whole, frac)
+}
+
+// String returns the amount in coin units without trailing zeros. (e.g. 1.5)
+func (a Amount) String() string {
+	s := a.Format(CoinDecimals)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// SetAssetPrecision sets the number of decimals shown for asset. (label or id)
+func SetAssetPrecision(asset string, decimals int) {
+	precisionMu.Lock()
+	defer precisionMu.Unlock()
+	precisionList[asset] = decimals
+}
+
+// FormatAsset returns the amount formatted with the precision of asset. (CoinDecimals if unset)
+func (a Amount) FormatAsset(asset string) string {
+	precisionMu.RLock()
+	decimals, ok := precisionList[asset]
+	precisionMu.RUnlock()
+	if !ok {
+		decimals = CoinDecimals
+	}
+	return a.Format(decimals)
+}
+
+// MarshalJSON writes the amount as a JSON number in coin units.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or string in coin units without going through float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// MarshalText writes the amount in coin units.
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText reads the amount in coin units, used for web-forms.
+func (a *Amount) UnmarshalText(text []byte) error {
+	v, err := ParseAmount(string(text))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
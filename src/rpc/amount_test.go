@@ -0,0 +1,56 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want Amount
+	}{
+		{"0", 0},
+		{"1.5", 150000000},
+		{" 200.00000000 ", 200 * Coin},
+		{"0.00000001", 1},
+		{"-0.1", -10000000},
+		{"+3", 3 * Coin},
+		{"92233720368.54775807", Amount(9223372036854775807)},
+	} {
+		got, err := ParseAmount(c.s)
+		if err != nil || got != c.want {
+			t.Errorf("%q: %s %v, want %s", c.s, got, err, c.want)
+		}
+	}
+
+	for _, s := range []string{"", ".5", "1.", "--1", "1/3", "1e8", "1E-8", "0x10", "0b1", "1_000", "Inf", "NaN", "1,5",
+		"0.000000001", "1.000000000", "92233720368.54775808", "1" + string(make([]byte, 1))} {
+		if got, err := ParseAmount(s); err == nil {
+			t.Errorf("%q: %s, want an error", s, got)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct{ Amount Amount }
+	err := json.Unmarshal([]byte(`{"Amount": 0.00000123}`), &v)
+	if err != nil || v.Amount != 123 {
+		t.Errorf("number: %s %v", v.Amount, err)
+	}
+	err = json.Unmarshal([]byte(`{"Amount": "12.5"}`), &v)
+	if err != nil || v.Amount != 1250000000 {
+		t.Errorf("string: %s %v", v.Amount, err)
+	}
+	if err = json.Unmarshal([]byte(`{"Amount": 1e300000000}`), &v); err == nil {
+		t.Errorf("exponent: %s", v.Amount)
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) != `{"Amount":12.5}` {
+		t.Errorf("marshal: %s %v", b, err)
+	}
+}
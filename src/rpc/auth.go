@@ -0,0 +1,169 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Authentication and wallet endpoint
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Authenticator gives the credentials of a request.
+type Authenticator interface {
+	Credentials() (user string, pass string, err error)
+}
+
+// BasicAuth is a fixed user and password.
+type BasicAuth struct {
+	User string
+	Pass string
+}
+
+// Credentials implements Authenticator.
+func (a BasicAuth) Credentials() (string, string, error) {
+	return a.User, a.Pass, nil
+}
+
+// CookieAuth reads the .cookie file the node writes when no rpcpassword is set.
+// The file is read on each request, since the node renews it on restart.
+type CookieAuth struct {
+	Path string
+}
+
+// Credentials implements Authenticator.
+func (a CookieAuth) Credentials() (string, string, error) {
+	data, err := ioutil.ReadFile(a.Path)
+	if err != nil {
+		return "", "", err
+	}
+	cookie := strings.TrimSpace(string(data))
+	i := strings.Index(cookie, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid cookie file [%s]", a.Path)
+	}
+	return cookie[:i], cookie[i+1:], nil
+}
+
+// CookiePath returns the path of the .cookie file of the chain (e.g. "elementsregtest") in datadir.
+func CookiePath(datadir string, chain string) string {
+	return filepath.Join(datadir, chain, ".cookie")
+}
+
+// Secret is the credentials of an actor in a secrets file.
+// Either User and Pass (as set by rpcauth of the node) or Cookie (a .cookie path) is used.
+type Secret struct {
+	User   string `json:"user"`
+	Pass   string `json:"pass"`
+	Cookie string `json:"cookie"`
+}
+
+// Authenticator returns the Authenticator of s.
+func (s Secret) Authenticator() Authenticator {
+	if s.Cookie != "" {
+		return CookieAuth{Path: s.Cookie}
+	}
+	return BasicAuth{User: s.User, Pass: s.Pass}
+}
+
+// LoadSecrets reads a secrets file, which is a JSON object of Secret keyed by actor name.
+//
+//	{"alice": {"user": "alice", "pass": "..."}, "fred": {"cookie": "/path/to/.cookie"}}
+func LoadSecrets(path string) (map[string]Secret, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]Secret)
+	err = json.Unmarshal(data, &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("secrets file [%s]: %v", path, err)
+	}
+	return secrets, nil
+}
+
+// RPCAuth returns the rpcauth line of the node configuration for user and password,
+// so that only the salted hash is written there.
+func RPCAuth(user string, password string) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	saltHex := hex.EncodeToString(salt)
+	mac := hmac.New(sha256.New, []byte(saltHex))
+	mac.Write([]byte(password))
+	return fmt.Sprintf("rpcauth=%s:%s$%s", user, saltHex, hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// Config is the connection settings of NewRpcWithConfig.
+// Credentials are taken from the first one set of SecretsFile, CookieFile and User/Pass.
+type Config struct {
+	URL         string
+	User        string
+	Pass        string
+	CookieFile  string // path of the .cookie file
+	SecretsFile string // path of the secrets file (see LoadSecrets)
+	SecretsName string // key of the secrets file
+	Wallet      string // wallet name for the /wallet/<name> endpoint
+	RecordFile  string // fixture file recording the exchanges with the node (see Recorder)
+	ReplayFile  string // fixture file replayed instead of the node (see Replayer)
+}
+
+// NewRpcWithConfig return new Rpc with the authentication and the wallet of cfg.
+func NewRpcWithConfig(cfg Config) (*Rpc, error) {
+	rpc := NewRpc(cfg.URL, cfg.User, cfg.Pass)
+	rpc.Wallet = cfg.Wallet
+
+	switch {
+	case cfg.SecretsFile != "":
+		secrets, err := LoadSecrets(cfg.SecretsFile)
+		if err != nil {
+			return nil, err
+		}
+		secret, ok := secrets[cfg.SecretsName]
+		if !ok {
+			return nil, fmt.Errorf("secret not found in [%s]: %s", cfg.SecretsFile, cfg.SecretsName)
+		}
+		rpc.Auth = secret.Authenticator()
+	case cfg.CookieFile != "":
+		rpc.Auth = CookieAuth{Path: cfg.CookieFile}
+	}
+
+	switch {
+	case cfg.ReplayFile != "":
+		replayer, err := LoadReplayer(cfg.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		rpc.Transport = replayer
+	case cfg.RecordFile != "":
+		rpc.Transport = NewRecorder(nil, cfg.RecordFile)
+	}
+	return rpc, nil
+}
+
+// credentials returns the user and password of a request.
+func (rpc *Rpc) credentials() (string, string, error) {
+	if rpc.Auth == nil {
+		return rpc.User, rpc.Pass, nil
+	}
+	return rpc.Auth.Credentials()
+}
+
+// endpoint returns the URL of requests, which is of the wallet if rpc.Wallet is set.
+func (rpc *Rpc) endpoint() string {
+	if rpc.Wallet == "" {
+		return rpc.Url
+	}
+	return strings.TrimRight(rpc.Url, "/") + "/wallet/" + url.PathEscape(rpc.Wallet)
+}
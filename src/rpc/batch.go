@@ -0,0 +1,201 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc JSON-RPC batch requests
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Batch collects requests which are sent to the node in a single HTTP round trip.
+//
+//	b := rpcClient.NewBatch()
+//	calls := make([]*rpc.BatchCall, len(txids))
+//	for i, txid := range txids {
+//		calls[i] = b.GetRawTransaction(txid)
+//	}
+//	err := b.Send() // transport error of the whole batch
+//	for _, c := range calls {
+//		rawtx, err := c.CastString() // error of each request
+//	}
+type Batch struct {
+	rpc   *Rpc
+	calls []*BatchCall
+}
+
+// BatchCall is a request in a Batch and, once the batch is sent, its response.
+type BatchCall struct {
+	Method   string
+	Params   []interface{}
+	Response RpcResponse
+	Err      error
+	id       string
+	done     bool
+}
+
+// NewBatch returns an empty Batch sent to this node.
+func (rpc *Rpc) NewBatch() *Batch {
+	return &Batch{rpc: rpc}
+}
+
+// Len returns the number of requests.
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// Add adds a request.
+func (b *Batch) Add(method string, params ...interface{}) *BatchCall {
+	if len(params) == 0 {
+		params = []interface{}{}
+	}
+	c := &BatchCall{Method: method, Params: params}
+	b.calls = append(b.calls, c)
+	return c
+}
+
+// GetBlockHash adds getblockhash. (see Rpc.GetBlockHash)
+func (b *Batch) GetBlockHash(height int64) *BatchCall {
+	return b.Add("getblockhash", height)
+}
+
+// GetBlock adds getblock. (see Rpc.GetBlock)
+func (b *Batch) GetBlock(hash string) *BatchCall {
+	return b.Add("getblock", hash)
+}
+
+// GetRawTransaction adds getrawtransaction. (see Rpc.GetRawTransaction)
+func (b *Batch) GetRawTransaction(txid string) *BatchCall {
+	return b.Add("getrawtransaction", txid, 0)
+}
+
+// GetReceivedByAddress adds getreceivedbyaddress in the shape of the node version.
+// (see Rpc.GetReceivedByAddress)
+func (b *Batch) GetReceivedByAddress(addr string, minconf int64, asset string) *BatchCall {
+	params := b.rpc.getAdapter().GetReceivedByAddressParams(addr, minconf, asset)
+	return b.Add("getreceivedbyaddress", params...)
+}
+
+// Send sends the requests, retrying transient errors according to Rpc.Retry.
+// The error is of the whole batch, each BatchCall has its own Err.
+func (b *Batch) Send() error {
+	if len(b.calls) == 0 {
+		return nil
+	}
+	ctx := b.rpc.Context()
+	idempotent := true
+	for _, c := range b.calls {
+		idempotent = idempotent && idempotentMethods[c.Method]
+	}
+	err := b.rpc.withRetry(ctx, "batch", idempotent, func() (bool, error) {
+		return b.send()
+	})
+	if err != nil {
+		// no call got its response.
+		for _, c := range b.calls {
+			if !c.done {
+				c.Err = err
+			}
+		}
+	}
+	return err
+}
+
+func (b *Batch) send() (bool, error) {
+	reqs := make([]RpcRequest, len(b.calls))
+	ids := make(map[string]*BatchCall, len(b.calls))
+	for i, c := range b.calls {
+		c.id = nextRequestID()
+		c.done = false
+		reqs[i] = RpcRequest{"1.0", c.id, c.Method, c.Params}
+		ids[c.id] = c
+	}
+	bs, err := json.Marshal(reqs)
+	if err != nil {
+		return false, err
+	}
+
+	hres, body, retry, err := b.rpc.post(b.rpc.Context(), "batch", bs)
+	if err != nil {
+		return retry, err
+	}
+	var responses []RpcResponse
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err = dec.Decode(&responses)
+	if err != nil {
+		// a node which does not support batches answers with a single error.
+		err = fmt.Errorf("status:%v, error:%v, body:%s", hres.Status, err, body)
+		return isRetryableStatus(hres.StatusCode), &TransportError{Method: "batch", Err: err}
+	}
+
+	for _, res := range responses {
+		c, ok := ids[res.Id]
+		if !ok || c.done {
+			continue
+		}
+		c.done = true
+		c.Response = res
+		c.Err = nil
+		if res.Error != nil {
+			rerr, err := res.newRpcError(c.Method, c.Params, hres.StatusCode)
+			if err != nil {
+				c.Err = &TransportError{Method: c.Method, Err: err}
+			} else {
+				c.Err = rerr
+			}
+		}
+	}
+	for _, c := range b.calls {
+		if !c.done {
+			c.Err = &TransportError{Method: c.Method, Err: fmt.Errorf("no response in batch: status:%v reqid:%v", hres.Status, c.id)}
+		}
+	}
+	if hres.StatusCode != http.StatusOK && len(responses) == 0 {
+		return isRetryableStatus(hres.StatusCode), &TransportError{Method: "batch", Err: fmt.Errorf("status:%v, body:%s", hres.Status, body)}
+	}
+	return false, nil
+}
+
+// Unmarshal converts the result into result of any type.
+func (c *BatchCall) Unmarshal(result interface{}) error {
+	if c.Err != nil {
+		return c.Err
+	}
+	if c.Response.Result == nil {
+		return fmt.Errorf("RpcResponse Result is nil.")
+	}
+	bs, err := json.Marshal(c.Response.Result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, result)
+}
+
+// CastString returns the result as string.
+func (c *BatchCall) CastString() (string, error) {
+	if c.Err != nil {
+		return "", c.Err
+	}
+	str, ok := c.Response.Result.(string)
+	if !ok {
+		return "", fmt.Errorf("RpcResponse Result cast error:%+v", c.Response.Result)
+	}
+	return str, nil
+}
+
+// CastAmount returns the result as Amount.
+func (c *BatchCall) CastAmount() (Amount, error) {
+	if c.Err != nil {
+		return 0, c.Err
+	}
+	jnum, ok := c.Response.Result.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("RpcResponse Result cast error:%+v", c.Response.Result)
+	}
+	return ParseAmount(jnum.String())
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newBatchNode returns a node answering each batch with answer, which gets the requests in order.
+func newBatchNode(t *testing.T, answer func(reqs []RpcRequest) (int, interface{})) *Rpc {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []RpcRequest
+		err := json.NewDecoder(r.Body).Decode(&reqs)
+		if err != nil {
+			t.Errorf("not a batch: %v", err)
+		}
+		status, res := answer(reqs)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(server.Close)
+	node := NewRpc(server.URL, "", "")
+	node.Retry = RetryPolicy{}
+	return node
+}
+
+// newBatch adds getblockhash of the heights.
+func newBatch(node *Rpc, heights ...int64) (*Batch, []*BatchCall) {
+	b := node.NewBatch()
+	calls := make([]*BatchCall, len(heights))
+	for i, h := range heights {
+		calls[i] = b.GetBlockHash(h)
+	}
+	return b, calls
+}
+
+func hashOf(req RpcRequest) string {
+	return "hash" + string(rune('0'+int(req.Params[0].(float64))))
+}
+
+func TestBatchOutOfOrder(t *testing.T) {
+	node := newBatchNode(t, func(reqs []RpcRequest) (int, interface{}) {
+		res := make([]RpcResponse, len(reqs))
+		for i, req := range reqs {
+			res[len(reqs)-1-i] = RpcResponse{Result: hashOf(req), Id: req.Id}
+		}
+		// the error of a request does not fail the others.
+		res[0] = RpcResponse{Error: map[string]interface{}{"code": ErrCodeInvalidParameter, "message": "Block height out of range"}, Id: reqs[len(reqs)-1].Id}
+		return http.StatusOK, res
+	})
+	b, calls := newBatch(node, 1, 2, 3)
+	err := b.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"hash1", "hash2"} {
+		got, err := calls[i].CastString()
+		if err != nil || got != want {
+			t.Errorf("call %d: %s %v, want %s", i, got, err, want)
+		}
+	}
+	_, err = calls[2].CastString()
+	var rerr *RpcError
+	if !errors.As(err, &rerr) || rerr.Code != ErrCodeInvalidParameter || rerr.Method != "getblockhash" {
+		t.Errorf("call 2: %#v", err)
+	}
+}
+
+func TestBatchMissingAndDuplicate(t *testing.T) {
+	node := newBatchNode(t, func(reqs []RpcRequest) (int, interface{}) {
+		// the first id answered twice, the second not at all, an unknown id.
+		return http.StatusOK, []RpcResponse{
+			{Result: hashOf(reqs[0]), Id: reqs[0].Id},
+			{Result: "again", Id: reqs[0].Id},
+			{Result: "unknown", Id: "other"},
+			{Result: hashOf(reqs[2]), Id: reqs[2].Id},
+		}
+	})
+	b, calls := newBatch(node, 1, 2, 3)
+	err := b.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := calls[0].CastString(); err != nil || got != "hash1" {
+		t.Errorf("duplicated: %s %v, want the first response", got, err)
+	}
+	if _, err := calls[1].CastString(); !errors.Is(err, ErrTransport) {
+		t.Errorf("missing: %v, want %v", err, ErrTransport)
+	}
+	if got, err := calls[2].CastString(); err != nil || got != "hash3" {
+		t.Errorf("call 2: %s %v", got, err)
+	}
+}
+
+func TestBatchNotSupported(t *testing.T) {
+	// a node without batches answers the array with a single error.
+	node := newBatchNode(t, func(reqs []RpcRequest) (int, interface{}) {
+		return http.StatusInternalServerError, RpcResponse{Error: map[string]interface{}{"code": -32700, "message": "Parse error"}}
+	})
+	b, calls := newBatch(node, 1, 2)
+	err := b.Send()
+	if !errors.Is(err, ErrTransport) {
+		t.Fatalf("send: %v, want %v", err, ErrTransport)
+	}
+	for i, c := range calls {
+		if _, err := c.CastString(); !errors.Is(err, ErrTransport) {
+			t.Errorf("call %d: %v, want the error of the batch", i, err)
+		}
+	}
+
+	// nothing is sent without requests.
+	if err := node.NewBatch().Send(); err != nil {
+		t.Error(err)
+	}
+}
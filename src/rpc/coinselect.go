@@ -0,0 +1,248 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Coin selection strategies
+package rpc
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CoinSelector chooses utxos out of candidates whose total amount covers target.
+// Implementations must not modify candidates.
+type CoinSelector interface {
+	Select(candidates UnspentList, target Amount) (UnspentList, error)
+}
+
+// Names of the coin selectors for NewCoinSelector.
+const (
+	SelectLargestFirst  = "largest"
+	SelectSmallestFirst = "smallest"
+	SelectBranchBound   = "bnb"
+	SelectRandomImprove = "randomimprove"
+	SelectPrivacy       = "privacy"
+)
+
+// ErrInsufficientFunds is returned when the candidates cannot cover the target.
+var ErrInsufficientFunds = fmt.Errorf("no sufficient utxo")
+
+// NewCoinSelector returns the coin selector by name. (see Select* constants)
+func NewCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case SelectLargestFirst, "":
+		return LargestFirst{}, nil
+	case SelectSmallestFirst:
+		return SmallestFirst{}, nil
+	case SelectBranchBound:
+		return &BranchAndBound{}, nil
+	case SelectRandomImprove:
+		return NewRandomImprove(time.Now().UnixNano()), nil
+	case SelectPrivacy:
+		return Privacy{}, nil
+	}
+	return nil, fmt.Errorf("unknown coin selector: %s", name)
+}
+
+func sortedCopy(candidates UnspentList, descending bool) UnspentList {
+	ul := make(UnspentList, len(candidates))
+	copy(ul, candidates)
+	if descending {
+		sort.Stable(sort.Reverse(ul))
+	} else {
+		sort.Stable(ul)
+	}
+	return ul
+}
+
+func accumulate(ul UnspentList, target Amount) (UnspentList, error) {
+	var total Amount
+	var utxos UnspentList
+	for _, u := range ul {
+		if target <= total {
+			break
+		}
+		total += u.Amount
+		utxos = append(utxos, u)
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+	return utxos, nil
+}
+
+// LargestFirst takes the largest utxos until the target is covered.
+type LargestFirst struct{}
+
+// Select implements CoinSelector.
+func (LargestFirst) Select(candidates UnspentList, target Amount) (UnspentList, error) {
+	return accumulate(sortedCopy(candidates, true), target)
+}
+
+// SmallestFirst takes the smallest utxos until the target is covered.
+type SmallestFirst struct{}
+
+// Select implements CoinSelector.
+func (SmallestFirst) Select(candidates UnspentList, target Amount) (UnspentList, error) {
+	return accumulate(sortedCopy(candidates, false), target)
+}
+
+// BranchAndBound searches for a set of utxos whose total is between target and
+// target+Tolerance, so that no change output is needed.
+// When no such set is found within MaxTries, Fallback is used. (LargestFirst if nil)
+type BranchAndBound struct {
+	Tolerance Amount
+	MaxTries  int
+	Fallback  CoinSelector
+}
+
+const defaultBnBMaxTries = 100000
+
+// Select implements CoinSelector.
+func (b *BranchAndBound) Select(candidates UnspentList, target Amount) (UnspentList, error) {
+	ul := sortedCopy(candidates, true)
+
+	// remain[i] is the total amount of ul[i:].
+	remain := make([]Amount, len(ul)+1)
+	for i := len(ul) - 1; i >= 0; i-- {
+		remain[i] = remain[i+1] + ul[i].Amount
+	}
+
+	maxTries := b.MaxTries
+	if maxTries <= 0 {
+		maxTries = defaultBnBMaxTries
+	}
+	tries := 0
+	picked := make([]bool, len(ul))
+	var search func(i int, total Amount) bool
+	search = func(i int, total Amount) bool {
+		tries++
+		if total > target+b.Tolerance || total+remain[i] < target || maxTries < tries {
+			return false
+		}
+		if target <= total {
+			return true
+		}
+		if i == len(ul) {
+			return false
+		}
+		picked[i] = true
+		if search(i+1, total+ul[i].Amount) {
+			return true
+		}
+		picked[i] = false
+		return search(i+1, total)
+	}
+
+	if search(0, 0) {
+		var utxos UnspentList
+		for i, u := range ul {
+			if picked[i] {
+				utxos = append(utxos, u)
+			}
+		}
+		return utxos, nil
+	}
+
+	fallback := b.Fallback
+	if fallback == nil {
+		fallback = LargestFirst{}
+	}
+	return fallback.Select(candidates, target)
+}
+
+// RandomImprove picks random utxos until the target is covered, then keeps adding
+// random utxos while the total moves closer to twice the target, which leaves a
+// change output of about the payment size. (no more than three times the target)
+// It is safe for concurrent use.
+type RandomImprove struct {
+	mu   sync.Mutex // guards rand, which is not safe for concurrent use
+	rand *rand.Rand
+}
+
+// NewRandomImprove returns RandomImprove with a seed, so the selection is reproducible.
+func NewRandomImprove(seed int64) *RandomImprove {
+	return &RandomImprove{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Select implements CoinSelector.
+func (r *RandomImprove) Select(candidates UnspentList, target Amount) (UnspentList, error) {
+	// sort first, so the result depends only on the seed and not on the RPC order.
+	ul := sortedCopy(candidates, false)
+	r.mu.Lock()
+	r.rand.Shuffle(len(ul), func(i, j int) { ul[i], ul[j] = ul[j], ul[i] })
+	r.mu.Unlock()
+
+	var total Amount
+	n := 0
+	for ; n < len(ul) && total < target; n++ {
+		total += ul[n].Amount
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+	utxos := append(UnspentList{}, ul[:n]...)
+
+	ideal := 2 * target
+	for _, u := range ul[n:] {
+		next := total + u.Amount
+		if 3*target < next || abs(ideal-next) >= abs(ideal-total) {
+			continue
+		}
+		total = next
+		utxos = append(utxos, u)
+	}
+	return utxos, nil
+}
+
+func abs(v Amount) Amount {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Privacy avoids linking addresses. It prefers a single utxo (an exact one first),
+// and otherwise spends whole addresses together, largest address first, so that no
+// address is left partly spent.
+type Privacy struct{}
+
+// Select implements CoinSelector.
+func (Privacy) Select(candidates UnspentList, target Amount) (UnspentList, error) {
+	ul := sortedCopy(candidates, false)
+	for _, u := range ul {
+		if target <= u.Amount {
+			return UnspentList{u}, nil
+		}
+	}
+
+	var addrs []string
+	groups := make(map[string]UnspentList)
+	for _, u := range ul {
+		if _, ok := groups[u.Address]; !ok {
+			addrs = append(addrs, u.Address)
+		}
+		groups[u.Address] = append(groups[u.Address], u)
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return groups[addrs[i]].GetAmount() > groups[addrs[j]].GetAmount()
+	})
+
+	var total Amount
+	var utxos UnspentList
+	for _, a := range addrs {
+		if target <= total {
+			break
+		}
+		total += groups[a].GetAmount()
+		utxos = append(utxos, groups[a]...)
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+	return utxos, nil
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package rpc
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// syntheticUnspents returns n utxos of random amounts up to max coins, spread over addrs addresses.
+// The same seed gives the same list.
+func syntheticUnspents(seed int64, n int, max int64, addrs int) UnspentList {
+	r := rand.New(rand.NewSource(seed))
+	ul := make(UnspentList, n)
+	for i := range ul {
+		ul[i] = &Unspent{
+			Txid:    fmt.Sprintf("%064x", i),
+			Vout:    int64(i % 3),
+			Address: fmt.Sprintf("addr%d", r.Intn(addrs)),
+			Amount:  Amount(1 + r.Int63n(max*int64(Coin))),
+		}
+	}
+	return ul
+}
+
+func unspents(amounts ...Amount) UnspentList {
+	ul := make(UnspentList, len(amounts))
+	for i, a := range amounts {
+		ul[i] = &Unspent{Txid: fmt.Sprintf("%064x", i), Address: fmt.Sprintf("addr%d", i), Amount: a * Coin}
+	}
+	return ul
+}
+
+// checkSelection checks that the selection is a covering subset of the candidates, left unmodified.
+func checkSelection(t *testing.T, name string, candidates UnspentList, before string, target Amount, utxos UnspentList) {
+	t.Helper()
+	if after := fmt.Sprint(candidates); after != before {
+		t.Errorf("%s: candidates modified", name)
+	}
+	known := make(map[*Unspent]bool)
+	for _, u := range candidates {
+		known[u] = true
+	}
+	for _, u := range utxos {
+		if !known[u] {
+			t.Errorf("%s: %+v is not a candidate or selected twice", name, u)
+		}
+		delete(known, u)
+	}
+	if utxos.GetAmount() < target {
+		t.Errorf("%s: total %s < target %s", name, utxos.GetAmount(), target)
+	}
+}
+
+func TestCoinSelectors(t *testing.T) {
+	names := []string{SelectLargestFirst, SelectSmallestFirst, SelectBranchBound, SelectRandomImprove, SelectPrivacy}
+	for seed := int64(1); seed <= 50; seed++ {
+		candidates := syntheticUnspents(seed, 1+int(seed%20), 10, 4)
+		before := fmt.Sprint(candidates)
+		for _, target := range []Amount{1, candidates.GetAmount() / 3, candidates.GetAmount()} {
+			for _, name := range names {
+				selector, err := NewCoinSelector(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				utxos, err := selector.Select(candidates, target)
+				if err != nil {
+					t.Errorf("%s seed:%d target:%s: %v", name, seed, target, err)
+					continue
+				}
+				checkSelection(t, name, candidates, before, target, utxos)
+			}
+		}
+		for _, name := range names {
+			selector, _ := NewCoinSelector(name)
+			_, err := selector.Select(candidates, candidates.GetAmount()+1)
+			if err != ErrInsufficientFunds {
+				t.Errorf("%s seed:%d: %v, want %v", name, seed, err, ErrInsufficientFunds)
+			}
+		}
+	}
+}
+
+func TestCoinSelectorChoices(t *testing.T) {
+	candidates := unspents(5, 1, 3, 8, 2)
+	for _, c := range []struct {
+		selector CoinSelector
+		target   Amount
+		want     string
+	}{
+		{LargestFirst{}, 9 * Coin, "[8 5]"},
+		{SmallestFirst{}, 4 * Coin, "[1 2 3]"},
+		// exact, no change although a single utxo would do.
+		{&BranchAndBound{}, 7 * Coin, "[5 2]"},
+		{&BranchAndBound{}, 19 * Coin, "[8 5 3 2 1]"},
+		// no exact match within the tolerance, so largest first.
+		{&BranchAndBound{Tolerance: 0}, Coin / 2, "[8]"},
+		{Privacy{}, 4 * Coin, "[5]"},
+		{Privacy{}, 10 * Coin, "[8 5]"},
+	} {
+		utxos, err := c.selector.Select(candidates, c.target)
+		if err != nil {
+			t.Errorf("%T %s: %v", c.selector, c.target, err)
+			continue
+		}
+		got := make([]int64, len(utxos))
+		for i, u := range utxos {
+			got[i] = int64(u.Amount / Coin)
+		}
+		if fmt.Sprint(got) != c.want {
+			t.Errorf("%T %s: %v, want %s", c.selector, c.target, got, c.want)
+		}
+	}
+}
+
+func TestPrivacyWholeAddresses(t *testing.T) {
+	candidates := unspents(4, 4, 3, 6)
+	candidates[1].Address = candidates[0].Address
+	utxos, err := Privacy{}.Select(candidates, 7*Coin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := make(map[string]int)
+	for _, u := range utxos {
+		spent[u.Address]++
+	}
+	if spent[candidates[0].Address] != 2 {
+		t.Errorf("address partly spent: %+v", utxos)
+	}
+}
+
+func TestRandomImproveReproducible(t *testing.T) {
+	candidates := syntheticUnspents(7, 30, 10, 5)
+	shuffled := append(UnspentList{}, candidates...)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), shuffled.Swap)
+	target := candidates.GetAmount() / 4
+
+	a, err := NewRandomImprove(42).Select(candidates, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRandomImprove(42).Select(shuffled, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("same seed, different selections:\n%v\n%v", a, b)
+	}
+	if 3*target < a.GetAmount() && 1 < len(a) {
+		t.Errorf("total %s improved beyond 3 times the target %s", a.GetAmount(), target)
+	}
+}
+
+// TestRandomImproveConcurrent shares a selector between goroutines as the actors do. (go test -race)
+func TestRandomImproveConcurrent(t *testing.T) {
+	selector := NewRandomImprove(1)
+	candidates := syntheticUnspents(3, 20, 10, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := selector.Select(candidates, Coin)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
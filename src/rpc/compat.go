@@ -0,0 +1,408 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Elements version compatibility
+package rpc
+
+import (
+	"elementstx"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Node versions (as reported by getnetworkinfo) where the wallet RPCs changed shape.
+const (
+	// Version0170 renamed signrawtransaction, removed generate and moved the listunspent asset filter.
+	Version0170 = 170000
+	// Version0210 added getbalances and replaced allowhighfees of sendrawtransaction with maxfeerate.
+	Version0210 = 210000
+	// Version2300 added include_immature_coinbase to getreceivedbyaddress.
+	Version2300 = 230000
+)
+
+// NetworkInfo is a part of getnetworkinfo.
+type NetworkInfo struct {
+	Version         int64  `json:"version"`
+	Subversion      string `json:"subversion"`
+	ProtocolVersion int64  `json:"protocolversion"`
+}
+
+// Adapter issues the wallet RPCs the demo needs in the shape of a node version.
+type Adapter interface {
+	SignRawTransaction(rpc *Rpc, tx string) (SignedTransaction, error)
+	BlindRawTransaction(rpc *Rpc, tx string, ignoreBlindFail bool, commitments []string) (string, error)
+	Generate(rpc *Rpc, blocks int64) ([]string, error)
+	DumpAssetLabels(rpc *Rpc) (map[string]string, error)
+	GetReceivedByAddress(rpc *Rpc, addr string, minconf int64, asset string) (Amount, error)
+	GetReceivedByAddressParams(addr string, minconf int64, asset string) []interface{}
+	GetBalance(rpc *Rpc) (BalanceMap, error)
+	ListUnspent(rpc *Rpc, opts ListUnspentOptions) (UnspentList, error)
+	SendRawTransaction(rpc *Rpc, tx string, allowHighFees bool) (string, error)
+	GetNewAddress(rpc *Rpc) (string, error)
+	ValidateAddress(rpc *Rpc, addr string) (ValidatedAddress, error)
+}
+
+// NewAdapter returns the adapter for a node version.
+func NewAdapter(version int64) Adapter {
+	if version < Version0170 {
+		return LegacyAdapter{}
+	}
+	return ModernAdapter{Version: version}
+}
+
+// DetectVersion asks the node its version with getnetworkinfo and chooses the adapter accordingly.
+func (rpc *Rpc) DetectVersion() (NetworkInfo, error) {
+	var info NetworkInfo
+
+	_, err := rpc.RequestAndUnmarshalResult(&info, "getnetworkinfo")
+	if err != nil {
+		return info, err
+	}
+
+	rpc.node.mu.Lock()
+	rpc.node.version = info.Version
+	rpc.node.adapter = NewAdapter(info.Version)
+	rpc.node.mu.Unlock()
+	return info, nil
+}
+
+// Version returns the node version detected by DetectVersion. (0 if not yet)
+func (rpc *Rpc) Version() int64 {
+	rpc.node.mu.Lock()
+	defer rpc.node.mu.Unlock()
+	return rpc.node.version
+}
+
+// getAdapter returns rpc.Adapter, or the one of the node version detected on first use.
+// Until the node answers getnetworkinfo, the 2017 shapes are used.
+func (rpc *Rpc) getAdapter() Adapter {
+	if rpc.Adapter != nil {
+		return rpc.Adapter
+	}
+	rpc.node.mu.Lock()
+	adapter := rpc.node.adapter
+	rpc.node.mu.Unlock()
+	if adapter != nil {
+		return adapter
+	}
+
+	_, err := rpc.DetectVersion()
+	if err != nil {
+		logger.Println("RPC/getnetworkinfo error:", err)
+		return LegacyAdapter{}
+	}
+	rpc.node.mu.Lock()
+	defer rpc.node.mu.Unlock()
+	return rpc.node.adapter
+}
+
+// SignRawTransaction signs tx with the wallet keys.
+func (rpc *Rpc) SignRawTransaction(tx string) (SignedTransaction, error) {
+	return rpc.getAdapter().SignRawTransaction(rpc, tx)
+}
+
+// BlindRawTransaction blinds tx. commitments are the asset commitments of the inputs.
+func (rpc *Rpc) BlindRawTransaction(tx string, ignoreBlindFail bool, commitments []string) (string, error) {
+	return rpc.getAdapter().BlindRawTransaction(rpc, tx, ignoreBlindFail, commitments)
+}
+
+// Generate mines blocks to the wallet and returns their hashes.
+func (rpc *Rpc) Generate(blocks int64) ([]string, error) {
+	return rpc.getAdapter().Generate(rpc, blocks)
+}
+
+// DumpAssetLabels returns the asset ids keyed by label.
+func (rpc *Rpc) DumpAssetLabels() (map[string]string, error) {
+	return rpc.getAdapter().DumpAssetLabels(rpc)
+}
+
+// GetReceivedByAddress returns the amount of asset received by addr.
+func (rpc *Rpc) GetReceivedByAddress(addr string, minconf int64, asset string) (Amount, error) {
+	return rpc.getAdapter().GetReceivedByAddress(rpc, addr, minconf, asset)
+}
+
+// GetBalance returns the trusted wallet balance keyed by asset label.
+func (rpc *Rpc) GetBalance() (BalanceMap, error) {
+	return rpc.getAdapter().GetBalance(rpc)
+}
+
+// ListUnspent returns the wallet utxos matching opts.
+func (rpc *Rpc) ListUnspent(opts ListUnspentOptions) (UnspentList, error) {
+	return rpc.getAdapter().ListUnspent(rpc, opts)
+}
+
+// SendRawTransaction broadcasts tx and returns its txid.
+// A tx already in the mempool or the chain is a success, e.g. a broadcast whose response was lost.
+func (rpc *Rpc) SendRawTransaction(tx string, allowHighFees bool) (string, error) {
+	txid, err := rpc.getAdapter().SendRawTransaction(rpc, tx, allowHighFees)
+	if err != nil && isAlreadyKnown(err) {
+		parsed, perr := elementstx.ParseTransactionHex(tx)
+		if perr == nil {
+			return parsed.TxID(), nil
+		}
+	}
+	return txid, err
+}
+
+// isAlreadyKnown reports whether err of sendrawtransaction tells that the tx was already accepted.
+func isAlreadyKnown(err error) bool {
+	var rerr *RpcError
+	if !errors.As(err, &rerr) {
+		return false
+	}
+	switch rerr.Code {
+	case ErrCodeVerifyAlreadyInBC:
+		return true
+	case ErrCodeVerifyRejected, ErrCodeVerify:
+		msg := strings.ToLower(rerr.Message)
+		return strings.Contains(msg, "already in") || strings.Contains(msg, "already-in") || strings.Contains(msg, "already-known")
+	}
+	return false
+}
+
+// GetNewAddress returns a new confidential base58 address.
+func (rpc *Rpc) GetNewAddress() (string, error) {
+	return rpc.getAdapter().GetNewAddress(rpc)
+}
+
+// ValidateAddress returns the details of addr including its confidential key.
+func (rpc *Rpc) ValidateAddress(addr string) (ValidatedAddress, error) {
+	return rpc.getAdapter().ValidateAddress(rpc, addr)
+}
+
+// LegacyAdapter issues the RPCs of Elements before 0.17.
+type LegacyAdapter struct{}
+
+// SignRawTransaction implements Adapter.
+func (LegacyAdapter) SignRawTransaction(rpc *Rpc, tx string) (SignedTransaction, error) {
+	var signed SignedTransaction
+	_, err := rpc.RequestAndUnmarshalResult(&signed, "signrawtransaction", tx)
+	return signed, err
+}
+
+// BlindRawTransaction implements Adapter.
+func (LegacyAdapter) BlindRawTransaction(rpc *Rpc, tx string, ignoreBlindFail bool, commitments []string) (string, error) {
+	blindtx, _, err := rpc.RequestAndCastString("blindrawtransaction", tx, ignoreBlindFail, commitments)
+	return blindtx, err
+}
+
+// Generate implements Adapter.
+func (LegacyAdapter) Generate(rpc *Rpc, blocks int64) ([]string, error) {
+	var hashs []string
+	_, err := rpc.RequestAndUnmarshalResult(&hashs, "generate", blocks)
+	return hashs, err
+}
+
+// DumpAssetLabels implements Adapter.
+func (LegacyAdapter) DumpAssetLabels(rpc *Rpc) (map[string]string, error) {
+	return dumpAssetLabels(rpc)
+}
+
+// GetReceivedByAddress implements Adapter.
+func (a LegacyAdapter) GetReceivedByAddress(rpc *Rpc, addr string, minconf int64, asset string) (Amount, error) {
+	amount, _, err := rpc.RequestAndCastAmount("getreceivedbyaddress", a.GetReceivedByAddressParams(addr, minconf, asset)...)
+	return amount, err
+}
+
+// GetReceivedByAddressParams implements Adapter.
+func (LegacyAdapter) GetReceivedByAddressParams(addr string, minconf int64, asset string) []interface{} {
+	return []interface{}{addr, minconf, asset}
+}
+
+// GetBalance implements Adapter.
+func (LegacyAdapter) GetBalance(rpc *Rpc) (BalanceMap, error) {
+	return getWalletInfoBalance(rpc)
+}
+
+// ListUnspent implements Adapter.
+func (LegacyAdapter) ListUnspent(rpc *Rpc, opts ListUnspentOptions) (UnspentList, error) {
+	var ul UnspentList
+	params := []interface{}{opts.MinConf, opts.maxConf(), opts.addresses(), opts.IncludeUnsafe}
+	if opts.Asset != "" {
+		params = append(params, opts.Asset)
+	}
+	_, err := rpc.RequestAndUnmarshalResult(&ul, "listunspent", params...)
+	return ul, err
+}
+
+// SendRawTransaction implements Adapter.
+func (LegacyAdapter) SendRawTransaction(rpc *Rpc, tx string, allowHighFees bool) (string, error) {
+	txid, _, err := rpc.RequestAndCastString("sendrawtransaction", tx, allowHighFees)
+	return txid, err
+}
+
+// GetNewAddress implements Adapter.
+func (LegacyAdapter) GetNewAddress(rpc *Rpc) (string, error) {
+	addr, _, err := rpc.RequestAndCastString("getnewaddress")
+	return addr, err
+}
+
+// ValidateAddress implements Adapter.
+func (LegacyAdapter) ValidateAddress(rpc *Rpc, addr string) (ValidatedAddress, error) {
+	var validAddr ValidatedAddress
+	_, err := rpc.RequestAndUnmarshalResult(&validAddr, "validateaddress", addr)
+	return validAddr, err
+}
+
+// ModernAdapter issues the RPCs of Elements 0.17 and later.
+type ModernAdapter struct {
+	Version int64
+}
+
+// SignRawTransaction implements Adapter.
+func (ModernAdapter) SignRawTransaction(rpc *Rpc, tx string) (SignedTransaction, error) {
+	var signed SignedTransaction
+	_, err := rpc.RequestAndUnmarshalResult(&signed, "signrawtransactionwithwallet", tx)
+	return signed, err
+}
+
+// BlindRawTransaction implements Adapter.
+func (ModernAdapter) BlindRawTransaction(rpc *Rpc, tx string, ignoreBlindFail bool, commitments []string) (string, error) {
+	blindtx, _, err := rpc.RequestAndCastString("blindrawtransaction", tx, ignoreBlindFail, commitments, false)
+	return blindtx, err
+}
+
+// Generate implements Adapter.
+func (a ModernAdapter) Generate(rpc *Rpc, blocks int64) ([]string, error) {
+	var hashs []string
+	addr, err := a.GetNewAddress(rpc)
+	if err != nil {
+		return hashs, err
+	}
+	_, err = rpc.RequestAndUnmarshalResult(&hashs, "generatetoaddress", blocks, addr)
+	return hashs, err
+}
+
+// DumpAssetLabels implements Adapter.
+func (ModernAdapter) DumpAssetLabels(rpc *Rpc) (map[string]string, error) {
+	return dumpAssetLabels(rpc)
+}
+
+// GetReceivedByAddress implements Adapter.
+func (a ModernAdapter) GetReceivedByAddress(rpc *Rpc, addr string, minconf int64, asset string) (Amount, error) {
+	amount, _, err := rpc.RequestAndCastAmount("getreceivedbyaddress", a.GetReceivedByAddressParams(addr, minconf, asset)...)
+	return amount, err
+}
+
+// GetReceivedByAddressParams implements Adapter.
+func (a ModernAdapter) GetReceivedByAddressParams(addr string, minconf int64, asset string) []interface{} {
+	if a.Version < Version2300 {
+		return []interface{}{addr, minconf, asset}
+	}
+	return []interface{}{addr, minconf, false, asset}
+}
+
+// GetBalance implements Adapter.
+func (a ModernAdapter) GetBalance(rpc *Rpc) (BalanceMap, error) {
+	if a.Version < Version0210 {
+		return getWalletInfoBalance(rpc)
+	}
+	var balances struct {
+		Mine struct {
+			Trusted BalanceMap `json:"trusted"`
+		} `json:"mine"`
+	}
+	_, err := rpc.RequestAndUnmarshalResult(&balances, "getbalances")
+	if err != nil {
+		return nil, err
+	}
+	return balances.Mine.Trusted, nil
+}
+
+// ListUnspent implements Adapter.
+func (ModernAdapter) ListUnspent(rpc *Rpc, opts ListUnspentOptions) (UnspentList, error) {
+	var list []struct {
+		*Unspent
+		Label string `json:"label"`
+	}
+	params := []interface{}{opts.MinConf, opts.maxConf(), opts.addresses(), opts.IncludeUnsafe}
+	if opts.Asset != "" {
+		params = append(params, map[string]string{"asset": opts.Asset})
+	}
+	_, err := rpc.RequestAndUnmarshalResult(&list, "listunspent", params...)
+	if err != nil {
+		return nil, err
+	}
+
+	// accounts were replaced by labels.
+	ul := make(UnspentList, len(list))
+	for i, u := range list {
+		ul[i] = u.Unspent
+		if ul[i].Account == "" {
+			ul[i].Account = u.Label
+		}
+	}
+	return ul, nil
+}
+
+// SendRawTransaction implements Adapter.
+func (a ModernAdapter) SendRawTransaction(rpc *Rpc, tx string, allowHighFees bool) (string, error) {
+	var txid string
+	var err error
+	switch {
+	case a.Version < Version0210:
+		txid, _, err = rpc.RequestAndCastString("sendrawtransaction", tx, allowHighFees)
+	case allowHighFees:
+		// a maxfeerate of 0 accepts any fee.
+		txid, _, err = rpc.RequestAndCastString("sendrawtransaction", tx, 0)
+	default:
+		txid, _, err = rpc.RequestAndCastString("sendrawtransaction", tx)
+	}
+	return txid, err
+}
+
+// GetNewAddress implements Adapter.
+// The default address type became bech32, so base58 is requested explicitly.
+func (ModernAdapter) GetNewAddress(rpc *Rpc) (string, error) {
+	addr, _, err := rpc.RequestAndCastString("getnewaddress", "", "legacy")
+	return addr, err
+}
+
+// ValidateAddress implements Adapter.
+// validateaddress no longer knows the wallet details, they moved to getaddressinfo.
+func (ModernAdapter) ValidateAddress(rpc *Rpc, addr string) (ValidatedAddress, error) {
+	var validAddr ValidatedAddress
+	_, err := rpc.RequestAndUnmarshalResult(&validAddr, "validateaddress", addr)
+	if err != nil || !validAddr.IsValid {
+		return validAddr, err
+	}
+	_, err = rpc.RequestAndUnmarshalResult(&validAddr, "getaddressinfo", addr)
+	validAddr.IsValid = true
+	return validAddr, err
+}
+
+// dumpAssetLabels returns the asset ids keyed by label, whichever way round the node writes the map.
+func dumpAssetLabels(rpc *Rpc) (map[string]string, error) {
+	var res map[string]string
+	_, err := rpc.RequestAndUnmarshalResult(&res, "dumpassetlabels")
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(res))
+	for k, v := range res {
+		if isAssetID(k) && !isAssetID(v) {
+			k, v = v, k
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+func isAssetID(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func getWalletInfoBalance(rpc *Rpc) (BalanceMap, error) {
+	var wallet Wallet
+	_, err := rpc.RequestAndUnmarshalResult(&wallet, "getwalletinfo")
+	if err != nil {
+		return nil, err
+	}
+	return wallet.Balance, nil
+}
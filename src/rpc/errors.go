@@ -0,0 +1,108 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Errors
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// RPC error codes of the node. (see rpc/protocol.h of Elements)
+const (
+	ErrCodeMisc                = -1
+	ErrCodeTypeError           = -3
+	ErrCodeInvalidAddress      = -5
+	ErrCodeOutOfMemory         = -7
+	ErrCodeInvalidParameter    = -8
+	ErrCodeNotConnected        = -9
+	ErrCodeInInitialDownload   = -10
+	ErrCodeDeserialization     = -22
+	ErrCodeVerify              = -25
+	ErrCodeVerifyRejected      = -26
+	ErrCodeVerifyAlreadyInBC   = -27
+	ErrCodeInWarmup            = -28
+	ErrCodeWallet              = -4
+	ErrCodeInsufficientFunds   = -6
+	ErrCodeKeypoolRanOut       = -12
+	ErrCodeUnlockNeeded        = -13
+	ErrCodePassphraseIncorrect = -14
+	ErrCodeWrongEncState       = -15
+	ErrCodeWalletNotFound      = -18
+	ErrCodeWalletNotSpecified  = -19
+)
+
+// Categories of the errors returned by Rpc, to be tested with errors.Is.
+var (
+	// ErrTransport is the node could not be reached or answered garbage.
+	ErrTransport = errors.New("rpc transport error")
+	// ErrAuth is the node refused the credentials.
+	ErrAuth = errors.New("rpc authentication failed")
+	// ErrNodeNotReady is the node is warming up, downloading blocks or not connected.
+	ErrNodeNotReady = errors.New("node not ready")
+	// ErrWallet is the wallet refused the request. (insufficient funds, locked, ...)
+	ErrWallet = errors.New("wallet error")
+	// ErrInvalidRequest is the node refused the parameters. (invalid address, bad hex, ...)
+	ErrInvalidRequest = errors.New("invalid rpc request")
+	// ErrRejected is the node refused the transaction.
+	ErrRejected = errors.New("transaction rejected")
+)
+
+// Error implements error. The message of the node comes with the method called.
+func (e *RpcError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("rpc %s: status:%d %s", e.Method, e.Status, e.Message)
+	}
+	return fmt.Sprintf("rpc %s: %s (code:%d)", e.Method, e.Message, e.Code)
+}
+
+// Is reports whether e belongs to the category target. (see Err* variables)
+func (e *RpcError) Is(target error) bool {
+	switch target {
+	case ErrAuth:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	case ErrNodeNotReady:
+		return e.Code == ErrCodeInWarmup || e.Code == ErrCodeInInitialDownload || e.Code == ErrCodeNotConnected
+	case ErrWallet:
+		switch e.Code {
+		case ErrCodeWallet, ErrCodeInsufficientFunds, ErrCodeKeypoolRanOut, ErrCodeUnlockNeeded,
+			ErrCodePassphraseIncorrect, ErrCodeWrongEncState, ErrCodeWalletNotFound, ErrCodeWalletNotSpecified:
+			return true
+		}
+	case ErrInvalidRequest:
+		switch e.Code {
+		case ErrCodeTypeError, ErrCodeInvalidAddress, ErrCodeInvalidParameter, ErrCodeDeserialization:
+			return true
+		}
+	case ErrRejected:
+		switch e.Code {
+		case ErrCodeVerify, ErrCodeVerifyRejected, ErrCodeVerifyAlreadyInBC:
+			return true
+		}
+	}
+	return false
+}
+
+// TransportError is a failure to talk to the node, it is ErrTransport.
+type TransportError struct {
+	Method string
+	Err    error
+}
+
+// Error implements error.
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("rpc %s: %v", e.Method, e.Err)
+}
+
+// Unwrap returns the cause, such as context.DeadlineExceeded.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrTransport.
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
@@ -8,19 +8,8 @@ package rpc
 import (
 	"fmt"
 	"sort"
-	"time"
 )
 
-// LockList to keep referenced utxos (potentially to-be-spent)
-type LockList map[string]time.Time
-
-var utxoLockDuration time.Duration
-
-// SetUtxoLockDuration set utxoLockDuration
-func SetUtxoLockDuration(utxoLockDurationIn time.Duration) {
-	utxoLockDuration = utxoLockDurationIn
-}
-
 func (ul UnspentList) Len() int {
 	return len(ul)
 }
@@ -40,8 +29,8 @@ func (ul UnspentList) Less(i, j int) bool {
 }
 
 // GetAmount get total amount.
-func (ul UnspentList) GetAmount() int64 {
-	var totalAmount = int64(0)
+func (ul UnspentList) GetAmount() Amount {
+	var totalAmount = Amount(0)
 
 	for _, u := range ul {
 		totalAmount += u.Amount
@@ -50,62 +39,11 @@ func (ul UnspentList) GetAmount() int64 {
 	return totalAmount
 }
 
-func getLockingKey(txid string, vout int64) string {
-	return fmt.Sprintf("%s:%d", txid, vout)
-}
-
-// Lock lock utxo.
-func (ll LockList) Lock(txid string, vout int64) bool {
-	key := getLockingKey(txid, vout)
-	now := time.Now()
-	to := now.Add(utxoLockDuration)
-
-	old, ok := ll[key]
-	if !ok {
-		// new lock.
-		ll[key] = to
-		return true
-	}
-	if old.Sub(now) < 0 {
-		// exists but no longer locked. lock again.
-		ll[key] = to
-		return true
-	}
-
-	// already locked.
-	return false
-}
-
-// Unlock unlock utxo.
-func (ll LockList) Unlock(txid string, vout int64) {
-	key := getLockingKey(txid, vout)
-	delete(ll, key)
-
-	return
-}
-
-// Sweep delete timeout
-func (ll LockList) Sweep() {
-	now := time.Now()
-	for k, v := range ll {
-		if v.Sub(now) < 0 {
-			delete(ll, k)
-		}
-	}
-}
-
-// UnlockUnspentList unlock utxos.
-func (ll LockList) UnlockUnspentList(ul UnspentList) {
-	for _, u := range ul {
-		ll.Unlock(u.Txid, u.Vout)
-	}
-}
-
 // GetNewAddr get new address, confidential or normal.
 func (rpc *Rpc) GetNewAddr(confidential bool) (string, error) {
 	var validAddr ValidatedAddress
 
-	adr, _, err := rpc.RequestAndCastString("getnewaddress")
+	adr, err := rpc.GetNewAddress()
 	if err != nil {
 		return "", err
 	}
@@ -114,7 +52,7 @@ func (rpc *Rpc) GetNewAddr(confidential bool) (string, error) {
 		return adr, nil
 	}
 
-	_, err = rpc.RequestAndUnmarshalResult(&validAddr, "validateaddress", adr)
+	validAddr, err = rpc.ValidateAddress(adr)
 	if err != nil {
 		return "", err
 	}
@@ -137,22 +75,16 @@ func (rpc *Rpc) GetCommitments(utxos UnspentList) ([]string, error) {
 	return commitments, nil
 }
 
-// SearchUnspent search unspent utxo.
-func (rpc *Rpc) SearchUnspent(lockList LockList, requestAsset string, requestAmount int64, blinding bool) (UnspentList, error) {
-	var totalAmount = int64(0)
-	var ul UnspentList
-	var utxos = make(UnspentList, 0)
+// SearchUnspent search unspent utxo with rpc.Selector. (LargestFirst if nil)
+func (rpc *Rpc) SearchUnspent(lockList *LockManager, requestAsset string, requestAmount Amount, blinding bool) (UnspentList, error) {
+	var candidates UnspentList
 
-	_, err := rpc.RequestAndUnmarshalResult(&ul, "listunspent", 1, 9999999, []string{}, false, requestAsset)
+	ul, err := rpc.ListUnspent(ListUnspentOptions{MinConf: 1, Asset: requestAsset})
 	if err != nil {
-		return utxos, err
+		return nil, err
 	}
-	sort.Sort(sort.Reverse(ul))
 
 	for _, u := range ul {
-		if requestAmount < totalAmount {
-			break
-		}
 		if blinding && (u.AssetCommitment == "") {
 			continue
 		}
@@ -162,28 +94,36 @@ func (rpc *Rpc) SearchUnspent(lockList LockList, requestAsset string, requestAmo
 		if !(u.Spendable || u.Solvable) {
 			continue
 		}
-		if !lockList.Lock(u.Txid, u.Vout) {
+		if lockList.IsLocked(u.Txid, u.Vout) {
 			continue
 		}
-		totalAmount += u.Amount
-		utxos = append(utxos, u)
+		candidates = append(candidates, u)
 	}
 
-	if requestAmount >= totalAmount {
-		lockList.UnlockUnspentList(utxos)
-		err = fmt.Errorf("no sufficient utxo")
-		return utxos, err
+	selector := rpc.Selector
+	if selector == nil {
+		selector = LargestFirst{}
+	}
+	utxos, err := selector.Select(candidates, requestAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, u := range utxos {
+		if !lockList.Lock(u.Txid, u.Vout) {
+			lockList.UnlockUnspentList(utxos[:i])
+			return nil, fmt.Errorf("utxo locked during selection: %s:%d", u.Txid, u.Vout)
+		}
 	}
 
 	return utxos, nil
 }
 
 // SearchMinimalUnspent search unspent minimal utxo.
-func (rpc *Rpc) SearchMinimalUnspent(lockList LockList, requestAsset string, blinding bool) (UnspentList, error) {
-	var ul UnspentList
+func (rpc *Rpc) SearchMinimalUnspent(lockList *LockManager, requestAsset string, blinding bool) (UnspentList, error) {
 	var utxos UnspentList
 
-	_, err := rpc.RequestAndUnmarshalResult(&ul, "listunspent", 1, 9999999, []string{}, false, requestAsset)
+	ul, err := rpc.ListUnspent(ListUnspentOptions{MinConf: 1, Asset: requestAsset})
 	if err != nil {
 		return utxos, err
 	}
@@ -0,0 +1,258 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc UTXO lock manager
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LockEntry is a referenced utxo (potentially to-be-spent).
+type LockEntry struct {
+	Txid    string    `json:"txid"`
+	Vout    int64     `json:"vout"`
+	Expiry  time.Time `json:"expiry"`
+	Owner   string    `json:"owner"`
+	Purpose string    `json:"purpose"`
+}
+
+// LockManager keeps referenced utxos (potentially to-be-spent).
+// It is safe for concurrent use, optionally persists the locks to a file
+// and mirrors them to the node's lockunspent.
+type LockManager struct {
+	mu       sync.Mutex
+	locks    map[string]*LockEntry
+	owner    string
+	duration time.Duration
+	path     string
+	node     *Rpc
+}
+
+// NewLockManager returns LockManager which locks utxos for duration, owned by owner unless tagged.
+func NewLockManager(owner string, duration time.Duration) *LockManager {
+	return &LockManager{
+		locks:    make(map[string]*LockEntry),
+		owner:    owner,
+		duration: duration,
+	}
+}
+
+func getLockingKey(txid string, vout int64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+// Load reads the locks persisted in path and persists further changes to it.
+func (lm *LockManager) Load(path string) error {
+	lm.mu.Lock()
+	lm.path = path
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		lm.mu.Unlock()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var entries []*LockEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		lm.mu.Unlock()
+		return fmt.Errorf("lock file [%s]: %v", path, err)
+	}
+	now := time.Now()
+	var restored []*LockEntry
+	for _, e := range entries {
+		if e.Expiry.Before(now) {
+			continue
+		}
+		lm.locks[getLockingKey(e.Txid, e.Vout)] = e
+		restored = append(restored, e)
+	}
+	lm.mu.Unlock()
+
+	lm.mirror(false, restored)
+	return nil
+}
+
+// Mirror makes every lock and unlock also call the node's lockunspent,
+// so the wallet itself does not spend reserved utxos.
+func (lm *LockManager) Mirror(node *Rpc) {
+	lm.mu.Lock()
+	lm.node = node
+	var entries []*LockEntry
+	for _, e := range lm.locks {
+		entries = append(entries, e)
+	}
+	lm.mu.Unlock()
+
+	lm.mirror(false, entries)
+}
+
+// Lock lock utxo.
+func (lm *LockManager) Lock(txid string, vout int64) bool {
+	return lm.LockWith(txid, vout, lm.owner, "")
+}
+
+// LockWith lock utxo with owner and purpose.
+func (lm *LockManager) LockWith(txid string, vout int64, owner string, purpose string) bool {
+	key := getLockingKey(txid, vout)
+	now := time.Now()
+
+	lm.mu.Lock()
+	old, ok := lm.locks[key]
+	if ok && now.Before(old.Expiry) {
+		// already locked.
+		lm.mu.Unlock()
+		return false
+	}
+	e := &LockEntry{Txid: txid, Vout: vout, Expiry: now.Add(lm.duration), Owner: owner, Purpose: purpose}
+	lm.locks[key] = e
+	lm.save()
+	lm.mu.Unlock()
+
+	lm.mirror(false, []*LockEntry{e})
+	return true
+}
+
+// Tag sets owner and purpose of locked utxos.
+func (lm *LockManager) Tag(ul UnspentList, owner string, purpose string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, u := range ul {
+		if e, ok := lm.locks[getLockingKey(u.Txid, u.Vout)]; ok {
+			e.Owner = owner
+			e.Purpose = purpose
+		}
+	}
+	lm.save()
+}
+
+// IsLocked reports whether utxo is locked.
+func (lm *LockManager) IsLocked(txid string, vout int64) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	e, ok := lm.locks[getLockingKey(txid, vout)]
+	return ok && time.Now().Before(e.Expiry)
+}
+
+// Unlock unlock utxo.
+func (lm *LockManager) Unlock(txid string, vout int64) {
+	lm.UnlockUnspentList(UnspentList{&Unspent{Txid: txid, Vout: vout}})
+}
+
+// UnlockUnspentList unlock utxos.
+func (lm *LockManager) UnlockUnspentList(ul UnspentList) {
+	var released []*LockEntry
+
+	lm.mu.Lock()
+	for _, u := range ul {
+		key := getLockingKey(u.Txid, u.Vout)
+		if e, ok := lm.locks[key]; ok {
+			released = append(released, e)
+			delete(lm.locks, key)
+		}
+	}
+	if len(released) != 0 {
+		lm.save()
+	}
+	lm.mu.Unlock()
+
+	lm.mirror(true, released)
+}
+
+// Sweep delete timeout
+func (lm *LockManager) Sweep() {
+	var released []*LockEntry
+	now := time.Now()
+
+	lm.mu.Lock()
+	for k, e := range lm.locks {
+		if e.Expiry.Before(now) {
+			released = append(released, e)
+			delete(lm.locks, k)
+		}
+	}
+	if len(released) != 0 {
+		lm.save()
+	}
+	lm.mu.Unlock()
+
+	lm.mirror(true, released)
+}
+
+// List returns the current locks ordered by expiry.
+func (lm *LockManager) List() []LockEntry {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	list := make([]LockEntry, 0, len(lm.locks))
+	for _, e := range lm.locks {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Expiry.Before(list[j].Expiry) })
+	return list
+}
+
+// Flush writes the locks to the file of Load, for stopping.
+func (lm *LockManager) Flush() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.write()
+}
+
+// save must be called with lm.mu held.
+func (lm *LockManager) save() {
+	err := lm.write()
+	if err != nil {
+		logger.Println("LockManager save error:", err)
+	}
+}
+
+// write must be called with lm.mu held.
+func (lm *LockManager) write() error {
+	if lm.path == "" {
+		return nil
+	}
+	entries := make([]*LockEntry, 0, len(lm.locks))
+	for _, e := range lm.locks {
+		entries = append(entries, e)
+	}
+	data, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := lm.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, lm.path)
+}
+
+// mirror must be called without lm.mu held, it calls the node.
+func (lm *LockManager) mirror(unlock bool, entries []*LockEntry) {
+	lm.mu.Lock()
+	node := lm.node
+	lm.mu.Unlock()
+	if node == nil || len(entries) == 0 {
+		return
+	}
+	outpoints := make([]OutPoint, len(entries))
+	for i, e := range entries {
+		outpoints[i] = OutPoint{Txid: e.Txid, Vout: e.Vout}
+	}
+	err := node.LockUnspent(unlock, outpoints)
+	if err != nil {
+		logger.Printf("RPC/lockunspent error: %v unlock:%v %+v", err, unlock, outpoints)
+	}
+}
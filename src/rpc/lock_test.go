@@ -0,0 +1,216 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package rpc_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"elementssim"
+	"rpc"
+)
+
+const (
+	txidA = "aa00000000000000000000000000000000000000000000000000000000000000"
+	txidB = "bb00000000000000000000000000000000000000000000000000000000000000"
+)
+
+func TestLockManagerLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks.json")
+	lm := rpc.NewLockManager("alice", time.Minute)
+	err := lm.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lm.Lock(txidA, 0) || !lm.LockWith(txidB, 1, "offer-1", "offer") {
+		t.Fatal("not locked")
+	}
+
+	// restarted
+	lm = rpc.NewLockManager("alice", time.Minute)
+	err = lm.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lm.IsLocked(txidA, 0) || !lm.IsLocked(txidB, 1) || lm.Lock(txidB, 1) {
+		t.Errorf("locks not restored: %+v", lm.List())
+	}
+	for _, e := range lm.List() {
+		if e.Txid == txidB && (e.Owner != "offer-1" || e.Purpose != "offer") {
+			t.Errorf("restored %+v", e)
+		}
+	}
+
+	// the locks expired while stopped are dropped.
+	entries := []rpc.LockEntry{
+		{Txid: txidA, Vout: 0, Expiry: time.Now().Add(-time.Second), Owner: "alice"},
+		{Txid: txidB, Vout: 1, Expiry: time.Now().Add(time.Minute), Owner: "alice"},
+	}
+	data, _ := json.Marshal(entries)
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm = rpc.NewLockManager("alice", time.Minute)
+	err = lm.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := lm.List(); len(list) != 1 || list[0].Txid != txidB {
+		t.Errorf("restored %+v, want the lock not expired", list)
+	}
+
+	err = ioutil.WriteFile(path, []byte("{"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rpc.NewLockManager("alice", time.Minute).Load(path); err == nil {
+		t.Error("broken lock file loaded")
+	}
+}
+
+func TestLockManagerConcurrent(t *testing.T) {
+	lm := rpc.NewLockManager("alice", time.Minute)
+	err := lm.Load(filepath.Join(t.TempDir(), "locks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	locked := 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if lm.LockWith(txidA, 0, fmt.Sprintf("offer-%d", i), "offer") {
+				mu.Lock()
+				locked++
+				mu.Unlock()
+			}
+			// other utxos are locked meanwhile.
+			if !lm.Lock(txidB, int64(i)) {
+				t.Errorf("%s:%d not locked", txidB, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if locked != 1 {
+		t.Errorf("locked %d times", locked)
+	}
+	if n := len(lm.List()); n != 17 {
+		t.Errorf("%d locks, want 17", n)
+	}
+}
+
+func TestLockManagerPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks.json")
+	lm := rpc.NewLockManager("alice", time.Minute)
+	err := lm.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm.Lock(txidA, 0)
+	lm.Lock(txidB, 0)
+	lm.UnlockUnspentList(rpc.UnspentList{{Txid: txidA, Vout: 0}})
+
+	restored := rpc.NewLockManager("alice", time.Minute)
+	err = restored.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.IsLocked(txidA, 0) || !restored.IsLocked(txidB, 0) {
+		t.Errorf("unlock not persisted: %+v", restored.List())
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lm.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []rpc.LockEntry
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil || len(entries) != 1 || entries[0].Txid != txidB {
+		t.Errorf("flushed %s %v", data, err)
+	}
+
+	// without a file, nothing is written.
+	err = rpc.NewLockManager("alice", time.Minute).Flush()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLockManagerMirror(t *testing.T) {
+	sim := elementssim.NewServer()
+	err := sim.CreateWallet("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.AddAsset("AIRSKY")
+	for i := 0; i < 2; i++ {
+		_, err = sim.Fund("alice", "AIRSKY", 100*int64(rpc.Coin), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(sim)
+	defer server.Close()
+	node := rpc.NewRpc(server.URL, "", "")
+	node.Wallet = "alice"
+	utxos, err := node.ListUnspent(rpc.ListUnspentOptions{MinConf: 1})
+	if err != nil || len(utxos) != 2 {
+		t.Fatalf("utxos %+v %v", utxos, err)
+	}
+	a, b := utxos[0], utxos[1]
+
+	nodeLocks := func() map[string]bool {
+		t.Helper()
+		outpoints, err := node.ListLockUnspent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]bool)
+		for _, op := range outpoints {
+			m[op.Txid] = true
+		}
+		return m
+	}
+
+	lm := rpc.NewLockManager("alice", time.Minute)
+	lm.Lock(a.Txid, a.Vout)
+	if len(nodeLocks()) != 0 {
+		t.Fatal("locked on the node before Mirror")
+	}
+	// the locks taken before are mirrored at once.
+	lm.Mirror(node)
+	if locks := nodeLocks(); !locks[a.Txid] || len(locks) != 1 {
+		t.Errorf("node locks %v, want %s", locks, a.Txid)
+	}
+	lm.Lock(b.Txid, b.Vout)
+	if locks := nodeLocks(); !locks[a.Txid] || !locks[b.Txid] {
+		t.Errorf("node locks %v, want both", locks)
+	}
+	listed, err := node.ListUnspent(rpc.ListUnspentOptions{MinConf: 1})
+	if err != nil || len(listed) != 0 {
+		t.Errorf("listed while locked: %+v %v", listed, err)
+	}
+	lm.Unlock(a.Txid, a.Vout)
+	if locks := nodeLocks(); locks[a.Txid] || !locks[b.Txid] {
+		t.Errorf("node locks %v, want %s", locks, b.Txid)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Typed RPC methods
+package rpc
+
+import (
+	"fmt"
+)
+
+// defaultMaxConf is the maxconf of listunspent when unset.
+const defaultMaxConf = 9999999
+
+// ListUnspentOptions is the filter of ListUnspent.
+type ListUnspentOptions struct {
+	MinConf       int64    // minimum confirmations
+	MaxConf       int64    // maximum confirmations (9999999 if 0)
+	Addresses     []string // only these addresses (all if empty)
+	IncludeUnsafe bool     // include unconfirmed utxos from others
+	Asset         string   // only this asset (all if empty)
+}
+
+func (opts ListUnspentOptions) maxConf() int64 {
+	if opts.MaxConf == 0 {
+		return defaultMaxConf
+	}
+	return opts.MaxConf
+}
+
+func (opts ListUnspentOptions) addresses() []string {
+	if opts.Addresses == nil {
+		return []string{}
+	}
+	return opts.Addresses
+}
+
+// OutPoint is a reference to a transaction output.
+type OutPoint struct {
+	Txid string `json:"txid"`
+	Vout int64  `json:"vout"`
+}
+
+// Block is block details.
+type Block struct {
+	Hash              string   `json:"hash"`
+	Confirmations     int64    `json:"confirmations"`
+	Size              int64    `json:"size"`
+	Height            int64    `json:"height"`
+	Version           int64    `json:"version"`
+	MerkleRoot        string   `json:"merkleroot"`
+	Tx                []string `json:"tx"`
+	Time              int64    `json:"time"`
+	PreviousBlockHash string   `json:"previousblockhash"`
+	NextBlockHash     string   `json:"nextblockhash"`
+}
+
+// IssueAssetOptions is the parameter of IssueAsset.
+type IssueAssetOptions struct {
+	Amount      Amount // amount of the asset
+	TokenAmount Amount // amount of the reissuance token
+	Blind       bool   // blind the issuance
+}
+
+// IssuedAsset is the result of IssueAsset.
+type IssuedAsset struct {
+	Txid    string `json:"txid"`
+	Vin     int64  `json:"vin"`
+	Entropy string `json:"entropy"`
+	Asset   string `json:"asset"`
+	Token   string `json:"token"`
+}
+
+// TxOut is the result of GetTxOut.
+type TxOut struct {
+	BestBlock     string       `json:"bestblock"`
+	Confirmations int64        `json:"confirmations"`
+	ScriptPubKey  ScriptPubKey `json:"scriptPubKey"`
+	Coinbase      bool         `json:"coinbase"`
+}
+
+// GetBlockCount returns the height of the best chain.
+func (rpc *Rpc) GetBlockCount() (int64, error) {
+	count, _, err := rpc.RequestAndCastNumber("getblockcount")
+	return int64(count), err
+}
+
+// GetBlockHash returns the hash of the block at height.
+func (rpc *Rpc) GetBlockHash(height int64) (string, error) {
+	hash, _, err := rpc.RequestAndCastString("getblockhash", height)
+	return hash, err
+}
+
+// GetBlock returns the block details.
+func (rpc *Rpc) GetBlock(hash string) (Block, error) {
+	var block Block
+	_, err := rpc.RequestAndUnmarshalResult(&block, "getblock", hash)
+	return block, err
+}
+
+// GetRawTransaction returns the serialized transaction in hex.
+func (rpc *Rpc) GetRawTransaction(txid string) (string, error) {
+	rawtx, _, err := rpc.RequestAndCastString("getrawtransaction", txid, 0)
+	return rawtx, err
+}
+
+// GetTxOut returns the unspent output of txid:vout, including the mempool. It returns nil if spent or unknown.
+func (rpc *Rpc) GetTxOut(txid string, vout int64) (*TxOut, error) {
+	res, err := rpc.Request("gettxout", txid, vout, true)
+	if err != nil || res.Result == nil {
+		return nil, err
+	}
+	var out TxOut
+	err = res.UnmarshalResult(&out)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetRawMempool returns the txids in the mempool.
+func (rpc *Rpc) GetRawMempool() ([]string, error) {
+	var txids []string
+	_, err := rpc.RequestAndUnmarshalResult(&txids, "getrawmempool")
+	return txids, err
+}
+
+// IssueAsset issues a new asset with its reissuance token.
+func (rpc *Rpc) IssueAsset(opts IssueAssetOptions) (IssuedAsset, error) {
+	var issued IssuedAsset
+	_, err := rpc.RequestAndUnmarshalResult(&issued, "issueasset", opts.Amount, opts.TokenAmount, opts.Blind)
+	return issued, err
+}
+
+// LockUnspent locks (or unlocks) outpoints in the node's wallet.
+func (rpc *Rpc) LockUnspent(unlock bool, outpoints []OutPoint) error {
+	ok, _, err := rpc.RequestAndCastBool("lockunspent", unlock, outpoints)
+	if err == nil && !ok {
+		err = fmt.Errorf("lockunspent failed: unlock:%v %+v", unlock, outpoints)
+	}
+	return err
+}
+
+// ListLockUnspent returns the outpoints locked in the node's wallet.
+func (rpc *Rpc) ListLockUnspent() ([]OutPoint, error) {
+	var outpoints []OutPoint
+	_, err := rpc.RequestAndUnmarshalResult(&outpoints, "listlockunspent")
+	return outpoints, err
+}
@@ -0,0 +1,374 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Record and replay of RPC exchanges
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// Exchange is a request and its response, a line of a fixture file.
+type Exchange struct {
+	Path     string          `json:"path,omitempty"`
+	Request  json.RawMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// Recorder is a transport which passes the requests to the node
+// and records the exchanges, appending them to Path if set.
+//
+//	rec := rpc.NewRecorder(nil, "testdata/dosend.jsonl")
+//	rpcClient.Transport = rec
+type Recorder struct {
+	Base      http.RoundTripper // transport to the node (the shared transport if nil)
+	Path      string            // fixture file appended on each exchange (not written if empty)
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewRecorder returns new Recorder.
+func NewRecorder(base http.RoundTripper, path string) *Recorder {
+	return &Recorder{Base: base, Path: path}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		bs, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bs
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	base := r.Base
+	if base == nil {
+		base = sharedClient.Transport
+	}
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	ex := Exchange{
+		Path:     req.URL.Path,
+		Request:  rawJSON(reqBody),
+		Status:   res.StatusCode,
+		Response: rawJSON(resBody),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exchanges = append(r.exchanges, ex)
+	if r.Path != "" {
+		err = appendExchange(r.Path, ex)
+		if err != nil {
+			return nil, fmt.Errorf("record [%s]: %v", r.Path, err)
+		}
+	}
+	return res, nil
+}
+
+// Exchanges returns the exchanges recorded so far.
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Replayer is a transport which serves recorded exchanges in order instead of the node.
+// The ids are rewritten to those of the requests, the methods and params must match.
+// A mismatch is answered with 400 Bad Request describing it, and kept in Mismatches.
+//
+//	rep, err := rpc.LoadReplayer("testdata/dosend.jsonl")
+//	rpcClient.Transport = rep
+//	...
+//	err = rep.Done() // all exchanges served without mismatch
+type Replayer struct {
+	mu         sync.Mutex
+	exchanges  []Exchange
+	pos        int
+	mismatches []error
+}
+
+// NewReplayer returns new Replayer serving exchanges.
+func NewReplayer(exchanges []Exchange) *Replayer {
+	return &Replayer{exchanges: exchanges}
+}
+
+// LoadReplayer returns new Replayer serving the exchanges of a fixture file.
+func LoadReplayer(path string) (*Replayer, error) {
+	exchanges, err := LoadExchanges(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(exchanges), nil
+}
+
+// LoadExchanges reads a fixture file written by Recorder.
+func LoadExchanges(path string) ([]Exchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var exchanges []Exchange
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var ex Exchange
+		err = json.Unmarshal(scanner.Bytes(), &ex)
+		if err != nil {
+			return nil, fmt.Errorf("fixture [%s] line %d: %v", path, line, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, scanner.Err()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		bs, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bs
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.exchanges) <= r.pos {
+		return r.mismatch(req, fmt.Errorf("replay #%d: no more exchanges, got %s", r.pos, describeRequest(reqBody)))
+	}
+	ex := r.exchanges[r.pos]
+	if ex.Path != "" && ex.Path != req.URL.Path {
+		return r.mismatch(req, fmt.Errorf("replay #%d: expected path %s, got %s", r.pos, ex.Path, req.URL.Path))
+	}
+	recorded, err := parseCalls(ex.Request)
+	if err != nil {
+		return nil, fmt.Errorf("replay #%d: recorded request: %v", r.pos, err)
+	}
+	actual, err := parseCalls(reqBody)
+	if err != nil {
+		return r.mismatch(req, fmt.Errorf("replay #%d: request: %v", r.pos, err))
+	}
+	if !sameCalls(recorded, actual) {
+		return r.mismatch(req, fmt.Errorf("replay #%d: expected %s, got %s", r.pos, describeRequest(ex.Request), describeRequest(reqBody)))
+	}
+
+	body, err := rewriteIDs(ex.Response, recorded, actual)
+	if err != nil {
+		return nil, fmt.Errorf("replay #%d: recorded response: %v", r.pos, err)
+	}
+	r.pos++
+	return newReplayResponse(req, ex.Status, body), nil
+}
+
+// Remaining returns the number of exchanges not served yet.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.exchanges) - r.pos
+}
+
+// Mismatches returns the requests which did not match the recorded ones.
+func (r *Replayer) Mismatches() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.mismatches...)
+}
+
+// Done returns the first mismatch, or an error if exchanges are left unserved.
+func (r *Replayer) Done() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if 0 < len(r.mismatches) {
+		return r.mismatches[0]
+	}
+	if r.pos < len(r.exchanges) {
+		return fmt.Errorf("replay: %d exchanges not served, next %s", len(r.exchanges)-r.pos, describeRequest(r.exchanges[r.pos].Request))
+	}
+	return nil
+}
+
+func (r *Replayer) mismatch(req *http.Request, err error) (*http.Response, error) {
+	r.mismatches = append(r.mismatches, err)
+	return newReplayResponse(req, http.StatusBadRequest, []byte(err.Error())), nil
+}
+
+// replayCall is a request in a recorded or actual payload.
+type replayCall struct {
+	Id     string      `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+// parseCalls parses a payload, a single request or a batch.
+func parseCalls(payload []byte) ([]replayCall, error) {
+	payload = bytes.TrimSpace(payload)
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber() // params compared as written, not as float64
+	if len(payload) != 0 && payload[0] == '[' {
+		var calls []replayCall
+		err := dec.Decode(&calls)
+		return calls, err
+	}
+	var call replayCall
+	err := dec.Decode(&call)
+	return []replayCall{call}, err
+}
+
+func sameCalls(a, b []replayCall) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Method != b[i].Method || !reflect.DeepEqual(a[i].Params, b[i].Params) {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteIDs replaces the recorded ids in the response with those of the actual requests.
+// Only the ids are rewritten, the other members are kept as recorded, amounts included.
+func rewriteIDs(response json.RawMessage, recorded, actual []replayCall) ([]byte, error) {
+	ids := make(map[string]string, len(recorded))
+	for i := range recorded {
+		ids[recorded[i].Id] = actual[i].Id
+	}
+	res := bytes.TrimSpace(response)
+	if len(res) == 0 {
+		return res, nil
+	}
+	if res[0] == '[' {
+		var items []map[string]json.RawMessage
+		err := json.Unmarshal(res, &items)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			err = rewriteID(item, ids)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(items)
+	}
+	if res[0] == '"' {
+		// a body which was not JSON.
+		var text string
+		err := json.Unmarshal(res, &text)
+		return []byte(text), err
+	}
+	if res[0] != '{' {
+		return res, nil
+	}
+	var item map[string]json.RawMessage
+	err := json.Unmarshal(res, &item)
+	if err != nil {
+		return nil, err
+	}
+	err = rewriteID(item, ids)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(item)
+}
+
+func rewriteID(item map[string]json.RawMessage, ids map[string]string) error {
+	var id string
+	if json.Unmarshal(item["id"], &id) != nil {
+		return nil
+	}
+	newID, ok := ids[id]
+	if !ok {
+		return nil
+	}
+	bs, err := json.Marshal(newID)
+	if err != nil {
+		return err
+	}
+	item["id"] = bs
+	return nil
+}
+
+func describeRequest(payload []byte) string {
+	calls, err := parseCalls(payload)
+	if err != nil {
+		return fmt.Sprintf("%q", payload)
+	}
+	var buf bytes.Buffer
+	for i, c := range calls {
+		if 0 < i {
+			buf.WriteString(", ")
+		}
+		params, _ := json.Marshal(c.Params)
+		fmt.Fprintf(&buf, "%s%s", c.Method, params)
+	}
+	return buf.String()
+}
+
+func newReplayResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// rawJSON returns bs as a JSON value, quoting it if it is not JSON.
+func rawJSON(bs []byte) json.RawMessage {
+	if json.Valid(bs) {
+		return json.RawMessage(bytes.TrimSpace(bs))
+	}
+	quoted, _ := json.Marshal(string(bs))
+	return quoted
+}
+
+func appendExchange(path string, ex Exchange) error {
+	bs, err := json.Marshal(ex)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(bs, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
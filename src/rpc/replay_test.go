@@ -0,0 +1,68 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func replay(t *testing.T, rep *Replayer, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("POST", "http://node/wallet/alice", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := rep.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+func TestReplayerRewritesOnlyIDs(t *testing.T) {
+	rep := NewReplayer([]Exchange{{
+		Path:     "/wallet/alice",
+		Request:  []byte(`{"jsonrpc":"1.0","id":"1","method":"getbalance","params":[12345678901234567890]}`),
+		Status:   200,
+		Response: []byte(`{"result":{"big":12345678901234567890,"small":0.00000001},"error":null,"id":"1"}`),
+	}})
+
+	status, body := replay(t, rep, `{"jsonrpc":"1.0","id":"7","method":"getbalance","params":[12345678901234567890]}`)
+	if status != 200 {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	for _, want := range []string{`"id":"7"`, `12345678901234567890`, `0.00000001`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response %s lacks %s", body, want)
+		}
+	}
+	if err := rep.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayerMismatch(t *testing.T) {
+	rep := NewReplayer([]Exchange{{
+		Request:  []byte(`{"id":"1","method":"getbalance","params":[12345678901234567890]}`),
+		Status:   200,
+		Response: []byte(`{"result":0,"error":null,"id":"1"}`),
+	}})
+
+	// the same float64, but not the same number.
+	status, _ := replay(t, rep, `{"id":"2","method":"getbalance","params":[12345678901234567891]}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", status, http.StatusBadRequest)
+	}
+	if err := rep.Done(); err == nil {
+		t.Error("Done: no mismatch")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+// Package rpc Transport and retry policy
+package rpc
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy is how a request is retried on transient errors:
+// transport errors, timeouts of an attempt, 502/503/504 and the warming up response.
+// Only the read methods are retried after an error which may follow the processing. (see idempotentMethods)
+type RetryPolicy struct {
+	MaxRetries int           // retries after the first attempt (0 disables retry)
+	Backoff    time.Duration // wait before the first retry, doubled on each retry
+	MaxBackoff time.Duration // upper limit of the wait (no limit if 0)
+}
+
+// DefaultRetryPolicy is the default of Rpc.Retry.
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 3, Backoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// backoff returns the wait before the retry after attempt. (0 origin)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 0; i < attempt; i++ {
+		wait *= 2
+		if 0 < p.MaxBackoff && p.MaxBackoff <= wait {
+			return p.MaxBackoff
+		}
+	}
+	if 0 < p.MaxBackoff && p.MaxBackoff < wait {
+		return p.MaxBackoff
+	}
+	return wait
+}
+
+// idempotentMethods are the methods retried after any transient error.
+// Another method may have been processed when its response is lost, so it is retried
+// only when the node surely did not process it. (see retryable)
+var idempotentMethods = map[string]bool{
+	"getnetworkinfo":       true,
+	"getblockchaininfo":    true,
+	"getblockcount":        true,
+	"getblockhash":         true,
+	"getblock":             true,
+	"getrawtransaction":    true,
+	"getrawmempool":        true,
+	"gettxout":             true,
+	"dumpassetlabels":      true,
+	"validateaddress":      true,
+	"getaddressinfo":       true,
+	"getwalletinfo":        true,
+	"getbalance":           true,
+	"getbalances":          true,
+	"listunspent":          true,
+	"listlockunspent":      true,
+	"listwallets":          true,
+	"getreceivedbyaddress": true,
+}
+
+// retryable reports whether a request failing with err may be sent again.
+func retryable(idempotent bool, err error) bool {
+	if idempotent || errors.Is(err, ErrNodeNotReady) {
+		return true
+	}
+	// not connected, nothing was sent.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// sharedClient is used by every Rpc, so connections to the node are pooled.
+var sharedClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
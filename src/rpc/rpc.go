@@ -7,13 +7,25 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var logger = log.New(ioutil.Discard, "", 0)
+
+// SetLogger sets logger.
+func SetLogger(loggerIn *log.Logger) {
+	logger = loggerIn
+}
+
 // ValidatedAddress contains address details.
 type ValidatedAddress struct {
 	IsValid         bool   `json:"isvalid"`          // : true|false,        (boolean) If the address is valid or not. If not, this is the only property returned.
@@ -39,7 +51,7 @@ type Unspent struct {
 	Address         string `json:"address"`         // "address"         : "address", (string)  the bitcoin address
 	Account         string `json:"account"`         // "account"         : "account", (string)  DEPRECATED. The associated account, or "" for the default account
 	ScriptPubKey    string `json:"scriptPubKey"`    // "scriptPubKey"    : "key",     (string)  the script key
-	Amount          int64  `json:"amount"`          // "amount"          : x.xxx,     (numeric) the transaction amount in BTC
+	Amount          Amount `json:"amount"`          // "amount"          : x.xxx,     (numeric) the transaction amount in BTC
 	Asset           string `json:"asset"`           // "asset"           : "hex"      (string)  the asset id for this output
 	AssetCommitment string `json:"assetcommitment"` // "assetcommitment" : "hex"      (string)  the asset commitment for this output
 	Confirmations   int64  `json:"confirmations"`   // "confirmations"   : n,         (numeric) The number of confirmations
@@ -54,7 +66,7 @@ type Unspent struct {
 type UnspentList []*Unspent
 
 // BalanceMap is a map where the key is an assetid and the value is a balance.
-type BalanceMap map[string]float64
+type BalanceMap map[string]Amount
 
 // Wallet is wallet details.
 type Wallet struct {
@@ -66,7 +78,7 @@ type Wallet struct {
 	KeypoolOldest      float64    `json:"keypoololdest"`       // : xxxxxx,      (numeric) the timestamp (seconds since GMT epoch) of the oldest pre-generated key in the key pool
 	KeypoolSize        int64      `json:"keypoolsize"`         // : xxxx,        (numeric) how many new keys are pre-generated
 	UnlockedUntil      int64      `json:"unlocked_until"`      // : ttt,         (numeric) the timestamp in seconds since epoch (midnight Jan 1 1970 GMT) that the wallet is unlocked for transfers, or 0 if the wallet is locked
-	PayTxFee           int64      `json:"paytxfee"`            // : x.xxxx,      (numeric) the transaction fee configuration, set in BTC/kB
+	PayTxFee           Amount     `json:"paytxfee"`            // : x.xxxx,      (numeric) the transaction fee configuration, set in BTC/kB
 	HDMasterKeyId      string     `json:"hdmasterkeyid"`       // : "<hash160>", (string) the Hash160 of the HD master pubkey
 }
 
@@ -83,6 +95,18 @@ type ScriptPubKey struct {
 	ReqSigs   int64    `json:"reqSigs"`
 	Type      string   `json:"type"`
 	Addresses []string `json:"addresses"`
+	Address   string   `json:"address"` // instead of addresses since 22.0
+}
+
+// GetAddress returns the address of the script, empty if none.
+func (s ScriptPubKey) GetAddress() string {
+	if s.Address != "" {
+		return s.Address
+	}
+	if len(s.Addresses) == 1 {
+		return s.Addresses[0]
+	}
+	return ""
 }
 
 // Vin is input details.
@@ -90,13 +114,13 @@ type Vin struct {
 	Txid        string    `json:"txid"`
 	Vout        int64     `json:"vout"`
 	ScriptSig   ScriptSig `json:"scriptSig"`
-	txinwitness string    `json:"txinwitness"`
-	sequence    int64     `json:"sequence"`
+	TxInWitness []string  `json:"txinwitness"`
+	Sequence    int64     `json:"sequence"`
 }
 
 // Vout is output details.
 type Vout struct {
-	Value        float64      `json:"value"`
+	Value        Amount       `json:"value"`
 	N            int64        `json:"n"`
 	Asset        string       `json:"asset"`
 	Assettag     string       `json:"assettag"`
@@ -105,15 +129,15 @@ type Vout struct {
 
 // RawTransaction is transaction details.
 type RawTransaction struct {
-	Txid     string  `json:"txid"`
-	Hash     string  `json:"hash"`
-	Size     int64   `json:"size"`
-	Vsize    int64   `json:"vsize"`
-	Version  int64   `json:"version"`
-	LockTime int64   `json:"locktime"`
-	Fee      float64 `json:"fee"`
-	Vin      []Vin   `json:"vin"`
-	Vout     []Vout  `json:"vout"`
+	Txid     string `json:"txid"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Vsize    int64  `json:"vsize"`
+	Version  int64  `json:"version"`
+	LockTime int64  `json:"locktime"`
+	Fee      Amount `json:"fee"`
+	Vin      []Vin  `json:"vin"`
+	Vout     []Vout `json:"vout"`
 }
 
 // SignedTransaction is transaction details.
@@ -123,11 +147,44 @@ type SignedTransaction struct {
 }
 
 // Rpc is request info.
+// Use NewRpc to create it, and WithContext to bind requests to a context.
 type Rpc struct {
-	Url  string
-	User string
-	Pass string
-	View bool
+	Url      string
+	User     string
+	Pass     string
+	View     bool
+	Selector CoinSelector
+	// Timeout is the deadline of each attempt when the context has none.
+	Timeout time.Duration
+	// Retry is the retry policy for transient errors.
+	Retry RetryPolicy
+	// Adapter issues the version dependent RPCs, detected on first use if nil.
+	Adapter Adapter
+	// Auth gives the credentials instead of User and Pass if set.
+	Auth Authenticator
+	// Wallet is the name of the wallet on a multi-wallet node. (default wallet if empty)
+	Wallet string
+	// Transport sends the HTTP requests instead of the shared transport if set. (see Recorder and Replayer)
+	Transport http.RoundTripper
+	ctx       context.Context
+	node      *nodeState
+}
+
+// nodeState is the node version detected by DetectVersion, shared with the WithContext copies.
+type nodeState struct {
+	mu      sync.Mutex
+	version int64
+	adapter Adapter
+}
+
+// DefaultTimeout is the default of Rpc.Timeout.
+const DefaultTimeout = 30 * time.Second
+
+// lastRequestID is the last JSON-RPC id issued in this process.
+var lastRequestID uint64
+
+func nextRequestID() string {
+	return strconv.FormatUint(atomic.AddUint64(&lastRequestID, 1), 10)
 }
 
 // RpcRequest is request parameters.
@@ -146,9 +203,13 @@ type RpcResponse struct {
 }
 
 // RpcError is error details.
+// Request returns it when the node answers with an error. (see Err* variables for the categories)
 type RpcError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int           `json:"code"`
+	Message string        `json:"message"`
+	Method  string        `json:"-"`
+	Params  []interface{} `json:"-"`
+	Status  int           `json:"-"`
 }
 
 // UnmarshalError converts the error response into an RpcError type
@@ -195,38 +256,176 @@ func NewRpc(url, user, pass string) *Rpc {
 	rpc.Url = url
 	rpc.User = user
 	rpc.Pass = pass
+	rpc.Timeout = DefaultTimeout
+	rpc.Retry = DefaultRetryPolicy
+	rpc.node = new(nodeState)
 	return rpc
 }
 
-// Request request server
+// WithContext returns a copy of rpc whose requests are cancelled with ctx.
+func (rpc *Rpc) WithContext(ctx context.Context) *Rpc {
+	r := new(Rpc)
+	*r = *rpc
+	r.ctx = ctx
+	return r
+}
+
+// Context returns the context bound by WithContext. (context.Background if none)
+func (rpc *Rpc) Context() context.Context {
+	if rpc.ctx == nil {
+		return context.Background()
+	}
+	return rpc.ctx
+}
+
+// Request request server with the bound context.
 func (rpc *Rpc) Request(method string, params ...interface{}) (RpcResponse, error) {
+	return rpc.RequestContext(rpc.Context(), method, params...)
+}
+
+// RequestContext request server, retrying transient errors according to rpc.Retry.
+func (rpc *Rpc) RequestContext(ctx context.Context, method string, params ...interface{}) (RpcResponse, error) {
 	var res RpcResponse
 	if len(params) == 0 {
 		params = []interface{}{}
 	}
-	id := fmt.Sprintf("%d", time.Now().Unix())
+	err := rpc.withRetry(ctx, method, idempotentMethods[method], func() (bool, error) {
+		var retry bool
+		var err error
+		res, retry, err = rpc.request(ctx, method, params)
+		return retry, err
+	})
+	return res, err
+}
+
+// withRetry calls attempt until it succeeds, fails for good or rpc.Retry gives up.
+// A request not idempotent is sent again only if it was surely not processed.
+func (rpc *Rpc) withRetry(ctx context.Context, method string, idempotent bool, attempt func() (bool, error)) error {
+	for n := 0; ; n++ {
+		retry, err := attempt()
+		if err == nil || !retry || !retryable(idempotent, err) || rpc.Retry.MaxRetries <= n {
+			return err
+		}
+		wait := rpc.Retry.backoff(n)
+		if rpc.View {
+			fmt.Printf("retry %s in %v: %v\n", method, wait, err)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// request sends a single attempt, and reports whether the error is worth retrying.
+func (rpc *Rpc) request(ctx context.Context, method string, params []interface{}) (RpcResponse, bool, error) {
+	var res RpcResponse
+
+	id := nextRequestID()
 	req := &RpcRequest{"1.0", id, method, params}
-	bs, _ := json.Marshal(req)
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return res, false, err
+	}
+
+	hres, body, retry, err := rpc.post(ctx, method, bs)
+	if err != nil {
+		if rerr, ok := err.(*RpcError); ok {
+			rerr.Params = params
+		}
+		return res, retry, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err = dec.Decode(&res)
+	if err != nil {
+		err = fmt.Errorf("status:%v, error:%v, body:%s", hres.Status, err, body)
+		return res, isRetryableStatus(hres.StatusCode), &TransportError{Method: method, Err: err}
+	}
+	if res.Error != nil {
+		rerr, err := res.newRpcError(method, params, hres.StatusCode)
+		if err != nil {
+			return res, false, &TransportError{Method: method, Err: err}
+		}
+		return res, rerr.Code == ErrCodeInWarmup, rerr
+	}
+	if hres.StatusCode != http.StatusOK || res.Id != id {
+		err = fmt.Errorf("status:%v, body:%s reqid:%v, resid:%v", hres.Status, body, id, res.Id)
+		return res, isRetryableStatus(hres.StatusCode), &TransportError{Method: method, Err: err}
+	}
+	return res, false, nil
+}
+
+// post sends a JSON-RPC payload and returns the response with its body.
+// method names the request in errors, retry reports whether the error is worth retrying.
+func (rpc *Rpc) post(ctx context.Context, method string, payload []byte) (*http.Response, []byte, bool, error) {
 	if rpc.View {
-		fmt.Printf("%s\n", bs)
+		fmt.Printf("%s\n", payload)
 	}
-	client := &http.Client{}
-	hreq, _ := http.NewRequest("POST", rpc.Url, bytes.NewBuffer(bs))
-	hreq.SetBasicAuth(rpc.User, rpc.Pass)
-	hres, err := client.Do(hreq)
+
+	actx := ctx
+	if _, ok := ctx.Deadline(); !ok && 0 < rpc.Timeout {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, rpc.Timeout)
+		defer cancel()
+	}
+	user, pass, err := rpc.credentials()
 	if err != nil {
-		return res, err
+		return nil, nil, false, fmt.Errorf("%w: %v", ErrAuth, err)
+	}
+	hreq, err := http.NewRequestWithContext(actx, "POST", rpc.endpoint(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	hreq.SetBasicAuth(user, pass)
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hres, err := rpc.httpClient().Do(hreq)
+	if err != nil {
+		// the transport failed or this attempt timed out, unless the caller gave up.
+		return nil, nil, ctx.Err() == nil, &TransportError{Method: method, Err: err}
 	}
 	defer hres.Body.Close()
-	body, _ := ioutil.ReadAll(hres.Body)
+	body, err := ioutil.ReadAll(hres.Body)
+	if err != nil {
+		return hres, nil, ctx.Err() == nil, &TransportError{Method: method, Err: err}
+	}
 	if rpc.View {
 		fmt.Printf("%d, %s\n", hres.StatusCode, body)
 	}
-	err = json.Unmarshal(body, &res)
-	if err != nil || hres.StatusCode != http.StatusOK || res.Id != id {
-		return res, fmt.Errorf("status:%v, error:%v, body:%s reqid:%v, resid:%v", hres.Status, err, body, id, res.Id)
+	if hres.StatusCode == http.StatusUnauthorized || hres.StatusCode == http.StatusForbidden {
+		return hres, body, false, &RpcError{Message: hres.Status, Method: method, Status: hres.StatusCode}
 	}
-	return res, nil
+	return hres, body, false, nil
+}
+
+// Close closes the idle connections to the node, for stopping.
+func (rpc *Rpc) Close() error {
+	rpc.httpClient().CloseIdleConnections()
+	return nil
+}
+
+// httpClient returns the client which sends the requests.
+func (rpc *Rpc) httpClient() *http.Client {
+	if rpc.Transport == nil {
+		return sharedClient
+	}
+	return &http.Client{Transport: rpc.Transport}
+}
+
+// newRpcError returns the error of the response as *RpcError.
+func (res *RpcResponse) newRpcError(method string, params []interface{}, status int) (*RpcError, error) {
+	rerr, err := res.UnmarshalError()
+	if err != nil {
+		return nil, err
+	}
+	rerr.Method = method
+	rerr.Params = params
+	rerr.Status = status
+	return &rerr, nil
 }
 
 // RequestAndUnmarshalResult do Request and UnmarshalResult
@@ -249,13 +448,35 @@ func (rpc *Rpc) RequestAndCastNumber(method string, params ...interface{}) (floa
 	if err != nil {
 		return num, res, err
 	}
-	num, ok := res.Result.(float64)
+	jnum, ok := res.Result.(json.Number)
 	if !ok {
 		return num, res, fmt.Errorf("RpcResponse Result cast error:%+v", res.Result)
 	}
+	num, err = jnum.Float64()
+	if err != nil {
+		return num, res, fmt.Errorf("RpcResponse Result cast error:%+v", res.Result)
+	}
 	return num, res, nil
 }
 
+// RequestAndCastAmount do Request and cast Amount
+func (rpc *Rpc) RequestAndCastAmount(method string, params ...interface{}) (Amount, RpcResponse, error) {
+	var amount Amount
+	res, err := rpc.Request(method, params...)
+	if err != nil {
+		return amount, res, err
+	}
+	jnum, ok := res.Result.(json.Number)
+	if !ok {
+		return amount, res, fmt.Errorf("RpcResponse Result cast error:%+v", res.Result)
+	}
+	amount, err = ParseAmount(jnum.String())
+	if err != nil {
+		return amount, res, err
+	}
+	return amount, res, nil
+}
+
 // RequestAndCastString do Request and cast string
 func (rpc *Rpc) RequestAndCastString(method string, params ...interface{}) (string, RpcResponse, error) {
 	var str string
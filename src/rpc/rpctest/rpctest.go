@@ -0,0 +1,63 @@
+// Copyright (c) 2017 DG Lab
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or http://www.opensource.org/licenses/mit-license.php.
+
+/*
+Package rpctest runs the node of an actor test on fixtures recorded against elementssim.
+
+usage:
+
+	// the node of alice, replaying testdata/dosend.jsonl (or recording it with go test alice -record)
+	rpcClient = rpctest.NewNode(t, server.URL, "alice", "testdata/dosend.jsonl")
+
+A replayed node answers the recorded responses without a chain, so the test must set up elementssim
+the same way in both modes for its counterparts to see the addresses and txids of the fixture.
+*/
+package rpctest
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"rpc"
+)
+
+// ReplayURL is the URL of a replayed node, which is never dialed.
+const ReplayURL = "http://elementssim.invalid"
+
+var record = flag.Bool("record", false, "record the fixtures of testdata against elementssim")
+
+// Recording reports whether the fixtures are recorded. (-record)
+func Recording() bool {
+	return *record
+}
+
+// NewNode returns the node of wallet replaying fixture, or recording it against url with -record.
+// A replayed node fails the test at its end if some recorded exchange was not requested.
+func NewNode(t testing.TB, url string, wallet string, fixture string) *rpc.Rpc {
+	t.Helper()
+	node := rpc.NewRpc(url, "", "")
+	node.Wallet = wallet
+	if *record {
+		err := os.Remove(fixture)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		node.Transport = rpc.NewRecorder(nil, fixture)
+		return node
+	}
+	rep, err := rpc.LoadReplayer(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := rep.Done()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	node.Url = ReplayURL
+	node.Transport = rep
+	return node
+}
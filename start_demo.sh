@@ -33,7 +33,7 @@ start_daemon () {
 # prepare
 ## be sure at elements-next folder
 cd "$(dirname "${BASH_SOURCE[0]}")"
-for i in elementsd elements-cli elements-tx ; do
+for i in elementsd elements-cli curl ; do
 	which $i > /dev/null
 	if [ ""$? != "0" ];then
 		echo "cannot find [" $i "]"
@@ -44,7 +44,6 @@ done
 DEMOD=$PWD/demo
 ELDAE=elementsd
 ELCLI=elements-cli
-ELTX=elements-tx
 
 ## cleanup previous data
 if [ -e ./demo.tmp ]; then
@@ -58,12 +57,11 @@ rm -rf ${DEMOD}/data
 echo "initial setup - asset generation"
 
 ## setup nodes
+## no rpcpassword: each node writes data/$i/elementsregtest/.cookie, read by elements-cli and the actors
 PORT=0
 for i in alice bob charlie dave fred; do
     mkdir -p ${DEMOD}/data/$i
     cat <<EOF > ${DEMOD}/data/$i/elements.conf
-rpcuser=user
-rpcpassword=pass
 rpcport=$((10000 + $PORT))
 port=$((10001 + $PORT))
 
@@ -77,7 +75,6 @@ initialfreecoins=2100000000000000
 EOF
     let PORT=PORT+10
     alias ${i}-dae="${ELDAE} -datadir=${DEMOD}/data/$i"
-    alias ${i}-tx="${ELTX}"
     alias ${i}="${ELCLI} -datadir=${DEMOD}/data/$i"
     echo "${i}_dir=\"-datadir=${DEMOD}/data/$i\"" >> ./demo.tmp
 done
@@ -111,6 +108,18 @@ assetdir=$MONECRE:MONECRE
 EOF
 done
 
+## push notifications to the actors instead of polling (see src/notify)
+cat <<EOF >> ${DEMOD}/data/bob/elements.conf
+blocknotify=curl -s http://127.0.0.1:8011/notify/block/%s
+EOF
+cat <<EOF >> ${DEMOD}/data/dave/elements.conf
+blocknotify=curl -s http://127.0.0.1:8031/notify/block/%s
+walletnotify=curl -s http://127.0.0.1:8031/notify/tx/%s
+EOF
+cat <<EOF >> ${DEMOD}/data/fred/elements.conf
+zmqpubhashtx=tcp://127.0.0.1:28040
+EOF
+
 start_daemon 0 alice bob charlie dave fred
 
 ## generate assets
@@ -164,7 +173,18 @@ done
 
 start_daemon 1 alice bob charlie dave fred
 
+# the API keys and the exchanger secret of the demo, referred to by democonf.json
+demo_secret() {
+    od -An -N16 -tx1 /dev/urandom | tr -d ' \n'
+}
+export DEMO_ALICE_UI_KEY=$(demo_secret)
+export DEMO_DAVE_ADMIN_KEY=$(demo_secret)
+export DEMO_EXCHANGER_SECRET=$(demo_secret)
+
 cd ${DEMOD}
+# the certificates of the actors, signed by a CA whose key is not kept (see src/democa)
+rm -rf democa
+./democa -dir democa alice charlie dave || exit 1
 for i in alice bob charlie dave fred; do
     ./$i &
     echo "${i}_pid=$!" >> ../demo.tmp
@@ -174,7 +194,7 @@ cd "$(dirname "${BASH_SOURCE[0]}")"
 sleep 2
 
 echo "Setup complete. Use these URLs to test it out:"
-echo "Alice -> http://127.0.0.1:8000/"
+echo "Alice -> http://127.0.0.1:8000/ (key: ${DEMO_ALICE_UI_KEY})"
 echo "Dave  -> http://127.0.0.1:8030/order.html"
-echo "Dave  -> http://127.0.0.1:8030/list.html"
+echo "Dave  -> http://127.0.0.1:8030/list.html (key: ${DEMO_DAVE_ADMIN_KEY})"
 echo "When finished, run stop_demo.sh"
@@ -5,17 +5,29 @@ if [ -e ./demo.tmp ]; then
 
     source ./demo.tmp
 
+    # the actors drain their requests, flush their state and exit by themselves.
     gopids=( $dave_pid $alice_pid $charlie_pid $fred_pid $bob_pid )
     for pid in "${gopids[@]}"; do
         if ps -p $pid > /dev/null ; then
-            echo "kill -SIGINT $pid"
-            kill -SIGINT $pid
+            echo "kill -SIGTERM $pid"
+            kill -SIGTERM $pid
         fi
     done
-    sleep 1
+    for i in $(seq 1 30); do
+        running=0
+        for pid in "${gopids[@]}"; do
+            if ps -p $pid > /dev/null ; then
+                running=1
+            fi
+        done
+        if [ $running == 0 ]; then
+            break
+        fi
+        sleep 0.5
+    done
     for pid in "${gopids[@]}"; do
         if ps -p $pid > /dev/null ; then
-            echo "kill -9 $pid"
+            echo "$pid did not stop, kill -9 $pid"
             kill -9 $pid
         fi
     done